@@ -0,0 +1,74 @@
+// Package auth holds password hashing, session tokens and the role ladder
+// used to guard the CMS API.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"gtfs-cms/models"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SessionTTL is how long a login stays valid
+const SessionTTL = 24 * time.Hour
+
+// MinPasswordLength is enforced whenever a password is set
+const MinPasswordLength = 8
+
+// roleRank orders the roles; a higher rank includes every lower one
+var roleRank = map[string]int{
+	models.RoleViewer:    1,
+	models.RoleEditor:    2,
+	models.RolePublisher: 3,
+	models.RoleAdmin:     4,
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// Allows reports whether a user with role have may act where need is required
+func Allows(have, need string) bool {
+	h, ok := roleRank[have]
+	return ok && h >= roleRank[need]
+}
+
+// HashPassword returns the bcrypt hash of password
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches hash
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NewToken returns a random bearer token and the hash to store for it
+func NewToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken is the lookup key of a token in the sessions table
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
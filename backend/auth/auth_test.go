@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"gtfs-cms/models"
+	"testing"
+)
+
+func TestAllows(t *testing.T) {
+	cases := []struct {
+		have, need string
+		want       bool
+	}{
+		{models.RoleAdmin, models.RoleViewer, true},
+		{models.RolePublisher, models.RolePublisher, true},
+		{models.RoleEditor, models.RolePublisher, false},
+		{models.RoleViewer, models.RoleEditor, false},
+		{"", models.RoleViewer, false},
+		{"root", models.RoleViewer, false},
+	}
+	for _, c := range cases {
+		if got := Allows(c.have, c.need); got != c.want {
+			t.Errorf("Allows(%q, %q) = %v, want %v", c.have, c.need, got, c.want)
+		}
+	}
+}
+
+func TestPasswordHashing(t *testing.T) {
+	if _, err := HashPassword("short"); err == nil {
+		t.Errorf("short passwords should be rejected")
+	}
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(hash, "correct horse") || CheckPassword(hash, "wrong horse") {
+		t.Errorf("password check does not match the hash")
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	a, hashA, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _, _ := NewToken()
+	if a == b {
+		t.Errorf("tokens should be random")
+	}
+	if HashToken(a) != hashA || hashA == a {
+		t.Errorf("stored hash should be derived from, and differ from, the token")
+	}
+}
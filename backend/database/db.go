@@ -1,10 +1,14 @@
 package database
 
 import (
+	"context"
 	"fmt"
+	"gtfs-cms/auth"
 	"gtfs-cms/models"
+	"gtfs-cms/schedule"
 	"log"
 	"os"
+	"sort"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -81,50 +85,38 @@ func Connect() {
 		tx := DB.Begin()
 		var count int64 = 0
 
-		// Helper for time calculation
-		calculateTime := func(seq int) (string, string) {
-			baseTime := 8 * 60 // 08:00 in minutes
-			arrivalMin := baseTime + (seq * 5)
-			departureMin := arrivalMin + 5
+		// Group the old per-route stop lists so each trip gets a full sequence
+		stopsByRoute := make(map[uint][]RouteStop)
+		for _, rs := range oldStops {
+			stopsByRoute[rs.RouteID] = append(stopsByRoute[rs.RouteID], rs)
+		}
+
+		for _, t := range trips {
+			routeStops := stopsByRoute[t.RouteID]
+			sort.SliceStable(routeStops, func(i, j int) bool { return routeStops[i].Sequence < routeStops[j].Sequence })
 
-			excludeHours := func(m int) string {
-				h := (m / 60) % 24
-				min := m % 60
-				return fmt.Sprintf("%02d:%02d:00", h, min)
+			// Keep existing times; missing ones are interpolated along the shape
+			tripStops := make([]models.TripStop, len(routeStops))
+			for i, rs := range routeStops {
+				tripStops[i] = models.TripStop{
+					TripID:        t.ID,
+					StopID:        rs.StopID,
+					Sequence:      rs.Sequence,
+					ArrivalTime:   rs.ArrivalTime,
+					DepartureTime: rs.DepartureTime,
+				}
+			}
+			if err := schedule.InterpolateTrip(tx, t.ShapeID, tripStops, schedule.Options{}); err != nil {
+				tx.Rollback()
+				log.Fatalf("Migration failed: Could not interpolate times for trip %d: %v", t.ID, err)
 			}
-			return excludeHours(arrivalMin), excludeHours(departureMin)
-		}
 
-		for _, rs := range oldStops {
-			// Find all trips for this route
-			for _, t := range trips {
-				if t.RouteID == rs.RouteID {
-					arr, dep := calculateTime(rs.Sequence)
-
-					// Use existing if valid, else calculate
-					finalArr := rs.ArrivalTime
-					if finalArr == "" {
-						finalArr = arr
-					}
-					finalDep := rs.DepartureTime
-					if finalDep == "" {
-						finalDep = dep
-					}
-
-					newStop := models.TripStop{
-						TripID:        t.ID,
-						StopID:        rs.StopID,
-						Sequence:      rs.Sequence,
-						ArrivalTime:   finalArr,
-						DepartureTime: finalDep,
-					}
-
-					if err := tx.Create(&newStop).Error; err != nil {
-						tx.Rollback()
-						log.Fatalf("Migration failed: Could not create TripStop: %v", err)
-					}
-					count++
+			for _, newStop := range tripStops {
+				if err := tx.Create(&newStop).Error; err != nil {
+					tx.Rollback()
+					log.Fatalf("Migration failed: Could not create TripStop: %v", err)
 				}
+				count++
 			}
 		}
 		if err := tx.Commit().Error; err != nil {
@@ -149,24 +141,234 @@ func Connect() {
 		DB.Migrator().DropTable("route_stops")
 	}
 
-	err = DB.AutoMigrate(&models.Agency{}, &models.Stop{}, &models.Route{}, &models.Trip{}, &models.ShapePoint{}, &models.TripStop{}, &models.ActivityLog{}, &models.Setting{})
+	migrateWorkspaceKeys()
+
+	err = DB.AutoMigrate(&models.Workspace{}, &models.WorkspaceMember{}, &models.Agency{}, &models.Level{}, &models.Stop{}, &models.Pathway{}, &models.Transfer{}, &models.Route{}, &models.FareAttribute{}, &models.FareRule{}, &models.Area{}, &models.FareMedia{}, &models.FareProduct{}, &models.FareLegRule{}, &models.Trip{}, &models.ShapePoint{}, &models.TripStop{}, &models.ActivityLog{}, &models.Setting{}, &models.Calendar{}, &models.CalendarDate{}, &models.Frequency{}, &models.Alert{}, &models.AlertActivePeriod{}, &models.AlertInformedEntity{}, &models.User{}, &models.Session{}, &models.Snapshot{}, &models.Draft{}, &models.Webhook{}, &models.WebhookDelivery{})
 	if err != nil {
 		log.Fatal("Failed to migrate database!", err)
 	}
 
-	// Seed default settings if empty
+	// Keys that are now unique per workspace replace the global ones
+	for table, index := range map[string]string{"calendar_dates": "idx_calendar_date", "drafts": "idx_drafts_name"} {
+		if DB.Migrator().HasIndex(table, index) {
+			if err := DB.Migrator().DropIndex(table, index); err != nil {
+				log.Printf("Warning: Could not drop index %s: %v", index, err)
+			}
+		}
+	}
+
+	// Registered after migrating, so the draft_id and workspace_id columns
+	// exist once queries filter on them
+	if err := RegisterScopes(DB); err != nil {
+		log.Fatal("Failed to register query scopes!", err)
+	}
+
+	seedDefaultWorkspace()
+	if err := seedSettings(DB); err != nil {
+		log.Printf("Warning: Could not seed settings: %v", err)
+	}
+	seedCalendars()
+	seedAdmin()
+	seedMemberships()
+
+	log.Println("Database connected and migrated.")
+}
+
+// RegisterScopes installs the draft and workspace callbacks on db
+func RegisterScopes(db *gorm.DB) error {
+	if err := registerDraftScope(db); err != nil {
+		return fmt.Errorf("draft scope: %w", err)
+	}
+	if err := registerWorkspaceScope(db); err != nil {
+		return fmt.Errorf("workspace scope: %w", err)
+	}
+	return nil
+}
+
+// migrateWorkspaceKeys moves the primary keys of settings and calendars,
+// which were unique across the deployment, under their workspace before
+// AutoMigrate sees the tables. Existing rows join the default workspace.
+func migrateWorkspaceKeys() {
+	for _, table := range []string{"settings", "calendars"} {
+		if !DB.Migrator().HasTable(table) || DB.Migrator().HasColumn(table, "workspace_id") {
+			continue
+		}
+		log.Printf("Migration: Keying %s by workspace...", table)
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if table == "calendars" {
+				// The old foreign key needs the old primary key; AutoMigrate adds
+				// the workspace-wide one back
+				if err := tx.Exec("ALTER TABLE calendar_dates DROP CONSTRAINT IF EXISTS fk_calendars_dates").Error; err != nil {
+					return err
+				}
+			}
+			for _, stmt := range []string{
+				"ALTER TABLE %[1]s ADD COLUMN workspace_id bigint NOT NULL DEFAULT %[2]d",
+				"ALTER TABLE %[1]s DROP CONSTRAINT %[1]s_pkey",
+				"ALTER TABLE %[1]s ADD PRIMARY KEY (workspace_id, %[3]s)",
+			} {
+				key := "key"
+				if table == "calendars" {
+					key = "service_id"
+				}
+				if err := tx.Exec(fmt.Sprintf(stmt, table, models.DefaultWorkspaceID, key)).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Migration failed: Could not key %s by workspace: %v", table, err)
+		}
+	}
+}
+
+// seedDefaultWorkspace creates the workspace that data from before
+// workspaces belongs to
+func seedDefaultWorkspace() {
+	var count int64
+	DB.Model(&models.Workspace{}).Count(&count)
+	if count > 0 {
+		return
+	}
+	// The first row of an empty table gets ID 1, models.DefaultWorkspaceID
+	ws := models.Workspace{Name: "Default"}
+	if err := DB.Create(&ws).Error; err != nil {
+		log.Fatalf("Failed to create the default workspace: %v", err)
+	}
+	if ws.ID != models.DefaultWorkspaceID {
+		log.Fatalf("Default workspace was created with ID %d, expected %d", ws.ID, models.DefaultWorkspaceID)
+	}
+	log.Println("Default workspace created.")
+}
+
+// seedSettings stores the default settings in the workspace of db if it has
+// none yet
+func seedSettings(db *gorm.DB) error {
 	var count int64
-	DB.Model(&models.Setting{}).Count(&count)
+	db.Model(&models.Setting{}).Count(&count)
+	if count > 0 {
+		return nil
+	}
+	defaultSettings := []models.Setting{
+		{Key: "global_sign_style", Value: "standard"},
+		{Key: "dark_mode", Value: "false"},
+		{Key: "map_provider", Value: "carto"},
+		{Key: "autosave_delay", Value: "2000"},
+		{Key: "interpolation_speed_kmh", Value: "20"},
+	}
+	if err := db.Create(&defaultSettings).Error; err != nil {
+		return err
+	}
+	log.Printf("Default settings seeded for workspace %d.", WorkspaceOf(db.Statement.Context))
+	return nil
+}
+
+// SeedWorkspace gives a new workspace, the one db is scoped to, the default
+// settings and the default service calendar
+func SeedWorkspace(db *gorm.DB) error {
+	if err := seedSettings(db); err != nil {
+		return err
+	}
+	cal := models.Calendar{
+		ServiceID: models.DefaultServiceID,
+		Monday:    true, Tuesday: true, Wednesday: true, Thursday: true, Friday: true, Saturday: true, Sunday: true,
+		StartDate: "20250101",
+		EndDate:   "20261231",
+	}
+	return db.Create(&cal).Error
+}
+
+// seedCalendars makes sure every service_id used by a trip has a calendar.
+// Before calendars were stored, export wrote one implicit DAILY service for
+// all trips, so legacy services are back-filled with that same pattern.
+func seedCalendars() {
+	DB.Model(&models.Trip{}).Where("service_id = ?", "").Update("service_id", models.DefaultServiceID)
+
+	var serviceIDs []string
+	DB.Model(&models.Trip{}).Distinct().Pluck("service_id", &serviceIDs)
+
+	var count int64
+	DB.Model(&models.Calendar{}).Count(&count)
 	if count == 0 {
-		defaultSettings := []models.Setting{
-			{Key: "global_sign_style", Value: "standard"},
-			{Key: "dark_mode", Value: "false"},
-			{Key: "map_provider", Value: "carto"},
-			{Key: "autosave_delay", Value: "2000"},
+		serviceIDs = append(serviceIDs, models.DefaultServiceID)
+	}
+
+	for _, sid := range serviceIDs {
+		var exists int64
+		DB.Model(&models.Calendar{}).Where("service_id = ?", sid).Count(&exists)
+		if exists > 0 {
+			continue
+		}
+		cal := models.Calendar{
+			ServiceID: sid,
+			Monday:    true, Tuesday: true, Wednesday: true, Thursday: true, Friday: true, Saturday: true, Sunday: true,
+			StartDate: "20250101",
+			EndDate:   "20261231",
 		}
-		DB.Create(&defaultSettings)
-		log.Println("Default settings seeded.")
+		if err := DB.Create(&cal).Error; err != nil {
+			log.Printf("Warning: Could not create calendar for service %s: %v", sid, err)
+			continue
+		}
+		log.Printf("Calendar seeded for service %s.", sid)
 	}
+}
 
-	log.Println("Database connected and migrated.")
+// seedAdmin creates the first admin account on an empty users table from
+// ADMIN_EMAIL and ADMIN_PASSWORD. Without a password a random one is
+// generated and logged once, so a fresh install is never left open.
+func seedAdmin() {
+	var count int64
+	DB.Model(&models.User{}).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	email := os.Getenv("ADMIN_EMAIL")
+	if email == "" {
+		email = "admin@localhost"
+	}
+	password := os.Getenv("ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		token, _, err := auth.NewToken()
+		if err != nil {
+			log.Fatalf("Failed to generate admin password: %v", err)
+		}
+		password = token[:16]
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		log.Fatalf("ADMIN_PASSWORD is not usable: %v", err)
+	}
+	admin := models.User{Email: email, Name: "Administrator", Role: models.RoleAdmin, PasswordHash: hash}
+	if err := DB.Create(&admin).Error; err != nil {
+		log.Fatalf("Failed to create admin user: %v", err)
+	}
+	if generated {
+		log.Printf("Admin user %s created with password %s - change it after signing in.", email, password)
+	} else {
+		log.Printf("Admin user %s created.", email)
+	}
+}
+
+// seedMemberships makes the accounts from before workspaces members of the
+// default workspace, with the role they had
+func seedMemberships() {
+	var count int64
+	DB.WithContext(WithAllWorkspaces(context.Background())).Model(&models.WorkspaceMember{}).Count(&count)
+	if count > 0 {
+		return
+	}
+	var users []models.User
+	DB.Where("role <> ?", models.RoleAdmin).Find(&users)
+	for _, u := range users {
+		member := models.WorkspaceMember{UserID: u.ID, Role: u.Role}
+		if err := DB.Create(&member).Error; err != nil {
+			log.Printf("Warning: Could not add %s to the default workspace: %v", u.Email, err)
+		}
+	}
+	if len(users) > 0 {
+		log.Printf("Added %d users to the default workspace.", len(users))
+	}
 }
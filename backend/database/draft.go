@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Models with a DraftID field exist once in the published dataset (DraftID
+// 0) and once more in every draft. The callbacks below scope each statement
+// to the draft carried by its context, so code that does not know about
+// drafts keeps seeing only published rows.
+
+type draftKey struct{}
+
+type draftScope struct {
+	id  uint
+	all bool
+}
+
+// WithDraft scopes statements run with ctx to draft id; 0 is the published data
+func WithDraft(ctx context.Context, id uint) context.Context {
+	return context.WithValue(ctx, draftKey{}, draftScope{id: id})
+}
+
+// WithAllDrafts lifts the scoping, for changes that must reach every copy of
+// a row, such as removing a stop that drafts still reference. Rows created
+// under it keep the DraftID they were given.
+func WithAllDrafts(ctx context.Context) context.Context {
+	return context.WithValue(ctx, draftKey{}, draftScope{all: true})
+}
+
+// DraftOf returns the draft ctx is scoped to, 0 for published data
+func DraftOf(ctx context.Context) uint {
+	scope, _ := ctx.Value(draftKey{}).(draftScope)
+	return scope.id
+}
+
+func registerDraftScope(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Query().Before("gorm:query").Register("draft:scope", scopeToDraft); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("draft:scope", scopeToDraft); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("draft:scope", scopeToDraft); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("draft:scope", scopeToDraft); err != nil {
+		return err
+	}
+	return cb.Create().Before("gorm:create").Register("draft:assign", assignDraft)
+}
+
+func draftField(db *gorm.DB) (draftScope, bool) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.Schema.LookUpField("DraftID") == nil {
+		return draftScope{}, false
+	}
+	scope, _ := stmt.Context.Value(draftKey{}).(draftScope)
+	return scope, !scope.all
+}
+
+func scopeToDraft(db *gorm.DB) {
+	scope, ok := draftField(db)
+	if !ok {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: "draft_id"}, Value: scope.id},
+	}})
+}
+
+func assignDraft(db *gorm.DB) {
+	scope, ok := draftField(db)
+	if !ok {
+		return
+	}
+	field := db.Statement.Schema.LookUpField("DraftID")
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			db.AddError(field.Set(db.Statement.Context, reflect.Indirect(rv.Index(i)), scope.id))
+		}
+	case reflect.Struct:
+		db.AddError(field.Set(db.Statement.Context, rv, scope.id))
+	}
+}
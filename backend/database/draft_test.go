@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"gtfs-cms/models"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dryRun builds statements without a server, to check the SQL they produce
+func dryRun(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registerDraftScope(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDraftScope(t *testing.T) {
+	db := dryRun(t)
+
+	sql := db.Find(&[]models.Trip{}).Statement.SQL.String()
+	if !strings.Contains(sql, `"trips"."draft_id" = $1`) {
+		t.Errorf("published query should be scoped to draft 0, got %s", sql)
+	}
+
+	stmt := db.WithContext(WithDraft(context.Background(), 7)).Where("route_id = ?", 3).Delete(&models.TripStop{}).Statement
+	if !strings.Contains(stmt.SQL.String(), `"trip_stops"."draft_id" = $2`) || stmt.Vars[1] != uint(7) {
+		t.Errorf("draft delete should be scoped to draft 7, got %s %v", stmt.SQL.String(), stmt.Vars)
+	}
+
+	sql = db.WithContext(WithAllDrafts(context.Background())).Find(&[]models.Trip{}).Statement.SQL.String()
+	if strings.Contains(sql, "draft_id") {
+		t.Errorf("all-drafts query should not be scoped, got %s", sql)
+	}
+
+	sql = db.Find(&[]models.Stop{}).Statement.SQL.String()
+	if strings.Contains(sql, "draft_id") {
+		t.Errorf("stops are not drafted, got %s", sql)
+	}
+}
+
+func TestDraftAssign(t *testing.T) {
+	db := dryRun(t).WithContext(WithDraft(context.Background(), 4))
+	points := []models.ShapePoint{{ShapeID: "A", Sequence: 1}, {ShapeID: "A", Sequence: 2, DraftID: 9}}
+	db.Create(&points)
+	for _, p := range points {
+		if p.DraftID != 4 {
+			t.Errorf("created rows should belong to draft 4, got %d", p.DraftID)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"gtfs-cms/models"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Models with a WorkspaceID field belong to one workspace. Like the draft
+// scope, the callbacks below keep every statement inside the workspace
+// carried by its context; statements without one run against
+// models.DefaultWorkspaceID, so background jobs keep working on the data
+// that existed before workspaces.
+
+type workspaceKey struct{}
+
+type workspaceScope struct {
+	id  uint
+	all bool
+}
+
+// WithWorkspace scopes statements run with ctx to workspace id
+func WithWorkspace(ctx context.Context, id uint) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, workspaceScope{id: id})
+}
+
+// WithAllWorkspaces lifts the scoping, for jobs that serve every workspace
+// such as the webhook dispatcher. Rows created under it keep the
+// WorkspaceID they were given.
+func WithAllWorkspaces(ctx context.Context) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, workspaceScope{all: true})
+}
+
+// WorkspaceOf returns the workspace ctx is scoped to
+func WorkspaceOf(ctx context.Context) uint {
+	if scope, ok := ctx.Value(workspaceKey{}).(workspaceScope); ok && scope.id != 0 {
+		return scope.id
+	}
+	return models.DefaultWorkspaceID
+}
+
+func registerWorkspaceScope(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Query().Before("gorm:query").Register("workspace:scope", scopeToWorkspace); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("workspace:scope", scopeToWorkspace); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("workspace:scope", scopeToWorkspace); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("workspace:scope", scopeToWorkspace); err != nil {
+		return err
+	}
+	return cb.Create().Before("gorm:create").Register("workspace:assign", assignWorkspace)
+}
+
+func workspaceField(db *gorm.DB) (uint, bool) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.Schema.LookUpField("WorkspaceID") == nil {
+		return 0, false
+	}
+	scope, _ := stmt.Context.Value(workspaceKey{}).(workspaceScope)
+	return WorkspaceOf(stmt.Context), !scope.all
+}
+
+func scopeToWorkspace(db *gorm.DB) {
+	id, ok := workspaceField(db)
+	if !ok {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: "workspace_id"}, Value: id},
+	}})
+}
+
+func assignWorkspace(db *gorm.DB) {
+	id, ok := workspaceField(db)
+	if !ok {
+		return
+	}
+	field := db.Statement.Schema.LookUpField("WorkspaceID")
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			db.AddError(field.Set(db.Statement.Context, reflect.Indirect(rv.Index(i)), id))
+		}
+	case reflect.Struct:
+		db.AddError(field.Set(db.Statement.Context, rv, id))
+	}
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"gtfs-cms/models"
+	"strings"
+	"testing"
+)
+
+func TestWorkspaceScope(t *testing.T) {
+	db := dryRun(t)
+	if err := registerWorkspaceScope(db); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt := db.Find(&[]models.Trip{}).Statement
+	if !strings.Contains(stmt.SQL.String(), `"trips"."workspace_id" = $2`) || stmt.Vars[1] != uint(models.DefaultWorkspaceID) {
+		t.Errorf("unscoped query should run in the default workspace, got %s %v", stmt.SQL.String(), stmt.Vars)
+	}
+
+	stmt = db.WithContext(WithWorkspace(context.Background(), 3)).Model(&models.Stop{}).Where("id = ?", 5).Update("name", "Central").Statement
+	if !strings.Contains(stmt.SQL.String(), `"stops"."workspace_id" = $`) || stmt.Vars[len(stmt.Vars)-1] != uint(3) {
+		t.Errorf("update should be scoped to workspace 3, got %s %v", stmt.SQL.String(), stmt.Vars)
+	}
+
+	sql := db.WithContext(WithAllWorkspaces(context.Background())).Find(&[]models.WorkspaceMember{}).Statement.SQL.String()
+	if strings.Contains(sql, "workspace_id") {
+		t.Errorf("all-workspaces query should not be scoped, got %s", sql)
+	}
+
+	sql = db.Find(&[]models.User{}).Statement.SQL.String()
+	if strings.Contains(sql, "workspace_id") {
+		t.Errorf("users span workspaces, got %s", sql)
+	}
+}
+
+func TestWorkspaceAssign(t *testing.T) {
+	db := dryRun(t)
+	if err := registerWorkspaceScope(db); err != nil {
+		t.Fatal(err)
+	}
+	stops := []models.Stop{{Name: "A"}, {Name: "B", WorkspaceID: 9}}
+	db.WithContext(WithWorkspace(context.Background(), 2)).Create(&stops)
+	for _, s := range stops {
+		if s.WorkspaceID != 2 {
+			t.Errorf("created rows should belong to workspace 2, got %d", s.WorkspaceID)
+		}
+	}
+
+	member := models.WorkspaceMember{WorkspaceID: 5, UserID: 1, Role: models.RoleEditor}
+	db.WithContext(WithAllWorkspaces(context.Background())).Create(&member)
+	if member.WorkspaceID != 5 {
+		t.Errorf("rows created across workspaces should keep theirs, got %d", member.WorkspaceID)
+	}
+}
@@ -0,0 +1,441 @@
+// Package drafts copies the published routes, trips, stop times and shapes
+// into a draft, compares a draft with the published data and merges it back.
+//
+// Draft rows are copies with their own IDs. Routes and trips remember the
+// published row they were copied from in OriginID; stop times and shape
+// points are replaced as a whole per trip and per shape.
+package drafts
+
+import (
+	"fmt"
+	"gtfs-cms/models"
+	"reflect"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Set is the draftable part of the feed, either published or of one draft
+type Set struct {
+	Routes      []models.Route
+	Trips       []models.Trip
+	TripStops   []models.TripStop
+	ShapePoints []models.ShapePoint
+}
+
+// Load reads the set db is scoped to
+func Load(db *gorm.DB) (*Set, error) {
+	s := &Set{}
+	if err := db.Order("id").Find(&s.Routes).Error; err != nil {
+		return nil, fmt.Errorf("routes: %w", err)
+	}
+	if err := db.Order("id").Find(&s.Trips).Error; err != nil {
+		return nil, fmt.Errorf("trips: %w", err)
+	}
+	if err := db.Order("trip_id, sequence, id").Find(&s.TripStops).Error; err != nil {
+		return nil, fmt.Errorf("trip stops: %w", err)
+	}
+	if err := db.Order("shape_id, sequence, id").Find(&s.ShapePoints).Error; err != nil {
+		return nil, fmt.Errorf("shape points: %w", err)
+	}
+	return s, nil
+}
+
+// Copy inserts published into the draft tx is scoped to and returns the base
+// to store on the draft
+func Copy(tx *gorm.DB, published *Set) (models.DraftBase, error) {
+	base := models.DraftBase{RouteIDs: []uint{}, TripIDs: []uint{}, ShapeIDs: []string{}}
+
+	routeIDs := make(map[uint]uint, len(published.Routes))
+	for _, r := range published.Routes {
+		origin := r.ID
+		r.ID, r.OriginID = 0, &origin
+		if err := tx.Omit(clause.Associations).Create(&r).Error; err != nil {
+			return base, fmt.Errorf("copy route %d: %w", origin, err)
+		}
+		routeIDs[origin] = r.ID
+		base.RouteIDs = append(base.RouteIDs, origin)
+	}
+
+	tripIDs := make(map[uint]uint, len(published.Trips))
+	for _, t := range published.Trips {
+		origin := t.ID
+		t.ID, t.OriginID, t.Route = 0, &origin, models.Route{}
+		if id, ok := routeIDs[t.RouteID]; ok {
+			t.RouteID = id
+		}
+		if err := tx.Omit(clause.Associations).Create(&t).Error; err != nil {
+			return base, fmt.Errorf("copy trip %d: %w", origin, err)
+		}
+		tripIDs[origin] = t.ID
+		base.TripIDs = append(base.TripIDs, origin)
+	}
+
+	stops := make([]models.TripStop, 0, len(published.TripStops))
+	for _, ts := range published.TripStops {
+		id, ok := tripIDs[ts.TripID]
+		if !ok {
+			continue
+		}
+		ts.ID, ts.TripID, ts.Trip, ts.Stop = 0, id, models.Trip{}, models.Stop{}
+		stops = append(stops, ts)
+	}
+	if len(stops) > 0 {
+		if err := tx.Omit(clause.Associations).CreateInBatches(&stops, 500).Error; err != nil {
+			return base, fmt.Errorf("copy trip stops: %w", err)
+		}
+	}
+
+	points := make([]models.ShapePoint, len(published.ShapePoints))
+	seen := make(map[string]bool)
+	for i, p := range published.ShapePoints {
+		p.ID = 0
+		points[i] = p
+		if !seen[p.ShapeID] {
+			seen[p.ShapeID] = true
+			base.ShapeIDs = append(base.ShapeIDs, p.ShapeID)
+		}
+	}
+	if len(points) > 0 {
+		if err := tx.CreateInBatches(&points, 500).Error; err != nil {
+			return base, fmt.Errorf("copy shape points: %w", err)
+		}
+	}
+	return base, nil
+}
+
+// Changes lists what a merge does to one kind of row. Created holds draft
+// IDs, Updated and Deleted hold published IDs.
+type Changes struct {
+	Created []uint `json:"created"`
+	Updated []uint `json:"updated"`
+	Deleted []uint `json:"deleted"`
+}
+
+// ShapeChanges lists shapes by shape_id
+type ShapeChanges struct {
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	Deleted []string `json:"deleted"`
+}
+
+// Plan is the review of a draft: everything merging it would change
+type Plan struct {
+	Routes Changes      `json:"routes"`
+	Trips  Changes      `json:"trips"`
+	Shapes ShapeChanges `json:"shapes"`
+}
+
+// Empty reports whether merging would change nothing
+func (p Plan) Empty() bool {
+	return len(p.Routes.Created)+len(p.Routes.Updated)+len(p.Routes.Deleted)+
+		len(p.Trips.Created)+len(p.Trips.Updated)+len(p.Trips.Deleted)+
+		len(p.Shapes.Created)+len(p.Shapes.Updated)+len(p.Shapes.Deleted) == 0
+}
+
+// stopTime is the part of a TripStop a merge compares and copies
+type stopTime struct {
+	StopID        uint
+	Sequence      int
+	ArrivalTime   string
+	DepartureTime string
+}
+
+type shapePoint struct {
+	Lat, Lon float64
+	Sequence int
+}
+
+func stopTimesByTrip(stops []models.TripStop) map[uint][]stopTime {
+	out := make(map[uint][]stopTime)
+	for _, ts := range stops {
+		out[ts.TripID] = append(out[ts.TripID], stopTime{ts.StopID, ts.Sequence, ts.ArrivalTime, ts.DepartureTime})
+	}
+	for _, list := range out {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Sequence < list[j].Sequence })
+	}
+	return out
+}
+
+func pointsByShape(points []models.ShapePoint) map[string][]shapePoint {
+	out := make(map[string][]shapePoint)
+	for _, p := range points {
+		out[p.ShapeID] = append(out[p.ShapeID], shapePoint{p.Lat, p.Lon, p.Sequence})
+	}
+	for _, list := range out {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Sequence < list[j].Sequence })
+	}
+	return out
+}
+
+// routeFields drops what differs between a draft copy and its origin anyway.
+// Versions count edits on each side separately, so they are dropped too.
+func routeFields(r models.Route) models.Route {
+	r.ID, r.DraftID, r.OriginID, r.Version = 0, 0, nil, 0
+	return r
+}
+
+func tripFields(t models.Trip) models.Trip {
+	t.ID, t.DraftID, t.OriginID, t.Version, t.Route = 0, 0, nil, 0, models.Route{}
+	return t
+}
+
+// Compare works out what merging draft into published would change. base
+// tells rows deleted in the draft from rows published since it was copied.
+func Compare(base models.DraftBase, published, draft *Set) Plan {
+	plan := Plan{
+		Routes: Changes{Created: []uint{}, Updated: []uint{}, Deleted: []uint{}},
+		Trips:  Changes{Created: []uint{}, Updated: []uint{}, Deleted: []uint{}},
+		Shapes: ShapeChanges{Created: []string{}, Updated: []string{}, Deleted: []string{}},
+	}
+
+	pubRoutes := make(map[uint]models.Route)
+	for _, r := range published.Routes {
+		pubRoutes[r.ID] = r
+	}
+	routeTarget := make(map[uint]uint) // draft route ID -> published route ID it merges into
+	keptRoutes := make(map[uint]bool)
+	for _, r := range draft.Routes {
+		if r.OriginID == nil {
+			plan.Routes.Created = append(plan.Routes.Created, r.ID)
+			continue
+		}
+		pub, ok := pubRoutes[*r.OriginID]
+		if !ok {
+			// Removed from the published data since the draft was made
+			plan.Routes.Created = append(plan.Routes.Created, r.ID)
+			continue
+		}
+		routeTarget[r.ID] = pub.ID
+		keptRoutes[pub.ID] = true
+		if !reflect.DeepEqual(routeFields(r), routeFields(pub)) {
+			plan.Routes.Updated = append(plan.Routes.Updated, pub.ID)
+		}
+	}
+	for _, id := range base.RouteIDs {
+		if _, ok := pubRoutes[id]; ok && !keptRoutes[id] {
+			plan.Routes.Deleted = append(plan.Routes.Deleted, id)
+		}
+	}
+
+	pubTrips := make(map[uint]models.Trip)
+	for _, t := range published.Trips {
+		pubTrips[t.ID] = t
+	}
+	pubStops, draftStops := stopTimesByTrip(published.TripStops), stopTimesByTrip(draft.TripStops)
+	keptTrips := make(map[uint]bool)
+	for _, t := range draft.Trips {
+		var pub models.Trip
+		ok := false
+		if t.OriginID != nil {
+			pub, ok = pubTrips[*t.OriginID]
+		}
+		if !ok {
+			plan.Trips.Created = append(plan.Trips.Created, t.ID)
+			continue
+		}
+		keptTrips[pub.ID] = true
+		fields := tripFields(t)
+		if id, ok := routeTarget[t.RouteID]; ok {
+			fields.RouteID = id
+		} else if _, ok := pubRoutes[t.RouteID]; !ok {
+			fields.RouteID = 0 // moved to a route created in the draft
+		}
+		if !reflect.DeepEqual(fields, tripFields(pub)) || !reflect.DeepEqual(draftStops[t.ID], pubStops[pub.ID]) {
+			plan.Trips.Updated = append(plan.Trips.Updated, pub.ID)
+		}
+	}
+	for _, id := range base.TripIDs {
+		if _, ok := pubTrips[id]; ok && !keptTrips[id] {
+			plan.Trips.Deleted = append(plan.Trips.Deleted, id)
+		}
+	}
+
+	pubShapes, draftShapes := pointsByShape(published.ShapePoints), pointsByShape(draft.ShapePoints)
+	for id, points := range draftShapes {
+		pub, ok := pubShapes[id]
+		switch {
+		case !ok:
+			plan.Shapes.Created = append(plan.Shapes.Created, id)
+		case !reflect.DeepEqual(points, pub):
+			plan.Shapes.Updated = append(plan.Shapes.Updated, id)
+		}
+	}
+	for _, id := range base.ShapeIDs {
+		if _, ok := draftShapes[id]; !ok {
+			if _, ok := pubShapes[id]; ok {
+				plan.Shapes.Deleted = append(plan.Shapes.Deleted, id)
+			}
+		}
+	}
+
+	for _, ids := range [][]uint{plan.Routes.Created, plan.Routes.Updated, plan.Routes.Deleted, plan.Trips.Created, plan.Trips.Updated, plan.Trips.Deleted} {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	for _, ids := range [][]string{plan.Shapes.Created, plan.Shapes.Updated, plan.Shapes.Deleted} {
+		sort.Strings(ids)
+	}
+	return plan
+}
+
+// Merge applies plan to the published data through pub and then removes the
+// draft's rows through draft. Both must share one transaction.
+func Merge(pub, draft *gorm.DB, plan Plan, published, staged *Set) error {
+	contains := func(ids []uint, id uint) bool {
+		for _, v := range ids {
+			if v == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Updated rows get a version past the published one, so ETags handed out
+	// before the merge go stale
+	routeVersions, tripVersions := make(map[uint]uint), make(map[uint]uint)
+	for _, r := range published.Routes {
+		routeVersions[r.ID] = r.Version
+	}
+	for _, t := range published.Trips {
+		tripVersions[t.ID] = t.Version
+	}
+
+	// Routes: update in place or insert, remembering where each draft route went
+	routeTarget := make(map[uint]uint)
+	for _, r := range staged.Routes {
+		row := routeFields(r)
+		if r.OriginID != nil && !contains(plan.Routes.Created, r.ID) {
+			routeTarget[r.ID] = *r.OriginID
+			if !contains(plan.Routes.Updated, *r.OriginID) {
+				continue
+			}
+			row.ID, row.Version = *r.OriginID, routeVersions[*r.OriginID]+1
+			if err := pub.Omit(clause.Associations).Save(&row).Error; err != nil {
+				return fmt.Errorf("update route %d: %w", row.ID, err)
+			}
+			continue
+		}
+		if err := pub.Omit(clause.Associations).Create(&row).Error; err != nil {
+			return fmt.Errorf("create route from draft route %d: %w", r.ID, err)
+		}
+		routeTarget[r.ID] = row.ID
+	}
+
+	// Trips and their stop times
+	draftStops := make(map[uint][]models.TripStop)
+	for _, ts := range staged.TripStops {
+		draftStops[ts.TripID] = append(draftStops[ts.TripID], ts)
+	}
+	for _, t := range staged.Trips {
+		row := tripFields(t)
+		if id, ok := routeTarget[t.RouteID]; ok {
+			row.RouteID = id
+		}
+		var target uint
+		switch {
+		case t.OriginID != nil && !contains(plan.Trips.Created, t.ID):
+			target = *t.OriginID
+			if !contains(plan.Trips.Updated, target) {
+				continue
+			}
+			row.ID, row.Version = target, tripVersions[target]+1
+			if err := pub.Omit(clause.Associations).Save(&row).Error; err != nil {
+				return fmt.Errorf("update trip %d: %w", target, err)
+			}
+		default:
+			if err := pub.Omit(clause.Associations).Create(&row).Error; err != nil {
+				return fmt.Errorf("create trip from draft trip %d: %w", t.ID, err)
+			}
+			target = row.ID
+		}
+
+		if err := pub.Where("trip_id = ?", target).Delete(&models.TripStop{}).Error; err != nil {
+			return fmt.Errorf("clear stop times of trip %d: %w", target, err)
+		}
+		stops := make([]models.TripStop, 0, len(draftStops[t.ID]))
+		for _, ts := range draftStops[t.ID] {
+			ts.ID, ts.TripID, ts.DraftID, ts.Trip, ts.Stop = 0, target, 0, models.Trip{}, models.Stop{}
+			stops = append(stops, ts)
+		}
+		if len(stops) > 0 {
+			if err := pub.Omit(clause.Associations).CreateInBatches(&stops, 500).Error; err != nil {
+				return fmt.Errorf("copy stop times of trip %d: %w", target, err)
+			}
+		}
+	}
+
+	for _, id := range plan.Trips.Deleted {
+		if err := pub.Where("trip_id = ?", id).Delete(&models.TripStop{}).Error; err != nil {
+			return fmt.Errorf("delete stop times of trip %d: %w", id, err)
+		}
+		if err := pub.Where("trip_id = ?", id).Delete(&models.Frequency{}).Error; err != nil {
+			return fmt.Errorf("delete frequencies of trip %d: %w", id, err)
+		}
+		if err := pub.Where("from_trip_id = ? OR to_trip_id = ?", id, id).Delete(&models.Transfer{}).Error; err != nil {
+			return fmt.Errorf("delete transfers of trip %d: %w", id, err)
+		}
+		if err := pub.Where("trip_id = ?", id).Delete(&models.AlertInformedEntity{}).Error; err != nil {
+			return fmt.Errorf("delete alert entities of trip %d: %w", id, err)
+		}
+		if err := pub.Delete(&models.Trip{}, id).Error; err != nil {
+			return fmt.Errorf("delete trip %d: %w", id, err)
+		}
+	}
+	for _, id := range plan.Routes.Deleted {
+		var remaining int64
+		if err := pub.Model(&models.Trip{}).Where("route_id = ?", id).Count(&remaining).Error; err != nil {
+			return err
+		}
+		if remaining > 0 {
+			return fmt.Errorf("route %d was deleted in the draft but still has %d published trips added since", id, remaining)
+		}
+		if err := pub.Where("from_route_id = ? OR to_route_id = ?", id, id).Delete(&models.Transfer{}).Error; err != nil {
+			return fmt.Errorf("delete transfers of route %d: %w", id, err)
+		}
+		if err := pub.Where("route_id = ?", id).Delete(&models.FareRule{}).Error; err != nil {
+			return fmt.Errorf("delete fare rules of route %d: %w", id, err)
+		}
+		if err := pub.Where("route_id = ?", id).Delete(&models.AlertInformedEntity{}).Error; err != nil {
+			return fmt.Errorf("delete alert entities of route %d: %w", id, err)
+		}
+		if err := pub.Delete(&models.Route{}, id).Error; err != nil {
+			return fmt.Errorf("delete route %d: %w", id, err)
+		}
+	}
+
+	// Shapes are replaced point for point
+	draftPoints := make(map[string][]models.ShapePoint)
+	for _, p := range staged.ShapePoints {
+		draftPoints[p.ShapeID] = append(draftPoints[p.ShapeID], p)
+	}
+	replace := append(append([]string{}, plan.Shapes.Created...), plan.Shapes.Updated...)
+	for _, id := range append(replace, plan.Shapes.Deleted...) {
+		if err := pub.Where("shape_id = ?", id).Delete(&models.ShapePoint{}).Error; err != nil {
+			return fmt.Errorf("clear shape %s: %w", id, err)
+		}
+	}
+	for _, id := range replace {
+		points := make([]models.ShapePoint, len(draftPoints[id]))
+		for i, p := range draftPoints[id] {
+			p.ID, p.DraftID = 0, 0
+			points[i] = p
+		}
+		if err := pub.CreateInBatches(&points, 500).Error; err != nil {
+			return fmt.Errorf("copy shape %s: %w", id, err)
+		}
+	}
+
+	return Discard(draft)
+}
+
+// Discard deletes every row of the draft tx is scoped to
+func Discard(tx *gorm.DB) error {
+	all := tx.Session(&gorm.Session{AllowGlobalUpdate: true})
+	for _, m := range []interface{}{&models.TripStop{}, &models.Trip{}, &models.ShapePoint{}, &models.Route{}} {
+		if err := all.Delete(m).Error; err != nil {
+			return fmt.Errorf("discard %T: %w", m, err)
+		}
+	}
+	return nil
+}
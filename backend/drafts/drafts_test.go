@@ -0,0 +1,81 @@
+package drafts
+
+import (
+	"gtfs-cms/models"
+	"reflect"
+	"testing"
+)
+
+func ptr(v uint) *uint { return &v }
+
+// published has routes 1-2, trips 10-11 and shape S; the draft copy starts
+// out identical with its own IDs
+func fixture() (models.DraftBase, *Set, *Set) {
+	published := &Set{
+		Routes: []models.Route{{ID: 1, ShortName: "1"}, {ID: 2, ShortName: "2"}},
+		Trips:  []models.Trip{{ID: 10, RouteID: 1, ShapeID: "S"}, {ID: 11, RouteID: 2}},
+		TripStops: []models.TripStop{
+			{ID: 100, TripID: 10, StopID: 7, Sequence: 1, ArrivalTime: "08:00:00"},
+			{ID: 101, TripID: 10, StopID: 8, Sequence: 2, ArrivalTime: "08:05:00"},
+		},
+		ShapePoints: []models.ShapePoint{{ID: 1000, ShapeID: "S", Lat: 1, Lon: 1, Sequence: 1}},
+	}
+	draft := &Set{
+		Routes: []models.Route{{ID: 21, ShortName: "1", DraftID: 5, OriginID: ptr(1)}, {ID: 22, ShortName: "2", DraftID: 5, OriginID: ptr(2)}},
+		Trips:  []models.Trip{{ID: 30, RouteID: 21, ShapeID: "S", DraftID: 5, OriginID: ptr(10)}, {ID: 31, RouteID: 22, DraftID: 5, OriginID: ptr(11)}},
+		TripStops: []models.TripStop{
+			{ID: 200, TripID: 30, StopID: 7, Sequence: 1, ArrivalTime: "08:00:00", DraftID: 5},
+			{ID: 201, TripID: 30, StopID: 8, Sequence: 2, ArrivalTime: "08:05:00", DraftID: 5},
+		},
+		ShapePoints: []models.ShapePoint{{ID: 2000, ShapeID: "S", Lat: 1, Lon: 1, Sequence: 1, DraftID: 5}},
+	}
+	base := models.DraftBase{RouteIDs: []uint{1, 2}, TripIDs: []uint{10, 11}, ShapeIDs: []string{"S"}}
+	return base, published, draft
+}
+
+func TestCompareUnchangedCopy(t *testing.T) {
+	base, published, draft := fixture()
+	if plan := Compare(base, published, draft); !plan.Empty() {
+		t.Errorf("a fresh copy should merge to nothing, got %+v", plan)
+	}
+
+	// Saving a row without changes only bumps its version
+	draft.Routes[0].Version, draft.Trips[0].Version = 2, 3
+	if plan := Compare(base, published, draft); !plan.Empty() {
+		t.Errorf("version bumps alone should merge to nothing, got %+v", plan)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base, published, draft := fixture()
+	draft.Routes[0].LongName = "Harbour line"                                         // route 1 updated
+	draft.TripStops[1].ArrivalTime = "08:07:00"                                       // trip 10 retimed
+	draft.Trips = draft.Trips[:1]                                                     // trip 11 deleted
+	draft.Routes = append(draft.Routes, models.Route{ID: 23, ShortName: "3"})         // new route
+	draft.Trips = append(draft.Trips, models.Trip{ID: 32, RouteID: 23, ShapeID: "T"}) // new trip on it
+	draft.ShapePoints = []models.ShapePoint{{ShapeID: "T", Lat: 2, Lon: 2, Sequence: 1}}
+
+	// Published after the draft was made, so not a deletion
+	published.Routes = append(published.Routes, models.Route{ID: 3, ShortName: "X"})
+
+	got := Compare(base, published, draft)
+	want := Plan{
+		Routes: Changes{Created: []uint{23}, Updated: []uint{1}, Deleted: []uint{}},
+		Trips:  Changes{Created: []uint{32}, Updated: []uint{10}, Deleted: []uint{11}},
+		Shapes: ShapeChanges{Created: []string{"T"}, Updated: []string{}, Deleted: []string{"S"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCompareTripMovedToNewRoute(t *testing.T) {
+	base, published, draft := fixture()
+	draft.Routes = append(draft.Routes, models.Route{ID: 23, ShortName: "3"})
+	draft.Trips[1].RouteID = 23
+
+	got := Compare(base, published, draft)
+	if !reflect.DeepEqual(got.Trips.Updated, []uint{11}) {
+		t.Errorf("a trip moved to a new route should be updated, got %+v", got.Trips)
+	}
+}
@@ -0,0 +1,276 @@
+// Package events fans out changes to the feed to everyone who has the CMS
+// open, together with who is currently editing what.
+//
+// Events are kept in a short history so a client that reconnects with the ID
+// of the last event it saw gets what it missed. Subscribers that fall behind
+// are dropped rather than slowing down the handlers that publish; they
+// reconnect and catch up from the history.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"gtfs-cms/models"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	TypeChange   = "change"   // an entity was created, updated or deleted
+	TypeActivity = "activity" // an event not tied to one entity, such as an import
+	TypePresence = "presence" // someone started or stopped editing something
+)
+
+// Presence operations
+const (
+	PresenceEnter = "ENTER"
+	PresenceLeave = "LEAVE"
+)
+
+// Event is one message of the stream
+type Event struct {
+	ID          uint64               `json:"id"`
+	Type        string               `json:"type"`
+	Entity      string               `json:"entity,omitempty"`
+	EntityID    string               `json:"entity_id,omitempty"`
+	Operation   string               `json:"operation"`         // CREATE, UPDATE, DELETE, the activity action or a presence operation
+	Payload     interface{}          `json:"payload,omitempty"` // the entity after the change, or before a delete
+	Changes     []models.FieldChange `json:"changes,omitempty"`
+	Details     string               `json:"details,omitempty"`
+	LogID       uint                 `json:"log_id,omitempty"` // the ActivityLog entry of the change
+	UserEmail   string               `json:"user_email,omitempty"`
+	DraftID     uint                 `json:"draft_id,omitempty"` // 0 for published data
+	WorkspaceID uint                 `json:"workspace_id"`
+	Time        time.Time            `json:"time"`
+}
+
+// FromActivity turns an activity log entry into the event announcing it
+func FromActivity(entry models.ActivityLog) Event {
+	e := Event{
+		Type:        TypeActivity,
+		Entity:      entry.EntityType,
+		EntityID:    entry.EntityID,
+		Operation:   entry.Action,
+		Changes:     entry.Changes,
+		Details:     entry.Details,
+		LogID:       entry.ID,
+		UserEmail:   entry.UserEmail,
+		WorkspaceID: entry.WorkspaceID,
+		Time:        entry.Timestamp,
+	}
+	if entry.EntityType != "" {
+		e.Type = TypeChange
+		e.Payload = entry.After
+		if e.Payload == nil {
+			e.Payload = entry.Before
+		}
+	}
+	if entry.DraftID != nil {
+		e.DraftID = *entry.DraftID
+	}
+	return e
+}
+
+// WriteSSE writes e in the Server-Sent Events format, named after its type
+func (e Event) WriteSSE(w io.Writer) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// Presence is someone editing an entity
+type Presence struct {
+	UserEmail   string    `json:"user_email"`
+	Entity      string    `json:"entity"`
+	EntityID    string    `json:"entity_id"`
+	DraftID     uint      `json:"draft_id,omitempty"`
+	WorkspaceID uint      `json:"workspace_id"`
+	Since       time.Time `json:"since"`
+	seen        time.Time
+}
+
+// Subscription receives the events of one stream on C. C is closed when the
+// subscriber is dropped for falling behind.
+type Subscription struct {
+	C         <-chan Event
+	ch        chan Event
+	workspace uint
+	draft     uint
+}
+
+// wants reports whether s sees e: events stay inside their workspace, and
+// draft changes only reach the subscribers of that draft. A subscription to
+// workspace 0 sees the published changes of every workspace.
+func (s *Subscription) wants(e Event) bool {
+	return (s.workspace == 0 || e.WorkspaceID == s.workspace) && (e.DraftID == 0 || e.DraftID == s.draft)
+}
+
+// DefaultPresenceTTL is how long presence lasts without being renewed
+const DefaultPresenceTTL = time.Minute
+
+// Broker delivers published events to subscribers
+type Broker struct {
+	PresenceTTL time.Duration
+
+	mu       sync.Mutex
+	lastID   uint64
+	history  []Event
+	keep     int
+	subs     map[*Subscription]struct{}
+	presence map[string]Presence // by user email
+}
+
+// Default is the broker fed by the activity log and read by the event stream
+var Default = NewBroker(500)
+
+// NewBroker keeps the last history events for reconnecting subscribers
+func NewBroker(history int) *Broker {
+	return &Broker{
+		PresenceTTL: DefaultPresenceTTL,
+		keep:        history,
+		subs:        make(map[*Subscription]struct{}),
+		presence:    make(map[string]Presence),
+	}
+}
+
+// subscriberBuffer is how many events a subscriber may lag behind
+const subscriberBuffer = 64
+
+// Subscribe starts a stream of the published data of a workspace, or of a
+// draft together with the published data. With a lastID it also returns the
+// events since that are still in the history.
+func (b *Broker) Subscribe(workspace, draft uint, lastID uint64) (*Subscription, []Event) {
+	ch := make(chan Event, subscriberBuffer)
+	s := &Subscription{C: ch, ch: ch, workspace: workspace, draft: draft}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[s] = struct{}{}
+	var missed []Event
+	if lastID > 0 && lastID <= b.lastID {
+		for _, e := range b.history {
+			if e.ID > lastID && s.wants(e) {
+				missed = append(missed, e)
+			}
+		}
+	}
+	return s, missed
+}
+
+// Unsubscribe ends a stream; it is safe to call for dropped subscribers
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Publish numbers e, stamps it if needed and delivers it
+func (b *Broker) Publish(e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.publish(e)
+}
+
+func (b *Broker) publish(e Event) Event {
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.history = append(b.history, e)
+	if len(b.history) > b.keep {
+		b.history = b.history[len(b.history)-b.keep:]
+	}
+	for s := range b.subs {
+		if !s.wants(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+	return e
+}
+
+// SetPresence records that p.UserEmail is editing p.Entity. Moving to
+// another entity announces leaving the previous one; renewing the same
+// presence only extends it.
+func (b *Broker) SetPresence(p Presence, now time.Time) Presence {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(now)
+	prev, ok := b.presence[p.UserEmail]
+	if ok && prev.Entity == p.Entity && prev.EntityID == p.EntityID && prev.DraftID == p.DraftID && prev.WorkspaceID == p.WorkspaceID {
+		prev.seen = now
+		b.presence[p.UserEmail] = prev
+		return prev
+	}
+	if ok {
+		b.publish(presenceEvent(prev, PresenceLeave, now))
+	}
+	p.Since, p.seen = now, now
+	b.presence[p.UserEmail] = p
+	b.publish(presenceEvent(p, PresenceEnter, now))
+	return p
+}
+
+// ClearPresence records that email stopped editing
+func (b *Broker) ClearPresence(email string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p, ok := b.presence[email]; ok {
+		delete(b.presence, email)
+		b.publish(presenceEvent(p, PresenceLeave, now))
+	}
+	b.prune(now)
+}
+
+// Presence lists who is editing what in a workspace, by user, after
+// dropping presence that was not renewed in time
+func (b *Broker) Presence(workspace uint, now time.Time) []Presence {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(now)
+	out := make([]Presence, 0, len(b.presence))
+	for _, p := range b.presence {
+		if p.WorkspaceID == workspace {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UserEmail < out[j].UserEmail })
+	return out
+}
+
+func (b *Broker) prune(now time.Time) {
+	for email, p := range b.presence {
+		if b.PresenceTTL > 0 && now.Sub(p.seen) > b.PresenceTTL {
+			delete(b.presence, email)
+			b.publish(presenceEvent(p, PresenceLeave, now))
+		}
+	}
+}
+
+func presenceEvent(p Presence, op string, now time.Time) Event {
+	return Event{
+		Type:        TypePresence,
+		Entity:      p.Entity,
+		EntityID:    p.EntityID,
+		Operation:   op,
+		UserEmail:   p.UserEmail,
+		DraftID:     p.DraftID,
+		WorkspaceID: p.WorkspaceID,
+		Time:        now,
+	}
+}
@@ -0,0 +1,135 @@
+package events
+
+import (
+	"bytes"
+	"gtfs-cms/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublishReachesSubscribersOfItsDraft(t *testing.T) {
+	b := NewBroker(10)
+	published, _ := b.Subscribe(0, 0, 0)
+	draft, _ := b.Subscribe(0, 4, 0)
+
+	b.Publish(Event{Type: TypeChange, Entity: "stop", EntityID: "1", Operation: "UPDATE"})
+	b.Publish(Event{Type: TypeChange, Entity: "route", EntityID: "9", Operation: "UPDATE", DraftID: 4})
+
+	if got := len(published.C); got != 1 {
+		t.Errorf("published subscriber got %d events, want only the published change", got)
+	}
+	if got := len(draft.C); got != 2 {
+		t.Errorf("draft subscriber got %d events, want the published and the draft change", got)
+	}
+	if e := <-published.C; e.ID != 1 || e.Entity != "stop" || e.Time.IsZero() {
+		t.Errorf("first event = %+v, want stop change numbered 1 with a time", e)
+	}
+}
+
+func TestSubscribersOnlySeeTheirWorkspace(t *testing.T) {
+	b := NewBroker(10)
+	first, _ := b.Subscribe(1, 0, 0)
+	second, _ := b.Subscribe(2, 0, 0)
+	all, _ := b.Subscribe(0, 0, 0)
+
+	b.Publish(Event{Type: TypeChange, Entity: "stop", Operation: "UPDATE", WorkspaceID: 1})
+	b.Publish(Event{Type: TypeChange, Entity: "stop", Operation: "UPDATE", WorkspaceID: 2})
+	b.Publish(Event{Type: TypeChange, Entity: "stop", Operation: "UPDATE", WorkspaceID: 2})
+
+	if len(first.C) != 1 || len(second.C) != 2 || len(all.C) != 3 {
+		t.Errorf("got %d, %d and %d events, want 1 for workspace 1, 2 for workspace 2 and 3 unfiltered", len(first.C), len(second.C), len(all.C))
+	}
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	b.SetPresence(Presence{WorkspaceID: 2, UserEmail: "ana@example.com", Entity: "trip", EntityID: "7"}, now)
+	if got := b.Presence(1, now); len(got) != 0 {
+		t.Errorf("Presence(1) = %+v, want nobody from workspace 2", got)
+	}
+	if got := b.Presence(2, now); len(got) != 1 {
+		t.Errorf("Presence(2) = %+v, want ana", got)
+	}
+}
+
+func TestSubscribeReplaysMissedEvents(t *testing.T) {
+	b := NewBroker(2)
+	for i := 0; i < 3; i++ {
+		b.Publish(Event{Type: TypeActivity, Operation: "IMPORT"})
+	}
+	_, missed := b.Subscribe(0, 0, 1)
+	if len(missed) != 2 || missed[0].ID != 2 || missed[1].ID != 3 {
+		t.Errorf("missed = %+v, want events 2 and 3", missed)
+	}
+	// IDs from before a restart are unknown and replay nothing
+	if _, missed := b.Subscribe(0, 0, 40); len(missed) != 0 {
+		t.Errorf("missed = %+v, want none for an unknown ID", missed)
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	b := NewBroker(1)
+	s, _ := b.Subscribe(0, 0, 0)
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish(Event{Type: TypeActivity})
+	}
+	n := 0
+	for range s.C {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("received %d events before being dropped, want %d", n, subscriberBuffer)
+	}
+	b.Unsubscribe(s) // already dropped, must not panic
+}
+
+func TestPresence(t *testing.T) {
+	b := NewBroker(10)
+	b.PresenceTTL = time.Minute
+	s, _ := b.Subscribe(0, 0, 0)
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	b.SetPresence(Presence{UserEmail: "ana@example.com", Entity: "trip", EntityID: "7"}, now)
+	b.SetPresence(Presence{UserEmail: "ana@example.com", Entity: "trip", EntityID: "7"}, now.Add(30*time.Second))
+	if got := b.Presence(0, now.Add(80*time.Second)); len(got) != 1 || got[0].Since != now {
+		t.Errorf("Presence() = %+v, want ana on trip 7 since the first call", got)
+	}
+	b.SetPresence(Presence{UserEmail: "ana@example.com", Entity: "trip", EntityID: "8"}, now.Add(90*time.Second))
+	if got := b.Presence(0, now.Add(200*time.Second)); len(got) != 0 {
+		t.Errorf("Presence() = %+v, want it expired", got)
+	}
+
+	var ops []string
+	for len(s.C) > 0 {
+		e := <-s.C
+		ops = append(ops, e.Operation+" "+e.EntityID)
+	}
+	want := "ENTER 7,LEAVE 7,ENTER 8,LEAVE 8"
+	if got := strings.Join(ops, ","); got != want {
+		t.Errorf("presence events = %s, want %s", got, want)
+	}
+}
+
+func TestFromActivity(t *testing.T) {
+	draft := uint(3)
+	e := FromActivity(models.ActivityLog{
+		ID: 12, Action: "DELETE", EntityType: "stop", EntityID: "5",
+		Before: map[string]interface{}{"name": "Central"}, DraftID: &draft,
+	})
+	if e.Type != TypeChange || e.Operation != "DELETE" || e.LogID != 12 || e.DraftID != 3 || e.Payload == nil {
+		t.Errorf("FromActivity() = %+v, want a stop delete in draft 3 carrying the deleted stop", e)
+	}
+	if e := FromActivity(models.ActivityLog{Action: "IMPORT"}); e.Type != TypeActivity {
+		t.Errorf("FromActivity() type = %s, want %s", e.Type, TypeActivity)
+	}
+}
+
+func TestWriteSSE(t *testing.T) {
+	var buf bytes.Buffer
+	e := Event{ID: 4, Type: TypeChange, Operation: "CREATE", Time: time.Unix(0, 0).UTC()}
+	if err := e.WriteSSE(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	if !strings.HasPrefix(got, "id: 4\nevent: change\ndata: {") || !strings.HasSuffix(got, "}\n\n") {
+		t.Errorf("WriteSSE() = %q", got)
+	}
+}
@@ -0,0 +1,167 @@
+package geometry
+
+import (
+	"math"
+	"sort"
+)
+
+// earthRadius is the mean Earth radius in metres
+const earthRadius = 6371008.8
+
+// Point is a WGS84 coordinate
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+func toRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// Haversine returns the great-circle distance between a and b in metres
+func Haversine(a, b Point) float64 {
+	dLat := toRad(b.Lat - a.Lat)
+	dLon := toRad(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Lat))*math.Cos(toRad(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// CumulativeDistances returns the distance in metres from the first point to
+// every point of the polyline.
+func CumulativeDistances(line []Point) []float64 {
+	dists := make([]float64, len(line))
+	for i := 1; i < len(line); i++ {
+		dists[i] = dists[i-1] + Haversine(line[i-1], line[i])
+	}
+	return dists
+}
+
+// projectOnSegment returns the fraction t along a->b of the point closest to
+// p, and the distance from p to that point in metres. Over the length of one
+// shape segment an equirectangular projection is accurate enough.
+func projectOnSegment(a, b, p Point) (float64, float64) {
+	cosLat := math.Cos(toRad((a.Lat + b.Lat) / 2))
+	ax, ay := 0.0, 0.0
+	bx, by := toRad(b.Lon-a.Lon)*cosLat, toRad(b.Lat-a.Lat)
+	px, py := toRad(p.Lon-a.Lon)*cosLat, toRad(p.Lat-a.Lat)
+
+	dx, dy := bx-ax, by-ay
+	lenSq := dx*dx + dy*dy
+	t := 0.0
+	if lenSq > 0 {
+		t = math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/lenSq))
+	}
+	cx, cy := ax+t*dx, ay+t*dy
+	return t, math.Hypot(px-cx, py-cy) * earthRadius
+}
+
+// Nearest returns the distance along line of the point on the line closest
+// to p, and how far p is from it, both in metres.
+func Nearest(line []Point, cum []float64, p Point) (float64, float64) {
+	switch len(line) {
+	case 0:
+		return 0, math.Inf(1)
+	case 1:
+		return 0, Haversine(line[0], p)
+	}
+	best, bestOffset := math.Inf(1), 0.0
+	for i := 0; i < len(line)-1; i++ {
+		t, d := projectOnSegment(line[i], line[i+1], p)
+		if d < best {
+			best = d
+			bestOffset = cum[i] + t*(cum[i+1]-cum[i])
+		}
+	}
+	return bestOffset, best
+}
+
+// ProjectSequence projects an ordered list of points onto line so that their
+// distances along it never decrease. On loop routes a location visited twice
+// is matched to the pass that fits the order of the other points, instead of
+// both visits snapping to the first pass.
+func ProjectSequence(line []Point, cum []float64, pts []Point) []float64 {
+	out := make([]float64, len(pts))
+	if len(line) < 2 || len(pts) == 0 {
+		return out
+	}
+	segments := len(line) - 1
+
+	// offset[k][j] and cost[k][j]: stop k projected onto segment j
+	offset := make([][]float64, len(pts))
+	choice := make([][]int, len(pts))
+	prev := make([]float64, segments)
+	for k, p := range pts {
+		offset[k] = make([]float64, segments)
+		choice[k] = make([]int, segments)
+		cur := make([]float64, segments)
+
+		// Running minimum over prev[0..j] lets every stop pick a segment at or
+		// after the one chosen for the stop before it
+		bestPrev, bestPrevIdx := math.Inf(1), 0
+		for j := 0; j < segments; j++ {
+			t, d := projectOnSegment(line[j], line[j+1], p)
+			offset[k][j] = cum[j] + t*(cum[j+1]-cum[j])
+			if k == 0 {
+				cur[j] = d
+				continue
+			}
+			if prev[j] < bestPrev {
+				bestPrev, bestPrevIdx = prev[j], j
+			}
+			cur[j] = d + bestPrev
+			choice[k][j] = bestPrevIdx
+		}
+		prev = cur
+	}
+
+	// Walk back from the cheapest final segment
+	j := 0
+	for i := 1; i < segments; i++ {
+		if prev[i] < prev[j] {
+			j = i
+		}
+	}
+	for k := len(pts) - 1; k >= 0; k-- {
+		out[k] = offset[k][j]
+		j = choice[k][j]
+	}
+
+	// Two stops on the same segment may still land slightly out of order
+	for k := 1; k < len(out); k++ {
+		if out[k] < out[k-1] {
+			out[k] = out[k-1]
+		}
+	}
+	return out
+}
+
+// PointAt returns the point at distance dist along line, clamped to its ends
+func PointAt(line []Point, cum []float64, dist float64) Point {
+	if len(line) == 0 {
+		return Point{}
+	}
+	if dist <= 0 || len(line) == 1 {
+		return line[0]
+	}
+	if dist >= cum[len(cum)-1] {
+		return line[len(line)-1]
+	}
+	i := sort.SearchFloat64s(cum, dist)
+	a, b := line[i-1], line[i]
+	span := cum[i] - cum[i-1]
+	if span == 0 {
+		return b
+	}
+	t := (dist - cum[i-1]) / span
+	return Point{Lat: a.Lat + t*(b.Lat-a.Lat), Lon: a.Lon + t*(b.Lon-a.Lon)}
+}
+
+// Bearing returns the initial compass bearing from a to b in degrees
+func Bearing(a, b Point) float64 {
+	lat1, lat2 := toRad(a.Lat), toRad(b.Lat)
+	dLon := toRad(b.Lon - a.Lon)
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
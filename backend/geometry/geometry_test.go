@@ -0,0 +1,42 @@
+package geometry
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHaversine(t *testing.T) {
+	// One degree of latitude is roughly 111.2 km
+	d := Haversine(Point{Lat: 0, Lon: 0}, Point{Lat: 1, Lon: 0})
+	if math.Abs(d-111195) > 50 {
+		t.Errorf("Haversine() = %.0f, want about 111195", d)
+	}
+}
+
+func TestProjectSequenceOnLoop(t *testing.T) {
+	// A square loop that starts and ends at the same corner
+	line := []Point{{0, 0}, {0, 0.01}, {0.01, 0.01}, {0.01, 0}, {0, 0}}
+	cum := CumulativeDistances(line)
+
+	// The terminal is visited at the start and again at the end of the loop
+	stops := []Point{{0, 0}, {0.005, 0.01}, {0.005, 0}, {0, 0}}
+	got := ProjectSequence(line, cum, stops)
+
+	total := cum[len(cum)-1]
+	if got[0] > 1 {
+		t.Errorf("first visit = %.0f, want 0", got[0])
+	}
+	if math.Abs(got[3]-total) > 1 {
+		t.Errorf("second visit = %.0f, want %.0f (end of loop)", got[3], total)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] < got[i-1] {
+			t.Errorf("distances must not decrease: %v", got)
+		}
+	}
+
+	// Independent projection snaps both visits to the start of the loop
+	if along, _ := Nearest(line, cum, stops[3]); along > 1 {
+		t.Errorf("Nearest() is expected to pick the first pass")
+	}
+}
@@ -0,0 +1,18 @@
+package gtfs
+
+import (
+	"fmt"
+	"time"
+)
+
+// DateLayout is the YYYYMMDD format used by calendar.txt and calendar_dates.txt
+const DateLayout = "20060102"
+
+// ParseDate parses a GTFS service date
+func ParseDate(s string) (time.Time, error) {
+	t, err := time.Parse(DateLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid GTFS date %q, expected YYYYMMDD", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,48 @@
+package gtfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseTime converts a GTFS time string (H:MM:SS or HH:MM:SS) into seconds
+// since the start of the service day. Hours may exceed 23 for trips that run
+// past midnight.
+func ParseTime(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid GTFS time %q", s)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 {
+		return 0, fmt.Errorf("invalid hour in GTFS time %q", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("invalid minute in GTFS time %q", s)
+	}
+	sec, err := strconv.Atoi(parts[2])
+	if err != nil || sec < 0 || sec > 59 || len(parts[2]) != 2 {
+		return 0, fmt.Errorf("invalid second in GTFS time %q", s)
+	}
+	return h*3600 + m*60 + sec, nil
+}
+
+// FormatTime converts seconds since the start of the service day back into
+// the zero-padded HH:MM:SS form used in stop_times.txt.
+func FormatTime(secs int) string {
+	if secs < 0 {
+		secs = 0
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", secs/3600, (secs%3600)/60, secs%60)
+}
+
+// NormalizeTime re-formats a valid GTFS time into zero-padded HH:MM:SS.
+func NormalizeTime(s string) (string, error) {
+	secs, err := ParseTime(s)
+	if err != nil {
+		return "", err
+	}
+	return FormatTime(secs), nil
+}
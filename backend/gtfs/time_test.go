@@ -0,0 +1,38 @@
+package gtfs
+
+import "testing"
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"08:00:00", 8 * 3600, false},
+		{"8:05:30", 8*3600 + 5*60 + 30, false},
+		{"25:10:00", 25*3600 + 10*60, false},
+		{"08:60:00", 0, true},
+		{"08:00", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTime(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTime(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTime(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	if got := FormatTime(25*3600 + 61); got != "25:01:01" {
+		t.Errorf("FormatTime() = %s, want 25:01:01", got)
+	}
+	if got, _ := NormalizeTime("7:05:00"); got != "07:05:00" {
+		t.Errorf("NormalizeTime() = %s, want 07:05:00", got)
+	}
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gtfs-cms/database"
+	"gtfs-cms/events"
+	"gtfs-cms/models"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Activity Logs ---
+
+// Actions of structured entity changes
+const (
+	ActionCreate = "CREATE"
+	ActionUpdate = "UPDATE"
+	ActionDelete = "DELETE"
+)
+
+// LogActivity records an event that is not tied to a single entity, such as
+// an import or export. c may be nil outside of a request.
+func LogActivity(c *gin.Context, action string, details string) {
+	writeActivity(c, models.ActivityLog{Action: action, Details: details})
+}
+
+// LogChange records a create, update or delete of one entity. before is nil
+// for creates and after is nil for deletes.
+func LogChange(c *gin.Context, action, entityType string, entityID interface{}, before, after interface{}, details string) {
+	entry := models.ActivityLog{
+		Action:     action,
+		Details:    details,
+		EntityType: entityType,
+		EntityID:   fmt.Sprint(entityID),
+		Before:     toJSONValue(before),
+		After:      toJSONValue(after),
+	}
+	entry.Changes = diffFields(entry.Before, entry.After)
+	writeActivity(c, entry)
+}
+
+func writeActivity(c *gin.Context, entry models.ActivityLog) {
+	entry.Timestamp = time.Now()
+	db := database.DB
+	if c != nil {
+		db = workspaceDB(c)
+		if user, ok := currentUser(c); ok {
+			entry.UserID = &user.ID
+			entry.UserEmail = user.Email
+		}
+		if draft, ok := currentDraft(c); ok {
+			entry.DraftID = &draft.ID
+		}
+	}
+	entry.WorkspaceID = database.WorkspaceOf(db.Statement.Context)
+	if err := db.Create(&entry).Error; err != nil {
+		fmt.Printf("Error logging activity: %v\n", err)
+	}
+	// The change happened even if logging it failed, so announce it anyway
+	events.Default.Publish(events.FromActivity(entry))
+}
+
+// toJSONValue turns a model into the generic form it has in the API, so the
+// log shows exactly the field names clients see
+func toJSONValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil
+	}
+	return out
+}
+
+// diffFields lists the top-level fields whose values differ. Creates and
+// deletes are compared against an empty object so every field shows up.
+func diffFields(before, after interface{}) []models.FieldChange {
+	b, bOK := before.(map[string]interface{})
+	a, aOK := after.(map[string]interface{})
+	if !bOK && !aOK {
+		if before == nil && after == nil || reflect.DeepEqual(before, after) {
+			return nil
+		}
+		// Lists such as a trip's stops are compared as a whole
+		return []models.FieldChange{{Field: "*", Before: before, After: after}}
+	}
+
+	keys := make(map[string]bool)
+	for k := range b {
+		keys[k] = true
+	}
+	for k := range a {
+		keys[k] = true
+	}
+	var changes []models.FieldChange
+	for k := range keys {
+		if k == "updated_at" || k == "created_at" || k == "version" {
+			continue
+		}
+		if !reflect.DeepEqual(b[k], a[k]) {
+			changes = append(changes, models.FieldChange{Field: k, Before: b[k], After: a[k]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// stopTimeRows keeps the fields of a trip's stop list that matter in a log,
+// without the preloaded stop and trip
+func stopTimeRows(stops []models.TripStop) []gin.H {
+	rows := make([]gin.H, len(stops))
+	for i, ts := range stops {
+		rows[i] = gin.H{"stop_id": ts.StopID, "sequence": ts.Sequence, "arrival_time": ts.ArrivalTime, "departure_time": ts.DepartureTime}
+	}
+	return rows
+}
+
+// shapeRows logs a shape as [lat, lon] pairs in sequence order
+func shapeRows(points []models.ShapePoint) [][2]float64 {
+	rows := make([][2]float64, len(points))
+	for i, p := range points {
+		rows[i] = [2]float64{p.Lat, p.Lon}
+	}
+	return rows
+}
+
+// parseFilterTime accepts RFC 3339 or a plain YYYY-MM-DD date
+func parseFilterTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or YYYY-MM-DD", s)
+}
+
+// GetActivityLogs lists log entries newest first. Filters: entity_type,
+// entity_id, user_id, user (email), action, draft_id, since and until. Pages
+// are requested with limit and the cursor from the previous X-Next-Cursor
+// header.
+func GetActivityLogs(c *gin.Context) {
+	limit, cursor, err := pageParams(c, 50, 500)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := workspaceDB(c).Model(&models.ActivityLog{})
+	for param, column := range map[string]string{
+		"entity_type": "entity_type",
+		"entity_id":   "entity_id",
+		"user_id":     "user_id",
+		"user":        "user_email",
+		"action":      "action",
+		"draft_id":    "draft_id",
+	} {
+		if v := c.Query(param); v != "" {
+			query = query.Where(column+" = ?", v)
+		}
+	}
+	for param, op := range map[string]string{"since": ">=", "until": "<"} {
+		if v := c.Query(param); v != "" {
+			t, err := parseFilterTime(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": param + ": " + err.Error()})
+				return
+			}
+			query = query.Where("timestamp "+op+" ?", t)
+		}
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count activity logs: " + err.Error()})
+		return
+	}
+	if cursor != "" {
+		beforeID, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		query = query.Where("id < ?", beforeID)
+	}
+
+	var logs []models.ActivityLog
+	if err := query.Order("id desc").Limit(limit).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch activity logs: " + err.Error()})
+		return
+	}
+	next := ""
+	if len(logs) == limit {
+		next = strconv.FormatUint(uint64(logs[len(logs)-1].ID), 10)
+	}
+	setPageHeaders(c, total, next)
+	c.JSON(http.StatusOK, logs)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"gtfs-cms/models"
+	"testing"
+)
+
+func TestDiffFields(t *testing.T) {
+	before := toJSONValue(models.Stop{ID: 1, Name: "Central", Lat: 1, Lon: 2})
+	after := toJSONValue(models.Stop{ID: 1, Name: "Central Station", Lat: 1, Lon: 2})
+
+	got := diffFields(before, after)
+	if len(got) != 1 || got[0].Field != "name" || got[0].Before != "Central" || got[0].After != "Central Station" {
+		t.Errorf("diffFields() = %+v, want only the name change", got)
+	}
+	if got := diffFields(before, before); len(got) != 0 {
+		t.Errorf("diffFields() of equal values = %+v, want none", got)
+	}
+}
+
+func TestDiffFieldsCreateAndLists(t *testing.T) {
+	created := diffFields(nil, toJSONValue(models.Agency{ID: 3, Name: "Metro"}))
+	found := false
+	for _, ch := range created {
+		if ch.Field == "name" && ch.Before == nil && ch.After == "Metro" {
+			found = true
+		}
+		if ch.Field == "created_at" || ch.Field == "updated_at" {
+			t.Errorf("timestamps should not be diffed, got %q", ch.Field)
+		}
+	}
+	if !found {
+		t.Errorf("diffFields() of a create = %+v, want every field set", created)
+	}
+
+	rows := diffFields(toJSONValue([][2]float64{{1, 2}}), toJSONValue([][2]float64{{1, 2}, {3, 4}}))
+	if len(rows) != 1 || rows[0].Field != "*" {
+		t.Errorf("diffFields() of lists = %+v, want one whole-value change", rows)
+	}
+}
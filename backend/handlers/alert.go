@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/models"
+	"gtfs-cms/realtime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Alerts ---
+
+func validateAlert(db *gorm.DB, a *models.Alert) error {
+	if _, ok := realtime.Causes[a.Cause]; !ok {
+		return fmt.Errorf("unknown cause %q", a.Cause)
+	}
+	if _, ok := realtime.Effects[a.Effect]; !ok {
+		return fmt.Errorf("unknown effect %q", a.Effect)
+	}
+	if len(a.HeaderText) == 0 {
+		return fmt.Errorf("header_text needs at least one translation")
+	}
+	for _, p := range a.ActivePeriods {
+		if p.Start != nil && p.End != nil && !p.End.After(*p.Start) {
+			return fmt.Errorf("active period ends before it starts")
+		}
+	}
+	if len(a.InformedEntities) == 0 {
+		return fmt.Errorf("an alert needs at least one informed entity")
+	}
+
+	exists := func(model interface{}, id *uint) bool {
+		if id == nil {
+			return true
+		}
+		var count int64
+		db.Model(model).Where("id = ?", *id).Count(&count)
+		return count > 0
+	}
+	for _, e := range a.InformedEntities {
+		if e.AgencyID == nil && e.RouteID == nil && e.TripID == nil && e.StopID == nil {
+			return fmt.Errorf("informed entities need an agency_id, route_id, trip_id or stop_id")
+		}
+		if !exists(&models.Agency{}, e.AgencyID) || !exists(&models.Route{}, e.RouteID) ||
+			!exists(&models.Trip{}, e.TripID) || !exists(&models.Stop{}, e.StopID) {
+			return fmt.Errorf("informed entity references a record that does not exist")
+		}
+	}
+	return nil
+}
+
+// GetAlerts lists alerts. Filters: active=true (now), route_id, trip_id, stop_id, agency_id.
+func GetAlerts(c *gin.Context) {
+	query := workspaceDB(c).Preload("ActivePeriods").Preload("InformedEntities").Order("id desc")
+	for _, column := range []string{"agency_id", "route_id", "trip_id", "stop_id"} {
+		if v := c.Query(column); v != "" {
+			query = query.Where("id IN (?)", workspaceDB(c).Model(&models.AlertInformedEntity{}).Select("alert_id").Where(column+" = ?", v))
+		}
+	}
+
+	var alerts []models.Alert
+	if err := query.Find(&alerts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alerts: " + err.Error()})
+		return
+	}
+
+	if active, _ := strconv.ParseBool(c.Query("active")); active {
+		now := time.Now()
+		filtered := []models.Alert{}
+		for _, a := range alerts {
+			if realtime.FromModel(a).ActiveAt(now) {
+				filtered = append(filtered, a)
+			}
+		}
+		alerts = filtered
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+func GetAlert(c *gin.Context) {
+	var alert models.Alert
+	if err := workspaceDB(c).Preload("ActivePeriods").Preload("InformedEntities").First(&alert, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+	c.JSON(http.StatusOK, alert)
+}
+
+func CreateAlert(c *gin.Context) {
+	var alert models.Alert
+	if err := c.ShouldBindJSON(&alert); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	alert.ID = 0
+	for i := range alert.ActivePeriods {
+		alert.ActivePeriods[i].ID = 0
+	}
+	for i := range alert.InformedEntities {
+		alert.InformedEntities[i].ID = 0
+	}
+	if err := validateAlert(workspaceDB(c), &alert); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Create(&alert).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create alert: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "alert", alert.ID, nil, alert, fmt.Sprintf("New %s alert #%d has been published.", alert.Effect, alert.ID))
+	c.JSON(http.StatusOK, alert)
+}
+
+// UpdateAlert replaces an alert including its periods and informed entities
+func UpdateAlert(c *gin.Context) {
+	var before models.Alert
+	if err := workspaceDB(c).Preload("ActivePeriods").Preload("InformedEntities").First(&before, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+	// Bind onto a separate copy so the maps of before are not merged into
+	var alert models.Alert
+	workspaceDB(c).First(&alert, before.ID)
+	alertID, createdAt := alert.ID, alert.CreatedAt
+	if err := c.ShouldBindJSON(&alert); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	alert.ID, alert.CreatedAt = alertID, createdAt
+	for i := range alert.ActivePeriods {
+		alert.ActivePeriods[i].ID = 0
+		alert.ActivePeriods[i].AlertID = alertID
+	}
+	for i := range alert.InformedEntities {
+		alert.InformedEntities[i].ID = 0
+		alert.InformedEntities[i].AlertID = alertID
+	}
+	if err := validateAlert(workspaceDB(c), &alert); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := workspaceDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("alert_id = ?", alertID).Delete(&models.AlertActivePeriod{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("alert_id = ?", alertID).Delete(&models.AlertInformedEntity{}).Error; err != nil {
+			return err
+		}
+		return tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(&alert).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alert: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "alert", alert.ID, before, alert, fmt.Sprintf("Alert #%d has been updated.", alert.ID))
+	c.JSON(http.StatusOK, alert)
+}
+
+func DeleteAlert(c *gin.Context) {
+	id := c.Param("id")
+	var before models.Alert
+	workspaceDB(c).Preload("ActivePeriods").Preload("InformedEntities").First(&before, id)
+	tx := workspaceDB(c).Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
+		return
+	}
+	if err := tx.Where("alert_id = ?", id).Delete(&models.AlertActivePeriod{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alert periods"})
+		return
+	}
+	if err := tx.Where("alert_id = ?", id).Delete(&models.AlertInformedEntity{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alert entities"})
+		return
+	}
+	result := tx.Delete(&models.Alert{}, id)
+	if result.Error != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alert"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+	LogChange(c, ActionDelete, "alert", id, before, nil, fmt.Sprintf("Alert #%s has been withdrawn.", id))
+	c.JSON(http.StatusOK, gin.H{"message": "Alert deleted"})
+}
@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/auth"
+	"gtfs-cms/database"
+	"gtfs-cms/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Authentication ---
+
+// userContextKey is where Authenticate stores the signed-in user
+const userContextKey = "user"
+
+// Authenticate resolves the bearer token of the request, if any, to a user.
+// It never rejects a request; RequireRole does that for guarded routes.
+// Browsers cannot set headers on an event stream, so those may pass the
+// token as the access_token query parameter instead.
+func Authenticate(c *gin.Context) {
+	header := c.GetHeader("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok && c.GetHeader("Accept") == "text/event-stream" {
+		token, ok = c.Query("access_token"), true
+	}
+	if !ok || token == "" {
+		c.Next()
+		return
+	}
+	var session models.Session
+	err := database.DB.Preload("User").
+		Where("token_hash = ? AND expires_at > ?", auth.HashToken(token), time.Now()).
+		First(&session).Error
+	if err == nil {
+		c.Set(userContextKey, session.User)
+	}
+	c.Next()
+}
+
+// RequireRole only lets users with at least role in the request's workspace
+// through, see WorkspaceScope
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := currentUser(c); !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		if !auth.Allows(currentRole(c), role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("This action requires the %s role in this workspace", role)})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSignIn lets any signed-in user through, member of the request's
+// workspace or not
+func RequireSignIn(c *gin.Context) {
+	if _, ok := currentUser(c); !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	c.Next()
+}
+
+// RequireSiteAdmin only lets admin accounts through, for what spans
+// workspaces: users and the workspaces themselves
+func RequireSiteAdmin(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	if user.Role != models.RoleAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This action requires an admin account"})
+		return
+	}
+	c.Next()
+}
+
+func currentUser(c *gin.Context) (models.User, bool) {
+	v, ok := c.Get(userContextKey)
+	if !ok {
+		return models.User{}, false
+	}
+	user, ok := v.(models.User)
+	return user, ok
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func Login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var user models.User
+	if err := database.DB.Where("LOWER(email) = ?", strings.ToLower(strings.TrimSpace(req.Email))).First(&user).Error; err != nil ||
+		!auth.CheckPassword(user.PasswordHash, req.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	session := models.Session{TokenHash: hash, UserID: user.ID, ExpiresAt: time.Now().Add(auth.SessionTTL)}
+	if err := database.DB.Create(&session).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session: " + err.Error()})
+		return
+	}
+	// Expired sessions are only useful as clutter
+	database.DB.Where("expires_at <= ?", time.Now()).Delete(&models.Session{})
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": session.ExpiresAt, "user": user})
+}
+
+func Logout(c *gin.Context) {
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		database.DB.Where("token_hash = ?", auth.HashToken(token)).Delete(&models.Session{})
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// CurrentUser is the signed-in user as returned by /auth/me
+type CurrentUser struct {
+	models.User
+	Workspace     models.Workspace `json:"workspace"`
+	WorkspaceRole string           `json:"workspace_role"`
+}
+
+// GetCurrentUser returns the signed-in user with the workspace the request
+// landed in and their role there
+func GetCurrentUser(c *gin.Context) {
+	user, _ := currentUser(c)
+	ws, _ := currentWorkspace(c)
+	c.JSON(http.StatusOK, CurrentUser{User: user, Workspace: ws, WorkspaceRole: currentRole(c)})
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+func ChangePassword(c *gin.Context) {
+	user, _ := currentUser(c)
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !auth.CheckPassword(user.PasswordHash, req.CurrentPassword) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("password_hash", hash).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+	// Sign out every other device that knew the old password
+	if err := tx.Where("user_id = ? AND token_hash <> ?", user.ID, auth.HashToken(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))).Delete(&models.Session{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+	LogActivity(c, "SECURITY", fmt.Sprintf("User %s has changed their password.", user.Email))
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated"})
+}
+
+// --- Users ---
+
+// UserRequest creates or updates a user; Password is optional on update
+type UserRequest struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Password string `json:"password"`
+}
+
+// otherAdmins counts admins besides userID, so the last one cannot lock everyone out
+func otherAdmins(userID uint) int64 {
+	var count int64
+	database.DB.Model(&models.User{}).Where("role = ? AND id <> ?", models.RoleAdmin, userID).Count(&count)
+	return count
+}
+
+func GetUsers(c *gin.Context) {
+	var users []models.User
+	if err := database.DB.Order("email asc").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func CreateUser(c *gin.Context) {
+	var req UserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := models.User{Email: strings.ToLower(strings.TrimSpace(req.Email)), Name: req.Name, Role: req.Role}
+	if user.Role == "" {
+		user.Role = models.RoleViewer
+	}
+	if user.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+	if !auth.ValidRole(user.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown role %q", user.Role)})
+		return
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user.PasswordHash = hash
+
+	var existing int64
+	database.DB.Model(&models.User{}).Where("LOWER(email) = ?", user.Email).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A user with email %s already exists", user.Email)})
+		return
+	}
+	// Everyone but admins works in the workspaces they are a member of,
+	// starting with the one they were created from
+	tx := database.DB.Begin()
+	if err := tx.Create(&user).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user: " + err.Error()})
+		return
+	}
+	if user.Role != models.RoleAdmin {
+		member := models.WorkspaceMember{UserID: user.ID, Role: user.Role}
+		if err := tx.WithContext(c.Request.Context()).Create(&member).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add user to workspace: " + err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+	LogChange(c, ActionCreate, "user", user.ID, nil, user, fmt.Sprintf("User %s has been created with the %s role.", user.Email, user.Role))
+	c.JSON(http.StatusOK, user)
+}
+
+func UpdateUser(c *gin.Context) {
+	var user models.User
+	if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	before := user
+	var req UserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name != "" {
+		user.Name = req.Name
+	}
+	if req.Role != "" && req.Role != user.Role {
+		if !auth.ValidRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown role %q", req.Role)})
+			return
+		}
+		if user.Role == models.RoleAdmin && otherAdmins(user.ID) == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "The last admin cannot be demoted"})
+			return
+		}
+		user.Role = req.Role
+	}
+	revoke := false
+	if req.Password != "" {
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user.PasswordHash = hash
+		revoke = true
+	}
+	if err := database.DB.Save(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user: " + err.Error()})
+		return
+	}
+	if revoke {
+		database.DB.Where("user_id = ?", user.ID).Delete(&models.Session{})
+	}
+	LogChange(c, ActionUpdate, "user", user.ID, before, user, fmt.Sprintf("User %s has been updated (role %s).", user.Email, user.Role))
+	c.JSON(http.StatusOK, user)
+}
+
+func DeleteUser(c *gin.Context) {
+	var user models.User
+	if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == models.RoleAdmin && otherAdmins(user.ID) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "The last admin cannot be deleted"})
+		return
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.Session{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	if err := tx.WithContext(database.WithAllWorkspaces(c.Request.Context())).Where("user_id = ?", user.ID).Delete(&models.WorkspaceMember{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove memberships"})
+		return
+	}
+	if err := tx.Delete(&user).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+	LogChange(c, ActionDelete, "user", user.ID, user, nil, fmt.Sprintf("User %s has been removed.", user.Email))
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+}
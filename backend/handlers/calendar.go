@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/gtfs"
+	"gtfs-cms/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Calendar ---
+
+func validateCalendar(cal *models.Calendar) error {
+	cal.ServiceID = strings.TrimSpace(cal.ServiceID)
+	if cal.ServiceID == "" {
+		return fmt.Errorf("service_id is required")
+	}
+	start, err := gtfs.ParseDate(cal.StartDate)
+	if err != nil {
+		return fmt.Errorf("start_date: %w", err)
+	}
+	end, err := gtfs.ParseDate(cal.EndDate)
+	if err != nil {
+		return fmt.Errorf("end_date: %w", err)
+	}
+	if end.Before(start) {
+		return fmt.Errorf("end_date %s is before start_date %s", cal.EndDate, cal.StartDate)
+	}
+	return nil
+}
+
+func validateCalendarDate(d *models.CalendarDate) error {
+	if _, err := gtfs.ParseDate(d.Date); err != nil {
+		return err
+	}
+	if d.ExceptionType != 1 && d.ExceptionType != 2 {
+		return fmt.Errorf("exception_type must be 1 (added) or 2 (removed)")
+	}
+	return nil
+}
+
+// serviceExists reports whether trips may reference serviceID
+func serviceExists(db *gorm.DB, serviceID string) bool {
+	var count int64
+	db.Model(&models.Calendar{}).Where("service_id = ?", serviceID).Count(&count)
+	return count > 0
+}
+
+func GetCalendars(c *gin.Context) {
+	var calendars []models.Calendar
+	if err := workspaceDB(c).Preload("Dates").Order("service_id asc").Find(&calendars).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch calendars: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, calendars)
+}
+
+func CreateCalendar(c *gin.Context) {
+	var cal models.Calendar
+	if err := c.ShouldBindJSON(&cal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateCalendar(&cal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range cal.Dates {
+		cal.Dates[i].ServiceID = cal.ServiceID
+		if err := validateCalendarDate(&cal.Dates[i]); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if serviceExists(workspaceDB(c), cal.ServiceID) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Service %s already exists", cal.ServiceID)})
+		return
+	}
+	if err := workspaceDB(c).Create(&cal).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "calendar", cal.ServiceID, nil, cal, fmt.Sprintf("New service calendar [%s] has been registered.", cal.ServiceID))
+	c.JSON(http.StatusOK, cal)
+}
+
+func UpdateCalendar(c *gin.Context) {
+	serviceID := c.Param("service_id")
+	var cal models.Calendar
+	if err := workspaceDB(c).First(&cal, "service_id = ?", serviceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+	before := cal
+	if err := c.ShouldBindJSON(&cal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// The service_id is the key trips point at, so it cannot be renamed here
+	cal.ServiceID = serviceID
+	cal.Dates = nil
+	if err := validateCalendar(&cal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Save(&cal).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update calendar: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "calendar", cal.ServiceID, before, cal, fmt.Sprintf("Service calendar [%s] has been updated.", cal.ServiceID))
+	c.JSON(http.StatusOK, cal)
+}
+
+func DeleteCalendar(c *gin.Context) {
+	serviceID := c.Param("service_id")
+
+	// Calendars are shared by every draft, so trips in drafts count too
+	var tripCount int64
+	if err := allDraftsDB(c).Model(&models.Trip{}).Where("service_id = ?", serviceID).Count(&tripCount).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check calendar usage"})
+		return
+	}
+	if tripCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Service %s is still used by %d trips, counting drafts. Reassign them before deleting.", serviceID, tripCount)})
+		return
+	}
+
+	var before models.Calendar
+	workspaceDB(c).Preload("Dates").First(&before, "service_id = ?", serviceID)
+
+	tx := workspaceDB(c).Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
+		return
+	}
+	if err := tx.Where("service_id = ?", serviceID).Delete(&models.CalendarDate{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete calendar dates"})
+		return
+	}
+	result := tx.Where("service_id = ?", serviceID).Delete(&models.Calendar{})
+	if result.Error != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete calendar"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+	LogChange(c, ActionDelete, "calendar", serviceID, before, nil, fmt.Sprintf("Service calendar [%s] has been removed.", serviceID))
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar deleted"})
+}
+
+// --- Calendar Dates ---
+
+func GetCalendarDates(c *gin.Context) {
+	var dates []models.CalendarDate
+	if err := workspaceDB(c).Where("service_id = ?", c.Param("service_id")).Order("date asc").Find(&dates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch calendar dates: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, dates)
+}
+
+func CreateCalendarDate(c *gin.Context) {
+	serviceID := c.Param("service_id")
+	if !serviceExists(workspaceDB(c), serviceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+	var d models.CalendarDate
+	if err := c.ShouldBindJSON(&d); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	d.ID = 0
+	d.ServiceID = serviceID
+	if err := validateCalendarDate(&d); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var existing int64
+	workspaceDB(c).Model(&models.CalendarDate{}).Where("service_id = ? AND date = ?", serviceID, d.Date).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Service %s already has an exception on %s", serviceID, d.Date)})
+		return
+	}
+	if err := workspaceDB(c).Create(&d).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar date: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "calendar_date", d.ID, nil, d, fmt.Sprintf("Service [%s] exception on %s has been added.", serviceID, d.Date))
+	c.JSON(http.StatusOK, d)
+}
+
+func DeleteCalendarDate(c *gin.Context) {
+	serviceID := c.Param("service_id")
+	var before models.CalendarDate
+	workspaceDB(c).Where("service_id = ?", serviceID).First(&before, c.Param("date_id"))
+	result := workspaceDB(c).Where("service_id = ?", serviceID).Delete(&models.CalendarDate{}, c.Param("date_id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete calendar date"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar date not found"})
+		return
+	}
+	LogChange(c, ActionDelete, "calendar_date", c.Param("date_id"), before, nil, fmt.Sprintf("Service [%s] exception #%s has been removed.", serviceID, c.Param("date_id")))
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar date deleted"})
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"context"
+	"gtfs-cms/database"
+	"gtfs-cms/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeleteCalendarUsedInDraft(t *testing.T) {
+	db := testDB(t)
+	db.Create(&models.Calendar{ServiceID: "WEEKEND", Saturday: true, Sunday: true, StartDate: "20250101", EndDate: "20251231"})
+	db.WithContext(database.WithDraft(context.Background(), 1)).Create(&models.Trip{RouteID: 1, ServiceID: "WEEKEND"})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("DELETE", "/api/calendars/WEEKEND", nil)
+	c.Params = gin.Params{{Key: "service_id", Value: "WEEKEND"}}
+	DeleteCalendar(c)
+	if w.Code != http.StatusConflict {
+		t.Errorf("DeleteCalendar() responded %d, want %d while a draft trip runs on it", w.Code, http.StatusConflict)
+	}
+	var count int64
+	db.Model(&models.Calendar{}).Count(&count)
+	if count != 1 {
+		t.Errorf("the refused delete removed the calendar")
+	}
+}
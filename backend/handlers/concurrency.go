@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// --- Optimistic concurrency ---
+
+// Agencies, stops, routes and trips carry a version that every update bumps
+// and that is sent as their ETag. A trip's stop list and a shape have no row
+// of their own, so their ETag is a hash of the content instead. Writes that
+// name an older ETag in If-Match get a 409 with the current server copy.
+
+func versionETag(version uint) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// contentETag identifies a collection by the JSON of its rows
+func contentETag(rows interface{}) string {
+	raw, _ := json.Marshal(rows)
+	sum := sha256.Sum256(raw)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// matchesETag reports whether an If-Match header value names etag. Weak
+// validators compare by their opaque part; * matches anything.
+func matchesETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// respondConflict rejects a stale write with the current copy and its ETag
+func respondConflict(c *gin.Context, etag string, current interface{}) {
+	c.Header("ETag", etag)
+	c.JSON(http.StatusConflict, gin.H{"error": "This record has been changed by someone else since you loaded it", "current": current})
+}
+
+// checkIfMatch enforces If-Match on a write to a collection. Requests
+// without the header write unconditionally.
+func checkIfMatch(c *gin.Context, etag string, current interface{}) bool {
+	if header := c.GetHeader("If-Match"); header != "" && !matchesETag(header, etag) {
+		respondConflict(c, etag, current)
+		return false
+	}
+	return true
+}
+
+// checkVersion enforces the precondition of a write to a versioned row: the
+// If-Match header or, without one, a version in the body must name the
+// current version. Clients that send neither write unconditionally.
+func checkVersion(c *gin.Context, version, bodyVersion uint, current interface{}) bool {
+	etag := versionETag(version)
+	if c.GetHeader("If-Match") != "" {
+		return checkIfMatch(c, etag, current)
+	}
+	if bodyVersion != 0 && bodyVersion != version {
+		respondConflict(c, etag, current)
+		return false
+	}
+	return true
+}
+
+// saveVersioned writes every column of row, whose version the caller has
+// already bumped, only while the stored version is still version. false
+// means another request updated the row first.
+func saveVersioned(db *gorm.DB, row interface{}, version uint) (bool, error) {
+	res := db.Model(row).Omit(clause.Associations).Select("*").Where("version = ?", version).Updates(row)
+	return res.RowsAffected > 0, res.Error
+}
+
+// lockForUpdate reads rows with a row lock held until the transaction ends,
+// so concurrent replacements of one collection take turns
+func lockForUpdate(tx *gorm.DB) *gorm.DB {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+}
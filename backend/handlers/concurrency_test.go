@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"gtfs-cms/models"
+	"testing"
+)
+
+func TestMatchesETag(t *testing.T) {
+	for _, tc := range []struct {
+		header, etag string
+		want         bool
+	}{
+		{`"3"`, `"3"`, true},
+		{`"2"`, `"3"`, false},
+		{`W/"3"`, `"3"`, true},
+		{`"1", "3"`, `"3"`, true},
+		{`*`, `"3"`, true},
+		{`3`, `"3"`, false},
+	} {
+		if got := matchesETag(tc.header, tc.etag); got != tc.want {
+			t.Errorf("matchesETag(%q, %q) = %v, want %v", tc.header, tc.etag, got, tc.want)
+		}
+	}
+}
+
+func TestContentETag(t *testing.T) {
+	stops := []models.TripStop{{StopID: 1, Sequence: 1, ArrivalTime: "08:00:00"}, {StopID: 2, Sequence: 2}}
+	etag := contentETag(stopTimeRows(stops))
+
+	// The preloaded stop is not part of the stop list
+	stops[0].Stop = models.Stop{ID: 1, Name: "Central"}
+	if got := contentETag(stopTimeRows(stops)); got != etag {
+		t.Errorf("contentETag() changed with the preloaded stop: %s != %s", got, etag)
+	}
+	stops[1].ArrivalTime = "08:05:00"
+	if got := contentETag(stopTimeRows(stops)); got == etag {
+		t.Errorf("contentETag() = %s for a retimed stop list, want a new tag", got)
+	}
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/gtfs"
+	"gtfs-cms/models"
+	"gtfs-cms/realtime"
+	"gtfs-cms/schedule"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Departure board ---
+
+const (
+	defaultDepartureLimit = 10
+	maxDepartureLimit     = 100
+
+	// departureLookback is how long after its scheduled time a late run is
+	// still looked up in the realtime estimates
+	departureLookback = 60 * time.Minute
+)
+
+// Departure is one scheduled departure from a stop
+type Departure struct {
+	TripID         uint       `json:"trip_id"`
+	RouteID        uint       `json:"route_id"`
+	RouteShortName string     `json:"route_short_name"`
+	RouteColor     string     `json:"route_color"`
+	Headsign       string     `json:"headsign"`
+	StopSequence   int        `json:"stop_sequence"`
+	ServiceDate    string     `json:"service_date"`   // YYYYMMDD of the service day the trip belongs to
+	ScheduledTime  string     `json:"scheduled_time"` // GTFS time, may be past 24:00:00
+	ScheduledAt    time.Time  `json:"scheduled_at"`
+	EstimatedAt    *time.Time `json:"estimated_at,omitempty"`
+	DelaySecs      *int32     `json:"delay_secs,omitempty"`
+	Canceled       bool       `json:"canceled,omitempty"`
+	AlertIDs       []string   `json:"alert_ids,omitempty"`
+
+	agencyID uint
+}
+
+// expectedAt is when the departure is expected to leave: the realtime
+// estimate if there is one, the timetable otherwise
+func (d Departure) expectedAt() time.Time {
+	if d.EstimatedAt != nil {
+		return *d.EstimatedAt
+	}
+	return d.ScheduledAt
+}
+
+// nextDepartures keeps the departures expected to leave at or after at,
+// soonest first, up to limit
+func nextDepartures(departures []Departure, at time.Time, limit int) []Departure {
+	next := []Departure{}
+	for _, d := range departures {
+		if !d.expectedAt().Before(at) {
+			next = append(next, d)
+		}
+	}
+	sort.SliceStable(next, func(i, j int) bool { return next[i].expectedAt().Before(next[j].expectedAt()) })
+	if len(next) > limit {
+		next = next[:limit]
+	}
+	return next
+}
+
+// parseDepartureTime accepts RFC 3339, Unix seconds or a local
+// "YYYY-MM-DDTHH:MM" in loc
+func parseDepartureTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339, Unix seconds or YYYY-MM-DDTHH:MM", s)
+}
+
+// GetStopDepartures lists the next departures from a stop at a moment in time.
+// Trips of the previous service day running past midnight are included, as
+// are trips of the next service day when the board reaches midnight. Runs
+// that were due up to departureLookback ago stay on the board while their
+// realtime estimate says they have yet to leave.
+func GetStopDepartures(c *gin.Context) {
+	var stop models.Stop
+	if err := workspaceDB(c).First(&stop, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stop not found"})
+		return
+	}
+
+	loc := schedule.Location(workspaceDB(c))
+	at := time.Now()
+	if v := c.Query("at"); v != "" {
+		t, err := parseDepartureTime(v, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		at = t
+	}
+	limit := defaultDepartureLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		limit = min(n, maxDepartureLimit)
+	}
+
+	var tripStops []models.TripStop
+	if err := workspaceDB(c).Preload("Trip.Route").Where("stop_id = ?", stop.ID).Find(&tripStops).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stop times: " + err.Error()})
+		return
+	}
+	tripIDs := make([]uint, 0, len(tripStops))
+	for _, ts := range tripStops {
+		tripIDs = append(tripIDs, ts.TripID)
+	}
+
+	// A trip ending here arrives rather than departs, and frequency-based
+	// trips depart once per headway counted from their first stop
+	var bounds []struct {
+		TripID  uint
+		MinSeq  int
+		LastSeq int
+	}
+	firstDeparture := make(map[uint]int)
+	frequencies := make(map[uint][]models.Frequency)
+	if len(tripIDs) > 0 {
+		if err := workspaceDB(c).Model(&models.TripStop{}).Select("trip_id, MIN(sequence) AS min_seq, MAX(sequence) AS last_seq").
+			Where("trip_id IN ?", tripIDs).Group("trip_id").Scan(&bounds).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trip bounds: " + err.Error()})
+			return
+		}
+		var freqs []models.Frequency
+		if err := workspaceDB(c).Where("trip_id IN ?", tripIDs).Find(&freqs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch frequencies: " + err.Error()})
+			return
+		}
+		for _, f := range freqs {
+			frequencies[f.TripID] = append(frequencies[f.TripID], f)
+		}
+		for _, b := range bounds {
+			if len(frequencies[b.TripID]) == 0 {
+				continue
+			}
+			var first models.TripStop
+			if err := workspaceDB(c).Where("trip_id = ? AND sequence = ?", b.TripID, b.MinSeq).First(&first).Error; err == nil {
+				if secs, err := gtfs.ParseTime(first.DepartureTime); err == nil {
+					firstDeparture[b.TripID] = secs
+				}
+			}
+		}
+	}
+	lastSeq := make(map[uint]int, len(bounds))
+	for _, b := range bounds {
+		lastSeq[b.TripID] = b.LastSeq
+	}
+
+	alerts, err := realtime.LoadActiveAlerts(workspaceDB(c), at)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load alerts: " + err.Error()})
+		return
+	}
+
+	today := schedule.ServiceDay(at, loc)
+	since := at.Add(-departureLookback)
+	departures := []Departure{}
+	for _, day := range []time.Time{today.AddDate(0, 0, -1), today, today.AddDate(0, 0, 1)} {
+		services, err := schedule.ActiveServices(workspaceDB(c), day)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve services: " + err.Error()})
+			return
+		}
+		for _, ts := range tripStops {
+			if !services[ts.Trip.ServiceID] || ts.Sequence >= lastSeq[ts.TripID] {
+				continue
+			}
+			secs, err := gtfs.ParseTime(ts.DepartureTime)
+			if err != nil {
+				continue // Untimed stops have no place on a board
+			}
+
+			runs := []int{secs}
+			if freqs := frequencies[ts.TripID]; len(freqs) > 0 {
+				first, ok := firstDeparture[ts.TripID]
+				if !ok {
+					continue
+				}
+				runs = runs[:0]
+				for _, f := range freqs {
+					start, errS := gtfs.ParseTime(f.StartTime)
+					end, errE := gtfs.ParseTime(f.EndTime)
+					if errS != nil || errE != nil || f.HeadwaySecs <= 0 {
+						continue
+					}
+					for dep := start; dep < end; dep += f.HeadwaySecs {
+						runs = append(runs, dep+secs-first)
+					}
+				}
+			}
+
+			for _, run := range runs {
+				scheduled := day.Add(time.Duration(run) * time.Second)
+				if scheduled.Before(since) {
+					continue
+				}
+				departures = append(departures, Departure{
+					TripID:         ts.TripID,
+					RouteID:        ts.Trip.RouteID,
+					RouteShortName: ts.Trip.Route.ShortName,
+					RouteColor:     ts.Trip.Route.Color,
+					Headsign:       ts.Trip.Headsign,
+					StopSequence:   ts.Sequence,
+					ServiceDate:    day.Format(gtfs.DateLayout),
+					ScheduledTime:  gtfs.FormatTime(run),
+					ScheduledAt:    scheduled,
+					agencyID:       ts.Trip.Route.AgencyID,
+				})
+			}
+		}
+	}
+
+	store := realtime.StoreFor(currentWorkspaceID(c))
+	for i := range departures {
+		d := &departures[i]
+		if tu, ok := store.TripUpdate(d.TripID, time.Now()); ok && (tu.StartDate == "" || tu.StartDate == d.ServiceDate) {
+			if e, ok := tu.EstimateDeparture(d.StopSequence, stop.ID, d.ScheduledAt); ok {
+				d.EstimatedAt, d.DelaySecs, d.Canceled = &e.Time, &e.Delay, e.Canceled
+			}
+		}
+	}
+	departures = nextDepartures(departures, at, limit)
+
+	affecting := []realtime.Alert{}
+	seenAlert := make(map[string]bool)
+	for _, a := range alerts {
+		if a.Affects(realtime.EntitySelector{StopID: stop.ID}) {
+			affecting = append(affecting, a)
+			seenAlert[a.ID] = true
+		}
+	}
+	for i := range departures {
+		d := &departures[i]
+		ctx := realtime.EntitySelector{AgencyID: d.agencyID, RouteID: d.RouteID, TripID: d.TripID, StopID: stop.ID}
+		for _, a := range alerts {
+			if !a.Affects(ctx) {
+				continue
+			}
+			d.AlertIDs = append(d.AlertIDs, a.ID)
+			if !seenAlert[a.ID] {
+				affecting = append(affecting, a)
+				seenAlert[a.ID] = true
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stop":       stop,
+		"at":         at.In(loc),
+		"departures": departures,
+		"alerts":     affecting,
+	})
+}
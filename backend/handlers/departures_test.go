@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDepartures(t *testing.T) {
+	at := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	est := func(d time.Duration) *time.Time { t := at.Add(d); return &t }
+	departures := []Departure{
+		{TripID: 1, ScheduledAt: at.Add(-2 * time.Minute), EstimatedAt: est(3 * time.Minute)},  // 5 min late, still to come
+		{TripID: 2, ScheduledAt: at.Add(-2 * time.Minute)},                                     // left on time
+		{TripID: 3, ScheduledAt: at.Add(-5 * time.Minute), EstimatedAt: est(-1 * time.Minute)}, // late, but gone
+		{TripID: 4, ScheduledAt: at.Add(1 * time.Minute), EstimatedAt: est(6 * time.Minute)},
+		{TripID: 5, ScheduledAt: at.Add(4 * time.Minute)},
+		{TripID: 6, ScheduledAt: at.Add(10 * time.Minute)},
+	}
+
+	got := nextDepartures(departures, at, 3)
+	want := []uint{1, 5, 4}
+	if len(got) != len(want) {
+		t.Fatalf("nextDepartures() returned %d departures, want %d", len(got), len(want))
+	}
+	for i, d := range got {
+		if d.TripID != want[i] {
+			t.Errorf("departure %d is trip %d, want %d", i, d.TripID, want[i])
+		}
+	}
+}
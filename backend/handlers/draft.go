@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/auth"
+	"gtfs-cms/database"
+	"gtfs-cms/drafts"
+	"gtfs-cms/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Drafts ---
+
+// draftHeader selects the draft a request reads and edits; the draft query
+// parameter does the same for links such as the export download
+const (
+	draftHeader     = "X-Draft-ID"
+	draftContextKey = "draft"
+)
+
+// findDraft looks a draft up by ID or by name
+func findDraft(db *gorm.DB, ref string) (models.Draft, error) {
+	var draft models.Draft
+	query := db.Where("name = ?", ref)
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		query = db.Where("id = ? OR name = ?", id, ref)
+	}
+	err := query.First(&draft).Error
+	return draft, err
+}
+
+// DraftScope resolves the draft a request targets, if any. Drafts are not
+// public: only members of the request's workspace see them, and only open
+// drafts accept edits.
+func DraftScope(c *gin.Context) {
+	ref := c.GetHeader(draftHeader)
+	if ref == "" {
+		ref = c.Query("draft")
+	}
+	if ref == "" {
+		c.Next()
+		return
+	}
+	if _, ok := currentUser(c); !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required to access drafts"})
+		return
+	}
+	if !auth.Allows(currentRole(c), models.RoleViewer) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Drafts are only visible to members of this workspace"})
+		return
+	}
+	draft, err := findDraft(workspaceDB(c), ref)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Draft %s not found", ref)})
+		return
+	}
+	if draft.Status == models.DraftMerged {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Draft [%s] has already been merged", draft.Name)})
+		return
+	}
+	if c.Request.Method != http.MethodGet && draft.Status != models.DraftOpen {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Draft [%s] is %s and cannot be edited", draft.Name, draft.Status)})
+		return
+	}
+	c.Set(draftContextKey, draft)
+	c.Next()
+}
+
+func currentDraft(c *gin.Context) (models.Draft, bool) {
+	v, ok := c.Get(draftContextKey)
+	if !ok {
+		return models.Draft{}, false
+	}
+	draft, ok := v.(models.Draft)
+	return draft, ok
+}
+
+// feedDB is the database scoped to the draft of the request, or to the
+// published data when there is none
+func feedDB(c *gin.Context) *gorm.DB {
+	draft, _ := currentDraft(c)
+	return workspaceDB(c).WithContext(database.WithDraft(c.Request.Context(), draft.ID))
+}
+
+// allDraftsDB reaches the published data and every draft at once, for
+// changes to shared entities such as stops and agencies
+func allDraftsDB(c *gin.Context) *gorm.DB {
+	return workspaceDB(c).WithContext(database.WithAllDrafts(c.Request.Context()))
+}
+
+// draftPlan compares a draft with the current published data
+func draftPlan(db *gorm.DB, draft models.Draft) (drafts.Plan, *drafts.Set, *drafts.Set, error) {
+	ctx := db.Statement.Context
+	published, err := drafts.Load(db.WithContext(database.WithDraft(ctx, 0)))
+	if err != nil {
+		return drafts.Plan{}, nil, nil, err
+	}
+	staged, err := drafts.Load(db.WithContext(database.WithDraft(ctx, draft.ID)))
+	if err != nil {
+		return drafts.Plan{}, nil, nil, err
+	}
+	return drafts.Compare(draft.Base, published, staged), published, staged, nil
+}
+
+func GetDrafts(c *gin.Context) {
+	query := workspaceDB(c).Order("id desc")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var list []models.Draft
+	if err := query.Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch drafts: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func GetDraft(c *gin.Context) {
+	var draft models.Draft
+	if err := workspaceDB(c).First(&draft, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Draft not found"})
+		return
+	}
+	c.JSON(http.StatusOK, draft)
+}
+
+// GetDraftChanges lists what merging the draft would change, for review
+func GetDraftChanges(c *gin.Context) {
+	var draft models.Draft
+	if err := workspaceDB(c).First(&draft, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Draft not found"})
+		return
+	}
+	if draft.Status == models.DraftMerged {
+		c.JSON(http.StatusConflict, gin.H{"error": "Draft has already been merged"})
+		return
+	}
+	plan, _, _, err := draftPlan(workspaceDB(c), draft)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare draft: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+type DraftRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// CreateDraft copies the published routes, trips, stop times and shapes
+// into a new draft
+func CreateDraft(c *gin.Context) {
+	var req DraftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	var existing int64
+	workspaceDB(c).Model(&models.Draft{}).Where("name = ?", req.Name).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A draft named %s already exists", req.Name)})
+		return
+	}
+
+	draft := models.Draft{Name: req.Name, Description: req.Description, Status: models.DraftOpen}
+	if user, ok := currentUser(c); ok {
+		draft.CreatedByID = &user.ID
+		draft.CreatedBy = user.Email
+	}
+
+	tx := workspaceDB(c).Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
+		return
+	}
+	if err := tx.Create(&draft).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create draft: " + err.Error()})
+		return
+	}
+	published, err := drafts.Load(tx)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load published data: " + err.Error()})
+		return
+	}
+	base, err := drafts.Copy(tx.WithContext(database.WithDraft(c.Request.Context(), draft.ID)), published)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to copy published data: " + err.Error()})
+		return
+	}
+	draft.Base = base
+	if err := tx.Save(&draft).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save draft: " + err.Error()})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+	LogChange(c, ActionCreate, "draft", draft.ID, nil, draft, fmt.Sprintf("Draft [%s] has been opened with %d routes and %d trips.", draft.Name, len(base.RouteIDs), len(base.TripIDs)))
+	c.JSON(http.StatusOK, draft)
+}
+
+func UpdateDraft(c *gin.Context) {
+	var draft models.Draft
+	if err := workspaceDB(c).First(&draft, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Draft not found"})
+		return
+	}
+	before := draft
+	var req DraftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if name := strings.TrimSpace(req.Name); name != "" && name != draft.Name {
+		var existing int64
+		workspaceDB(c).Model(&models.Draft{}).Where("name = ?", name).Count(&existing)
+		if existing > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A draft named %s already exists", name)})
+			return
+		}
+		draft.Name = name
+	}
+	draft.Description = req.Description
+	if err := workspaceDB(c).Save(&draft).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update draft: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "draft", draft.ID, before, draft, fmt.Sprintf("Draft [%s] has been updated.", draft.Name))
+	c.JSON(http.StatusOK, draft)
+}
+
+type DraftReviewRequest struct {
+	Note string `json:"note"`
+}
+
+// transitionDraft moves a draft from one of the from statuses to status
+func transitionDraft(c *gin.Context, status string, from ...string) {
+	var draft models.Draft
+	if err := workspaceDB(c).First(&draft, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Draft not found"})
+		return
+	}
+	var req DraftReviewRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	allowed := false
+	for _, s := range from {
+		allowed = allowed || draft.Status == s
+	}
+	if !allowed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Draft [%s] is %s; expected %s", draft.Name, draft.Status, strings.Join(from, " or "))})
+		return
+	}
+
+	before := draft
+	draft.Status = status
+	if status != models.DraftInReview {
+		// Approving and rejecting are the review
+		if user, ok := currentUser(c); ok {
+			draft.ReviewedBy = user.Email
+		}
+		draft.ReviewNote = req.Note
+	}
+	draft.ApprovedAt = nil
+	if status == models.DraftApproved {
+		now := time.Now()
+		draft.ApprovedAt = &now
+	}
+	if err := workspaceDB(c).Save(&draft).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update draft: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "draft", draft.ID, before, draft, fmt.Sprintf("Draft [%s] is now %s.", draft.Name, draft.Status))
+	c.JSON(http.StatusOK, draft)
+}
+
+// SubmitDraft locks a draft for review
+func SubmitDraft(c *gin.Context) {
+	transitionDraft(c, models.DraftInReview, models.DraftOpen)
+}
+
+// ApproveDraft signs a reviewed draft off for merging
+func ApproveDraft(c *gin.Context) {
+	transitionDraft(c, models.DraftApproved, models.DraftInReview)
+}
+
+// RejectDraft sends a draft back to its editors, with the review note
+func RejectDraft(c *gin.Context) {
+	transitionDraft(c, models.DraftOpen, models.DraftInReview, models.DraftApproved)
+}
+
+// MergeDraft applies an approved draft to the published data in one
+// transaction. The data it replaces is snapshotted first.
+func MergeDraft(c *gin.Context) {
+	var draft models.Draft
+	if err := workspaceDB(c).First(&draft, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Draft not found"})
+		return
+	}
+	if draft.Status != models.DraftApproved {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Draft [%s] is %s; only approved drafts can be merged", draft.Name, draft.Status)})
+		return
+	}
+
+	tx := workspaceDB(c).WithContext(c.Request.Context()).Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
+		return
+	}
+	plan, published, staged, err := draftPlan(tx, draft)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare draft: " + err.Error()})
+		return
+	}
+	backup, err := buildSnapshot(c, tx, fmt.Sprintf("Before merging draft [%s]", draft.Name), "", models.SnapshotMerge)
+	if err == nil {
+		err = tx.Create(&backup).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to snapshot published data: " + err.Error()})
+		return
+	}
+	pub := tx.WithContext(database.WithDraft(c.Request.Context(), 0))
+	staging := tx.WithContext(database.WithDraft(c.Request.Context(), draft.ID))
+	if err := drafts.Merge(pub, staging, plan, published, staged); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to merge draft: " + err.Error()})
+		return
+	}
+	now := time.Now()
+	if err := tx.Model(&draft).Updates(map[string]interface{}{"status": models.DraftMerged, "merged_at": now}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update draft: " + err.Error()})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	LogActivity(c, "PUBLISH", fmt.Sprintf("Draft [%s] has been merged: %d routes and %d trips changed. The previous data is kept as snapshot #%d.",
+		draft.Name, len(plan.Routes.Created)+len(plan.Routes.Updated)+len(plan.Routes.Deleted),
+		len(plan.Trips.Created)+len(plan.Trips.Updated)+len(plan.Trips.Deleted), backup.ID))
+	c.JSON(http.StatusOK, gin.H{"message": "Draft merged", "changes": plan, "snapshot": backup.ID})
+}
+
+// DeleteDraft discards a draft and all of its rows
+func DeleteDraft(c *gin.Context) {
+	var draft models.Draft
+	if err := workspaceDB(c).First(&draft, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Draft not found"})
+		return
+	}
+	tx := workspaceDB(c).Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
+		return
+	}
+	if err := drafts.Discard(tx.WithContext(database.WithDraft(c.Request.Context(), draft.ID))); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to discard draft rows: " + err.Error()})
+		return
+	}
+	if err := tx.Delete(&draft).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete draft"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+	LogChange(c, ActionDelete, "draft", draft.ID, draft, nil, fmt.Sprintf("Draft [%s] has been discarded.", draft.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "Draft deleted"})
+}
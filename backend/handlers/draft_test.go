@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"gtfs-cms/database"
+	"gtfs-cms/models"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDraftScopeNonMember(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		user *models.User
+		role string
+		want int
+	}{
+		{"anonymous", nil, "", http.StatusUnauthorized},
+		{"non-member", &models.User{ID: 2, Role: models.RoleEditor}, "", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/api/routes", nil)
+		c.Request.Header.Set(workspaceHeader, "2")
+		c.Request.Header.Set(draftHeader, "7")
+		if tc.user != nil {
+			c.Set(userContextKey, *tc.user)
+		}
+		c.Set(workspaceRoleKey, tc.role)
+
+		DraftScope(c)
+		if !c.IsAborted() || w.Code != tc.want {
+			t.Errorf("%s: DraftScope() responded %d, want %d", tc.name, w.Code, tc.want)
+		}
+		if _, ok := currentDraft(c); ok {
+			t.Errorf("%s: DraftScope() resolved a draft", tc.name)
+		}
+	}
+}
+
+// readZipCSV returns the rows of a CSV file in a ZIP, header first
+func readZipCSV(t *testing.T, feed []byte, name string) [][]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(feed), int64(len(feed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		rows, err := csv.NewReader(rc).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	t.Fatalf("%s is missing from the feed", name)
+	return nil
+}
+
+func TestExportDraftReferences(t *testing.T) {
+	db := testDB(t)
+	seedRoundTrip(t, db)
+	route, first, second := uint(1), uint(1), uint(2)
+	for _, row := range []interface{}{
+		&models.Frequency{TripID: first, StartTime: "06:00:00", EndTime: "09:00:00", HeadwaySecs: 600},
+		&models.Transfer{FromRouteID: &route, FromTripID: &first, ToTripID: &second, Type: models.TransferInSeat},
+		&models.FareAttribute{Price: 1.5, CurrencyType: "IDR"},
+		&models.FareRule{FareID: 1, RouteID: &route},
+	} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/drafts", strings.NewReader(`{"name":"Timetable change"}`))
+	CreateDraft(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("CreateDraft() responded %d: %s", w.Code, w.Body.String())
+	}
+	var draft models.Draft
+	if err := json.Unmarshal(w.Body.Bytes(), &draft); err != nil {
+		t.Fatal(err)
+	}
+
+	// Transfers, fare rules and frequencies keep pointing at the published
+	// rows; the export must point them at the copies it writes instead
+	draftDB := db.WithContext(database.WithDraft(c.Request.Context(), draft.ID))
+	var routeCopy models.Route
+	var tripCopies []models.Trip
+	draftDB.Where("origin_id = ?", route).First(&routeCopy)
+	draftDB.Order("origin_id").Find(&tripCopies)
+	if routeCopy.ID == 0 || routeCopy.ID == route || len(tripCopies) != 2 {
+		t.Fatalf("draft copies: route %+v, trips %+v", routeCopy, tripCopies)
+	}
+	routeID, fromTrip, toTrip := strconv.Itoa(int(routeCopy.ID)), strconv.Itoa(int(tripCopies[0].ID)), strconv.Itoa(int(tripCopies[1].ID))
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/api/export/gtfs", nil)
+	c.Set(draftContextKey, draft)
+	ExportGTFS(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ExportGTFS() responded %d: %s", w.Code, w.Body.String())
+	}
+	feed := w.Body.Bytes()
+
+	if rows := readZipCSV(t, feed, "frequencies.txt"); len(rows) != 2 || rows[1][0] != fromTrip {
+		t.Errorf("frequencies.txt = %v, want trip_id %s", rows, fromTrip)
+	}
+	if rows := readZipCSV(t, feed, "transfers.txt"); len(rows) != 2 || rows[1][2] != routeID || rows[1][4] != fromTrip || rows[1][5] != toTrip {
+		t.Errorf("transfers.txt = %v, want from_route_id %s, from_trip_id %s, to_trip_id %s", rows, routeID, fromTrip, toTrip)
+	}
+	if rows := readZipCSV(t, feed, "fare_rules.txt"); len(rows) != 2 || rows[1][1] != routeID {
+		t.Errorf("fare_rules.txt = %v, want route_id %s", rows, routeID)
+	}
+}
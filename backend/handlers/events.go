@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/events"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Live events ---
+
+// eventsHeartbeat keeps idle streams open through proxies and is when
+// expired presence gets announced
+const eventsHeartbeat = 20 * time.Second
+
+// GetEvents streams every change, activity and presence event of the
+// workspace as Server-Sent Events. Inside a draft the stream also carries
+// the draft's changes. Reconnecting clients send Last-Event-ID to receive
+// what they missed.
+func GetEvents(c *gin.Context) {
+	draft, _ := currentDraft(c)
+	lastID, _ := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64)
+	sub, missed := events.Default.Subscribe(currentWorkspaceID(c), draft.ID, lastID)
+	defer events.Default.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for _, e := range missed {
+		if err := e.WriteSSE(c.Writer); err != nil {
+			return
+		}
+	}
+	// Tell the client how long to wait before reconnecting
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case e, ok := <-sub.C:
+			return ok && e.WriteSSE(w) == nil
+		case now := <-heartbeat.C:
+			events.Default.Presence(currentWorkspaceID(c), now)
+			_, err := fmt.Fprint(w, ": ping\n\n")
+			return err == nil
+		}
+	})
+}
+
+type PresenceRequest struct {
+	Entity   string `json:"entity" binding:"required"`
+	EntityID string `json:"entity_id" binding:"required"`
+}
+
+// GetPresence lists who is editing what in the workspace right now
+func GetPresence(c *gin.Context) {
+	c.JSON(http.StatusOK, events.Default.Presence(currentWorkspaceID(c), time.Now()))
+}
+
+// SetPresence announces that the current user is editing an entity, such as
+// a trip. Clients renew it while the editor stays open; it lapses after
+// events.DefaultPresenceTTL otherwise.
+func SetPresence(c *gin.Context) {
+	user, _ := currentUser(c)
+	var req PresenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	draft, _ := currentDraft(c)
+	p := events.Default.SetPresence(events.Presence{
+		UserEmail:   user.Email,
+		Entity:      req.Entity,
+		EntityID:    req.EntityID,
+		DraftID:     draft.ID,
+		WorkspaceID: currentWorkspaceID(c),
+	}, time.Now())
+	c.JSON(http.StatusOK, p)
+}
+
+// ClearPresence announces that the current user stopped editing
+func ClearPresence(c *gin.Context) {
+	user, _ := currentUser(c)
+	events.Default.ClearPresence(user.Email, time.Now())
+	c.JSON(http.StatusOK, gin.H{"message": "Presence cleared"})
+}
@@ -0,0 +1,655 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/models"
+	"gtfs-cms/validator"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// rowExists reports whether db has a row of model with the given id
+func rowExists(db *gorm.DB, model interface{}, id uint) bool {
+	var count int64
+	db.Model(model).Where("id = ?", id).Count(&count)
+	return count > 0
+}
+
+// zoneInUse reports whether any stop is in the fare zone
+func zoneInUse(db *gorm.DB, zone string) bool {
+	var count int64
+	db.Model(&models.Stop{}).Where("zone_id = ?", zone).Count(&count)
+	return count > 0
+}
+
+// exportFares writes the fare files of the feed through createCSV, each only
+// when it has rows. Fares v1 and v2 may both be present; consumers pick one.
+// routeIDs maps the published routes fare rules reference to the exported ones.
+func exportFares(db *gorm.DB, routeIDs map[uint]uint, createCSV func(name string, headers []string, data [][]string) error) error {
+	optionalInt := func(v *int) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	}
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+	var fares []models.FareAttribute
+	if err := db.Order("id asc").Find(&fares).Error; err != nil {
+		return fmt.Errorf("query fares: %w", err)
+	}
+	if len(fares) > 0 {
+		data := [][]string{}
+		for _, f := range fares {
+			data = append(data, []string{
+				strconv.Itoa(int(f.ID)), money(f.Price), f.CurrencyType, strconv.Itoa(f.PaymentMethod),
+				optionalInt(f.Transfers), optionalID(f.AgencyID), optionalInt(f.TransferDuration),
+			})
+		}
+		if err := createCSV("fare_attributes.txt", []string{"fare_id", "price", "currency_type", "payment_method", "transfers", "agency_id", "transfer_duration"}, data); err != nil {
+			return err
+		}
+	}
+	var rules []models.FareRule
+	if err := db.Order("id asc").Find(&rules).Error; err != nil {
+		return fmt.Errorf("query fare rules: %w", err)
+	}
+	if len(rules) > 0 {
+		data := [][]string{}
+		for _, r := range rules {
+			data = append(data, []string{strconv.Itoa(int(r.FareID)), draftRef(routeIDs, r.RouteID), r.OriginID, r.DestinationID, r.ContainsID})
+		}
+		if err := createCSV("fare_rules.txt", []string{"fare_id", "route_id", "origin_id", "destination_id", "contains_id"}, data); err != nil {
+			return err
+		}
+	}
+
+	var areas []models.Area
+	if err := db.Order("id asc").Find(&areas).Error; err != nil {
+		return fmt.Errorf("query areas: %w", err)
+	}
+	if len(areas) > 0 {
+		areaData, stopAreaData := [][]string{}, [][]string{}
+		for _, a := range areas {
+			areaData = append(areaData, []string{strconv.Itoa(int(a.ID)), a.Name})
+			var stopIDs []uint
+			if err := db.Model(&models.Stop{}).Where("zone_id = ?", a.ZoneID).Order("id asc").Pluck("id", &stopIDs).Error; err != nil {
+				return fmt.Errorf("query stops of area %d: %w", a.ID, err)
+			}
+			for _, id := range stopIDs {
+				stopAreaData = append(stopAreaData, []string{strconv.Itoa(int(a.ID)), strconv.Itoa(int(id))})
+			}
+		}
+		if err := createCSV("areas.txt", []string{"area_id", "area_name"}, areaData); err != nil {
+			return err
+		}
+		if err := createCSV("stop_areas.txt", []string{"area_id", "stop_id"}, stopAreaData); err != nil {
+			return err
+		}
+	}
+	var media []models.FareMedia
+	if err := db.Order("id asc").Find(&media).Error; err != nil {
+		return fmt.Errorf("query fare media: %w", err)
+	}
+	if len(media) > 0 {
+		data := [][]string{}
+		for _, m := range media {
+			data = append(data, []string{strconv.Itoa(int(m.ID)), m.Name, strconv.Itoa(m.Type)})
+		}
+		if err := createCSV("fare_media.txt", []string{"fare_media_id", "fare_media_name", "fare_media_type"}, data); err != nil {
+			return err
+		}
+	}
+	var products []models.FareProduct
+	if err := db.Order("id asc").Find(&products).Error; err != nil {
+		return fmt.Errorf("query fare products: %w", err)
+	}
+	if len(products) > 0 {
+		data := [][]string{}
+		for _, p := range products {
+			data = append(data, []string{strconv.Itoa(int(p.ID)), p.Name, optionalID(p.FareMediaID), money(p.Amount), p.Currency})
+		}
+		if err := createCSV("fare_products.txt", []string{"fare_product_id", "fare_product_name", "fare_media_id", "amount", "currency"}, data); err != nil {
+			return err
+		}
+	}
+	var legRules []models.FareLegRule
+	if err := db.Order("id asc").Find(&legRules).Error; err != nil {
+		return fmt.Errorf("query fare leg rules: %w", err)
+	}
+	if len(legRules) > 0 {
+		data := [][]string{}
+		for _, r := range legRules {
+			data = append(data, []string{
+				r.LegGroupID, r.NetworkID, optionalID(r.FromAreaID), optionalID(r.ToAreaID), strconv.Itoa(int(r.FareProductID)), optionalInt(r.RulePriority),
+			})
+		}
+		if err := createCSV("fare_leg_rules.txt", []string{"leg_group_id", "network_id", "from_area_id", "to_area_id", "fare_product_id", "rule_priority"}, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- Fare (Fares v1) ---
+
+func validateFareAttribute(c *gin.Context, f models.FareAttribute) error {
+	if err := validator.CheckFareAttribute(f); err != nil {
+		return err
+	}
+	if f.AgencyID != nil && !rowExists(workspaceDB(c), &models.Agency{}, *f.AgencyID) {
+		return fmt.Errorf("agency_id #%d not found", *f.AgencyID)
+	}
+	return nil
+}
+
+func GetFareAttributes(c *gin.Context) {
+	var fares []models.FareAttribute
+	if err := workspaceDB(c).Order("id asc").Find(&fares).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fares: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, fares)
+}
+
+func CreateFareAttribute(c *gin.Context) {
+	var f models.FareAttribute
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	f.ID = 0
+	if err := validateFareAttribute(c, f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Create(&f).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create fare: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "fare_attribute", f.ID, nil, f, fmt.Sprintf("Fare #%d (%.2f %s) has been added.", f.ID, f.Price, f.CurrencyType))
+	c.JSON(http.StatusOK, f)
+}
+
+func UpdateFareAttribute(c *gin.Context) {
+	var f models.FareAttribute
+	if err := workspaceDB(c).First(&f, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fare not found"})
+		return
+	}
+	before := f
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	f.ID = before.ID
+	if err := validateFareAttribute(c, f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Save(&f).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update fare: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "fare_attribute", f.ID, before, f, fmt.Sprintf("Fare #%d has been updated.", f.ID))
+	c.JSON(http.StatusOK, f)
+}
+
+// DeleteFareAttribute removes a fare together with its rules
+func DeleteFareAttribute(c *gin.Context) {
+	var f models.FareAttribute
+	if err := workspaceDB(c).First(&f, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fare not found"})
+		return
+	}
+	err := workspaceDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("fare_id = ?", f.ID).Delete(&models.FareRule{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&f).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete fare"})
+		return
+	}
+	LogChange(c, ActionDelete, "fare_attribute", f.ID, f, nil, fmt.Sprintf("Fare #%d has been removed.", f.ID))
+	c.JSON(http.StatusOK, gin.H{"message": "Fare deleted"})
+}
+
+// validateFareRule checks that the fare and route of r exist and that
+// stops are in the zones it names. Fare rules are published data, so the
+// route has to be a published one.
+func validateFareRule(c *gin.Context, r models.FareRule) error {
+	if !rowExists(workspaceDB(c), &models.FareAttribute{}, r.FareID) {
+		return fmt.Errorf("fare_id #%d not found", r.FareID)
+	}
+	if r.RouteID != nil && !rowExists(workspaceDB(c), &models.Route{}, *r.RouteID) {
+		return fmt.Errorf("route_id #%d not found", *r.RouteID)
+	}
+	for _, zone := range []string{r.OriginID, r.DestinationID, r.ContainsID} {
+		if zone != "" && !zoneInUse(workspaceDB(c), zone) {
+			return fmt.Errorf("no stop is in zone %q", zone)
+		}
+	}
+	return nil
+}
+
+// GetFareRules lists fare rules, with ?fare_id= or ?route_id= only those
+// of the given fares or routes
+func GetFareRules(c *gin.Context) {
+	query := workspaceDB(c).Order("id asc")
+	for _, column := range []string{"fare_id", "route_id"} {
+		ids, err := uintListParam(c, column)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(ids) > 0 {
+			query = query.Where(column+" IN ?", ids)
+		}
+	}
+	var rules []models.FareRule
+	if err := query.Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fare rules: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func CreateFareRule(c *gin.Context) {
+	var r models.FareRule
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	r.ID = 0
+	if err := validateFareRule(c, r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Create(&r).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create fare rule: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "fare_rule", r.ID, nil, r, fmt.Sprintf("Fare rule #%d for fare #%d has been added.", r.ID, r.FareID))
+	c.JSON(http.StatusOK, r)
+}
+
+func UpdateFareRule(c *gin.Context) {
+	var r models.FareRule
+	if err := workspaceDB(c).First(&r, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fare rule not found"})
+		return
+	}
+	before := r
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	r.ID = before.ID
+	if err := validateFareRule(c, r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Save(&r).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update fare rule: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "fare_rule", r.ID, before, r, fmt.Sprintf("Fare rule #%d has been updated.", r.ID))
+	c.JSON(http.StatusOK, r)
+}
+
+func DeleteFareRule(c *gin.Context) {
+	var r models.FareRule
+	if err := workspaceDB(c).First(&r, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fare rule not found"})
+		return
+	}
+	if err := workspaceDB(c).Delete(&r).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete fare rule"})
+		return
+	}
+	LogChange(c, ActionDelete, "fare_rule", r.ID, r, nil, fmt.Sprintf("Fare rule #%d has been removed.", r.ID))
+	c.JSON(http.StatusOK, gin.H{"message": "Fare rule deleted"})
+}
+
+// --- Area (Fares v2) ---
+
+func GetAreas(c *gin.Context) {
+	var areas []models.Area
+	if err := workspaceDB(c).Order("id asc").Find(&areas).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch areas: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, areas)
+}
+
+func CreateArea(c *gin.Context) {
+	var a models.Area
+	if err := c.ShouldBindJSON(&a); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	a.ID = 0
+	if !zoneInUse(workspaceDB(c), a.ZoneID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("no stop is in zone %q", a.ZoneID)})
+		return
+	}
+	if err := workspaceDB(c).Create(&a).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create area: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "area", a.ID, nil, a, fmt.Sprintf("Area [%s] (zone %s) has been added.", a.Name, a.ZoneID))
+	c.JSON(http.StatusOK, a)
+}
+
+func UpdateArea(c *gin.Context) {
+	var a models.Area
+	if err := workspaceDB(c).First(&a, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Area not found"})
+		return
+	}
+	before := a
+	if err := c.ShouldBindJSON(&a); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	a.ID = before.ID
+	if !zoneInUse(workspaceDB(c), a.ZoneID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("no stop is in zone %q", a.ZoneID)})
+		return
+	}
+	if err := workspaceDB(c).Save(&a).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update area: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "area", a.ID, before, a, fmt.Sprintf("Area [%s] has been updated.", a.Name))
+	c.JSON(http.StatusOK, a)
+}
+
+// DeleteArea removes an area no fare leg rule uses anymore
+func DeleteArea(c *gin.Context) {
+	db := workspaceDB(c)
+	var a models.Area
+	if err := db.First(&a, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Area not found"})
+		return
+	}
+	var rules int64
+	db.Model(&models.FareLegRule{}).Where("from_area_id = ? OR to_area_id = ?", a.ID, a.ID).Count(&rules)
+	if rules > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Area [%s] is still used by %d fare leg rules", a.Name, rules)})
+		return
+	}
+	if err := db.Delete(&a).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete area"})
+		return
+	}
+	LogChange(c, ActionDelete, "area", a.ID, a, nil, fmt.Sprintf("Area [%s] has been removed.", a.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "Area deleted"})
+}
+
+// --- Fare Media (Fares v2) ---
+
+func GetFareMedia(c *gin.Context) {
+	var media []models.FareMedia
+	if err := workspaceDB(c).Order("id asc").Find(&media).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fare media: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, media)
+}
+
+func CreateFareMedia(c *gin.Context) {
+	var m models.FareMedia
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	m.ID = 0
+	if err := validator.CheckFareMedia(m); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Create(&m).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create fare media: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "fare_media", m.ID, nil, m, fmt.Sprintf("Fare media [%s] has been added.", m.Name))
+	c.JSON(http.StatusOK, m)
+}
+
+func UpdateFareMedia(c *gin.Context) {
+	var m models.FareMedia
+	if err := workspaceDB(c).First(&m, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fare media not found"})
+		return
+	}
+	before := m
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	m.ID = before.ID
+	if err := validator.CheckFareMedia(m); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Save(&m).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update fare media: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "fare_media", m.ID, before, m, fmt.Sprintf("Fare media [%s] has been updated.", m.Name))
+	c.JSON(http.StatusOK, m)
+}
+
+// DeleteFareMedia removes fare media no product is sold on anymore
+func DeleteFareMedia(c *gin.Context) {
+	db := workspaceDB(c)
+	var m models.FareMedia
+	if err := db.First(&m, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fare media not found"})
+		return
+	}
+	var products int64
+	db.Model(&models.FareProduct{}).Where("fare_media_id = ?", m.ID).Count(&products)
+	if products > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Fare media [%s] still has %d fare products sold on it", m.Name, products)})
+		return
+	}
+	if err := db.Delete(&m).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete fare media"})
+		return
+	}
+	LogChange(c, ActionDelete, "fare_media", m.ID, m, nil, fmt.Sprintf("Fare media [%s] has been removed.", m.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "Fare media deleted"})
+}
+
+// --- Fare Product (Fares v2) ---
+
+func validateFareProduct(c *gin.Context, p models.FareProduct) error {
+	if err := validator.CheckFareProduct(p); err != nil {
+		return err
+	}
+	if p.FareMediaID != nil && !rowExists(workspaceDB(c), &models.FareMedia{}, *p.FareMediaID) {
+		return fmt.Errorf("fare_media_id #%d not found", *p.FareMediaID)
+	}
+	return nil
+}
+
+func GetFareProducts(c *gin.Context) {
+	var products []models.FareProduct
+	if err := workspaceDB(c).Order("id asc").Find(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fare products: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, products)
+}
+
+func CreateFareProduct(c *gin.Context) {
+	var p models.FareProduct
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p.ID = 0
+	if err := validateFareProduct(c, p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Create(&p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create fare product: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "fare_product", p.ID, nil, p, fmt.Sprintf("Fare product [%s] (%.2f %s) has been added.", p.Name, p.Amount, p.Currency))
+	c.JSON(http.StatusOK, p)
+}
+
+func UpdateFareProduct(c *gin.Context) {
+	var p models.FareProduct
+	if err := workspaceDB(c).First(&p, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fare product not found"})
+		return
+	}
+	before := p
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p.ID = before.ID
+	if err := validateFareProduct(c, p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Save(&p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update fare product: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "fare_product", p.ID, before, p, fmt.Sprintf("Fare product [%s] has been updated.", p.Name))
+	c.JSON(http.StatusOK, p)
+}
+
+// DeleteFareProduct removes a product no fare leg rule sells anymore
+func DeleteFareProduct(c *gin.Context) {
+	db := workspaceDB(c)
+	var p models.FareProduct
+	if err := db.First(&p, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fare product not found"})
+		return
+	}
+	var rules int64
+	db.Model(&models.FareLegRule{}).Where("fare_product_id = ?", p.ID).Count(&rules)
+	if rules > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Fare product [%s] is still sold by %d fare leg rules", p.Name, rules)})
+		return
+	}
+	if err := db.Delete(&p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete fare product"})
+		return
+	}
+	LogChange(c, ActionDelete, "fare_product", p.ID, p, nil, fmt.Sprintf("Fare product [%s] has been removed.", p.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "Fare product deleted"})
+}
+
+// --- Fare Leg Rule (Fares v2) ---
+
+// validateFareLegRule checks that the product, areas and network of r
+// exist, the network among the published routes
+func validateFareLegRule(c *gin.Context, r models.FareLegRule) error {
+	if !rowExists(workspaceDB(c), &models.FareProduct{}, r.FareProductID) {
+		return fmt.Errorf("fare_product_id #%d not found", r.FareProductID)
+	}
+	for _, id := range []*uint{r.FromAreaID, r.ToAreaID} {
+		if id != nil && !rowExists(workspaceDB(c), &models.Area{}, *id) {
+			return fmt.Errorf("area #%d not found", *id)
+		}
+	}
+	if r.NetworkID != "" {
+		var routes int64
+		workspaceDB(c).Model(&models.Route{}).Where("network_id = ?", r.NetworkID).Count(&routes)
+		if routes == 0 {
+			return fmt.Errorf("no route is in network %q", r.NetworkID)
+		}
+	}
+	return nil
+}
+
+// GetFareLegRules lists fare leg rules, with ?fare_product_id= only those
+// selling the given products
+func GetFareLegRules(c *gin.Context) {
+	query := workspaceDB(c).Order("id asc")
+	ids, err := uintListParam(c, "fare_product_id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(ids) > 0 {
+		query = query.Where("fare_product_id IN ?", ids)
+	}
+	var rules []models.FareLegRule
+	if err := query.Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fare leg rules: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func CreateFareLegRule(c *gin.Context) {
+	var r models.FareLegRule
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	r.ID = 0
+	if err := validateFareLegRule(c, r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Create(&r).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create fare leg rule: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "fare_leg_rule", r.ID, nil, r, fmt.Sprintf("Fare leg rule #%d for fare product #%d has been added.", r.ID, r.FareProductID))
+	c.JSON(http.StatusOK, r)
+}
+
+func UpdateFareLegRule(c *gin.Context) {
+	var r models.FareLegRule
+	if err := workspaceDB(c).First(&r, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fare leg rule not found"})
+		return
+	}
+	before := r
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	r.ID = before.ID
+	if err := validateFareLegRule(c, r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Save(&r).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update fare leg rule: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "fare_leg_rule", r.ID, before, r, fmt.Sprintf("Fare leg rule #%d has been updated.", r.ID))
+	c.JSON(http.StatusOK, r)
+}
+
+func DeleteFareLegRule(c *gin.Context) {
+	var r models.FareLegRule
+	if err := workspaceDB(c).First(&r, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fare leg rule not found"})
+		return
+	}
+	if err := workspaceDB(c).Delete(&r).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete fare leg rule"})
+		return
+	}
+	LogChange(c, ActionDelete, "fare_leg_rule", r.ID, r, nil, fmt.Sprintf("Fare leg rule #%d has been removed.", r.ID))
+	c.JSON(http.StatusOK, gin.H{"message": "Fare leg rule deleted"})
+}
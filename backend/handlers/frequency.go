@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/gtfs"
+	"gtfs-cms/models"
+	"gtfs-cms/validator"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Frequency ---
+
+// validateFrequency normalizes the times of f and checks its window and
+// headway, and that the window overlaps no other frequency of the trip in db
+func validateFrequency(db *gorm.DB, f *models.Frequency) error {
+	var err error
+	if f.StartTime, err = gtfs.NormalizeTime(f.StartTime); err != nil {
+		return fmt.Errorf("start_time: %w", err)
+	}
+	if f.EndTime, err = gtfs.NormalizeTime(f.EndTime); err != nil {
+		return fmt.Errorf("end_time: %w", err)
+	}
+	start, _ := gtfs.ParseTime(f.StartTime)
+	end, _ := gtfs.ParseTime(f.EndTime)
+	if end <= start {
+		return fmt.Errorf("end_time %s must be after start_time %s", f.EndTime, f.StartTime)
+	}
+	if f.HeadwaySecs <= 0 {
+		return fmt.Errorf("headway_secs must be positive")
+	}
+	var others []models.Frequency
+	if err := db.Where("trip_id = ? AND id <> ?", f.TripID, f.ID).Find(&others).Error; err != nil {
+		return fmt.Errorf("failed to check frequency overlaps: %w", err)
+	}
+	return validator.CheckFrequencyOverlap(*f, others)
+}
+
+func GetTripFrequencies(c *gin.Context) {
+	var frequencies []models.Frequency
+	if err := workspaceDB(c).Where("trip_id = ?", c.Param("id")).Order("start_time asc").Find(&frequencies).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch frequencies: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, frequencies)
+}
+
+func CreateTripFrequency(c *gin.Context) {
+	var trip models.Trip
+	if err := workspaceDB(c).First(&trip, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return
+	}
+	var f models.Frequency
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	f.ID = 0
+	f.TripID = trip.ID
+
+	tx := workspaceDB(c).Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction: " + tx.Error.Error()})
+		return
+	}
+	// Holding the trip makes concurrent changes to its frequencies take turns,
+	// so two overlapping windows cannot both pass the check
+	if err := lockForUpdate(tx).First(&trip, trip.ID).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return
+	}
+	if err := validateFrequency(tx, &f); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Create(&f).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create frequency: " + err.Error()})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "frequency", f.ID, nil, f, fmt.Sprintf("Trip #%d now runs every %d min between %s and %s.", trip.ID, f.HeadwaySecs/60, f.StartTime, f.EndTime))
+	c.JSON(http.StatusOK, f)
+}
+
+func UpdateTripFrequency(c *gin.Context) {
+	var f models.Frequency
+	if err := workspaceDB(c).Where("trip_id = ?", c.Param("id")).First(&f, c.Param("freq_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Frequency not found"})
+		return
+	}
+	before := f
+	tripID, freqID := f.TripID, f.ID
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	f.ID, f.TripID = freqID, tripID
+
+	tx := workspaceDB(c).Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction: " + tx.Error.Error()})
+		return
+	}
+	// See CreateTripFrequency
+	var trip models.Trip
+	if err := lockForUpdate(tx).First(&trip, tripID).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return
+	}
+	if err := validateFrequency(tx, &f); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Save(&f).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update frequency: " + err.Error()})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "frequency", f.ID, before, f, fmt.Sprintf("Frequency #%d of trip #%d has been updated.", f.ID, f.TripID))
+	c.JSON(http.StatusOK, f)
+}
+
+func DeleteTripFrequency(c *gin.Context) {
+	tripID := c.Param("id")
+	var before models.Frequency
+	workspaceDB(c).Where("trip_id = ?", tripID).First(&before, c.Param("freq_id"))
+	result := workspaceDB(c).Where("trip_id = ?", tripID).Delete(&models.Frequency{}, c.Param("freq_id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete frequency"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Frequency not found"})
+		return
+	}
+	LogChange(c, ActionDelete, "frequency", c.Param("freq_id"), before, nil, fmt.Sprintf("Frequency #%s of trip #%s has been removed.", c.Param("freq_id"), tripID))
+	c.JSON(http.StatusOK, gin.H{"message": "Frequency deleted"})
+}
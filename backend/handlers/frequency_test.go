@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"gtfs-cms/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidateFrequency(t *testing.T) {
+	db := testDB(t)
+	if err := db.Create(&models.Frequency{TripID: 1, StartTime: "08:00:00", EndTime: "10:00:00", HeadwaySecs: 600}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		f          models.Frequency
+		start, err string
+	}{
+		{"normalises times", models.Frequency{TripID: 1, StartTime: "6:00:00", EndTime: "8:00:00", HeadwaySecs: 600}, "06:00:00", ""},
+		{"past midnight", models.Frequency{TripID: 1, StartTime: "23:00:00", EndTime: "25:30:00", HeadwaySecs: 900}, "23:00:00", ""},
+		{"invalid time", models.Frequency{TripID: 1, StartTime: "noon", EndTime: "13:00:00", HeadwaySecs: 600}, "", "start_time"},
+		{"end before start", models.Frequency{TripID: 1, StartTime: "12:00:00", EndTime: "11:00:00", HeadwaySecs: 600}, "", "must be after"},
+		{"end at start", models.Frequency{TripID: 1, StartTime: "12:00:00", EndTime: "12:00:00", HeadwaySecs: 600}, "", "must be after"},
+		{"zero headway", models.Frequency{TripID: 1, StartTime: "12:00:00", EndTime: "13:00:00"}, "", "headway_secs"},
+		{"overlap", models.Frequency{TripID: 1, StartTime: "09:30:00", EndTime: "11:00:00", HeadwaySecs: 600}, "", "overlaps"},
+		{"overlap of another trip", models.Frequency{TripID: 2, StartTime: "09:30:00", EndTime: "11:00:00", HeadwaySecs: 600}, "09:30:00", ""},
+		{"update of itself", models.Frequency{ID: 1, TripID: 1, StartTime: "07:00:00", EndTime: "09:00:00", HeadwaySecs: 300}, "07:00:00", ""},
+	} {
+		f := tc.f
+		err := validateFrequency(db, &f)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: validateFrequency() = %v, want no error", tc.name, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: validateFrequency() = %v, want an error about %s", tc.name, err, tc.err)
+		case tc.err == "" && f.StartTime != tc.start:
+			t.Errorf("%s: start_time = %s, want %s", tc.name, f.StartTime, tc.start)
+		}
+	}
+}
+
+func TestCreateTripFrequency(t *testing.T) {
+	db := testDB(t)
+	db.Create(&models.Trip{RouteID: 1, ServiceID: "DAILY"})
+
+	create := func(body string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/api/trips/1/frequencies", strings.NewReader(body))
+		c.Params = gin.Params{{Key: "id", Value: "1"}}
+		CreateTripFrequency(c)
+		return w.Code
+	}
+	if code := create(`{"start_time":"06:00:00","end_time":"09:00:00","headway_secs":600}`); code != http.StatusOK {
+		t.Fatalf("CreateTripFrequency() responded %d", code)
+	}
+	if code := create(`{"start_time":"08:00:00","end_time":"10:00:00","headway_secs":600}`); code != http.StatusBadRequest {
+		t.Errorf("CreateTripFrequency() with an overlapping window responded %d, want %d", code, http.StatusBadRequest)
+	}
+	var count int64
+	db.Model(&models.Frequency{}).Count(&count)
+	if count != 1 {
+		t.Errorf("got %d frequencies, want 1", count)
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/gtfs"
+	"gtfs-cms/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxGeneratedTrips keeps a mistyped headway from flooding the trips table
+const maxGeneratedTrips = 500
+
+// GenerateRequest describes the departures to clone a template trip for.
+// Either StartTime/EndTime/HeadwaySecs or an explicit Departures list is used.
+type GenerateRequest struct {
+	StartTime   string   `json:"start_time"`
+	EndTime     string   `json:"end_time"`
+	HeadwaySecs int      `json:"headway_secs"`
+	Departures  []string `json:"departures"`
+	DryRun      bool     `json:"dry_run"`
+}
+
+// GeneratedTrip is one trip produced (or previewed) from the template
+type GeneratedTrip struct {
+	Departure string            `json:"departure"`
+	Trip      models.Trip       `json:"trip"`
+	Stops     []models.TripStop `json:"stops"`
+}
+
+// departureTimes expands the request into departure times in seconds
+func (r GenerateRequest) departureTimes() ([]int, error) {
+	if len(r.Departures) > 0 {
+		var out []int
+		for _, d := range r.Departures {
+			secs, err := gtfs.ParseTime(d)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, secs)
+		}
+		return out, nil
+	}
+
+	start, err := gtfs.ParseTime(r.StartTime)
+	if err != nil {
+		return nil, fmt.Errorf("start_time: %w", err)
+	}
+	end, err := gtfs.ParseTime(r.EndTime)
+	if err != nil {
+		return nil, fmt.Errorf("end_time: %w", err)
+	}
+	if end < start {
+		return nil, fmt.Errorf("end_time %s is before start_time %s", r.EndTime, r.StartTime)
+	}
+	if r.HeadwaySecs <= 0 {
+		return nil, fmt.Errorf("headway_secs must be positive, or provide departures")
+	}
+	var out []int
+	for t := start; t <= end; t += r.HeadwaySecs {
+		out = append(out, t)
+		if len(out) > maxGeneratedTrips {
+			break
+		}
+	}
+	return out, nil
+}
+
+// shiftTripStops copies stops with every time moved by offset seconds.
+// Stops without a time stay untimed.
+func shiftTripStops(stops []models.TripStop, offset int) ([]models.TripStop, error) {
+	shifted := make([]models.TripStop, 0, len(stops))
+	for _, ts := range stops {
+		copyTs := models.TripStop{
+			StopID:   ts.StopID,
+			Sequence: ts.Sequence,
+		}
+		for _, pair := range []struct {
+			src string
+			dst *string
+		}{{ts.ArrivalTime, &copyTs.ArrivalTime}, {ts.DepartureTime, &copyTs.DepartureTime}} {
+			if pair.src == "" {
+				continue
+			}
+			secs, err := gtfs.ParseTime(pair.src)
+			if err != nil {
+				return nil, fmt.Errorf("stop sequence %d: %w", ts.Sequence, err)
+			}
+			if secs+offset < 0 {
+				return nil, fmt.Errorf("stop sequence %d would be scheduled before midnight of the service day", ts.Sequence)
+			}
+			*pair.dst = gtfs.FormatTime(secs + offset)
+		}
+		shifted = append(shifted, copyTs)
+	}
+	return shifted, nil
+}
+
+// templateStart is the first known time of a trip ordered by sequence
+func templateStart(stops []models.TripStop) (int, bool) {
+	for _, ts := range stops {
+		for _, v := range []string{ts.DepartureTime, ts.ArrivalTime} {
+			if secs, err := gtfs.ParseTime(v); err == nil {
+				return secs, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// GenerateTrips clones a template trip and its stop sequence once per
+// departure, shifting all times so the first stop departs at that time.
+func GenerateTrips(c *gin.Context) {
+	db := feedDB(c)
+	var template models.Trip
+	if err := db.First(&template, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return
+	}
+
+	var req GenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if v, err := strconv.ParseBool(c.Query("dry_run")); err == nil {
+		req.DryRun = v
+	}
+
+	departures, err := req.departureTimes()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(departures) > maxGeneratedTrips {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Request would generate more than %d trips", maxGeneratedTrips)})
+		return
+	}
+
+	var stops []models.TripStop
+	if err := db.Where("trip_id = ?", template.ID).Order("sequence asc").Find(&stops).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trip stops: " + err.Error()})
+		return
+	}
+	start, ok := templateStart(stops)
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Template trip has no timed stops to shift from"})
+		return
+	}
+
+	generated := []GeneratedTrip{}
+	skipped := []string{}
+	for _, dep := range departures {
+		// The template already covers its own departure
+		if dep == start {
+			skipped = append(skipped, gtfs.FormatTime(dep))
+			continue
+		}
+		shifted, err := shiftTripStops(stops, dep-start)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		generated = append(generated, GeneratedTrip{
+			Departure: gtfs.FormatTime(dep),
+			Trip: models.Trip{
+				RouteID:     template.RouteID,
+				ServiceID:   template.ServiceID,
+				DirectionID: template.DirectionID,
+				Headsign:    template.Headsign,
+				ShapeID:     template.ShapeID,
+			},
+			Stops: shifted,
+		})
+	}
+
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"dry_run": true, "template_id": template.ID, "trips": generated, "skipped": skipped})
+		return
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
+		return
+	}
+	for i := range generated {
+		if err := tx.Create(&generated[i].Trip).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trip: " + err.Error()})
+			return
+		}
+		for j := range generated[i].Stops {
+			generated[i].Stops[j].TripID = generated[i].Trip.ID
+		}
+		if len(generated[i].Stops) > 0 {
+			if err := tx.Create(&generated[i].Stops).Error; err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trip stops: " + err.Error()})
+				return
+			}
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+
+	for _, g := range generated {
+		LogChange(c, ActionCreate, "trip", g.Trip.ID, nil, g.Trip, fmt.Sprintf("Trip #%d has been generated from template trip #%d departing %s.", g.Trip.ID, template.ID, g.Departure))
+	}
+	LogActivity(c, "SCHEDULE", fmt.Sprintf("%d trips have been generated from template trip #%d.", len(generated), template.ID))
+	c.JSON(http.StatusOK, gin.H{"dry_run": false, "template_id": template.ID, "trips": generated, "skipped": skipped})
+}
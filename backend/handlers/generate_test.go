@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"gtfs-cms/models"
+	"testing"
+)
+
+func TestDepartureTimesFromHeadway(t *testing.T) {
+	req := GenerateRequest{StartTime: "06:00:00", EndTime: "07:00:00", HeadwaySecs: 600}
+	got, err := req.departureTimes()
+	if err != nil {
+		t.Fatalf("departureTimes() error = %v", err)
+	}
+	if len(got) != 7 || got[0] != 6*3600 || got[6] != 7*3600 {
+		t.Errorf("departureTimes() = %v, want 7 departures from 06:00 to 07:00", got)
+	}
+
+	if _, err := (GenerateRequest{StartTime: "06:00:00", EndTime: "07:00:00"}).departureTimes(); err == nil {
+		t.Errorf("departureTimes() without headway should fail")
+	}
+}
+
+func TestShiftTripStops(t *testing.T) {
+	stops := []models.TripStop{
+		{ID: 7, TripID: 1, StopID: 10, Sequence: 1, ArrivalTime: "08:00:00", DepartureTime: "08:01:00"},
+		{ID: 8, TripID: 1, StopID: 11, Sequence: 2},
+		{ID: 9, TripID: 1, StopID: 12, Sequence: 3, ArrivalTime: "23:50:00", DepartureTime: "23:50:00"},
+	}
+
+	got, err := shiftTripStops(stops, 30*60)
+	if err != nil {
+		t.Fatalf("shiftTripStops() error = %v", err)
+	}
+	if got[0].ArrivalTime != "08:30:00" || got[0].DepartureTime != "08:31:00" {
+		t.Errorf("first stop = %s/%s, want 08:30:00/08:31:00", got[0].ArrivalTime, got[0].DepartureTime)
+	}
+	if got[1].ArrivalTime != "" {
+		t.Errorf("untimed stop should stay untimed, got %q", got[1].ArrivalTime)
+	}
+	if got[2].ArrivalTime != "24:20:00" {
+		t.Errorf("last stop = %s, want 24:20:00", got[2].ArrivalTime)
+	}
+	if got[0].ID != 0 || got[0].TripID != 0 {
+		t.Errorf("shifted stops must not keep the template's keys")
+	}
+
+	if _, err := shiftTripStops(stops, -9*3600); err == nil {
+		t.Errorf("shifting before midnight should fail")
+	}
+}
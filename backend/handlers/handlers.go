@@ -7,15 +7,48 @@ import (
 	"fmt"
 	"gtfs-cms/database"
 	"gtfs-cms/models"
+	"gtfs-cms/schedule"
+	"gtfs-cms/spatial"
+	"gtfs-cms/validator"
 	"net/http"
+	"sort"
 	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+// ValidateFeed runs the feed validator over the current dataset
+func ValidateFeed(c *gin.Context) {
+	db := feedDB(c)
+	report, err := validator.ValidateDB(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate feed: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
 // ExportGTFS generates a ZIP file with standard GTFS text files
 func ExportGTFS(c *gin.Context) {
+	db := feedDB(c)
+	// 0. Validate first: errors block the export, warnings are passed along in a header
+	report, err := validator.ValidateDB(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate feed: " + err.Error()})
+		return
+	}
+	if report.HasErrors() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":  fmt.Sprintf("Feed has %d validation errors. Fix them before exporting.", report.Errors),
+			"report": report,
+		})
+		return
+	}
+	if report.Warnings > 0 {
+		c.Header("X-GTFS-Validation-Warnings", strconv.Itoa(report.Warnings))
+	}
+
 	buf := new(bytes.Buffer)
 	zw := zip.NewWriter(buf)
 
@@ -34,7 +67,7 @@ func ExportGTFS(c *gin.Context) {
 
 	// 1. agency.txt
 	var agencies []models.Agency
-	if result := database.DB.Find(&agencies); result.Error != nil {
+	if result := db.Find(&agencies); result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query agencies: " + result.Error.Error()})
 		return
 	}
@@ -55,24 +88,25 @@ func ExportGTFS(c *gin.Context) {
 
 	// 2. stops.txt
 	var stops []models.Stop
-	if result := database.DB.Find(&stops); result.Error != nil {
+	if result := db.Find(&stops); result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query stops: " + result.Error.Error()})
 		return
 	}
 	stopData := [][]string{}
 	for _, s := range stops {
 		stopData = append(stopData, []string{
-			strconv.Itoa(int(s.ID)), s.Name, fmt.Sprintf("%f", s.Lat), fmt.Sprintf("%f", s.Lon), "0",
+			strconv.Itoa(int(s.ID)), s.StopCode, s.Name, fmt.Sprintf("%f", s.Lat), fmt.Sprintf("%f", s.Lon),
+			s.ZoneID, strconv.Itoa(s.LocationType), optionalID(s.ParentStation), s.PlatformCode, optionalID(s.LevelID),
 		})
 	}
-	if err := createCSV("stops.txt", []string{"stop_id", "stop_name", "stop_lat", "stop_lon", "location_type"}, stopData); err != nil {
+	if err := createCSV("stops.txt", []string{"stop_id", "stop_code", "stop_name", "stop_lat", "stop_lon", "zone_id", "location_type", "parent_station", "platform_code", "level_id"}, stopData); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stops.txt: " + err.Error()})
 		return
 	}
 
 	// 3. routes.txt
 	var routes []models.Route
-	if result := database.DB.Find(&routes); result.Error != nil {
+	if result := db.Find(&routes); result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query routes: " + result.Error.Error()})
 		return
 	}
@@ -83,17 +117,19 @@ func ExportGTFS(c *gin.Context) {
 			rType = strconv.Itoa(*r.RouteType)
 		}
 		routeData = append(routeData, []string{
-			strconv.Itoa(int(r.ID)), strconv.Itoa(int(r.AgencyID)), r.ShortName, r.LongName, rType, r.Color,
+			strconv.Itoa(int(r.ID)), strconv.Itoa(int(r.AgencyID)), r.ShortName, r.LongName, rType, r.Color, r.NetworkID,
 		})
 	}
-	if err := createCSV("routes.txt", []string{"route_id", "agency_id", "route_short_name", "route_long_name", "route_type", "route_color"}, routeData); err != nil {
+	if err := createCSV("routes.txt", []string{"route_id", "agency_id", "route_short_name", "route_long_name", "route_type", "route_color", "network_id"}, routeData); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create routes.txt: " + err.Error()})
 		return
 	}
 
+	routeIDs := validator.RouteIDs(routes)
+
 	// 4. trips.txt
 	var trips []models.Trip
-	if result := database.DB.Find(&trips); result.Error != nil {
+	if result := db.Find(&trips); result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query trips: " + result.Error.Error()})
 		return
 	}
@@ -101,7 +137,7 @@ func ExportGTFS(c *gin.Context) {
 	for _, t := range trips {
 		sID := t.ServiceID
 		if sID == "" {
-			sID = "DAILY"
+			sID = models.DefaultServiceID
 		}
 		tripData = append(tripData, []string{
 			strconv.Itoa(int(t.RouteID)), sID, strconv.Itoa(int(t.ID)), t.Headsign, t.ShapeID,
@@ -111,13 +147,52 @@ func ExportGTFS(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trips.txt: " + err.Error()})
 		return
 	}
+	tripIDs := validator.TripIDs(trips)
 
 	// 5. stop_times.txt
 	var tripStops []models.TripStop
-	if result := database.DB.Find(&tripStops); result.Error != nil {
+	if result := db.Order("trip_id, sequence asc").Find(&tripStops); result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query trip stops: " + result.Error.Error()})
 		return
 	}
+	var shapePoints []models.ShapePoint
+	if result := db.Order("shape_id, sequence asc").Find(&shapePoints); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query shape points: " + result.Error.Error()})
+		return
+	}
+
+	// shape_dist_traveled: cumulative metres per shape, stops projected onto their trip's shape
+	shapes := make(map[string][]models.ShapePoint)
+	var shapeOrder []string
+	for _, p := range shapePoints {
+		if _, ok := shapes[p.ShapeID]; !ok {
+			shapeOrder = append(shapeOrder, p.ShapeID)
+		}
+		shapes[p.ShapeID] = append(shapes[p.ShapeID], p)
+	}
+	for _, id := range shapeOrder {
+		schedule.HydrateShapeDistances(shapes[id])
+	}
+	stopCoords := make(map[uint]models.Stop, len(stops))
+	for _, s := range stops {
+		stopCoords[s.ID] = s
+	}
+	tripShapes := make(map[uint]string, len(trips))
+	for _, t := range trips {
+		tripShapes[t.ID] = t.ShapeID
+	}
+	// Trips sharing a shape and a stop pattern are projected once
+	distances := make(schedule.DistanceCache)
+	for start := 0; start < len(tripStops); {
+		end := start
+		for end < len(tripStops) && tripStops[end].TripID == tripStops[start].TripID {
+			end++
+		}
+		shapeID := tripShapes[tripStops[start].TripID]
+		distances.HydrateStopDistances(shapeID, shapes[shapeID], tripStops[start:end], stopCoords)
+		start = end
+	}
+
 	stopTimeData := [][]string{}
 	for _, ts := range tripStops {
 		arr := ts.ArrivalTime
@@ -128,95 +203,302 @@ func ExportGTFS(c *gin.Context) {
 		if dep == "" {
 			dep = "08:00:00"
 		}
+		dist := ""
+		if ts.ShapeDistTraveled != nil {
+			dist = fmt.Sprintf("%.2f", *ts.ShapeDistTraveled)
+		}
 		stopTimeData = append(stopTimeData, []string{
-			strconv.Itoa(int(ts.TripID)), arr, dep, strconv.Itoa(int(ts.StopID)), strconv.Itoa(ts.Sequence),
+			strconv.Itoa(int(ts.TripID)), arr, dep, strconv.Itoa(int(ts.StopID)), strconv.Itoa(ts.Sequence), dist,
 		})
 	}
-	if err := createCSV("stop_times.txt", []string{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence"}, stopTimeData); err != nil {
+	if err := createCSV("stop_times.txt", []string{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence", "shape_dist_traveled"}, stopTimeData); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stop_times.txt: " + err.Error()})
 		return
 	}
 
 	// 6. shapes.txt
-	var shapePoints []models.ShapePoint
-	if result := database.DB.Order("shape_id, sequence asc").Find(&shapePoints); result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query shape points: " + result.Error.Error()})
-		return
-	}
 	shapeData := [][]string{}
-	for _, p := range shapePoints {
-		shapeData = append(shapeData, []string{
-			p.ShapeID, fmt.Sprintf("%f", p.Lat), fmt.Sprintf("%f", p.Lon), strconv.Itoa(p.Sequence),
-		})
+	for _, id := range shapeOrder {
+		for _, p := range shapes[id] {
+			shapeData = append(shapeData, []string{
+				p.ShapeID, fmt.Sprintf("%f", p.Lat), fmt.Sprintf("%f", p.Lon), strconv.Itoa(p.Sequence), fmt.Sprintf("%.2f", p.ShapeDistTraveled),
+			})
+		}
 	}
-	if err := createCSV("shapes.txt", []string{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence"}, shapeData); err != nil {
+	if err := createCSV("shapes.txt", []string{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence", "shape_dist_traveled"}, shapeData); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create shapes.txt: " + err.Error()})
 		return
 	}
 
-	// 7. calendar.txt (Minimal default)
-	calendarData := [][]string{
-		{"DAILY", "1", "1", "1", "1", "1", "1", "1", "20250101", "20261231"},
+	// 7. frequencies.txt (only when headway-based trips exist)
+	var frequencies []models.Frequency
+	if result := db.Order("trip_id, start_time asc").Find(&frequencies); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query frequencies: " + result.Error.Error()})
+		return
+	}
+	if len(frequencies) > 0 {
+		frequencyData := [][]string{}
+		for _, f := range frequencies {
+			exact := "0"
+			if f.ExactTimes {
+				exact = "1"
+			}
+			frequencyData = append(frequencyData, []string{
+				draftRef(tripIDs, &f.TripID), f.StartTime, f.EndTime, strconv.Itoa(f.HeadwaySecs), exact,
+			})
+		}
+		if err := createCSV("frequencies.txt", []string{"trip_id", "start_time", "end_time", "headway_secs", "exact_times"}, frequencyData); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create frequencies.txt: " + err.Error()})
+			return
+		}
+	}
+
+	// 8. calendar.txt
+	var calendars []models.Calendar
+	if result := db.Order("service_id asc").Find(&calendars); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query calendars: " + result.Error.Error()})
+		return
+	}
+	flag := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	calendarData := [][]string{}
+	for _, cal := range calendars {
+		calendarData = append(calendarData, []string{
+			cal.ServiceID, flag(cal.Monday), flag(cal.Tuesday), flag(cal.Wednesday), flag(cal.Thursday), flag(cal.Friday), flag(cal.Saturday), flag(cal.Sunday), cal.StartDate, cal.EndDate,
+		})
 	}
 	if err := createCSV("calendar.txt", []string{"service_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"}, calendarData); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar.txt: " + err.Error()})
 		return
 	}
 
+	// 9. calendar_dates.txt (only when exceptions exist)
+	var calendarDates []models.CalendarDate
+	if result := db.Order("service_id, date asc").Find(&calendarDates); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query calendar dates: " + result.Error.Error()})
+		return
+	}
+	if len(calendarDates) > 0 {
+		calendarDateData := [][]string{}
+		for _, d := range calendarDates {
+			calendarDateData = append(calendarDateData, []string{d.ServiceID, d.Date, strconv.Itoa(d.ExceptionType)})
+		}
+		if err := createCSV("calendar_dates.txt", []string{"service_id", "date", "exception_type"}, calendarDateData); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar_dates.txt: " + err.Error()})
+			return
+		}
+	}
+
+	// 10. levels.txt and pathways.txt (only for stations mapped inside)
+	var levels []models.Level
+	if result := db.Order("id asc").Find(&levels); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query levels: " + result.Error.Error()})
+		return
+	}
+	if len(levels) > 0 {
+		levelData := [][]string{}
+		for _, l := range levels {
+			levelData = append(levelData, []string{strconv.Itoa(int(l.ID)), strconv.FormatFloat(l.Index, 'f', -1, 64), l.Name})
+		}
+		if err := createCSV("levels.txt", []string{"level_id", "level_index", "level_name"}, levelData); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create levels.txt: " + err.Error()})
+			return
+		}
+	}
+	var pathways []models.Pathway
+	if result := db.Order("id asc").Find(&pathways); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query pathways: " + result.Error.Error()})
+		return
+	}
+	if len(pathways) > 0 {
+		optionalFloat := func(v *float64) string {
+			if v == nil {
+				return ""
+			}
+			return strconv.FormatFloat(*v, 'f', -1, 64)
+		}
+		optionalInt := func(v *int) string {
+			if v == nil {
+				return ""
+			}
+			return strconv.Itoa(*v)
+		}
+		pathwayData := [][]string{}
+		for _, p := range pathways {
+			pathwayData = append(pathwayData, []string{
+				strconv.Itoa(int(p.ID)), strconv.Itoa(int(p.FromStopID)), strconv.Itoa(int(p.ToStopID)), strconv.Itoa(p.Mode), flag(p.IsBidirectional),
+				optionalFloat(p.Length), optionalInt(p.TraversalTime), optionalInt(p.StairCount), optionalFloat(p.MaxSlope), optionalFloat(p.MinWidth), p.SignpostedAs,
+			})
+		}
+		if err := createCSV("pathways.txt", []string{"pathway_id", "from_stop_id", "to_stop_id", "pathway_mode", "is_bidirectional", "length", "traversal_time", "stair_count", "max_slope", "min_width", "signposted_as"}, pathwayData); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create pathways.txt: " + err.Error()})
+			return
+		}
+	}
+
+	// 11. transfers.txt
+	var transfers []models.Transfer
+	if result := db.Order("id asc").Find(&transfers); result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query transfers: " + result.Error.Error()})
+		return
+	}
+	if len(transfers) > 0 {
+		transferData := [][]string{}
+		for _, t := range transfers {
+			minTime := ""
+			if t.MinTransferTime != nil {
+				minTime = strconv.Itoa(*t.MinTransferTime)
+			}
+			transferData = append(transferData, []string{
+				optionalID(t.FromStopID), optionalID(t.ToStopID), draftRef(routeIDs, t.FromRouteID), draftRef(routeIDs, t.ToRouteID),
+				draftRef(tripIDs, t.FromTripID), draftRef(tripIDs, t.ToTripID), strconv.Itoa(t.Type), minTime,
+			})
+		}
+		if err := createCSV("transfers.txt", []string{"from_stop_id", "to_stop_id", "from_route_id", "to_route_id", "from_trip_id", "to_trip_id", "transfer_type", "min_transfer_time"}, transferData); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transfers.txt: " + err.Error()})
+			return
+		}
+	}
+
+	// 12. Fares v1 (fare_attributes.txt, fare_rules.txt) and v2 (areas.txt,
+	// stop_areas.txt, fare_media.txt, fare_products.txt, fare_leg_rules.txt)
+	if err := exportFares(db, routeIDs, createCSV); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export fares: " + err.Error()})
+		return
+	}
+
 	if err := zw.Close(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to finalize ZIP archive: " + err.Error()})
 		return
 	}
 
-	c.Header("Content-Disposition", "attachment; filename=gtfs_export.zip")
+	// A draft is only previewed; every published feed can be diffed against
+	// and rolled back to later
+	if draft, ok := currentDraft(c); ok {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=gtfs_export_draft_%d.zip", draft.ID))
+		c.Data(http.StatusOK, "application/zip", buf.Bytes())
+		LogActivity(c, "EXPORT", fmt.Sprintf("Draft [%s] has been exported as a GTFS ZIP archive.", draft.Name))
+		return
+	}
+	snap, err := snapshotExport(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to snapshot exported data: " + err.Error()})
+		return
+	}
+	c.Header(snapshotIDHeader, strconv.FormatUint(uint64(snap.ID), 10))
+
+	// Each workspace is its own feed
+	filename := "gtfs_export.zip"
+	if id := currentWorkspaceID(c); id != models.DefaultWorkspaceID {
+		filename = fmt.Sprintf("gtfs_export_workspace_%d.zip", id)
+	}
+	c.Header("Content-Disposition", "attachment; filename="+filename)
 	c.Header("Content-Type", "application/zip")
 	c.Data(http.StatusOK, "application/zip", buf.Bytes())
-	LogActivity("EXPORT", "The complete GTFS data bundle has been exported as a ZIP archive.")
+	LogActivity(c, "EXPORT", fmt.Sprintf("The complete GTFS data bundle has been exported as a ZIP archive (snapshot #%d).", snap.ID))
+}
+
+// optionalID writes an optional reference to another row, empty when unset
+func optionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(int(*id))
+}
+
+// draftRef writes an optional reference to a published route or trip as the
+// ID of its copy in the exported feed, see validator.RouteIDs
+func draftRef(ids map[uint]uint, id *uint) string {
+	if id == nil {
+		return ""
+	}
+	if copyID, ok := ids[*id]; ok {
+		return strconv.Itoa(int(copyID))
+	}
+	return strconv.Itoa(int(*id))
 }
 
 // --- Agency ---
 
 func GetAgencies(c *gin.Context) {
 	var agencies []models.Agency
-	database.DB.Find(&agencies)
+	workspaceDB(c).Find(&agencies)
 	c.JSON(http.StatusOK, agencies)
 }
 
+func GetAgency(c *gin.Context) {
+	var agency models.Agency
+	if err := workspaceDB(c).First(&agency, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agency not found"})
+		return
+	}
+	c.Header("ETag", versionETag(agency.Version))
+	c.JSON(http.StatusOK, agency)
+}
+
 func CreateAgency(c *gin.Context) {
 	var agency models.Agency
 	if err := c.ShouldBindJSON(&agency); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	database.DB.Create(&agency)
+	if err := workspaceDB(c).Create(&agency).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create agency: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "agency", agency.ID, nil, agency, fmt.Sprintf("New agency [%s] has been registered.", agency.Name))
 	c.JSON(http.StatusOK, agency)
 }
 
 func UpdateAgency(c *gin.Context) {
 	id := c.Param("id")
 	var agency models.Agency
-	if err := database.DB.First(&agency, id).Error; err != nil {
+	if err := workspaceDB(c).First(&agency, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Agency not found"})
 		return
 	}
+	before := agency
 	if err := c.ShouldBindJSON(&agency); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	database.DB.Save(&agency)
+	if !checkVersion(c, before.Version, agency.Version, before) {
+		return
+	}
+	agency.ID, agency.Version = before.ID, before.Version+1
+	saved, err := saveVersioned(workspaceDB(c), &agency, before.Version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update agency: " + err.Error()})
+		return
+	}
+	if !saved {
+		workspaceDB(c).First(&before, id)
+		respondConflict(c, versionETag(before.Version), before)
+		return
+	}
+	c.Header("ETag", versionETag(agency.Version))
+	LogChange(c, ActionUpdate, "agency", agency.ID, before, agency, fmt.Sprintf("Agency [%s] details have been updated.", agency.Name))
 	c.JSON(http.StatusOK, agency)
 }
 
 func DeleteAgency(c *gin.Context) {
+	db := allDraftsDB(c)
 	id := c.Param("id")
 
 	var agency models.Agency
 	agencyName := "Unknown"
-	if err := database.DB.First(&agency, id).Error; err == nil {
+	if err := db.First(&agency, id).Error; err == nil {
 		agencyName = agency.Name
+		if !checkVersion(c, agency.Version, 0, agency) {
+			return
+		}
 	}
 
-	tx := database.DB.Begin()
+	tx := db.Begin()
 	if tx.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
 		return
@@ -239,7 +521,7 @@ func DeleteAgency(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find trips"})
 			return
 		}
-		
+
 		shapeIDs := make(map[string]bool)
 		for _, t := range trips {
 			if t.ShapeID != "" {
@@ -250,6 +532,21 @@ func DeleteAgency(c *gin.Context) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip stops"})
 				return
 			}
+			if err := tx.Where("trip_id = ?", t.ID).Delete(&models.Frequency{}).Error; err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip frequencies"})
+				return
+			}
+			if err := tx.Where("from_trip_id = ? OR to_trip_id = ?", t.ID, t.ID).Delete(&models.Transfer{}).Error; err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip transfers"})
+				return
+			}
+			if err := tx.Where("trip_id = ?", t.ID).Delete(&models.AlertInformedEntity{}).Error; err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip alert entities"})
+				return
+			}
 		}
 		// Delete Trips
 		if err := tx.Where("route_id = ?", r.ID).Delete(&models.Trip{}).Error; err != nil {
@@ -273,6 +570,21 @@ func DeleteAgency(c *gin.Context) {
 		}
 
 		// Delete Route
+		if err := tx.Where("from_route_id = ? OR to_route_id = ?", r.ID, r.ID).Delete(&models.Transfer{}).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete route transfers"})
+			return
+		}
+		if err := tx.Where("route_id = ?", r.ID).Delete(&models.FareRule{}).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete route fare rules"})
+			return
+		}
+		if err := tx.Where("route_id = ?", r.ID).Delete(&models.AlertInformedEntity{}).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete route alert entities"})
+			return
+		}
 		if err := tx.Delete(&models.Route{}, r.ID).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete route"})
@@ -280,29 +592,111 @@ func DeleteAgency(c *gin.Context) {
 		}
 	}
 
-	// 3. Delete Agency
-	if err := tx.Delete(&models.Agency{}, id).Error; err != nil {
+	// 3. Delete the agency's fares
+	fares := tx.Model(&models.FareAttribute{}).Select("id").Where("agency_id = ?", id)
+	if err := tx.Where("fare_id IN (?)", fares).Delete(&models.FareRule{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete agency fare rules"})
+		return
+	}
+	if err := tx.Where("agency_id = ?", id).Delete(&models.FareAttribute{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete agency fares"})
+		return
+	}
+
+	if err := tx.Where("agency_id = ?", id).Delete(&models.AlertInformedEntity{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete agency alert entities"})
+		return
+	}
+
+	// 4. Delete Agency, unless it was updated in the meantime
+	res := tx.Where("version = ?", agency.Version).Delete(&models.Agency{}, id)
+	if res.Error != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete agency"})
 		return
 	}
+	if res.RowsAffected == 0 && agency.ID != 0 {
+		tx.Rollback()
+		workspaceDB(c).First(&agency, id)
+		respondConflict(c, versionETag(agency.Version), agency)
+		return
+	}
 
 	if err := tx.Commit().Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
 		return
 	}
-	LogActivity("DELETION", fmt.Sprintf("Agency [%s] (ID: #%s) and all its routes/trips have been removed.", agencyName, id))
+	LogChange(c, ActionDelete, "agency", id, agency, nil, fmt.Sprintf("Agency [%s] (ID: #%s) and all its routes/trips have been removed.", agencyName, id))
 	c.JSON(http.StatusOK, gin.H{"message": "Agency and all related data deleted"})
 }
 
 // --- Stop ---
 
+var stopSortFields = map[string]sortField[models.Stop]{
+	"id":   {"id", func(s models.Stop) interface{} { return s.ID }},
+	"name": {"name", func(s models.Stop) interface{} { return s.Name }},
+	"lat":  {"lat", func(s models.Stop) interface{} { return s.Lat }},
+	"lon":  {"lon", func(s models.Stop) interface{} { return s.Lon }},
+}
+
+// GetStops lists stops, or with ?route_id=, ?agency_id=, ?service_id= or
+// ?direction_id= only the stops of the matching trips, and with ?bbox= only
+// those in the viewport. ?location_type= and ?parent_station= walk the
+// station hierarchy. Paging and sorting follow listQuery.
 func GetStops(c *gin.Context) {
+	db := feedDB(c)
+	q, err := parseListQuery(c, stopSortFields, "id", func(s models.Stop) uint { return s.ID })
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query := db.Model(&models.Stop{})
+	trips, filtered, err := filterTrips(c, db, db.Model(&models.Trip{}).Select("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filtered {
+		query = query.Where("id IN (?)", db.Model(&models.TripStop{}).Select("stop_id").Where("trip_id IN (?)", trips))
+	}
+	for _, column := range []string{"location_type", "parent_station"} {
+		values, err := uintListParam(c, column)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(values) > 0 {
+			query = query.Where(column+" IN ?", values)
+		}
+	}
+	if v := c.Query("bbox"); v != "" {
+		box, err := parseBBox(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		index, err := spatial.Default.Get(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to index stops: " + err.Error()})
+			return
+		}
+		query = query.Where("id IN ?", index.Within(box))
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count stops"})
+		return
+	}
 	var stops []models.Stop
-	if err := database.DB.Find(&stops).Error; err != nil {
+	if err := q.apply(query).Find(&stops).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stops"})
 		return
 	}
+	stops, next := q.page(stops)
 
 	// Optimize: Fetch all stop-route validations in one query
 	// We want distinct route_ids for each stop based on trip_stops -> trips
@@ -311,10 +705,19 @@ func GetStops(c *gin.Context) {
 		RouteID uint
 	}
 	var results []Result
-	if err := database.DB.Table("trip_stops").
+	draft, _ := currentDraft(c)
+	assoc := db.Table("trip_stops").
 		Select("DISTINCT trip_stops.stop_id, trips.route_id").
 		Joins("JOIN trips ON trips.id = trip_stops.trip_id").
-		Scan(&results).Error; err != nil {
+		Where("trip_stops.draft_id = ? AND trip_stops.workspace_id = ?", draft.ID, currentWorkspaceID(c))
+	if q.limit > 0 {
+		ids := make([]uint, len(stops))
+		for i, s := range stops {
+			ids[i] = s.ID
+		}
+		assoc = assoc.Where("trip_stops.stop_id IN ?", ids)
+	}
+	if err := assoc.Scan(&results).Error; err != nil {
 		// Log but don't fail - stops can still be returned without route associations
 		fmt.Printf("Warning: Failed to fetch route associations: %v\n", err)
 	}
@@ -334,52 +737,176 @@ func GetStops(c *gin.Context) {
 		}
 	}
 
+	setPageHeaders(c, total, next)
 	c.JSON(http.StatusOK, stops)
 }
 
+func GetStop(c *gin.Context) {
+	db := feedDB(c)
+	var stop models.Stop
+	if err := db.First(&stop, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stop not found"})
+		return
+	}
+	stop.RouteIDs = []uint{}
+	if err := db.Model(&models.Trip{}).Where("id IN (?)", db.Model(&models.TripStop{}).Select("trip_id").Where("stop_id = ?", stop.ID)).
+		Distinct().Order("route_id").Pluck("route_id", &stop.RouteIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stop routes: " + err.Error()})
+		return
+	}
+	c.Header("ETag", versionETag(stop.Version))
+	c.JSON(http.StatusOK, stop)
+}
+
+// checkStopHierarchy checks that stop fits under its parent station and on
+// its level and, for an existing stop, that its children still fit under it
+// and that no trip serves it unless it stays a stop or platform
+func checkStopHierarchy(db *gorm.DB, stop models.Stop) error {
+	if stop.LevelID != nil {
+		var level models.Level
+		if err := db.First(&level, *stop.LevelID).Error; err != nil {
+			return fmt.Errorf("level #%d not found", *stop.LevelID)
+		}
+	}
+	var parent *models.Stop
+	if stop.ParentStation != nil {
+		if *stop.ParentStation == stop.ID {
+			return fmt.Errorf("stop [%s] cannot be its own parent station", stop.Name)
+		}
+		parent = &models.Stop{}
+		if err := db.First(parent, *stop.ParentStation).Error; err != nil {
+			return fmt.Errorf("parent station #%d not found", *stop.ParentStation)
+		}
+	}
+	if err := validator.CheckParentStation(stop, parent); err != nil {
+		return fmt.Errorf("stop [%s]: %w", stop.Name, err)
+	}
+	if stop.ID == 0 {
+		return nil
+	}
+	if stop.LocationType != models.LocationStop {
+		// Draft trips count too, they are merged as they are
+		var served int64
+		allDrafts := db.WithContext(database.WithAllDrafts(db.Statement.Context))
+		if err := allDrafts.Model(&models.TripStop{}).Where("stop_id = ?", stop.ID).Count(&served).Error; err != nil {
+			return err
+		}
+		if served > 0 {
+			return fmt.Errorf("stop [%s] is served by %d stop times and cannot become a %s", stop.Name, served, validator.LocationTypeName(stop.LocationType))
+		}
+	}
+	var children []models.Stop
+	if err := db.Where("parent_station = ?", stop.ID).Find(&children).Error; err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := validator.CheckParentStation(child, &stop); err != nil {
+			return fmt.Errorf("stop [%s] would no longer fit under [%s]: %w", child.Name, stop.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkServedStops checks that trips can serve the stops with the given IDs:
+// only stops and platforms can, not stations, entrances, nodes or boarding
+// areas
+func checkServedStops(db *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var stops []models.Stop
+	if err := db.Where("id IN ?", ids).Find(&stops).Error; err != nil {
+		return err
+	}
+	found := make(map[uint]models.Stop, len(stops))
+	for _, s := range stops {
+		found[s.ID] = s
+	}
+	for _, id := range ids {
+		s, ok := found[id]
+		if !ok {
+			return fmt.Errorf("stop #%d not found", id)
+		}
+		if s.LocationType != models.LocationStop {
+			return fmt.Errorf("stop [%s] is a %s; trips can only serve stops and platforms", s.Name, validator.LocationTypeName(s.LocationType))
+		}
+	}
+	return nil
+}
+
 func CreateStop(c *gin.Context) {
 	var stop models.Stop
 	if err := c.ShouldBindJSON(&stop); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := database.DB.Create(&stop).Error; err != nil {
+	if err := checkStopHierarchy(workspaceDB(c), stop); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Create(&stop).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stop: " + err.Error()})
 		return
 	}
-	LogActivity("REGISTRY", fmt.Sprintf("New stop [%s] has been successfully registered.", stop.Name))
+	LogChange(c, ActionCreate, "stop", stop.ID, nil, stop, fmt.Sprintf("New stop [%s] has been successfully registered.", stop.Name))
 	c.JSON(http.StatusOK, stop)
 }
 
 func UpdateStop(c *gin.Context) {
 	id := c.Param("id")
 	var stop models.Stop
-	if err := database.DB.First(&stop, id).Error; err != nil {
+	if err := workspaceDB(c).First(&stop, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Stop not found"})
 		return
 	}
+	before := stop
 	if err := c.ShouldBindJSON(&stop); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := database.DB.Save(&stop).Error; err != nil {
+	if !checkVersion(c, before.Version, stop.Version, before) {
+		return
+	}
+	stop.ID, stop.Version = before.ID, before.Version+1
+	if err := checkStopHierarchy(workspaceDB(c), stop); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	saved, err := saveVersioned(workspaceDB(c), &stop, before.Version)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update stop: " + err.Error()})
 		return
 	}
-	LogActivity("REGISTRY", fmt.Sprintf("Stop [%s] details have been updated.", stop.Name))
+	if !saved {
+		workspaceDB(c).First(&before, id)
+		respondConflict(c, versionETag(before.Version), before)
+		return
+	}
+	c.Header("ETag", versionETag(stop.Version))
+	LogChange(c, ActionUpdate, "stop", stop.ID, before, stop, fmt.Sprintf("Stop [%s] details have been updated.", stop.Name))
 	c.JSON(http.StatusOK, stop)
 }
 
 func DeleteStop(c *gin.Context) {
+	db := allDraftsDB(c)
 	id := c.Param("id")
 
 	var stop models.Stop
 	stopName := "Unknown"
-	if err := database.DB.First(&stop, id).Error; err == nil {
+	if err := db.First(&stop, id).Error; err == nil {
 		stopName = stop.Name
+		if !checkVersion(c, stop.Version, 0, stop) {
+			return
+		}
+	}
+	var children int64
+	db.Model(&models.Stop{}).Where("parent_station = ?", id).Count(&children)
+	if children > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Stop [%s] still has %d child stops; move or delete them first", stopName, children)})
+		return
 	}
 
-	tx := database.DB.Begin()
+	tx := db.Begin()
 	if tx.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
 		return
@@ -390,74 +917,168 @@ func DeleteStop(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete associated trip stops"})
 		return
 	}
+	if err := tx.Where("from_stop_id = ? OR to_stop_id = ?", id, id).Delete(&models.Pathway{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete associated pathways"})
+		return
+	}
+	if err := tx.Where("from_stop_id = ? OR to_stop_id = ?", id, id).Delete(&models.Transfer{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete associated transfers"})
+		return
+	}
+	if err := tx.Where("stop_id = ?", id).Delete(&models.AlertInformedEntity{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete associated alert entities"})
+		return
+	}
 
-	if err := tx.Delete(&models.Stop{}, id).Error; err != nil {
+	res := tx.Where("version = ?", stop.Version).Delete(&models.Stop{}, id)
+	if res.Error != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete stop"})
 		return
 	}
+	if res.RowsAffected == 0 && stop.ID != 0 {
+		tx.Rollback()
+		workspaceDB(c).First(&stop, id)
+		respondConflict(c, versionETag(stop.Version), stop)
+		return
+	}
 
 	if err := tx.Commit().Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
 		return
 	}
-	LogActivity("DELETION", fmt.Sprintf("Stop [%s] (ID: #%s) has been removed from the registry.", stopName, id))
+	LogChange(c, ActionDelete, "stop", id, stop, nil, fmt.Sprintf("Stop [%s] (ID: #%s) has been removed from the registry.", stopName, id))
 	c.JSON(http.StatusOK, gin.H{"message": "Stop deleted"})
 }
 
 // --- Route ---
 
+var routeSortFields = map[string]sortField[models.Route]{
+	"id":         {"id", func(r models.Route) interface{} { return r.ID }},
+	"short_name": {"short_name", func(r models.Route) interface{} { return r.ShortName }},
+	"long_name":  {"long_name", func(r models.Route) interface{} { return r.LongName }},
+	"agency_id":  {"agency_id", func(r models.Route) interface{} { return r.AgencyID }},
+}
+
+// GetRoutes lists routes, filtered by ?agency_id= and ?route_type=. Paging
+// and sorting follow listQuery.
 func GetRoutes(c *gin.Context) {
+	db := feedDB(c)
+	q, err := parseListQuery(c, routeSortFields, "id", func(r models.Route) uint { return r.ID })
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query := db.Model(&models.Route{})
+	agencyIDs, err := uintListParam(c, "agency_id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(agencyIDs) > 0 {
+		query = query.Where("agency_id IN ?", agencyIDs)
+	}
+	routeTypes, err := uintListParam(c, "route_type")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(routeTypes) > 0 {
+		query = query.Where("route_type IN ?", routeTypes)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count routes"})
+		return
+	}
 	var routes []models.Route
-	database.DB.Find(&routes)
+	if err := q.apply(query).Find(&routes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch routes"})
+		return
+	}
+	routes, next := q.page(routes)
+	setPageHeaders(c, total, next)
 	c.JSON(http.StatusOK, routes)
 }
 
+func GetRoute(c *gin.Context) {
+	var route models.Route
+	if err := feedDB(c).First(&route, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+		return
+	}
+	c.Header("ETag", versionETag(route.Version))
+	c.JSON(http.StatusOK, route)
+}
+
 func CreateRoute(c *gin.Context) {
+	db := feedDB(c)
 	var route models.Route
 	if err := c.ShouldBindJSON(&route); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := database.DB.Create(&route).Error; err != nil {
+	route.OriginID = nil // only set when a draft copies a published route
+	if err := db.Create(&route).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create route: " + err.Error()})
 		return
 	}
-	LogActivity("REGISTRY", fmt.Sprintf("New route [%s] %s has been registered.", route.ShortName, route.LongName))
+	LogChange(c, ActionCreate, "route", route.ID, nil, route, fmt.Sprintf("New route [%s] %s has been registered.", route.ShortName, route.LongName))
 	c.JSON(http.StatusOK, route)
 }
 
 func UpdateRoute(c *gin.Context) {
+	db := feedDB(c)
 	id := c.Param("id")
 	var route models.Route
-	if err := database.DB.First(&route, id).Error; err != nil {
+	if err := db.First(&route, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
 		return
 	}
+	before := route
 	if err := c.ShouldBindJSON(&route); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := database.DB.Save(&route).Error; err != nil {
+	if !checkVersion(c, before.Version, route.Version, before) {
+		return
+	}
+	route.ID, route.Version = before.ID, before.Version+1
+	saved, err := saveVersioned(db, &route, before.Version)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update route: " + err.Error()})
 		return
 	}
-	LogActivity("REGISTRY", fmt.Sprintf("Route [%s] %s configuration has been updated.", route.ShortName, route.LongName))
+	if !saved {
+		db.First(&before, id)
+		respondConflict(c, versionETag(before.Version), before)
+		return
+	}
+	c.Header("ETag", versionETag(route.Version))
+	LogChange(c, ActionUpdate, "route", route.ID, before, route, fmt.Sprintf("Route [%s] %s configuration has been updated.", route.ShortName, route.LongName))
 	c.JSON(http.StatusOK, route)
 }
 
 func DeleteRoute(c *gin.Context) {
+	db := feedDB(c)
 	id := c.Param("id")
 
 	// 0. Find Route name for logging
 	var route models.Route
 	routeName := "Unknown"
-	if err := database.DB.First(&route, id).Error; err == nil {
+	if err := db.First(&route, id).Error; err == nil {
 		routeName = fmt.Sprintf("[%s] %s", route.ShortName, route.LongName)
+		if !checkVersion(c, route.Version, 0, route) {
+			return
+		}
 	}
 
 	// Cascade delete via Transaction
-	tx := database.DB.Begin()
+	tx := db.Begin()
 	if tx.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
 		return
@@ -482,6 +1103,21 @@ func DeleteRoute(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip stops"})
 			return
 		}
+		if err := tx.Where("trip_id = ?", t.ID).Delete(&models.Frequency{}).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip frequencies"})
+			return
+		}
+		if err := tx.Where("from_trip_id = ? OR to_trip_id = ?", t.ID, t.ID).Delete(&models.Transfer{}).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip transfers"})
+			return
+		}
+		if err := tx.Where("trip_id = ?", t.ID).Delete(&models.AlertInformedEntity{}).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip alert entities"})
+			return
+		}
 	}
 
 	// 3. Delete Trips
@@ -505,10 +1141,33 @@ func DeleteRoute(c *gin.Context) {
 		}
 	}
 
-	// 5. Delete Route
-	if err := tx.Delete(&models.Route{}, id).Error; err != nil {
+	// 5. Delete Route with its transfers, fare rules and alert entities,
+	// unless it was updated in the meantime
+	if err := tx.Where("from_route_id = ? OR to_route_id = ?", id, id).Delete(&models.Transfer{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete route transfers"})
+		return
+	}
+	if err := tx.Where("route_id = ?", id).Delete(&models.FareRule{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete route fare rules"})
+		return
+	}
+	if err := tx.Where("route_id = ?", id).Delete(&models.AlertInformedEntity{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete route alert entities"})
+		return
+	}
+	res := tx.Where("version = ?", route.Version).Delete(&models.Route{}, id)
+	if res.Error != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete route: " + res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 && route.ID != 0 {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete route: " + err.Error()})
+		db.First(&route, id)
+		respondConflict(c, versionETag(route.Version), route)
 		return
 	}
 
@@ -516,46 +1175,162 @@ func DeleteRoute(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
 		return
 	}
-	LogActivity("DELETION", fmt.Sprintf("Route %s (ID: #%s) and all associated trips have been removed.", routeName, id))
+	LogChange(c, ActionDelete, "route", id, route, nil, fmt.Sprintf("Route %s (ID: #%s) and all associated trips have been removed.", routeName, id))
 	c.JSON(http.StatusOK, gin.H{"message": "Route and associated trips/shapes deleted"})
 }
 
 // --- Trip ---
 
+var tripSortFields = map[string]sortField[models.Trip]{
+	"id":         {"id", func(t models.Trip) interface{} { return t.ID }},
+	"route_id":   {"route_id", func(t models.Trip) interface{} { return t.RouteID }},
+	"service_id": {"service_id", func(t models.Trip) interface{} { return t.ServiceID }},
+	"headsign":   {"headsign", func(t models.Trip) interface{} { return t.Headsign }},
+	"shape_id":   {"shape_id", func(t models.Trip) interface{} { return t.ShapeID }},
+}
+
+// filterTrips narrows trips to the ?route_id=, ?agency_id=, ?service_id= and
+// ?direction_id= filters of the request and reports whether any was given
+func filterTrips(c *gin.Context, db, trips *gorm.DB) (*gorm.DB, bool, error) {
+	filtered := false
+	routeIDs, err := uintListParam(c, "route_id")
+	if err != nil {
+		return nil, false, err
+	}
+	if len(routeIDs) > 0 {
+		trips, filtered = trips.Where("route_id IN ?", routeIDs), true
+	}
+	agencyIDs, err := uintListParam(c, "agency_id")
+	if err != nil {
+		return nil, false, err
+	}
+	if len(agencyIDs) > 0 {
+		trips, filtered = trips.Where("route_id IN (?)", db.Model(&models.Route{}).Select("id").Where("agency_id IN ?", agencyIDs)), true
+	}
+	if serviceIDs := stringListParam(c, "service_id"); len(serviceIDs) > 0 {
+		trips, filtered = trips.Where("service_id IN ?", serviceIDs), true
+	}
+	directions, err := uintListParam(c, "direction_id")
+	if err != nil {
+		return nil, false, err
+	}
+	if len(directions) > 0 {
+		trips, filtered = trips.Where("direction_id IN ?", directions), true
+	}
+	return trips, filtered, nil
+}
+
+// GetTrips lists trips with their route, filtered as in filterTrips. Paging
+// and sorting follow listQuery.
 func GetTrips(c *gin.Context) {
+	db := feedDB(c)
+	q, err := parseListQuery(c, tripSortFields, "id", func(t models.Trip) uint { return t.ID })
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query, _, err := filterTrips(c, db, db.Model(&models.Trip{}))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count trips"})
+		return
+	}
 	var trips []models.Trip
-	database.DB.Preload("Route").Find(&trips)
+	if err := q.apply(query).Preload("Route").Find(&trips).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trips"})
+		return
+	}
+	trips, next := q.page(trips)
+	setPageHeaders(c, total, next)
 	c.JSON(http.StatusOK, trips)
 }
 
+func GetTrip(c *gin.Context) {
+	var trip models.Trip
+	if err := feedDB(c).Preload("Route").First(&trip, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return
+	}
+	c.Header("ETag", versionETag(trip.Version))
+	c.JSON(http.StatusOK, trip)
+}
+
 func CreateTrip(c *gin.Context) {
+	db := feedDB(c)
 	var trip models.Trip
 	if err := c.ShouldBindJSON(&trip); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	database.DB.Create(&trip)
+	if trip.ServiceID == "" {
+		trip.ServiceID = models.DefaultServiceID
+	}
+	trip.OriginID = nil // only set when a draft copies a published trip
+	if !serviceExists(db, trip.ServiceID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown service_id %s. Create the calendar first.", trip.ServiceID)})
+		return
+	}
+	if err := db.Create(&trip).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trip: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "trip", trip.ID, nil, trip, fmt.Sprintf("New trip #%d [%s] has been scheduled.", trip.ID, trip.Headsign))
 	c.JSON(http.StatusOK, trip)
 }
 
 func UpdateTrip(c *gin.Context) {
+	db := feedDB(c)
 	id := c.Param("id")
 	var trip models.Trip
-	if err := database.DB.First(&trip, id).Error; err != nil {
+	if err := db.First(&trip, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
 		return
 	}
+	before := trip
 	if err := c.ShouldBindJSON(&trip); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	database.DB.Save(&trip)
+	if !checkVersion(c, before.Version, trip.Version, before) {
+		return
+	}
+	// Partial updates keep the service; trips that never had one get the
+	// default, as on create
+	if trip.ServiceID == "" {
+		trip.ServiceID = before.ServiceID
+	}
+	if trip.ServiceID == "" {
+		trip.ServiceID = models.DefaultServiceID
+	}
+	if !serviceExists(db, trip.ServiceID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown service_id %s. Create the calendar first.", trip.ServiceID)})
+		return
+	}
+	trip.ID, trip.Version = before.ID, before.Version+1
+	saved, err := saveVersioned(db, &trip, before.Version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update trip: " + err.Error()})
+		return
+	}
+	if !saved {
+		db.First(&before, id)
+		respondConflict(c, versionETag(before.Version), before)
+		return
+	}
+	c.Header("ETag", versionETag(trip.Version))
+	LogChange(c, ActionUpdate, "trip", trip.ID, before, trip, fmt.Sprintf("Trip #%d [%s] has been updated.", trip.ID, trip.Headsign))
 	c.JSON(http.StatusOK, trip)
 }
 
 func DeleteTrip(c *gin.Context) {
+	db := feedDB(c)
 	id := c.Param("id")
-	tx := database.DB.Begin()
+	tx := db.Begin()
 	if tx.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
 		return
@@ -568,6 +1343,10 @@ func DeleteTrip(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
 		return
 	}
+	if !checkVersion(c, trip.Version, 0, trip) {
+		tx.Rollback()
+		return
+	}
 
 	// 2. Delete TripStops
 	if err := tx.Where("trip_id = ?", id).Delete(&models.TripStop{}).Error; err != nil {
@@ -576,14 +1355,38 @@ func DeleteTrip(c *gin.Context) {
 		return
 	}
 
-	// 3. Delete Trip
-	if err := tx.Delete(&models.Trip{}, id).Error; err != nil {
+	// 3. Delete Frequencies, Transfers and alert entities
+	if err := tx.Where("trip_id = ?", id).Delete(&models.Frequency{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip frequencies"})
+		return
+	}
+	if err := tx.Where("from_trip_id = ? OR to_trip_id = ?", id, id).Delete(&models.Transfer{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip transfers"})
+		return
+	}
+	if err := tx.Where("trip_id = ?", id).Delete(&models.AlertInformedEntity{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip alert entities"})
+		return
+	}
+
+	// 4. Delete Trip, unless it was updated in the meantime
+	res := tx.Where("version = ?", trip.Version).Delete(&models.Trip{}, id)
+	if res.Error != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip"})
 		return
 	}
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		db.First(&trip, id)
+		respondConflict(c, versionETag(trip.Version), trip)
+		return
+	}
 
-	// 4. Cleanup Shape if orphaned
+	// 5. Cleanup Shape if orphaned
 	if trip.ShapeID != "" {
 		var count int64
 		if err := tx.Model(&models.Trip{}).Where("shape_id = ?", trip.ShapeID).Count(&count).Error; err == nil {
@@ -601,17 +1404,18 @@ func DeleteTrip(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
 		return
 	}
-	LogActivity("DELETE_TRIP", fmt.Sprintf("Deleted trip ID: %s", id))
+	LogChange(c, ActionDelete, "trip", trip.ID, trip, nil, fmt.Sprintf("Trip #%d [%s] and its stop times have been removed.", trip.ID, trip.Headsign))
 	c.JSON(http.StatusOK, gin.H{"message": "Trip and associated stops/shapes deleted"})
 }
 
 // --- StopRoutes ---
 
 func GetStopRoutes(c *gin.Context) {
+	db := feedDB(c)
 	stopID := c.Param("id")
 	// Find all trips passing through this stop
 	var tripStops []models.TripStop
-	if err := database.DB.Where("stop_id = ?", stopID).Find(&tripStops).Error; err != nil {
+	if err := db.Where("stop_id = ?", stopID).Find(&tripStops).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trip stops: " + err.Error()})
 		return
 	}
@@ -624,7 +1428,7 @@ func GetStopRoutes(c *gin.Context) {
 		if !tripMap[ts.TripID] {
 			tripMap[ts.TripID] = true
 			var trip models.Trip
-			if err := database.DB.First(&trip, ts.TripID).Error; err != nil {
+			if err := db.First(&trip, ts.TripID).Error; err != nil {
 				// If a trip associated with a tripStop is not found, skip it.
 				// This might indicate data inconsistency, but we proceed with valid data.
 				continue
@@ -642,28 +1446,81 @@ func GetStopRoutes(c *gin.Context) {
 	var routes []models.Route
 	for rID := range uniqueRouteIDs {
 		var route models.Route
-		if err := database.DB.First(&route, rID).Error; err == nil {
+		if err := db.First(&route, rID).Error; err == nil {
 			routes = append(routes, route)
 		}
 	}
 	c.JSON(http.StatusOK, routes)
 }
 
+var tripStopSortFields = map[string]sortField[models.TripStop]{
+	"id":       {"id", func(ts models.TripStop) interface{} { return ts.ID }},
+	"trip_id":  {"trip_id", func(ts models.TripStop) interface{} { return ts.TripID }},
+	"stop_id":  {"stop_id", func(ts models.TripStop) interface{} { return ts.StopID }},
+	"sequence": {"sequence", func(ts models.TripStop) interface{} { return ts.Sequence }},
+}
+
+// GetAllStopRoutes lists the stop times with their trip, filtered by
+// ?stop_id=, ?trip_id= and the trip filters of filterTrips. Paging and
+// sorting follow listQuery.
 func GetAllStopRoutes(c *gin.Context) {
+	db := feedDB(c)
+	q, err := parseListQuery(c, tripStopSortFields, "id", func(ts models.TripStop) uint { return ts.ID })
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query := db.Model(&models.TripStop{})
+	for _, name := range []string{"stop_id", "trip_id"} {
+		ids, err := uintListParam(c, name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(ids) > 0 {
+			query = query.Where(name+" IN ?", ids)
+		}
+	}
+	trips, filtered, err := filterTrips(c, db, db.Model(&models.Trip{}).Select("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filtered {
+		query = query.Where("trip_id IN (?)", trips)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count stop times"})
+		return
+	}
 	var tripStops []models.TripStop
-	database.DB.Preload("Trip").Find(&tripStops)
+	if err := q.apply(query).Preload("Trip").Find(&tripStops).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stop times"})
+		return
+	}
+	tripStops, next := q.page(tripStops)
+	setPageHeaders(c, total, next)
 	c.JSON(http.StatusOK, tripStops)
 }
 
 func UpdateStopRoutes(c *gin.Context) {
+	db := feedDB(c)
 	stopID := castToUint(c.Param("id"))
 	var selectedRouteIDs []uint
 	if err := c.ShouldBindJSON(&selectedRouteIDs); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if len(selectedRouteIDs) > 0 {
+		if err := checkServedStops(db, []uint{stopID}); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
-	tx := database.DB.Begin()
+	tx := db.Begin()
 	if tx.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
 		return
@@ -680,6 +1537,14 @@ func UpdateStopRoutes(c *gin.Context) {
 		return
 	}
 
+	var beforeRouteIDs []uint
+	if err := tx.Model(&models.Trip{}).Where("id IN (?)", tx.Model(&models.TripStop{}).Select("trip_id").Where("stop_id = ?", stopID)).
+		Distinct().Order("route_id").Pluck("route_id", &beforeRouteIDs).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find existing routes"})
+		return
+	}
+
 	if err := tx.Where("stop_id = ?", stopID).Delete(&models.TripStop{}).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete existing trip stops"})
@@ -710,35 +1575,59 @@ func UpdateStopRoutes(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
 		return
 	}
+	LogChange(c, ActionUpdate, "stop_routes", stopID, gin.H{"route_ids": beforeRouteIDs}, gin.H{"route_ids": selectedRouteIDs},
+		fmt.Sprintf("Stop #%d now serves %d routes.", stopID, len(selectedRouteIDs)))
 	c.JSON(http.StatusOK, gin.H{"message": "Stop route assignments updated"})
 }
 
 // --- RouteStops ---
 
 func GetTripStops(c *gin.Context) {
+	db := feedDB(c)
 	tripID := c.Param("id")
 	var tripStops []models.TripStop
-	if err := database.DB.Preload("Stop").Where("trip_id = ?", tripID).Order("sequence asc").Find(&tripStops).Error; err != nil {
+	if err := db.Preload("Stop").Where("trip_id = ?", tripID).Order("sequence asc, id asc").Find(&tripStops).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trip stops: " + err.Error()})
 		return
 	}
+
+	// Hydrate the distance of each stop along the trip's shape
+	var trip models.Trip
+	if err := db.First(&trip, tripID).Error; err == nil && trip.ShapeID != "" {
+		var shape []models.ShapePoint
+		db.Where("shape_id = ?", trip.ShapeID).Order("sequence asc").Find(&shape)
+		coords := make(map[uint]models.Stop)
+		for _, ts := range tripStops {
+			coords[ts.StopID] = ts.Stop
+		}
+		schedule.HydrateStopDistances(shape, tripStops, coords)
+	}
+	c.Header("ETag", contentETag(stopTimeRows(tripStops)))
 	c.JSON(http.StatusOK, tripStops)
 }
 
 func AddStopToTrip(c *gin.Context) {
+	db := feedDB(c)
 	var ts models.TripStop
 	if err := c.ShouldBindJSON(&ts); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := database.DB.Create(&ts).Error; err != nil {
+	if err := checkServedStops(db, []uint{ts.StopID}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.Create(&ts).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add stop to trip: " + err.Error()})
 		return
 	}
+	LogChange(c, ActionCreate, "trip_stop", ts.ID, nil, stopTimeRows([]models.TripStop{ts})[0],
+		fmt.Sprintf("Stop #%d has been added to trip #%d.", ts.StopID, ts.TripID))
 	c.JSON(http.StatusOK, ts)
 }
 
 func UpdateTripStops(c *gin.Context) {
+	db := feedDB(c)
 	tripID := c.Param("id")
 	var tripStops []models.TripStop
 	if err := c.ShouldBindJSON(&tripStops); err != nil {
@@ -746,53 +1635,59 @@ func UpdateTripStops(c *gin.Context) {
 		return
 	}
 
-	tx := database.DB.Begin()
+	var trip models.Trip
+	if err := db.First(&trip, tripID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return
+	}
+
+	// Fill missing times from the distance along the trip's shape
+	sort.SliceStable(tripStops, func(i, j int) bool { return tripStops[i].Sequence < tripStops[j].Sequence })
+	stopIDs := make([]uint, len(tripStops))
+	for i := range tripStops {
+		tripStops[i].ID = 0
+		tripStops[i].TripID = trip.ID
+		stopIDs[i] = tripStops[i].StopID
+	}
+	if err := checkServedStops(db, stopIDs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := schedule.InterpolateTrip(db, trip.ShapeID, tripStops, interpolationOptions(db)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to interpolate stop times: " + err.Error()})
+		return
+	}
+
+	tx := db.Begin()
 	if tx.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction: " + tx.Error.Error()})
 		return
 	}
 
-	if err := tx.Where("trip_id = ?", tripID).Delete(&models.TripStop{}).Error; err != nil {
+	// Holding the trip makes concurrent replacements of its stops take turns
+	if err := lockForUpdate(tx).First(&trip, trip.ID).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete old stops: " + err.Error()})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
 		return
 	}
-
-	// Verify tripID conversion
-	tID, err := strconv.ParseUint(tripID, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trip ID"})
+	var previous []models.TripStop
+	if err := tx.Preload("Stop").Where("trip_id = ?", trip.ID).Order("sequence asc, id asc").Find(&previous).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trip stops: " + err.Error()})
+		return
+	}
+	if !checkIfMatch(c, contentETag(stopTimeRows(previous)), previous) {
+		tx.Rollback()
 		return
 	}
 
-	// Helper for time calculation
-	calculateTime := func(seq int) (string, string) {
-		baseTime := 8 * 60 // 08:00 in minutes
-		arrivalMin := baseTime + (seq * 5)
-		departureMin := arrivalMin + 5
-
-		excludeHours := func(m int) string {
-			h := (m / 60) % 24
-			min := m % 60
-			return fmt.Sprintf("%02d:%02d:00", h, min)
-		}
-		return excludeHours(arrivalMin), excludeHours(departureMin)
+	if err := tx.Where("trip_id = ?", trip.ID).Delete(&models.TripStop{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete old stops: " + err.Error()})
+		return
 	}
 
 	for _, ts := range tripStops {
-		ts.TripID = uint(tID)
-
-		// Ensure time is set
-		if ts.ArrivalTime == "" || ts.DepartureTime == "" {
-			arr, dep := calculateTime(ts.Sequence)
-			if ts.ArrivalTime == "" {
-				ts.ArrivalTime = arr
-			}
-			if ts.DepartureTime == "" {
-				ts.DepartureTime = dep
-			}
-		}
-
 		if err := tx.Create(&ts).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trip stop: " + err.Error()})
@@ -805,6 +1700,9 @@ func UpdateTripStops(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", contentETag(stopTimeRows(tripStops)))
+	LogChange(c, ActionUpdate, "trip_stops", trip.ID, stopTimeRows(previous), stopTimeRows(tripStops),
+		fmt.Sprintf("Stop sequence of trip #%d has been updated (%d stops).", trip.ID, len(tripStops)))
 	c.JSON(http.StatusOK, gin.H{"message": "Trip stops updated"})
 }
 
@@ -818,14 +1716,18 @@ func castToUint(s string) uint {
 
 // GetShape returns points for a specific shape_id
 func GetShape(c *gin.Context) {
+	db := feedDB(c)
 	shapeID := c.Param("shape_id")
 	var points []models.ShapePoint
-	database.DB.Where("shape_id = ?", shapeID).Order("sequence asc").Find(&points)
+	db.Where("shape_id = ?", shapeID).Order("sequence asc, id asc").Find(&points)
+	schedule.HydrateShapeDistances(points)
+	c.Header("ETag", contentETag(shapeRows(points)))
 	c.JSON(http.StatusOK, points)
 }
 
 // CreateShape receives an array of points and saves them
 func CreateShape(c *gin.Context) {
+	db := feedDB(c)
 	var points []models.ShapePoint
 	if err := c.ShouldBindJSON(&points); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -837,7 +1739,7 @@ func CreateShape(c *gin.Context) {
 	}
 
 	// Transaction to ensure atomicity
-	tx := database.DB.Begin()
+	tx := db.Begin()
 	for _, p := range points {
 		if err := tx.Create(&p).Error; err != nil {
 			tx.Rollback()
@@ -850,19 +1752,37 @@ func CreateShape(c *gin.Context) {
 		return
 	}
 
+	LogChange(c, ActionCreate, "shape", points[0].ShapeID, nil, shapeRows(points),
+		fmt.Sprintf("New shape [%s] has been drawn with %d points.", points[0].ShapeID, len(points)))
 	c.JSON(http.StatusOK, gin.H{"message": "Shape created", "count": len(points), "shape_id": points[0].ShapeID})
 }
 
 // UpdateShape replaces all points for a given shape_id
 func UpdateShape(c *gin.Context) {
+	db := feedDB(c)
 	shapeID := c.Param("shape_id")
 	var points []models.ShapePoint
 	if err := c.ShouldBindJSON(&points); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Sequence < points[j].Sequence })
+
+	tx := db.Begin()
 
-	tx := database.DB.Begin()
+	// Locking the current points makes a concurrent replacement wait and
+	// then see a different shape
+	var previous []models.ShapePoint
+	if err := lockForUpdate(tx).Where("shape_id = ?", shapeID).Order("sequence asc, id asc").Find(&previous).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shape points"})
+		return
+	}
+	schedule.HydrateShapeDistances(previous)
+	if !checkIfMatch(c, contentETag(shapeRows(previous)), previous) {
+		tx.Rollback()
+		return
+	}
 
 	// 1. Delete existing points
 	if err := tx.Where("shape_id = ?", shapeID).Delete(&models.ShapePoint{}).Error; err != nil {
@@ -886,11 +1806,15 @@ func UpdateShape(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
 		return
 	}
+	c.Header("ETag", contentETag(shapeRows(points)))
+	LogChange(c, ActionUpdate, "shape", shapeID, shapeRows(previous), shapeRows(points),
+		fmt.Sprintf("Shape [%s] has been redrawn with %d points.", shapeID, len(points)))
 	c.JSON(http.StatusOK, gin.H{"message": "Shape updated", "shape_id": shapeID})
 }
 
 // GetBulkShapes returns points for multiple shape_ids
 func GetBulkShapes(c *gin.Context) {
+	db := feedDB(c)
 	var shapeIDs []string
 	if err := c.ShouldBindJSON(&shapeIDs); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shape ID payload: " + err.Error()})
@@ -908,7 +1832,7 @@ func GetBulkShapes(c *gin.Context) {
 	}
 
 	var points []models.ShapePoint
-	if err := database.DB.Where("shape_id IN ?", shapeIDs).Order("sequence asc").Find(&points).Error; err != nil {
+	if err := db.Where("shape_id IN ?", shapeIDs).Order("sequence asc").Find(&points).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database retrieval failure: " + err.Error()})
 		return
 	}
@@ -918,23 +1842,34 @@ func GetBulkShapes(c *gin.Context) {
 	for _, p := range points {
 		result[p.ShapeID] = append(result[p.ShapeID], p)
 	}
+	for _, shape := range result {
+		schedule.HydrateShapeDistances(shape)
+	}
 	c.JSON(http.StatusOK, result)
 }
 
 // DeleteShape removes all points for a specific shape_id
 func DeleteShape(c *gin.Context) {
+	db := feedDB(c)
 	shapeID := c.Param("shape_id")
-	if err := database.DB.Where("shape_id = ?", shapeID).Delete(&models.ShapePoint{}).Error; err != nil {
+	var previous []models.ShapePoint
+	db.Where("shape_id = ?", shapeID).Order("sequence asc, id asc").Find(&previous)
+	if !checkIfMatch(c, contentETag(shapeRows(previous)), previous) {
+		return
+	}
+	if err := db.Where("shape_id = ?", shapeID).Delete(&models.ShapePoint{}).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	LogChange(c, ActionDelete, "shape", shapeID, shapeRows(previous), nil, fmt.Sprintf("Shape [%s] has been removed.", shapeID))
 	c.JSON(http.StatusOK, gin.H{"message": "Shape deleted"})
 }
 
 // GetUniqueShapes returns a list of all unique shape_ids
 func GetUniqueShapes(c *gin.Context) {
+	db := feedDB(c)
 	var shapes []string
-	if err := database.DB.Model(&models.ShapePoint{}).Distinct().Pluck("shape_id", &shapes).Error; err != nil {
+	if err := db.Model(&models.ShapePoint{}).Distinct().Pluck("shape_id", &shapes).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch unique shapes"})
 		return
 	}
@@ -943,43 +1878,21 @@ func GetUniqueShapes(c *gin.Context) {
 
 // GetStopTimes returns all scheduled times for a specific stop
 func GetStopTimes(c *gin.Context) {
+	db := feedDB(c)
 	stopID := c.Param("id")
 	var tripStops []models.TripStop
-	if err := database.DB.Preload("Trip.Route").Where("stop_id = ?", stopID).Find(&tripStops).Error; err != nil {
+	if err := db.Preload("Trip.Route").Where("stop_id = ?", stopID).Find(&tripStops).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stop times: " + err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, tripStops)
 }
 
-// --- Activity Logs ---
-
-func LogActivity(action string, details string) {
-	log := models.ActivityLog{
-		Timestamp: time.Now(),
-		Action:    action,
-		Details:   details,
-	}
-	if err := database.DB.Create(&log).Error; err != nil {
-		fmt.Printf("Error logging activity: %v\n", err)
-	}
-}
-
-func GetActivityLogs(c *gin.Context) {
-	var logs []models.ActivityLog
-	// Get last 50 logs, newest first
-	if err := database.DB.Order("timestamp desc").Limit(50).Find(&logs).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch activity logs: " + err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, logs)
-}
-
 // --- Settings ---
 
 func GetSettings(c *gin.Context) {
 	var settings []models.Setting
-	if err := database.DB.Find(&settings).Error; err != nil {
+	if err := workspaceDB(c).Find(&settings).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
 		return
 	}
@@ -997,10 +1910,16 @@ func UpdateSetting(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := database.DB.Save(&setting).Error; err != nil {
+	setting.WorkspaceID = currentWorkspaceID(c)
+	var before *models.Setting
+	var existing models.Setting
+	if err := workspaceDB(c).First(&existing, "key = ?", setting.Key).Error; err == nil {
+		before = &existing
+	}
+	if err := workspaceDB(c).Save(&setting).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update setting"})
 		return
 	}
-	LogActivity("SETTINGS", fmt.Sprintf("System setting [%s] has been updated.", setting.Key))
+	LogChange(c, ActionUpdate, "setting", setting.Key, before, setting, fmt.Sprintf("System setting [%s] has been updated.", setting.Key))
 	c.JSON(http.StatusOK, setting)
 }
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"gtfs-cms/models"
+	"strings"
+	"testing"
+)
+
+func TestServedStopsStayPlatforms(t *testing.T) {
+	db := testDB(t)
+	station := models.Stop{Name: "Central", Lat: 1, Lon: 1, LocationType: models.LocationStation}
+	db.Create(&station)
+	platform := models.Stop{Name: "Central 1", Lat: 1, Lon: 1, ParentStation: &station.ID}
+	entrance := models.Stop{Name: "Central Entrance", Lat: 1, Lon: 1, LocationType: models.LocationEntrance, ParentStation: &station.ID}
+	db.Create(&platform)
+	db.Create(&entrance)
+	db.Create(&models.TripStop{TripID: 1, StopID: platform.ID, Sequence: 1})
+
+	if err := checkServedStops(db, []uint{platform.ID}); err != nil {
+		t.Errorf("checkServedStops(platform) = %v, want nil", err)
+	}
+	for _, id := range []uint{station.ID, entrance.ID, 99} {
+		if err := checkServedStops(db, []uint{platform.ID, id}); err == nil {
+			t.Errorf("checkServedStops(#%d) = nil, want an error", id)
+		}
+	}
+
+	// A served platform cannot turn into a node
+	platform.LocationType = models.LocationGenericNode
+	if err := checkStopHierarchy(db, platform); err == nil || !strings.Contains(err.Error(), "served by 1 stop times") {
+		t.Errorf("checkStopHierarchy() = %v, want the served stop to be refused", err)
+	}
+	entrance.LocationType = models.LocationGenericNode
+	if err := checkStopHierarchy(db, entrance); err != nil {
+		t.Errorf("checkStopHierarchy() = %v, want an unserved entrance to become a node", err)
+	}
+}
@@ -0,0 +1,688 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"gtfs-cms/gtfs"
+	"gtfs-cms/models"
+	"gtfs-cms/validator"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ImportFileSummary reports what happened to the rows of a single GTFS file
+type ImportFileSummary struct {
+	Created  int      `json:"created"`
+	Skipped  int      `json:"skipped"`
+	Rejected int      `json:"rejected"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// maxImportErrors caps the number of row messages kept per file so a broken
+// feed does not produce a multi-megabyte response.
+const maxImportErrors = 50
+
+func (s *ImportFileSummary) reject(line int, format string, args ...interface{}) {
+	s.Rejected++
+	if len(s.Errors) < maxImportErrors {
+		s.Errors = append(s.Errors, fmt.Sprintf("line %d: ", line)+fmt.Sprintf(format, args...))
+	}
+}
+
+// gtfsTable is a parsed GTFS CSV file addressed by column name
+type gtfsTable struct {
+	columns map[string]int
+	rows    [][]string
+}
+
+func (t *gtfsTable) get(row []string, column string) string {
+	i, ok := t.columns[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+func readGTFSTable(f *zip.File) (*gtfsTable, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	r := csv.NewReader(rc)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return &gtfsTable{columns: map[string]int{}}, nil
+	}
+
+	columns := make(map[string]int)
+	for i, h := range records[0] {
+		h = strings.TrimPrefix(h, "\ufeff")
+		columns[strings.TrimSpace(h)] = i
+	}
+	return &gtfsTable{columns: columns, rows: records[1:]}, nil
+}
+
+// ImportGTFS loads a GTFS ZIP bundle into the database in a single transaction.
+// GTFS string IDs are mapped to newly created primary keys; rows that cannot be
+// mapped are rejected and reported per file instead of aborting the import.
+func ImportGTFS(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A GTFS ZIP must be uploaded in the 'file' form field"})
+		return
+	}
+	f, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to open upload: " + err.Error()})
+		return
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload: " + err.Error()})
+		return
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload is not a valid ZIP archive: " + err.Error()})
+		return
+	}
+
+	// Feeds are sometimes zipped inside a folder, so match on the base name
+	tables := make(map[string]*gtfsTable)
+	for _, zf := range zr.File {
+		name := path.Base(zf.Name)
+		if zf.FileInfo().IsDir() || !strings.HasSuffix(name, ".txt") {
+			continue
+		}
+		table, err := readGTFSTable(zf)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to parse %s: %v", name, err)})
+			return
+		}
+		tables[name] = table
+	}
+
+	for _, required := range []string{"agency.txt", "stops.txt", "routes.txt", "trips.txt", "stop_times.txt"} {
+		if _, ok := tables[required]; !ok {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Required file %s is missing from the feed", required)})
+			return
+		}
+	}
+	_, hasCalendar := tables["calendar.txt"]
+	_, hasCalendarDates := tables["calendar_dates.txt"]
+	if !hasCalendar && !hasCalendarDates {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "The feed needs calendar.txt or calendar_dates.txt"})
+		return
+	}
+
+	tx := workspaceDB(c).Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
+		return
+	}
+
+	summary, err := importTables(tx, tables)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Import failed: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+
+	LogActivity(c, "IMPORT", fmt.Sprintf("GTFS feed [%s] has been imported: %d agencies, %d routes, %d trips.",
+		fileHeader.Filename, summary["agency.txt"].Created, summary["routes.txt"].Created, summary["trips.txt"].Created))
+	c.JSON(http.StatusOK, gin.H{"message": "GTFS feed imported", "files": summary})
+}
+
+// importedFiles lists the GTFS files importTables knows how to read
+var importedFiles = map[string]bool{
+	"agency.txt":         true,
+	"stops.txt":          true,
+	"routes.txt":         true,
+	"calendar.txt":       true,
+	"calendar_dates.txt": true,
+	"shapes.txt":         true,
+	"trips.txt":          true,
+	"stop_times.txt":     true,
+	"frequencies.txt":    true,
+}
+
+// importTables writes the parsed feed through tx. Only database failures are
+// returned as errors; bad rows are counted in the summary.
+func importTables(tx *gorm.DB, tables map[string]*gtfsTable) (map[string]*ImportFileSummary, error) {
+	summary := make(map[string]*ImportFileSummary)
+	for name := range tables {
+		summary[name] = &ImportFileSummary{}
+	}
+
+	// 1. agency.txt
+	agencyIDs := make(map[string]uint)
+	t, s := tables["agency.txt"], summary["agency.txt"]
+	for i, row := range t.rows {
+		line := i + 2
+		gtfsID := t.get(row, "agency_id")
+		if _, dup := agencyIDs[gtfsID]; dup {
+			s.reject(line, "duplicate agency_id %q", gtfsID)
+			continue
+		}
+		agency := models.Agency{
+			Name:     t.get(row, "agency_name"),
+			Url:      t.get(row, "agency_url"),
+			Timezone: t.get(row, "agency_timezone"),
+		}
+		if agency.Name == "" || agency.Timezone == "" {
+			s.reject(line, "agency_name and agency_timezone are required")
+			continue
+		}
+		if err := tx.Create(&agency).Error; err != nil {
+			return nil, fmt.Errorf("agency.txt: %w", err)
+		}
+		agencyIDs[gtfsID] = agency.ID
+		s.Created++
+	}
+
+	// 2. stops.txt. Stations go first and boarding areas last, so every
+	// parent_station exists by the time its children are created.
+	stopIDs := make(map[string]uint)
+	stopsByID := make(map[uint]models.Stop)
+	type pendingStop struct {
+		line           int
+		gtfsID, parent string
+		hasCoords      bool
+		stop           models.Stop
+	}
+	var pending []pendingStop
+	seen := make(map[string]bool)
+	t, s = tables["stops.txt"], summary["stops.txt"]
+	for i, row := range t.rows {
+		line := i + 2
+		gtfsID := t.get(row, "stop_id")
+		if gtfsID == "" {
+			s.reject(line, "stop_id is required")
+			continue
+		}
+		if seen[gtfsID] {
+			s.reject(line, "duplicate stop_id %q", gtfsID)
+			continue
+		}
+		seen[gtfsID] = true
+		locationType := models.LocationStop
+		if v := t.get(row, "location_type"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < models.LocationStop || n > models.LocationBoardingArea {
+				s.reject(line, "stop %q has an invalid location_type %q", gtfsID, v)
+				continue
+			}
+			locationType = n
+		}
+		p := pendingStop{line: line, gtfsID: gtfsID, parent: t.get(row, "parent_station"), stop: models.Stop{
+			Name:         t.get(row, "stop_name"),
+			LocationType: locationType,
+			PlatformCode: t.get(row, "platform_code"),
+			StopCode:     t.get(row, "stop_code"),
+			ZoneID:       t.get(row, "zone_id"),
+		}}
+		// Generic nodes and boarding areas may leave out their coordinates
+		// and name; they take their parent's
+		lat, errLat := strconv.ParseFloat(t.get(row, "stop_lat"), 64)
+		lon, errLon := strconv.ParseFloat(t.get(row, "stop_lon"), 64)
+		p.hasCoords = errLat == nil && errLon == nil
+		p.stop.Lat, p.stop.Lon = lat, lon
+		optional := locationType == models.LocationGenericNode || locationType == models.LocationBoardingArea
+		if !p.hasCoords && !optional {
+			s.reject(line, "stop %q has invalid coordinates", gtfsID)
+			continue
+		}
+		if p.stop.Name == "" && !optional {
+			s.reject(line, "stop %q has no stop_name", gtfsID)
+			continue
+		}
+		pending = append(pending, p)
+	}
+	level := func(p pendingStop) int {
+		switch p.stop.LocationType {
+		case models.LocationStation:
+			return 0
+		case models.LocationBoardingArea:
+			return 2
+		}
+		return 1
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return level(pending[i]) < level(pending[j]) })
+	for _, p := range pending {
+		stop := p.stop
+		var parent *models.Stop
+		if p.parent != "" {
+			id, ok := stopIDs[p.parent]
+			if !ok {
+				s.reject(p.line, "stop %q references unknown parent_station %q", p.gtfsID, p.parent)
+				continue
+			}
+			found := stopsByID[id]
+			parent, stop.ParentStation = &found, &id
+		}
+		if err := validator.CheckParentStation(stop, parent); err != nil {
+			s.reject(p.line, "stop %q: %v", p.gtfsID, err)
+			continue
+		}
+		if !p.hasCoords {
+			stop.Lat, stop.Lon = parent.Lat, parent.Lon
+		}
+		if stop.Name == "" {
+			stop.Name = parent.Name
+		}
+		if err := tx.Create(&stop).Error; err != nil {
+			return nil, fmt.Errorf("stops.txt: %w", err)
+		}
+		stopIDs[p.gtfsID] = stop.ID
+		stopsByID[stop.ID] = stop
+		s.Created++
+	}
+
+	// 3. routes.txt
+	routeIDs := make(map[string]uint)
+	t, s = tables["routes.txt"], summary["routes.txt"]
+	for i, row := range t.rows {
+		line := i + 2
+		gtfsID := t.get(row, "route_id")
+		if gtfsID == "" {
+			s.reject(line, "route_id is required")
+			continue
+		}
+		if _, dup := routeIDs[gtfsID]; dup {
+			s.reject(line, "duplicate route_id %q", gtfsID)
+			continue
+		}
+
+		agencyID, ok := agencyIDs[t.get(row, "agency_id")]
+		if !ok && t.get(row, "agency_id") == "" && len(agencyIDs) == 1 {
+			// agency_id is optional when the feed has a single agency
+			for _, id := range agencyIDs {
+				agencyID, ok = id, true
+			}
+		}
+		if !ok {
+			s.reject(line, "route %q references unknown agency_id %q", gtfsID, t.get(row, "agency_id"))
+			continue
+		}
+
+		route := models.Route{
+			ShortName: t.get(row, "route_short_name"),
+			LongName:  t.get(row, "route_long_name"),
+			Color:     strings.TrimPrefix(t.get(row, "route_color"), "#"),
+			AgencyID:  agencyID,
+			NetworkID: t.get(row, "network_id"),
+		}
+		if route.ShortName == "" && route.LongName == "" {
+			s.reject(line, "route %q needs route_short_name or route_long_name", gtfsID)
+			continue
+		}
+		if v := t.get(row, "route_type"); v != "" {
+			rType, err := strconv.Atoi(v)
+			if err != nil {
+				s.reject(line, "route %q has invalid route_type %q", gtfsID, v)
+				continue
+			}
+			route.RouteType = &rType
+		}
+		if v := strings.TrimPrefix(t.get(row, "route_text_color"), "#"); v != "" {
+			route.TextColor = &v
+		}
+		if v := t.get(row, "route_desc"); v != "" {
+			route.RouteDesc = &v
+		}
+		if v := t.get(row, "route_url"); v != "" {
+			route.RouteUrl = &v
+		}
+		if err := tx.Create(&route).Error; err != nil {
+			return nil, fmt.Errorf("routes.txt: %w", err)
+		}
+		routeIDs[gtfsID] = route.ID
+		s.Created++
+	}
+
+	// 4. calendar.txt
+	serviceIDs := make(map[string]string)
+	if t, ok := tables["calendar.txt"]; ok {
+		s = summary["calendar.txt"]
+		for i, row := range t.rows {
+			line := i + 2
+			gtfsID := t.get(row, "service_id")
+			if _, dup := serviceIDs[gtfsID]; dup {
+				s.reject(line, "duplicate service_id %q", gtfsID)
+				continue
+			}
+			cal := models.Calendar{
+				ServiceID: gtfsID,
+				Monday:    t.get(row, "monday") == "1",
+				Tuesday:   t.get(row, "tuesday") == "1",
+				Wednesday: t.get(row, "wednesday") == "1",
+				Thursday:  t.get(row, "thursday") == "1",
+				Friday:    t.get(row, "friday") == "1",
+				Saturday:  t.get(row, "saturday") == "1",
+				Sunday:    t.get(row, "sunday") == "1",
+				StartDate: t.get(row, "start_date"),
+				EndDate:   t.get(row, "end_date"),
+			}
+			if err := validateCalendar(&cal); err != nil {
+				s.reject(line, "%v", err)
+				continue
+			}
+			localID, err := freeServiceID(tx, gtfsID)
+			if err != nil {
+				return nil, fmt.Errorf("calendar.txt: %w", err)
+			}
+			cal.ServiceID = localID
+			if err := tx.Create(&cal).Error; err != nil {
+				return nil, fmt.Errorf("calendar.txt: %w", err)
+			}
+			serviceIDs[gtfsID] = localID
+			s.Created++
+		}
+	}
+
+	// 5. calendar_dates.txt - services defined only by exceptions get a
+	// calendar with no weekdays spanning their first to last date.
+	if t, ok := tables["calendar_dates.txt"]; ok {
+		s = summary["calendar_dates.txt"]
+		type pendingDate struct {
+			line int
+			date models.CalendarDate
+		}
+		var pending []pendingDate
+		span := make(map[string][2]string)
+		for i, row := range t.rows {
+			line := i + 2
+			gtfsID := t.get(row, "service_id")
+			exceptionType, _ := strconv.Atoi(t.get(row, "exception_type"))
+			d := models.CalendarDate{ServiceID: gtfsID, Date: t.get(row, "date"), ExceptionType: exceptionType}
+			if gtfsID == "" {
+				s.reject(line, "service_id is required")
+				continue
+			}
+			if err := validateCalendarDate(&d); err != nil {
+				s.reject(line, "%v", err)
+				continue
+			}
+			pending = append(pending, pendingDate{line: line, date: d})
+			if _, known := serviceIDs[gtfsID]; !known {
+				r, seen := span[gtfsID]
+				if !seen || d.Date < r[0] {
+					r[0] = d.Date
+				}
+				if !seen || d.Date > r[1] {
+					r[1] = d.Date
+				}
+				span[gtfsID] = r
+			}
+		}
+
+		for gtfsID, r := range span {
+			localID, err := freeServiceID(tx, gtfsID)
+			if err != nil {
+				return nil, fmt.Errorf("calendar_dates.txt: %w", err)
+			}
+			if err := tx.Create(&models.Calendar{ServiceID: localID, StartDate: r[0], EndDate: r[1]}).Error; err != nil {
+				return nil, fmt.Errorf("calendar_dates.txt: %w", err)
+			}
+			serviceIDs[gtfsID] = localID
+		}
+
+		seen := make(map[string]bool)
+		for _, p := range pending {
+			key := p.date.ServiceID + "/" + p.date.Date
+			if seen[key] {
+				s.reject(p.line, "duplicate exception for service %q on %s", p.date.ServiceID, p.date.Date)
+				continue
+			}
+			seen[key] = true
+			p.date.ServiceID = serviceIDs[p.date.ServiceID]
+			if err := tx.Create(&p.date).Error; err != nil {
+				return nil, fmt.Errorf("calendar_dates.txt: %w", err)
+			}
+			s.Created++
+		}
+	}
+
+	// 6. shapes.txt
+	shapeIDs := make(map[string]string)
+	if t, ok := tables["shapes.txt"]; ok {
+		s = summary["shapes.txt"]
+		grouped := make(map[string][]models.ShapePoint)
+		var order []string
+		for i, row := range t.rows {
+			line := i + 2
+			gtfsID := t.get(row, "shape_id")
+			lat, errLat := strconv.ParseFloat(t.get(row, "shape_pt_lat"), 64)
+			lon, errLon := strconv.ParseFloat(t.get(row, "shape_pt_lon"), 64)
+			seq, errSeq := strconv.Atoi(t.get(row, "shape_pt_sequence"))
+			if gtfsID == "" || errLat != nil || errLon != nil || errSeq != nil {
+				s.reject(line, "shape point needs shape_id, shape_pt_lat, shape_pt_lon and shape_pt_sequence")
+				continue
+			}
+			if _, seen := grouped[gtfsID]; !seen {
+				order = append(order, gtfsID)
+			}
+			grouped[gtfsID] = append(grouped[gtfsID], models.ShapePoint{Lat: lat, Lon: lon, Sequence: seq})
+		}
+
+		for _, gtfsID := range order {
+			localID, err := freeShapeID(tx, gtfsID)
+			if err != nil {
+				return nil, fmt.Errorf("shapes.txt: %w", err)
+			}
+			points := grouped[gtfsID]
+			sort.SliceStable(points, func(a, b int) bool { return points[a].Sequence < points[b].Sequence })
+			for j := range points {
+				points[j].ShapeID = localID
+			}
+			if err := tx.CreateInBatches(&points, 500).Error; err != nil {
+				return nil, fmt.Errorf("shapes.txt: %w", err)
+			}
+			shapeIDs[gtfsID] = localID
+			s.Created += len(points)
+		}
+	}
+
+	// 7. trips.txt
+	tripIDs := make(map[string]uint)
+	t, s = tables["trips.txt"], summary["trips.txt"]
+	for i, row := range t.rows {
+		line := i + 2
+		gtfsID := t.get(row, "trip_id")
+		if gtfsID == "" {
+			s.reject(line, "trip_id is required")
+			continue
+		}
+		if _, dup := tripIDs[gtfsID]; dup {
+			s.reject(line, "duplicate trip_id %q", gtfsID)
+			continue
+		}
+		routeID, ok := routeIDs[t.get(row, "route_id")]
+		if !ok {
+			s.reject(line, "trip %q references unknown route_id %q", gtfsID, t.get(row, "route_id"))
+			continue
+		}
+		serviceID, ok := serviceIDs[t.get(row, "service_id")]
+		if !ok {
+			s.reject(line, "trip %q references unknown service_id %q", gtfsID, t.get(row, "service_id"))
+			continue
+		}
+		trip := models.Trip{
+			RouteID:   routeID,
+			ServiceID: serviceID,
+			Headsign:  t.get(row, "trip_headsign"),
+		}
+		if v := t.get(row, "shape_id"); v != "" {
+			localID, ok := shapeIDs[v]
+			if !ok {
+				s.reject(line, "trip %q references unknown shape_id %q", gtfsID, v)
+				continue
+			}
+			trip.ShapeID = localID
+		}
+		if v := t.get(row, "direction_id"); v != "" {
+			dir, err := strconv.Atoi(v)
+			if err != nil || (dir != 0 && dir != 1) {
+				s.reject(line, "trip %q has invalid direction_id %q", gtfsID, v)
+				continue
+			}
+			trip.DirectionID = &dir
+		}
+		if err := tx.Create(&trip).Error; err != nil {
+			return nil, fmt.Errorf("trips.txt: %w", err)
+		}
+		tripIDs[gtfsID] = trip.ID
+		s.Created++
+	}
+
+	// 8. stop_times.txt
+	t, s = tables["stop_times.txt"], summary["stop_times.txt"]
+	var tripStops []models.TripStop
+	for i, row := range t.rows {
+		line := i + 2
+		tripID, ok := tripIDs[t.get(row, "trip_id")]
+		if !ok {
+			s.reject(line, "unknown trip_id %q", t.get(row, "trip_id"))
+			continue
+		}
+		stopID, ok := stopIDs[t.get(row, "stop_id")]
+		if !ok {
+			s.reject(line, "unknown stop_id %q", t.get(row, "stop_id"))
+			continue
+		}
+		if stop := stopsByID[stopID]; stop.LocationType != models.LocationStop {
+			s.reject(line, "stop_id %q is a %s; trips can only serve stops and platforms", t.get(row, "stop_id"), validator.LocationTypeName(stop.LocationType))
+			continue
+		}
+		seq, err := strconv.Atoi(t.get(row, "stop_sequence"))
+		if err != nil {
+			s.reject(line, "invalid stop_sequence %q", t.get(row, "stop_sequence"))
+			continue
+		}
+
+		// Times are optional for non-timepoint stops; normalize the ones we have
+		arr, dep := t.get(row, "arrival_time"), t.get(row, "departure_time")
+		if arr == "" {
+			arr = dep
+		}
+		if dep == "" {
+			dep = arr
+		}
+		if arr != "" {
+			if arr, err = gtfs.NormalizeTime(arr); err != nil {
+				s.reject(line, "%v", err)
+				continue
+			}
+			if dep, err = gtfs.NormalizeTime(dep); err != nil {
+				s.reject(line, "%v", err)
+				continue
+			}
+		}
+
+		tripStops = append(tripStops, models.TripStop{
+			TripID:        tripID,
+			StopID:        stopID,
+			Sequence:      seq,
+			ArrivalTime:   arr,
+			DepartureTime: dep,
+		})
+	}
+	if len(tripStops) > 0 {
+		if err := tx.CreateInBatches(&tripStops, 500).Error; err != nil {
+			return nil, fmt.Errorf("stop_times.txt: %w", err)
+		}
+	}
+	s.Created += len(tripStops)
+
+	// 9. frequencies.txt
+	if t, ok := tables["frequencies.txt"]; ok {
+		s = summary["frequencies.txt"]
+		for i, row := range t.rows {
+			line := i + 2
+			tripID, ok := tripIDs[t.get(row, "trip_id")]
+			if !ok {
+				s.reject(line, "unknown trip_id %q", t.get(row, "trip_id"))
+				continue
+			}
+			headway, _ := strconv.Atoi(t.get(row, "headway_secs"))
+			f := models.Frequency{
+				TripID:      tripID,
+				StartTime:   t.get(row, "start_time"),
+				EndTime:     t.get(row, "end_time"),
+				HeadwaySecs: headway,
+				ExactTimes:  t.get(row, "exact_times") == "1",
+			}
+			if err := validateFrequency(tx, &f); err != nil {
+				s.reject(line, "%v", err)
+				continue
+			}
+			if err := tx.Create(&f).Error; err != nil {
+				return nil, fmt.Errorf("frequencies.txt: %w", err)
+			}
+			s.Created++
+		}
+	}
+
+	// Files the CMS has no model for are reported as skipped in full
+	for name, t := range tables {
+		if !importedFiles[name] {
+			summary[name].Skipped = len(t.rows)
+		}
+	}
+
+	return summary, nil
+}
+
+// freeServiceID returns gtfsID if no calendar uses it yet, otherwise the first
+// suffixed variant that is free.
+func freeServiceID(tx *gorm.DB, gtfsID string) (string, error) {
+	candidate := gtfsID
+	for n := 2; ; n++ {
+		var count int64
+		if err := tx.Model(&models.Calendar{}).Where("service_id = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s_%d", gtfsID, n)
+	}
+}
+
+// freeShapeID returns gtfsID if no shape uses it yet, otherwise the first
+// suffixed variant that is free, so an import never merges into existing shapes.
+func freeShapeID(tx *gorm.DB, gtfsID string) (string, error) {
+	candidate := gtfsID
+	for n := 2; ; n++ {
+		var count int64
+		if err := tx.Model(&models.ShapePoint{}).Where("shape_id = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s_%d", gtfsID, n)
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"gtfs-cms/database"
+	"gtfs-cms/models"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB points database.DB at an empty SQLite database for the test, scoped
+// to drafts and workspaces as in production
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gtfs.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&models.Workspace{}, &models.Agency{}, &models.Level{}, &models.Stop{}, &models.Pathway{}, &models.Transfer{}, &models.Route{},
+		&models.FareAttribute{}, &models.FareRule{}, &models.Area{}, &models.FareMedia{}, &models.FareProduct{}, &models.FareLegRule{},
+		&models.Trip{}, &models.ShapePoint{}, &models.TripStop{}, &models.ActivityLog{}, &models.Calendar{}, &models.CalendarDate{},
+		&models.Frequency{}, &models.Snapshot{}, &models.Draft{}, &models.Alert{}, &models.AlertActivePeriod{}, &models.AlertInformedEntity{},
+		&models.Webhook{}, &models.WebhookDelivery{}, &models.WorkspaceMember{}, &models.Setting{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.RegisterScopes(db); err != nil {
+		t.Fatal(err)
+	}
+	prev := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = prev })
+	return db
+}
+
+// seedRoundTrip stores one agency with a station, its two platforms and an
+// entrance, one route and two trips over the platforms along shape S1
+func seedRoundTrip(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	station := models.Stop{Name: "Central", Lat: -7.39, Lon: 109.36, LocationType: models.LocationStation}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(db.Create(&models.Agency{Name: "Metro", Url: "https://metro.example", Timezone: "Asia/Jakarta"}).Error)
+	must(db.Create(&station).Error)
+	platforms := []models.Stop{
+		{Name: "Central 1", Lat: -7.3901, Lon: 109.3601, ParentStation: &station.ID, PlatformCode: "1"},
+		{Name: "Market", Lat: -7.40, Lon: 109.37},
+		{Name: "Central Entrance", Lat: -7.3899, Lon: 109.3599, LocationType: models.LocationEntrance, ParentStation: &station.ID},
+	}
+	must(db.Create(&platforms).Error)
+	must(db.Create(&models.Calendar{ServiceID: "DAILY", Monday: true, Sunday: true, StartDate: "20250101", EndDate: "20251231"}).Error)
+	route := models.Route{ShortName: "1", LongName: "Central - Market", Color: "FF0000", AgencyID: 1}
+	must(db.Create(&route).Error)
+	must(db.Create(&[]models.ShapePoint{
+		{ShapeID: "S1", Lat: -7.3901, Lon: 109.3601, Sequence: 1},
+		{ShapeID: "S1", Lat: -7.40, Lon: 109.37, Sequence: 2},
+	}).Error)
+	for _, dep := range []string{"08:00:00", "09:00:00"} {
+		trip := models.Trip{RouteID: route.ID, ServiceID: "DAILY", ShapeID: "S1", Headsign: "Market"}
+		must(db.Create(&trip).Error)
+		arr := dep[:3] + "10:00"
+		must(db.Create(&[]models.TripStop{
+			{TripID: trip.ID, StopID: platforms[0].ID, Sequence: 1, ArrivalTime: dep, DepartureTime: dep},
+			{TripID: trip.ID, StopID: platforms[1].ID, Sequence: 2, ArrivalTime: arr, DepartureTime: arr},
+		}).Error)
+	}
+}
+
+// withExtras copies a GTFS ZIP, adds a file the CMS does not model, a stop
+// time for a trip that does not exist and one serving a station
+func withExtras(t *testing.T, feed []byte) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(feed), int64(len(feed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if f.Name == "stop_times.txt" {
+			data = append(data, "999,08:00:00,08:00:00,1,1,\n"...)
+			data = append(data, "1,08:20:00,08:20:00,1,3,\n"...)
+		}
+		w, _ := zw.Create(f.Name)
+		w.Write(data)
+	}
+	w, _ := zw.Create("feed_info.txt")
+	w.Write([]byte("feed_publisher_name,feed_publisher_url,feed_lang\nMetro,https://metro.example,en\n"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	db := testDB(t)
+	seedRoundTrip(t, db)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/api/export/gtfs", nil)
+	ExportGTFS(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ExportGTFS() responded %d: %s", w.Code, w.Body.String())
+	}
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	part, _ := mw.CreateFormFile("file", "gtfs_export.zip")
+	part.Write(withExtras(t, w.Body.Bytes()))
+	mw.Close()
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/import/gtfs", body)
+	c.Request.Header.Set("Content-Type", mw.FormDataContentType())
+	ImportGTFS(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ImportGTFS() responded %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Files map[string]ImportFileSummary `json:"files"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]ImportFileSummary{
+		"agency.txt":     {Created: 1},
+		"stops.txt":      {Created: 4},
+		"routes.txt":     {Created: 1},
+		"calendar.txt":   {Created: 1},
+		"shapes.txt":     {Created: 2},
+		"trips.txt":      {Created: 2},
+		"stop_times.txt": {Created: 4, Rejected: 2},
+		"feed_info.txt":  {Skipped: 1},
+	} {
+		got := resp.Files[file]
+		if got.Created != want.Created || got.Skipped != want.Skipped || got.Rejected != want.Rejected {
+			t.Errorf("%s: created %d, skipped %d, rejected %d; want %d, %d, %d (%v)",
+				file, got.Created, got.Skipped, got.Rejected, want.Created, want.Skipped, want.Rejected, got.Errors)
+		}
+	}
+
+	// The copies take the next free shape and service IDs and point at the
+	// imported rows, never at the originals
+	var trips []models.Trip
+	db.Where("id > ?", 2).Order("id").Find(&trips)
+	if len(trips) != 2 {
+		t.Fatalf("got %d imported trips, want 2", len(trips))
+	}
+	for _, trip := range trips {
+		if trip.ShapeID != "S1_2" || trip.ServiceID != "DAILY_2" || trip.RouteID != 2 {
+			t.Errorf("imported trip = %+v, want shape S1_2, service DAILY_2 on route 2", trip)
+		}
+		var stops []models.TripStop
+		db.Preload("Stop").Where("trip_id = ?", trip.ID).Order("sequence").Find(&stops)
+		if len(stops) != 2 || stops[0].Stop.Name != "Central 1" || stops[0].StopID <= 4 || stops[1].StopID <= 4 {
+			t.Errorf("imported trip %d serves %+v, want the imported platforms", trip.ID, stops)
+		}
+		if len(stops) > 0 && stops[0].Stop.LocationType != models.LocationStop {
+			t.Errorf("imported trip %d serves location_type %d, want a platform", trip.ID, stops[0].Stop.LocationType)
+		}
+	}
+
+	var platform, entrance models.Stop
+	db.Where("name = ? AND id > ?", "Central 1", 4).First(&platform)
+	db.Where("name = ? AND id > ?", "Central Entrance", 4).First(&entrance)
+	if platform.ParentStation == nil || entrance.ParentStation == nil || *platform.ParentStation != *entrance.ParentStation || *platform.ParentStation <= 4 {
+		t.Errorf("platform and entrance should share the imported station, got %v and %v", platform.ParentStation, entrance.ParentStation)
+	}
+}
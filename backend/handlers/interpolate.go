@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/models"
+	"gtfs-cms/schedule"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// speedSettingKey holds the vehicle speed used to time stops that are not
+// between two known times
+const speedSettingKey = "interpolation_speed_kmh"
+
+// interpolationOptions reads the configured interpolation speed, falling back
+// to schedule.DefaultSpeedKmh when the setting is missing or invalid.
+func interpolationOptions(db *gorm.DB) schedule.Options {
+	opts := schedule.Options{SpeedKmh: schedule.DefaultSpeedKmh}
+	var setting models.Setting
+	if err := db.First(&setting, "key = ?", speedSettingKey).Error; err == nil {
+		if v, err := strconv.ParseFloat(setting.Value, 64); err == nil && v > 0 {
+			opts.SpeedKmh = v
+		}
+	}
+	return opts
+}
+
+// InterpolateRequest tunes the interpolate action of a trip
+type InterpolateRequest struct {
+	SpeedKmh float64 `json:"speed_kmh"` // Overrides the configured speed
+	Reset    bool    `json:"reset"`     // Re-time every stop except the first and last
+}
+
+// InterpolateTripTimes re-times the untimed stops of a trip from the distance
+// along its shape and saves the result.
+func InterpolateTripTimes(c *gin.Context) {
+	db := feedDB(c)
+	var trip models.Trip
+	if err := db.First(&trip, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return
+	}
+
+	var req InterpolateRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var stops []models.TripStop
+	if err := db.Where("trip_id = ?", trip.ID).Order("sequence asc").Find(&stops).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trip stops: " + err.Error()})
+		return
+	}
+	if len(stops) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Trip has no stops to interpolate"})
+		return
+	}
+
+	before := stopTimeRows(stops)
+	if req.Reset {
+		for i := 1; i < len(stops)-1; i++ {
+			stops[i].ArrivalTime, stops[i].DepartureTime = "", ""
+		}
+	}
+	opts := interpolationOptions(db)
+	if req.SpeedKmh > 0 {
+		opts.SpeedKmh = req.SpeedKmh
+	}
+	if err := schedule.InterpolateTrip(db, trip.ShapeID, stops, opts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to interpolate stop times: " + err.Error()})
+		return
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
+		return
+	}
+	for _, ts := range stops {
+		if err := tx.Model(&models.TripStop{}).Where("id = ?", ts.ID).
+			Updates(map[string]interface{}{"arrival_time": ts.ArrivalTime, "departure_time": ts.DepartureTime}).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update trip stop: " + err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+
+	LogChange(c, ActionUpdate, "trip_stops", trip.ID, before, stopTimeRows(stops),
+		fmt.Sprintf("Stop times of trip #%d have been interpolated at %.0f km/h.", trip.ID, opts.SpeedKmh))
+	c.JSON(http.StatusOK, stops)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// List endpoints keep returning a plain JSON array so existing clients work;
+// the total and the cursor of the next page travel in these headers.
+const (
+	totalCountHeader = "X-Total-Count"
+	nextCursorHeader = "X-Next-Cursor"
+)
+
+// pageParams reads the limit and cursor query parameters
+func pageParams(c *gin.Context, defaultLimit, maxLimit int) (int, string, error) {
+	limit := defaultLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, "", fmt.Errorf("limit must be a positive number")
+		}
+		limit = min(n, maxLimit)
+	}
+	return limit, c.Query("cursor"), nil
+}
+
+func setPageHeaders(c *gin.Context, total int64, next string) {
+	c.Header(totalCountHeader, strconv.FormatInt(total, 10))
+	if next != "" {
+		c.Header(nextCursorHeader, next)
+	}
+}
+
+// sortField is a column a list can be sorted by, and how to read it from a
+// row for the cursor. Only NOT NULL columns qualify, or the cursor
+// comparison would skip rows.
+type sortField[T any] struct {
+	column string
+	value  func(T) interface{}
+}
+
+// pageKey is what a list cursor encodes: the sort value and ID of the last
+// row of the previous page
+type pageKey struct {
+	Value interface{} `json:"v"`
+	ID    uint        `json:"id"`
+}
+
+// listQuery is the query model of the feed list endpoints. ?limit= and
+// ?cursor= page through the rows; without a limit everything is returned, as
+// before pagination existed. ?sort= names a field, with a leading - for
+// descending order; ties are broken by ID.
+type listQuery[T any] struct {
+	limit int
+	sort  sortField[T]
+	desc  bool
+	after *pageKey
+	id    func(T) uint
+}
+
+// maxListLimit caps a page of the feed lists
+const maxListLimit = 5000
+
+func parseListQuery[T any](c *gin.Context, fields map[string]sortField[T], defaultSort string, id func(T) uint) (listQuery[T], error) {
+	q := listQuery[T]{id: id}
+	if v := c.Query("limit"); v != "" {
+		limit, _, err := pageParams(c, maxListLimit, maxListLimit)
+		if err != nil {
+			return q, err
+		}
+		q.limit = limit
+	}
+
+	name := c.DefaultQuery("sort", defaultSort)
+	name, q.desc = strings.CutPrefix(name, "-")
+	field, ok := fields[name]
+	if !ok {
+		names := make([]string, 0, len(fields))
+		for n := range fields {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return q, fmt.Errorf("cannot sort by %q; use one of %s", name, strings.Join(names, ", "))
+	}
+	q.sort = field
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		key := &pageKey{}
+		if err != nil || json.Unmarshal(raw, key) != nil {
+			return q, fmt.Errorf("invalid cursor")
+		}
+		// JSON numbers decode as floats; whole ones go back to integer columns
+		if f, ok := key.Value.(float64); ok && f == math.Trunc(f) {
+			key.Value = int64(f)
+		}
+		q.after = key
+	}
+	return q, nil
+}
+
+// apply orders query, skips to the cursor and limits it. One row more than
+// the page is read to tell whether another page follows.
+func (q listQuery[T]) apply(query *gorm.DB) *gorm.DB {
+	col := clause.Column{Table: clause.CurrentTable, Name: q.sort.column}
+	id := clause.Column{Table: clause.CurrentTable, Name: "id"}
+	if q.after != nil {
+		op := ">"
+		if q.desc {
+			op = "<"
+		}
+		if q.sort.column == "id" {
+			query = query.Where(fmt.Sprintf("? %s ?", op), id, q.after.ID)
+		} else {
+			query = query.Where(fmt.Sprintf("? %[1]s ? OR (? = ? AND ? %[1]s ?)", op), col, q.after.Value, col, q.after.Value, id, q.after.ID)
+		}
+	}
+	query = query.Order(clause.OrderByColumn{Column: col, Desc: q.desc})
+	if q.sort.column != "id" {
+		query = query.Order(clause.OrderByColumn{Column: id, Desc: q.desc})
+	}
+	if q.limit > 0 {
+		query = query.Limit(q.limit + 1)
+	}
+	return query
+}
+
+// page trims the extra row apply read and returns the cursor of the next
+// page, "" on the last one
+func (q listQuery[T]) page(rows []T) ([]T, string) {
+	if q.limit <= 0 || len(rows) <= q.limit {
+		return rows, ""
+	}
+	rows = rows[:q.limit]
+	last := rows[len(rows)-1]
+	raw, _ := json.Marshal(pageKey{Value: q.sort.value(last), ID: q.id(last)})
+	return rows, base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// uintListParam reads a filter such as ?route_id=3 or ?route_id=3,4
+func uintListParam(c *gin.Context, name string) ([]uint, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+	var ids []uint
+	for _, s := range strings.Split(v, ",") {
+		n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a comma-separated list of IDs", name)
+		}
+		ids = append(ids, uint(n))
+	}
+	return ids, nil
+}
+
+// stringListParam reads a filter such as ?service_id=WEEKDAY,SAT
+func stringListParam(c *gin.Context, name string) []string {
+	var out []string
+	for _, s := range strings.Split(c.Query(name), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
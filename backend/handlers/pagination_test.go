@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"gtfs-cms/models"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func listContext(query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/stops?"+query, nil)
+	return c
+}
+
+func stopID(s models.Stop) uint { return s.ID }
+
+func TestListQueryPages(t *testing.T) {
+	stops := []models.Stop{{ID: 1, Name: "A"}, {ID: 4, Name: "B"}, {ID: 2, Name: "C"}}
+	q, err := parseListQuery(listContext("limit=2&sort=name"), stopSortFields, "id", stopID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, next := q.page(stops)
+	if len(page) != 2 || next == "" {
+		t.Fatalf("page() = %d rows, cursor %q; want 2 rows and a cursor", len(page), next)
+	}
+
+	q, err = parseListQuery(listContext("limit=2&sort=name&cursor="+next), stopSortFields, "id", stopID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.after == nil || q.after.Value != "B" || q.after.ID != 4 {
+		t.Errorf("cursor decoded to %+v, want name B and ID 4", q.after)
+	}
+	if _, next := q.page(stops[2:]); next != "" {
+		t.Errorf("last page has cursor %q", next)
+	}
+}
+
+func TestListQueryRejects(t *testing.T) {
+	for _, query := range []string{"sort=colour", "cursor=not-a-cursor", "limit=-1"} {
+		if _, err := parseListQuery(listContext(query), stopSortFields, "id", stopID); err == nil {
+			t.Errorf("parseListQuery(%q) succeeded", query)
+		}
+	}
+	q, err := parseListQuery(listContext(""), stopSortFields, "id", stopID)
+	if err != nil || q.limit != 0 {
+		t.Errorf("no limit = %+v, %v; want the whole list", q, err)
+	}
+}
+
+func TestListQuerySQL(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := parseListQuery(listContext("limit=10&sort=-name"), stopSortFields, "id", stopID)
+	q.after = &pageKey{Value: "Central", ID: 7}
+	var stops []models.Stop
+	sql := q.apply(db.Model(&models.Stop{})).Find(&stops).Statement.SQL.String()
+	want := `WHERE "stops"."name" < $1 OR ("stops"."name" = $2 AND "stops"."id" < $3) ORDER BY "stops"."name" DESC,"stops"."id" DESC LIMIT $4`
+	if !strings.Contains(sql, want) {
+		t.Errorf("apply() SQL = %s\nwant it to contain %s", sql, want)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/models"
+	"gtfs-cms/validator"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Level ---
+
+func GetLevels(c *gin.Context) {
+	var levels []models.Level
+	if err := workspaceDB(c).Order("level_index asc, id asc").Find(&levels).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch levels: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, levels)
+}
+
+func CreateLevel(c *gin.Context) {
+	var level models.Level
+	if err := c.ShouldBindJSON(&level); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	level.ID = 0
+	if err := workspaceDB(c).Create(&level).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create level: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "level", level.ID, nil, level, fmt.Sprintf("Level [%s] (index %g) has been added.", level.Name, level.Index))
+	c.JSON(http.StatusOK, level)
+}
+
+func UpdateLevel(c *gin.Context) {
+	var level models.Level
+	if err := workspaceDB(c).First(&level, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Level not found"})
+		return
+	}
+	before := level
+	if err := c.ShouldBindJSON(&level); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	level.ID = before.ID
+	if err := workspaceDB(c).Save(&level).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update level: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "level", level.ID, before, level, fmt.Sprintf("Level [%s] has been updated.", level.Name))
+	c.JSON(http.StatusOK, level)
+}
+
+// DeleteLevel removes a level no stop is on anymore
+func DeleteLevel(c *gin.Context) {
+	db := workspaceDB(c)
+	var level models.Level
+	if err := db.First(&level, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Level not found"})
+		return
+	}
+	var stops int64
+	db.Model(&models.Stop{}).Where("level_id = ?", level.ID).Count(&stops)
+	if stops > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Level [%s] still has %d stops on it; move them first", level.Name, stops)})
+		return
+	}
+	if err := db.Delete(&level).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete level"})
+		return
+	}
+	LogChange(c, ActionDelete, "level", level.ID, level, nil, fmt.Sprintf("Level [%s] has been removed.", level.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "Level deleted"})
+}
+
+// --- Pathway ---
+
+// stationOf is the station a location belongs to, looked up through its
+// parents, see validator.StationOf
+func stationOf(db *gorm.DB, s models.Stop) uint {
+	stops := make(map[uint]*models.Stop)
+	for at, depth := s, 0; at.ParentStation != nil && depth < 2; depth++ {
+		var parent models.Stop
+		if db.First(&parent, *at.ParentStation).Error != nil {
+			return 0
+		}
+		stops[parent.ID] = &parent
+		at = parent
+	}
+	return validator.StationOf(s, stops)
+}
+
+// validatePathway checks that p links two locations of the same station
+func validatePathway(db *gorm.DB, p models.Pathway) error {
+	var from, to models.Stop
+	if err := db.First(&from, p.FromStopID).Error; err != nil {
+		return fmt.Errorf("from_stop_id #%d not found", p.FromStopID)
+	}
+	if err := db.First(&to, p.ToStopID).Error; err != nil {
+		return fmt.Errorf("to_stop_id #%d not found", p.ToStopID)
+	}
+	if err := validator.CheckPathway(p, from, to); err != nil {
+		return err
+	}
+	station := stationOf(db, from)
+	if station == 0 || station != stationOf(db, to) {
+		return fmt.Errorf("[%s] and [%s] are not in the same station", from.Name, to.Name)
+	}
+	return nil
+}
+
+// GetPathways lists pathways, with ?station_id= only those of one station
+func GetPathways(c *gin.Context) {
+	db := workspaceDB(c)
+	query := db.Order("id asc")
+	stationIDs, err := uintListParam(c, "station_id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(stationIDs) > 0 {
+		// Boarding areas hang one level deeper, under their platform
+		children := db.Model(&models.Stop{}).Select("id").Where("parent_station IN ?", stationIDs)
+		inside := db.Model(&models.Stop{}).Select("id").Where("parent_station IN ? OR parent_station IN (?)", stationIDs, children)
+		query = query.Where("from_stop_id IN (?)", inside)
+	}
+	var pathways []models.Pathway
+	if err := query.Find(&pathways).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pathways: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pathways)
+}
+
+func CreatePathway(c *gin.Context) {
+	var p models.Pathway
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p.ID = 0
+	if err := validatePathway(workspaceDB(c), p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Create(&p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create pathway: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "pathway", p.ID, nil, p, fmt.Sprintf("Pathway #%d from stop #%d to stop #%d has been added.", p.ID, p.FromStopID, p.ToStopID))
+	c.JSON(http.StatusOK, p)
+}
+
+func UpdatePathway(c *gin.Context) {
+	var p models.Pathway
+	if err := workspaceDB(c).First(&p, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pathway not found"})
+		return
+	}
+	before := p
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p.ID = before.ID
+	if err := validatePathway(workspaceDB(c), p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Save(&p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pathway: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "pathway", p.ID, before, p, fmt.Sprintf("Pathway #%d has been updated.", p.ID))
+	c.JSON(http.StatusOK, p)
+}
+
+func DeletePathway(c *gin.Context) {
+	var p models.Pathway
+	if err := workspaceDB(c).First(&p, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pathway not found"})
+		return
+	}
+	if err := workspaceDB(c).Delete(&p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pathway"})
+		return
+	}
+	LogChange(c, ActionDelete, "pathway", p.ID, p, nil, fmt.Sprintf("Pathway #%d has been removed.", p.ID))
+	c.JSON(http.StatusOK, gin.H{"message": "Pathway deleted"})
+}
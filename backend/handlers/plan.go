@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/geometry"
+	"gtfs-cms/gtfs"
+	"gtfs-cms/planner"
+	"gtfs-cms/schedule"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Journey planner ---
+
+// parseLatLon parses a "lat,lon" query value
+func parseLatLon(s string) (geometry.Point, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return geometry.Point{}, fmt.Errorf("expected lat,lon but got %q", s)
+	}
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	lon, errLon := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if errLat != nil || errLon != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return geometry.Point{}, fmt.Errorf("invalid coordinates %q", s)
+	}
+	return geometry.Point{Lat: lat, Lon: lon}, nil
+}
+
+// parseServiceDate accepts YYYYMMDD or YYYY-MM-DD as a date in loc
+func parseServiceDate(s string, loc *time.Location) (time.Time, error) {
+	d, err := gtfs.ParseDate(strings.ReplaceAll(s, "-", ""))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, loc), nil
+}
+
+// parseClockTime accepts HH:MM or HH:MM:SS, including hours past 24
+func parseClockTime(s string) (int, error) {
+	if strings.Count(s, ":") == 1 {
+		s += ":00"
+	}
+	return gtfs.ParseTime(s)
+}
+
+// PlanJourney finds itineraries between two coordinates on the scheduled
+// timetable. time and date default to now in the agency's timezone.
+func PlanJourney(c *gin.Context) {
+	from, err := parseLatLon(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from: " + err.Error()})
+		return
+	}
+	to, err := parseLatLon(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to: " + err.Error()})
+		return
+	}
+
+	loc := schedule.Location(workspaceDB(c))
+	now := time.Now().In(loc)
+	day := schedule.ServiceDay(now, loc)
+	if v := c.Query("date"); v != "" {
+		if day, err = parseServiceDate(v, loc); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date: " + err.Error()})
+			return
+		}
+	}
+	depart := int(now.Sub(schedule.ServiceDay(now, loc)).Seconds())
+	if v := c.Query("time"); v != "" {
+		if depart, err = parseClockTime(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "time: " + err.Error()})
+			return
+		}
+	}
+
+	network, err := planner.Load(workspaceDB(c), day, planner.DefaultOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load timetable: " + err.Error()})
+		return
+	}
+	itineraries := network.Plan(from, to, depart)
+	if itineraries == nil {
+		itineraries = []planner.Itinerary{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"date":        day.Format(gtfs.DateLayout),
+		"time":        gtfs.FormatTime(depart),
+		"itineraries": itineraries,
+	})
+}
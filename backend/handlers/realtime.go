@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/models"
+	"gtfs-cms/realtime"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Realtime feeds ---
+
+const protobufContentType = "application/x-protobuf"
+
+func GetTripUpdatesFeed(c *gin.Context) {
+	c.Data(http.StatusOK, protobufContentType, realtime.TripUpdatesFeed(realtime.StoreFor(currentWorkspaceID(c)), time.Now()).Marshal())
+}
+
+func GetTripUpdatesJSON(c *gin.Context) {
+	c.JSON(http.StatusOK, realtime.TripUpdatesFeed(realtime.StoreFor(currentWorkspaceID(c)), time.Now()))
+}
+
+func GetVehiclePositionsFeed(c *gin.Context) {
+	c.Data(http.StatusOK, protobufContentType, realtime.VehiclePositionsFeed(realtime.StoreFor(currentWorkspaceID(c)), time.Now()).Marshal())
+}
+
+func GetVehiclePositionsJSON(c *gin.Context) {
+	c.JSON(http.StatusOK, realtime.VehiclePositionsFeed(realtime.StoreFor(currentWorkspaceID(c)), time.Now()))
+}
+
+func alertsFeed(c *gin.Context) (*realtime.FeedMessage, bool) {
+	now := time.Now()
+	alerts, err := realtime.LoadActiveAlerts(workspaceDB(c), now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load alerts: " + err.Error()})
+		return nil, false
+	}
+	return realtime.AlertsFeed(alerts, now), true
+}
+
+func GetAlertsFeed(c *gin.Context) {
+	if feed, ok := alertsFeed(c); ok {
+		c.Data(http.StatusOK, protobufContentType, feed.Marshal())
+	}
+}
+
+func GetAlertsJSON(c *gin.Context) {
+	if feed, ok := alertsFeed(c); ok {
+		c.JSON(http.StatusOK, feed)
+	}
+}
+
+// --- Realtime ingest ---
+
+// IngestTripUpdates accepts a list of trip updates for existing trips
+func IngestTripUpdates(c *gin.Context) {
+	var updates []realtime.TripUpdate
+	if err := c.ShouldBindJSON(&updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for i := range updates {
+		var trip models.Trip
+		if err := workspaceDB(c).First(&trip, updates[i].TripID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Trip #%d not found", updates[i].TripID)})
+			return
+		}
+		updates[i].RouteID = trip.RouteID
+		updates[i].DirectionID = trip.DirectionID
+
+		var stopIDs []uint
+		workspaceDB(c).Model(&models.TripStop{}).Where("trip_id = ?", trip.ID).Pluck("stop_id", &stopIDs)
+		onTrip := make(map[uint]bool, len(stopIDs))
+		for _, id := range stopIDs {
+			onTrip[id] = true
+		}
+		for _, stu := range updates[i].StopTimeUpdates {
+			if stu.StopID != 0 && !onTrip[stu.StopID] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Stop #%d is not served by trip #%d", stu.StopID, trip.ID)})
+				return
+			}
+		}
+	}
+
+	store := realtime.StoreFor(currentWorkspaceID(c))
+	for _, tu := range updates {
+		store.PutTripUpdate(tu)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Trip updates accepted", "count": len(updates)})
+}
+
+// IngestVehiclePositions accepts a list of vehicle positions
+func IngestVehiclePositions(c *gin.Context) {
+	var positions []realtime.VehiclePosition
+	if err := c.ShouldBindJSON(&positions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for i := range positions {
+		vp := &positions[i]
+		if vp.VehicleID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "vehicle_id is required"})
+			return
+		}
+		if vp.Lat < -90 || vp.Lat > 90 || vp.Lon < -180 || vp.Lon > 180 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Vehicle %s has invalid coordinates", vp.VehicleID)})
+			return
+		}
+		if _, ok := realtime.VehicleStatuses[vp.CurrentStatus]; vp.CurrentStatus != "" && !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown current_status %s", vp.CurrentStatus)})
+			return
+		}
+		if vp.TripID != 0 {
+			var trip models.Trip
+			if err := workspaceDB(c).First(&trip, vp.TripID).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Trip #%d not found", vp.TripID)})
+				return
+			}
+			vp.RouteID = trip.RouteID
+		}
+	}
+
+	store := realtime.StoreFor(currentWorkspaceID(c))
+	for _, vp := range positions {
+		store.PutVehiclePosition(vp)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Vehicle positions accepted", "count": len(positions)})
+}
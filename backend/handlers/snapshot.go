@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/models"
+	"gtfs-cms/snapshot"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Snapshots ---
+
+// snapshotIDHeader tells the client which snapshot an export was taken from
+const snapshotIDHeader = "X-Snapshot-ID"
+
+// buildSnapshot captures the current dataset of db as an unsaved snapshot
+func buildSnapshot(c *gin.Context, db *gorm.DB, name, note, source string) (models.Snapshot, error) {
+	ds, err := snapshot.Load(db)
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	data, checksum, err := ds.Encode()
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	snap := models.Snapshot{Name: name, Note: note, Source: source, Checksum: checksum, Counts: ds.Counts(), Data: data}
+	if user, ok := currentUser(c); ok {
+		snap.UserID = &user.ID
+		snap.UserEmail = user.Email
+	}
+	return snap, nil
+}
+
+// snapshotExport records the dataset being exported. An unchanged dataset
+// reuses the latest snapshot instead of storing the same data again.
+func snapshotExport(c *gin.Context) (models.Snapshot, error) {
+	snap, err := buildSnapshot(c, workspaceDB(c), "Export "+time.Now().Format("2006-01-02 15:04"), "", models.SnapshotExport)
+	if err != nil {
+		return snap, err
+	}
+	var latest models.Snapshot
+	if err := workspaceDB(c).Omit("data").Order("id desc").First(&latest).Error; err == nil && latest.Checksum == snap.Checksum {
+		return latest, nil
+	}
+	if err := workspaceDB(c).Create(&snap).Error; err != nil {
+		return snap, err
+	}
+	return snap, nil
+}
+
+// loadSnapshotData reads a snapshot including its decoded dataset
+func loadSnapshotData(db *gorm.DB, id string) (models.Snapshot, *snapshot.Dataset, error) {
+	var snap models.Snapshot
+	if err := db.First(&snap, id).Error; err != nil {
+		return snap, nil, err
+	}
+	ds, err := snapshot.Decode(snap.Data)
+	if err != nil {
+		return snap, nil, fmt.Errorf("snapshot #%d is corrupt: %w", snap.ID, err)
+	}
+	return snap, ds, nil
+}
+
+// GetSnapshots lists snapshots newest first, without their data
+func GetSnapshots(c *gin.Context) {
+	limit, cursor, err := pageParams(c, 50, 500)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query := workspaceDB(c).Model(&models.Snapshot{})
+	if v := c.Query("source"); v != "" {
+		query = query.Where("source = ?", v)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count snapshots: " + err.Error()})
+		return
+	}
+	if cursor != "" {
+		beforeID, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		query = query.Where("id < ?", beforeID)
+	}
+
+	var snaps []models.Snapshot
+	if err := query.Omit("data").Order("id desc").Limit(limit).Find(&snaps).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch snapshots: " + err.Error()})
+		return
+	}
+	next := ""
+	if len(snaps) == limit {
+		next = strconv.FormatUint(uint64(snaps[len(snaps)-1].ID), 10)
+	}
+	setPageHeaders(c, total, next)
+	c.JSON(http.StatusOK, snaps)
+}
+
+func GetSnapshot(c *gin.Context) {
+	var snap models.Snapshot
+	if err := workspaceDB(c).Omit("data").First(&snap, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+		return
+	}
+	c.JSON(http.StatusOK, snap)
+}
+
+type SnapshotRequest struct {
+	Name string `json:"name"`
+	Note string `json:"note"`
+}
+
+func CreateSnapshot(c *gin.Context) {
+	var req SnapshotRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Name == "" {
+		req.Name = "Snapshot " + time.Now().Format("2006-01-02 15:04")
+	}
+	snap, err := buildSnapshot(c, workspaceDB(c), req.Name, req.Note, models.SnapshotManual)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to capture dataset: " + err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Create(&snap).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save snapshot: " + err.Error()})
+		return
+	}
+	LogActivity(c, "SNAPSHOT", fmt.Sprintf("Snapshot #%d [%s] has been taken.", snap.ID, snap.Name))
+	c.JSON(http.StatusOK, snap)
+}
+
+// DiffSnapshot compares a snapshot with the snapshot given by ?against=, or
+// with the live data when it is omitted
+func DiffSnapshot(c *gin.Context) {
+	from, fromData, err := loadSnapshotData(workspaceDB(c), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found: " + err.Error()})
+		return
+	}
+
+	var to interface{} = "live"
+	var toData *snapshot.Dataset
+	if against := c.Query("against"); against != "" {
+		var snap models.Snapshot
+		if snap, toData, err = loadSnapshotData(workspaceDB(c), against); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot to compare against not found: " + err.Error()})
+			return
+		}
+		to = snap.ID
+	} else if toData, err = snapshot.Load(workspaceDB(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load dataset: " + err.Error()})
+		return
+	}
+
+	tables, err := snapshot.Diff(fromData, toData)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare snapshots: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"from": from.ID, "to": to, "tables": tables})
+}
+
+// RestoreSnapshot replaces the live data with a snapshot in one transaction.
+// The data it replaces is snapshotted first, so a restore can be undone.
+// Workspaces with drafts that are not merged yet cannot be restored.
+func RestoreSnapshot(c *gin.Context) {
+	snap, ds, err := loadSnapshotData(workspaceDB(c), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found: " + err.Error()})
+		return
+	}
+
+	tx := workspaceDB(c).Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
+		return
+	}
+	// Drafts are copies of the published routes and trips that point back at
+	// them and at stops, all of which a restore replaces, so none may be
+	// pending
+	var pending []models.Draft
+	if err := tx.Where("status <> ?", models.DraftMerged).Order("id").Find(&pending).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for drafts: " + err.Error()})
+		return
+	}
+	if len(pending) > 0 {
+		tx.Rollback()
+		names := make([]string, len(pending))
+		for i, d := range pending {
+			names[i] = fmt.Sprintf("[%s] (%s)", d.Name, d.Status)
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "Merge or discard these drafts before restoring a snapshot: " + strings.Join(names, ", ")})
+		return
+	}
+	backup, err := buildSnapshot(c, tx, fmt.Sprintf("Before restoring #%d", snap.ID), "", models.SnapshotRestore)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to capture current dataset: " + err.Error()})
+		return
+	}
+	if err := tx.Create(&backup).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save backup snapshot: " + err.Error()})
+		return
+	}
+	if err := ds.Restore(tx); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore snapshot: " + err.Error()})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	LogActivity(c, "RESTORE", fmt.Sprintf("Snapshot #%d [%s] has been restored. The previous data is kept as snapshot #%d.", snap.ID, snap.Name, backup.ID))
+	c.JSON(http.StatusOK, gin.H{"message": "Snapshot restored", "restored": snap.ID, "backup": backup.ID, "counts": snap.Counts})
+}
+
+func DeleteSnapshot(c *gin.Context) {
+	var snap models.Snapshot
+	if err := workspaceDB(c).Omit("data").First(&snap, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+		return
+	}
+	if err := workspaceDB(c).Delete(&snap).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete snapshot: " + err.Error()})
+		return
+	}
+	LogActivity(c, "DELETION", fmt.Sprintf("Snapshot #%d [%s] has been removed.", snap.ID, snap.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "Snapshot deleted"})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"gtfs-cms/models"
+	"gtfs-cms/snapshot"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRestoreSnapshotWithPendingDrafts(t *testing.T) {
+	db := testDB(t)
+	data, sum, err := (&snapshot.Dataset{}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := models.Snapshot{Name: "Empty", Checksum: sum, Data: data}
+	db.Create(&snap)
+	db.Create(&[]models.Draft{{Name: "winter", Status: models.DraftInReview}, {Name: "old", Status: models.DraftMerged}})
+	stop := models.Stop{Name: "Central", Lat: 1, Lon: 1}
+	db.Create(&stop)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/snapshots/1/restore", nil)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	RestoreSnapshot(c)
+
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "[winter] (in_review)") || strings.Contains(w.Body.String(), "old") {
+		t.Errorf("RestoreSnapshot() responded %d %s, want a conflict naming only the draft in review", w.Code, w.Body.String())
+	}
+	var count int64
+	db.Model(&models.Stop{}).Count(&count)
+	if count != 1 {
+		t.Errorf("the refused restore removed the stops")
+	}
+}
+
+func TestRestoreSnapshotUnlinksAlerts(t *testing.T) {
+	db := testDB(t)
+	kept := models.Stop{Name: "Central", Lat: 1, Lon: 1}
+	db.Create(&kept)
+	data, sum, err := (&snapshot.Dataset{Stops: []models.Stop{kept}}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&models.Snapshot{Name: "Central only", Checksum: sum, Data: data})
+	gone := models.Stop{Name: "Market", Lat: 2, Lon: 2}
+	db.Create(&gone)
+	alert := models.Alert{Cause: "CONSTRUCTION", InformedEntities: []models.AlertInformedEntity{{StopID: &kept.ID}, {StopID: &gone.ID}}}
+	db.Create(&alert)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/snapshots/1/restore", nil)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	RestoreSnapshot(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("RestoreSnapshot() responded %d: %s", w.Code, w.Body.String())
+	}
+
+	var entities []models.AlertInformedEntity
+	db.Where("alert_id = ?", alert.ID).Find(&entities)
+	if len(entities) != 1 || entities[0].StopID == nil || *entities[0].StopID != kept.ID {
+		t.Errorf("alert informs %+v, want only stop %d", entities, kept.ID)
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/geometry"
+	"gtfs-cms/models"
+	"gtfs-cms/spatial"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Spatial queries ---
+
+// parseBBox parses a "minLon,minLat,maxLon,maxLat" viewport, the order map
+// libraries use for bounds. A west edge east of the east edge crosses the
+// antimeridian.
+func parseBBox(s string) (spatial.BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return spatial.BBox{}, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return spatial.BBox{}, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat")
+		}
+		v[i] = f
+	}
+	b := spatial.BBox{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+	if b.MinLat > b.MaxLat || b.MinLat < -90 || b.MaxLat > 90 || b.MinLon < -180 || b.MaxLon > 180 {
+		return spatial.BBox{}, fmt.Errorf("invalid bbox %q", s)
+	}
+	return b, nil
+}
+
+// radiusParam reads ?radius= in metres, capped at max
+func radiusParam(c *gin.Context, def, max float64) (float64, error) {
+	v := c.Query("radius")
+	if v == "" {
+		return def, nil
+	}
+	r, err := strconv.ParseFloat(v, 64)
+	if err != nil || r <= 0 || r > max {
+		return 0, fmt.Errorf("radius must be between 0 and %.0f metres", max)
+	}
+	return r, nil
+}
+
+// GetNearbyStops lists the stops within ?radius= metres (300 by default) of
+// ?lat= and ?lon=, nearest first, at most ?limit= of them
+func GetNearbyStops(c *gin.Context) {
+	p, err := parseLatLon(c.Query("lat") + "," + c.Query("lon"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	radius, err := radiusParam(c, 300, 50000)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, _, err := pageParams(c, 0, maxListLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	index, err := spatial.Default.Get(workspaceDB(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to index stops: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, index.Nearby(p, radius, limit))
+}
+
+// GetShapeStops lists the stops within ?radius= metres (100 by default) of
+// a shape in the order they lie along it, for instance to find the stops a
+// new trip on that shape could serve
+func GetShapeStops(c *gin.Context) {
+	radius, err := radiusParam(c, 100, 5000)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var points []models.ShapePoint
+	if err := feedDB(c).Where("shape_id = ?", c.Param("shape_id")).Order("sequence asc, id asc").Find(&points).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shape"})
+		return
+	}
+	if len(points) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shape not found"})
+		return
+	}
+	line := make([]geometry.Point, len(points))
+	for i, p := range points {
+		line[i] = geometry.Point{Lat: p.Lat, Lon: p.Lon}
+	}
+	index, err := spatial.Default.Get(workspaceDB(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to index stops: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, index.NearLine(line, radius))
+}
@@ -1,48 +0,0 @@
-package handlers
-
-import (
-	"fmt"
-	"testing"
-)
-
-// calculateTime is an internal helper in handlers.go, but for testing purposes without exporting it,
-// we'll replicate the logic here to assert expected behavior or refactor the code to make it testable.
-// Better yet, let's extract it to a shared function if we want to test it directly.
-// However, since we can't easily change visibility or create new files in shared util quickly without scope creep,
-// we will verify the logic by implementing the test against the same algorithm to ensure it produces the times we expect.
-
-func calculateTimeForTest(seq int) (string, string) {
-	baseTime := 8 * 60 // 08:00 in minutes
-	arrivalMin := baseTime + (seq * 5)
-	departureMin := arrivalMin + 5
-
-	excludeHours := func(m int) string {
-		h := (m / 60) % 24
-		min := m % 60
-		return fmt.Sprintf("%02d:%02d:00", h, min)
-	}
-	return excludeHours(arrivalMin), excludeHours(departureMin)
-}
-
-func TestIncrementalTimeCalculations(t *testing.T) {
-	tests := []struct {
-		sequence int
-		wantArr  string
-		wantDep  string
-	}{
-		{0, "08:00:00", "08:05:00"},
-		{1, "08:05:00", "08:10:00"},
-		{2, "08:10:00", "08:15:00"},
-		{12, "09:00:00", "09:05:00"}, // 12 * 5 = 60 mins -> 08:00 + 1:00 = 09:00
-	}
-
-	for _, tt := range tests {
-		gotArr, gotDep := calculateTimeForTest(tt.sequence)
-		if gotArr != tt.wantArr {
-			t.Errorf("calculateTimeForTest(%d) arrival = %v, want %v", tt.sequence, gotArr, tt.wantArr)
-		}
-		if gotDep != tt.wantDep {
-			t.Errorf("calculateTimeForTest(%d) departure = %v, want %v", tt.sequence, gotDep, tt.wantDep)
-		}
-	}
-}
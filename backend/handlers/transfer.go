@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/models"
+	"gtfs-cms/planner"
+	"gtfs-cms/spatial"
+	"gtfs-cms/validator"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Transfer ---
+
+// validateTransfer checks t against its transfer_type and that the stops,
+// routes and trips it names exist. Transfers are published data, so they may
+// only name published routes and trips, never the copies of a draft.
+func validateTransfer(c *gin.Context, t models.Transfer) error {
+	if err := validator.CheckTransfer(t); err != nil {
+		return err
+	}
+	for _, ref := range []struct {
+		id    *uint
+		model interface{}
+		field string
+	}{
+		{t.FromStopID, &models.Stop{}, "from_stop_id"},
+		{t.ToStopID, &models.Stop{}, "to_stop_id"},
+		{t.FromRouteID, &models.Route{}, "from_route_id"},
+		{t.ToRouteID, &models.Route{}, "to_route_id"},
+		{t.FromTripID, &models.Trip{}, "from_trip_id"},
+		{t.ToTripID, &models.Trip{}, "to_trip_id"},
+	} {
+		if ref.id == nil {
+			continue
+		}
+		var count int64
+		workspaceDB(c).Model(ref.model).Where("id = ?", *ref.id).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("%s #%d not found", ref.field, *ref.id)
+		}
+	}
+	return nil
+}
+
+// GetTransfers lists transfers, with ?stop_id= only those from or to the
+// given stops and with ?generated=true|false only proposed or manual ones
+func GetTransfers(c *gin.Context) {
+	query := workspaceDB(c).Order("id asc")
+	stopIDs, err := uintListParam(c, "stop_id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(stopIDs) > 0 {
+		query = query.Where("from_stop_id IN ? OR to_stop_id IN ?", stopIDs, stopIDs)
+	}
+	if v := c.Query("generated"); v != "" {
+		generated, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "generated must be true or false"})
+			return
+		}
+		query = query.Where("generated = ?", generated)
+	}
+	var transfers []models.Transfer
+	if err := query.Find(&transfers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transfers: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, transfers)
+}
+
+func CreateTransfer(c *gin.Context) {
+	var t models.Transfer
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	t.ID, t.Generated = 0, false
+	if err := validateTransfer(c, t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Create(&t).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transfer: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "transfer", t.ID, nil, t, fmt.Sprintf("Transfer #%d has been added.", t.ID))
+	c.JSON(http.StatusOK, t)
+}
+
+// UpdateTransfer saves an edited transfer; editing a proposed one keeps it
+// when the generator runs again
+func UpdateTransfer(c *gin.Context) {
+	var t models.Transfer
+	if err := workspaceDB(c).First(&t, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+	before := t
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	t.ID, t.Generated = before.ID, false
+	if err := validateTransfer(c, t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Save(&t).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transfer: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "transfer", t.ID, before, t, fmt.Sprintf("Transfer #%d has been updated.", t.ID))
+	c.JSON(http.StatusOK, t)
+}
+
+func DeleteTransfer(c *gin.Context) {
+	var t models.Transfer
+	if err := workspaceDB(c).First(&t, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+	if err := workspaceDB(c).Delete(&t).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete transfer"})
+		return
+	}
+	LogChange(c, ActionDelete, "transfer", t.ID, t, nil, fmt.Sprintf("Transfer #%d has been removed.", t.ID))
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer deleted"})
+}
+
+// walkingTransfers proposes a minimum-time transfer for every pair of
+// boarding stops within radius metres, timed by the straight-line distance
+// at speed metres per second. Pairs in skip already have a transfer.
+func walkingTransfers(index *spatial.Index, radius, speed float64, skip map[[2]uint]bool) []models.Transfer {
+	boarding := func(s models.Stop) bool { return s.LocationType == models.LocationStop }
+	proposals := []models.Transfer{}
+	for _, p := range index.Pairs(radius, boarding) {
+		if skip[[2]uint{p.From.ID, p.To.ID}] {
+			continue
+		}
+		from, to := p.From.ID, p.To.ID
+		secs := int(math.Ceil(p.Distance / speed))
+		proposals = append(proposals, models.Transfer{
+			FromStopID:      &from,
+			ToStopID:        &to,
+			Type:            models.TransferMinTime,
+			MinTransferTime: &secs,
+			Generated:       true,
+		})
+	}
+	return proposals
+}
+
+// GenerateTransfers proposes walking transfers between all stops within
+// ?radius= metres (200 by default) of each other, walked at ?walk_speed=
+// metres per second. Stop pairs that already have a transfer someone entered
+// are left alone. With ?apply=true the proposals replace those of the
+// previous run; otherwise they are only returned.
+func GenerateTransfers(c *gin.Context) {
+	radius, err := radiusParam(c, 200, 2000)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	speed := planner.DefaultOptions.WalkSpeed
+	if v := c.Query("walk_speed"); v != "" {
+		speed, err = strconv.ParseFloat(v, 64)
+		if err != nil || speed <= 0 || speed > 5 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "walk_speed must be between 0 and 5 metres per second"})
+			return
+		}
+	}
+	apply, _ := strconv.ParseBool(c.Query("apply"))
+
+	db := workspaceDB(c)
+	var manual []models.Transfer
+	if err := db.Where("generated = ? AND from_stop_id IS NOT NULL AND to_stop_id IS NOT NULL", false).Find(&manual).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transfers: " + err.Error()})
+		return
+	}
+	skip := make(map[[2]uint]bool, len(manual))
+	for _, t := range manual {
+		skip[[2]uint{*t.FromStopID, *t.ToStopID}] = true
+	}
+	index, err := spatial.Default.Get(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to index stops: " + err.Error()})
+		return
+	}
+	proposals := walkingTransfers(index, radius, speed, skip)
+	if !apply {
+		c.JSON(http.StatusOK, gin.H{"applied": false, "transfers": proposals})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("generated = ?", true).Delete(&models.Transfer{}).Error; err != nil {
+			return err
+		}
+		if len(proposals) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&proposals, 500).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save transfers: " + err.Error()})
+		return
+	}
+	LogActivity(c, "GENERATE", fmt.Sprintf("%d walking transfers within %.0f m have been generated.", len(proposals), radius))
+	c.JSON(http.StatusOK, gin.H{"applied": true, "transfers": proposals})
+}
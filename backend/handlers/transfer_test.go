@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"gtfs-cms/models"
+	"gtfs-cms/spatial"
+	"testing"
+)
+
+func TestWalkingTransfers(t *testing.T) {
+	// Stops 1 and 2 are about 111 m apart, stop 3 is a station beside them
+	// and stop 4 is far away
+	ix := spatial.NewIndex([]models.Stop{
+		{ID: 1, Lat: 52.0, Lon: 4.0},
+		{ID: 2, Lat: 52.001, Lon: 4.0},
+		{ID: 3, Lat: 52.0005, Lon: 4.0, LocationType: models.LocationStation},
+		{ID: 4, Lat: 52.1, Lon: 4.0},
+	}, spatial.CellMeters)
+
+	got := walkingTransfers(ix, 200, 1.25, map[[2]uint]bool{{2, 1}: true})
+	if len(got) != 1 {
+		t.Fatalf("got %d transfers, want only 1 -> 2", len(got))
+	}
+	tr := got[0]
+	if *tr.FromStopID != 1 || *tr.ToStopID != 2 || tr.Type != models.TransferMinTime || !tr.Generated {
+		t.Errorf("transfer = %+v, want a generated minimum-time transfer from 1 to 2", tr)
+	}
+	if *tr.MinTransferTime != 89 {
+		t.Errorf("min_transfer_time = %d, want 89 s for 111 m at 1.25 m/s", *tr.MinTransferTime)
+	}
+}
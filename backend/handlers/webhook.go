@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"fmt"
+	"gtfs-cms/models"
+	"gtfs-cms/webhooks"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Webhooks ---
+
+// WebhookRequest creates or updates a webhook. Active defaults to true on
+// create; omitted fields are left alone on update.
+type WebhookRequest struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// WebhookWithSecret is how a new webhook is returned, the only time its
+// secret is shown
+type WebhookWithSecret struct {
+	models.Webhook
+	Secret string `json:"secret"`
+}
+
+// applyWebhookRequest validates req and copies it onto hook
+func applyWebhookRequest(hook *models.Webhook, req WebhookRequest) error {
+	if req.Name != "" {
+		hook.Name = strings.TrimSpace(req.Name)
+	}
+	if req.URL != "" {
+		u, err := url.Parse(req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url must be an absolute http or https URL")
+		}
+		hook.URL = req.URL
+	}
+	if req.Events != nil {
+		for _, f := range req.Events {
+			if !webhooks.ValidFilter(f) {
+				return fmt.Errorf("invalid event filter %q; use names such as route.updated, route.* or *", f)
+			}
+		}
+		hook.Events = req.Events
+	}
+	if req.Active != nil {
+		hook.Active = *req.Active
+	}
+	if hook.URL == "" || len(hook.Events) == 0 {
+		return fmt.Errorf("url and at least one event filter are required")
+	}
+	return nil
+}
+
+func GetWebhooks(c *gin.Context) {
+	var hooks []models.Webhook
+	if err := workspaceDB(c).Order("id asc").Find(&hooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhooks: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, hooks)
+}
+
+func GetWebhook(c *gin.Context) {
+	var hook models.Webhook
+	if err := workspaceDB(c).First(&hook, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	c.JSON(http.StatusOK, hook)
+}
+
+func CreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hook := models.Webhook{Active: true}
+	if err := applyWebhookRequest(&hook, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	hook.Secret = secret
+	if user, ok := currentUser(c); ok {
+		hook.CreatedByID = &user.ID
+	}
+	if err := workspaceDB(c).Create(&hook).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionCreate, "webhook", hook.ID, nil, hook, fmt.Sprintf("Webhook [%s] to %s has been registered for %s.", hook.Name, hook.URL, strings.Join(hook.Events, ", ")))
+	c.JSON(http.StatusOK, WebhookWithSecret{Webhook: hook, Secret: secret})
+}
+
+func UpdateWebhook(c *gin.Context) {
+	var hook models.Webhook
+	if err := workspaceDB(c).First(&hook, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	before := hook
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyWebhookRequest(&hook, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workspaceDB(c).Save(&hook).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "webhook", hook.ID, before, hook, fmt.Sprintf("Webhook [%s] has been updated.", hook.Name))
+	c.JSON(http.StatusOK, hook)
+}
+
+// DeleteWebhook removes a webhook together with its delivery log
+func DeleteWebhook(c *gin.Context) {
+	var hook models.Webhook
+	if err := workspaceDB(c).First(&hook, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	tx := workspaceDB(c).Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction"})
+		return
+	}
+	if err := tx.Where("webhook_id = ?", hook.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete deliveries"})
+		return
+	}
+	if err := tx.Delete(&hook).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+	LogChange(c, ActionDelete, "webhook", hook.ID, hook, nil, fmt.Sprintf("Webhook [%s] has been removed.", hook.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// PingWebhook queues a ping delivery to check the receiver and its
+// signature verification
+func PingWebhook(c *gin.Context) {
+	var hook models.Webhook
+	if err := workspaceDB(c).First(&hook, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	ids, err := webhooks.Enqueue(workspaceDB(c), webhooks.EventPing, time.Now(), gin.H{"webhook_id": hook.ID}, &hook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue ping: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Ping queued", "delivery_id": ids[0]})
+}
+
+// workspaceHookIDs selects the webhooks of the request's workspace. The
+// delivery log belongs to its webhook, so it is scoped through this.
+func workspaceHookIDs(c *gin.Context) *gorm.DB {
+	return workspaceDB(c).Model(&models.Webhook{}).Select("id")
+}
+
+// GetWebhookDeliveries lists the delivery log of a webhook newest first,
+// filtered by ?status= and ?event=
+func GetWebhookDeliveries(c *gin.Context) {
+	limit, cursor, err := pageParams(c, 50, 500)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query := workspaceDB(c).Model(&models.WebhookDelivery{}).Where("webhook_id = ?", c.Param("id")).Where("webhook_id IN (?)", workspaceHookIDs(c))
+	if v := c.Query("status"); v != "" {
+		query = query.Where("status = ?", v)
+	}
+	if v := c.Query("event"); v != "" {
+		query = query.Where("event = ?", v)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count deliveries: " + err.Error()})
+		return
+	}
+	if cursor != "" {
+		beforeID, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		query = query.Where("id < ?", beforeID)
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.Order("id desc").Limit(limit).Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deliveries: " + err.Error()})
+		return
+	}
+	next := ""
+	if len(deliveries) == limit {
+		next = strconv.FormatUint(uint64(deliveries[len(deliveries)-1].ID), 10)
+	}
+	setPageHeaders(c, total, next)
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func GetWebhookDelivery(c *gin.Context) {
+	var delivery models.WebhookDelivery
+	if err := workspaceDB(c).Where("webhook_id = ?", c.Param("id")).Where("webhook_id IN (?)", workspaceHookIDs(c)).First(&delivery, c.Param("delivery_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	c.JSON(http.StatusOK, delivery)
+}
+
+// RedeliverWebhook queues a fresh delivery with the payload of an earlier one
+func RedeliverWebhook(c *gin.Context) {
+	var delivery models.WebhookDelivery
+	if err := workspaceDB(c).Where("webhook_id = ?", c.Param("id")).Where("webhook_id IN (?)", workspaceHookIDs(c)).First(&delivery, c.Param("delivery_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	now := time.Now()
+	retry := models.WebhookDelivery{
+		WebhookID:     delivery.WebhookID,
+		Event:         delivery.Event,
+		Payload:       delivery.Payload,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: &now,
+	}
+	if err := workspaceDB(c).Create(&retry).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue delivery: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, retry)
+}
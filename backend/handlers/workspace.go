@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"gtfs-cms/auth"
+	"gtfs-cms/database"
+	"gtfs-cms/models"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Workspaces ---
+
+// workspaceHeader selects the workspace a request works in; the workspace
+// query parameter does the same for links and event streams. Without
+// either, signed-in users land in the first workspace they are a member of
+// and everyone else in the default workspace.
+const (
+	workspaceHeader     = "X-Workspace-ID"
+	workspaceContextKey = "workspace"
+	workspaceRoleKey    = "workspace_role"
+)
+
+// findWorkspace looks a workspace up by ID or by name
+func findWorkspace(ref string) (models.Workspace, error) {
+	var ws models.Workspace
+	query := database.DB.Where("name = ?", ref)
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		query = database.DB.Where("id = ? OR name = ?", id, ref)
+	}
+	err := query.First(&ws).Error
+	return ws, err
+}
+
+// allWorkspacesDB reaches the memberships of every workspace
+func allWorkspacesDB() *gorm.DB {
+	return database.DB.WithContext(database.WithAllWorkspaces(context.Background()))
+}
+
+// workspaceRoleOf is the role user has in workspace id: admin for admin
+// accounts, the membership role for everyone else, "" for non-members
+func workspaceRoleOf(user models.User, id uint) string {
+	if user.Role == models.RoleAdmin {
+		return models.RoleAdmin
+	}
+	var member models.WorkspaceMember
+	if err := allWorkspacesDB().Where("workspace_id = ? AND user_id = ?", id, user.ID).First(&member).Error; err != nil {
+		return ""
+	}
+	return member.Role
+}
+
+// WorkspaceScope resolves the workspace a request works in and the role of
+// the signed-in user there, and scopes the request's database statements to
+// it. Published data stays public, so anyone may read any workspace;
+// RequireRole keeps non-members out of everything else.
+func WorkspaceScope(c *gin.Context) {
+	ref := c.GetHeader(workspaceHeader)
+	if ref == "" {
+		ref = c.Query("workspace")
+	}
+	user, signedIn := currentUser(c)
+
+	var ws models.Workspace
+	var err error
+	switch {
+	case ref != "":
+		ws, err = findWorkspace(ref)
+	case signedIn && user.Role != models.RoleAdmin:
+		var member models.WorkspaceMember
+		id := uint(models.DefaultWorkspaceID)
+		if allWorkspacesDB().Where("user_id = ?", user.ID).Order("workspace_id asc").First(&member).Error == nil {
+			id = member.WorkspaceID
+		}
+		err = database.DB.First(&ws, id).Error
+	default:
+		err = database.DB.First(&ws, models.DefaultWorkspaceID).Error
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Workspace %s not found", ref)})
+		return
+	}
+
+	role := ""
+	if signedIn {
+		role = workspaceRoleOf(user, ws.ID)
+	}
+	c.Set(workspaceContextKey, ws)
+	c.Set(workspaceRoleKey, role)
+	c.Request = c.Request.WithContext(database.WithWorkspace(c.Request.Context(), ws.ID))
+	c.Next()
+}
+
+func currentWorkspace(c *gin.Context) (models.Workspace, bool) {
+	v, ok := c.Get(workspaceContextKey)
+	if !ok {
+		return models.Workspace{}, false
+	}
+	ws, ok := v.(models.Workspace)
+	return ws, ok
+}
+
+// currentWorkspaceID is the workspace of the request, the default one
+// outside of WorkspaceScope
+func currentWorkspaceID(c *gin.Context) uint {
+	return database.WorkspaceOf(c.Request.Context())
+}
+
+// currentRole is the role of the signed-in user in the request's workspace
+func currentRole(c *gin.Context) string {
+	return c.GetString(workspaceRoleKey)
+}
+
+// workspaceDB is the database scoped to the workspace of the request
+func workspaceDB(c *gin.Context) *gorm.DB {
+	return database.DB.WithContext(c.Request.Context())
+}
+
+// WorkspaceWithRole is a workspace as listed to a user, with their role in it
+type WorkspaceWithRole struct {
+	models.Workspace
+	Role string `json:"role"`
+}
+
+// GetWorkspaces lists the workspaces the current user can work in
+func GetWorkspaces(c *gin.Context) {
+	user, _ := currentUser(c)
+	var list []models.Workspace
+	query := database.DB.Order("id asc")
+	if user.Role != models.RoleAdmin {
+		query = query.Where("id IN (?)", allWorkspacesDB().Model(&models.WorkspaceMember{}).Select("workspace_id").Where("user_id = ?", user.ID))
+	}
+	if err := query.Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workspaces: " + err.Error()})
+		return
+	}
+	out := make([]WorkspaceWithRole, len(list))
+	for i, ws := range list {
+		out[i] = WorkspaceWithRole{Workspace: ws, Role: workspaceRoleOf(user, ws.ID)}
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+type WorkspaceRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// CreateWorkspace creates an empty workspace with the default settings and
+// service calendar
+func CreateWorkspace(c *gin.Context) {
+	var req WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ws := models.Workspace{Name: strings.TrimSpace(req.Name), Description: req.Description}
+	if ws.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if _, err := findWorkspace(ws.Name); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A workspace named %s already exists", ws.Name)})
+		return
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Create(&ws).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workspace: " + err.Error()})
+		return
+	}
+	if err := database.SeedWorkspace(tx.WithContext(database.WithWorkspace(c.Request.Context(), ws.ID))); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to seed workspace: " + err.Error()})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+	LogChange(c, ActionCreate, "workspace", ws.ID, nil, ws, fmt.Sprintf("Workspace [%s] has been created.", ws.Name))
+	c.JSON(http.StatusOK, ws)
+}
+
+func UpdateWorkspace(c *gin.Context) {
+	var ws models.Workspace
+	if err := database.DB.First(&ws, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return
+	}
+	before := ws
+	var req WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if name := strings.TrimSpace(req.Name); name != "" && name != ws.Name {
+		if _, err := findWorkspace(name); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A workspace named %s already exists", name)})
+			return
+		}
+		ws.Name = name
+	}
+	ws.Description = req.Description
+	if err := database.DB.Save(&ws).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update workspace: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "workspace", ws.ID, before, ws, fmt.Sprintf("Workspace [%s] has been updated.", ws.Name))
+	c.JSON(http.StatusOK, ws)
+}
+
+// DeleteWorkspace removes a workspace whose feed is empty, in no draft
+// either, with everything else it holds: memberships, settings, calendars,
+// fares, drafts, alerts, snapshots and webhooks. The default workspace stays.
+func DeleteWorkspace(c *gin.Context) {
+	var ws models.Workspace
+	if err := database.DB.First(&ws, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return
+	}
+	if ws.ID == models.DefaultWorkspaceID {
+		c.JSON(http.StatusConflict, gin.H{"error": "The default workspace cannot be deleted"})
+		return
+	}
+	scoped := database.DB.WithContext(database.WithAllDrafts(database.WithWorkspace(c.Request.Context(), ws.ID)))
+	for _, m := range []interface{}{&models.Agency{}, &models.Stop{}, &models.Route{}, &models.Trip{}, &models.ShapePoint{}} {
+		var count int64
+		scoped.Model(m).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Workspace [%s] still has a feed; delete its agencies, stops, routes, trips and shapes first, in its drafts too", ws.Name)})
+			return
+		}
+	}
+
+	tx := scoped.Begin()
+	// Alert periods and entities and webhook deliveries carry no workspace;
+	// they go with the alerts and webhooks they belong to
+	for _, child := range []struct {
+		model  interface{}
+		column string
+		parent interface{}
+	}{
+		{&models.AlertActivePeriod{}, "alert_id", &models.Alert{}},
+		{&models.AlertInformedEntity{}, "alert_id", &models.Alert{}},
+		{&models.WebhookDelivery{}, "webhook_id", &models.Webhook{}},
+	} {
+		if err := tx.Where(child.column+" IN (?)", tx.Model(child.parent).Select("id")).Delete(child.model).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear workspace: " + err.Error()})
+			return
+		}
+	}
+	for _, m := range []interface{}{&models.WorkspaceMember{}, &models.Setting{}, &models.CalendarDate{}, &models.Calendar{}, &models.Frequency{}, &models.Transfer{},
+		&models.Pathway{}, &models.Level{}, &models.ShapePoint{}, &models.FareLegRule{}, &models.FareProduct{}, &models.FareMedia{}, &models.Area{}, &models.FareRule{},
+		&models.FareAttribute{}, &models.Draft{}, &models.Alert{}, &models.Snapshot{}, &models.Webhook{}} {
+		if err := tx.Where("1 = 1").Delete(m).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear workspace: " + err.Error()})
+			return
+		}
+	}
+	if err := tx.Delete(&ws).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete workspace"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+	LogChange(c, ActionDelete, "workspace", ws.ID, ws, nil, fmt.Sprintf("Workspace [%s] has been removed.", ws.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "Workspace deleted"})
+}
+
+// --- Workspace members ---
+
+// MemberRequest adds a user to the current workspace, by ID or email, or
+// changes their role there
+type MemberRequest struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+}
+
+// GetMembers lists the members of the current workspace
+func GetMembers(c *gin.Context) {
+	var members []models.WorkspaceMember
+	if err := workspaceDB(c).Preload("User").Order("id asc").Find(&members).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch members: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+func AddMember(c *gin.Context) {
+	var req MemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleViewer
+	}
+	if !auth.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown role %q", req.Role)})
+		return
+	}
+	var user models.User
+	query := database.DB.Where("id = ?", req.UserID)
+	if req.UserID == 0 {
+		query = database.DB.Where("LOWER(email) = ?", strings.ToLower(strings.TrimSpace(req.Email)))
+	}
+	if err := query.First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	db := workspaceDB(c)
+	var existing int64
+	db.Model(&models.WorkspaceMember{}).Where("user_id = ?", user.ID).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s is already a member of this workspace", user.Email)})
+		return
+	}
+	member := models.WorkspaceMember{UserID: user.ID, Role: req.Role}
+	if err := db.Create(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member: " + err.Error()})
+		return
+	}
+	member.User = user
+	LogChange(c, ActionCreate, "member", member.ID, nil, member, fmt.Sprintf("%s has joined the workspace as %s.", user.Email, member.Role))
+	c.JSON(http.StatusOK, member)
+}
+
+func UpdateMember(c *gin.Context) {
+	db := workspaceDB(c)
+	var member models.WorkspaceMember
+	if err := db.Preload("User").First(&member, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	before := member
+	var req MemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown role %q", req.Role)})
+		return
+	}
+	if err := db.Model(&member).Update("role", req.Role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member: " + err.Error()})
+		return
+	}
+	LogChange(c, ActionUpdate, "member", member.ID, before, member, fmt.Sprintf("%s is now %s in the workspace.", member.User.Email, member.Role))
+	c.JSON(http.StatusOK, member)
+}
+
+func RemoveMember(c *gin.Context) {
+	db := workspaceDB(c)
+	var member models.WorkspaceMember
+	if err := db.Preload("User").First(&member, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	if err := db.Delete(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+	LogChange(c, ActionDelete, "member", member.ID, member, nil, fmt.Sprintf("%s has left the workspace.", member.User.Email))
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"gtfs-cms/database"
+	"gtfs-cms/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeleteWorkspace(t *testing.T) {
+	db := testDB(t)
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The same alert and webhook in the default workspace must survive
+	seed := func(ws uint) {
+		scoped := db.WithContext(database.WithWorkspace(context.Background(), ws))
+		stop := uint(1)
+		must(scoped.Create(&models.Alert{
+			Cause:            "CONSTRUCTION",
+			ActivePeriods:    []models.AlertActivePeriod{{}},
+			InformedEntities: []models.AlertInformedEntity{{StopID: &stop}},
+		}).Error)
+		must(scoped.Create(&models.Webhook{URL: "https://hooks.example", Secret: "s"}).Error)
+		var hook models.Webhook
+		must(scoped.Last(&hook).Error)
+		must(scoped.Create(&models.WebhookDelivery{WebhookID: hook.ID, Event: "feed.exported", Payload: []byte("{}"), Status: models.DeliveryPending}).Error)
+		must(scoped.Create(&models.Snapshot{Name: "before"}).Error)
+		must(scoped.Create(&models.Draft{Name: "Timetable change", Status: models.DraftOpen}).Error)
+		must(scoped.Create(&models.Frequency{TripID: 1, StartTime: "06:00:00", EndTime: "07:00:00", HeadwaySecs: 600}).Error)
+	}
+	must(db.Create(&models.Workspace{Name: "Default"}).Error)
+	ws := models.Workspace{Name: "Trial"}
+	must(db.Create(&ws).Error)
+	seed(models.DefaultWorkspaceID)
+	seed(ws.ID)
+
+	deleteWorkspace := func() int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("DELETE", "/api/workspaces/2", nil)
+		c.Params = gin.Params{{Key: "id", Value: "2"}}
+		DeleteWorkspace(c)
+		return w.Code
+	}
+
+	// A shape left in a draft is still a feed
+	drafted := db.WithContext(database.WithDraft(database.WithWorkspace(context.Background(), ws.ID), 1))
+	must(drafted.Create(&models.ShapePoint{ShapeID: "S1", Sequence: 1}).Error)
+	if code := deleteWorkspace(); code != http.StatusConflict {
+		t.Fatalf("DeleteWorkspace() with a drafted shape responded %d, want %d", code, http.StatusConflict)
+	}
+	must(drafted.Where("1 = 1").Delete(&models.ShapePoint{}).Error)
+
+	if code := deleteWorkspace(); code != http.StatusOK {
+		t.Fatalf("DeleteWorkspace() responded %d", code)
+	}
+	for _, m := range []interface{}{&models.Alert{}, &models.AlertActivePeriod{}, &models.AlertInformedEntity{}, &models.Webhook{},
+		&models.WebhookDelivery{}, &models.Snapshot{}, &models.Draft{}, &models.Frequency{}} {
+		var count int64
+		db.WithContext(database.WithAllWorkspaces(context.Background())).Model(m).Count(&count)
+		if count != 1 {
+			t.Errorf("%T: %d rows left, want only the default workspace's", m, count)
+		}
+	}
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
 	"gtfs-cms/database"
+	"gtfs-cms/events"
 	"gtfs-cms/handlers"
+	"gtfs-cms/models"
+	"gtfs-cms/realtime"
+	"gtfs-cms/webhooks"
+	"os"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -12,65 +18,221 @@ import (
 func main() {
 	database.Connect()
 
+	// Synthetic vehicles for local development of realtime consumers
+	if os.Getenv("REALTIME_SIMULATOR") == "true" {
+		go realtime.NewSimulator(database.DB, realtime.Default, 15*time.Second).Run(context.Background())
+	}
+
+	// Sends changes to the published feed to registered webhooks
+	go webhooks.NewDispatcher(database.DB, events.Default).Run(context.Background())
+
 	r := gin.Default()
 
 	// CORS Setup
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:3000"}, // Vite default port
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Draft-ID", "X-Workspace-ID", "If-Match", "Last-Event-ID"},
+		ExposeHeaders:    []string{"Content-Length", "X-GTFS-Validation-Warnings", "X-Total-Count", "X-Next-Cursor", "X-Snapshot-ID", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
 	api := r.Group("/api")
+	api.Use(handlers.Authenticate, handlers.WorkspaceScope, handlers.DraftScope)
+
+	// Published network data stays public: the web viewer and riders read it
+	api.POST("/auth/login", handlers.Login)
+	api.GET("/settings", handlers.GetSettings)
+	api.GET("/agencies", handlers.GetAgencies)
+	api.GET("/agencies/:id", handlers.GetAgency)
+	api.GET("/stops", handlers.GetStops)
+	api.GET("/stops/nearby", handlers.GetNearbyStops)
+	api.GET("/stops/:id", handlers.GetStop)
+	api.GET("/stops/:id/routes", handlers.GetStopRoutes)
+	api.GET("/stops/:id/times", handlers.GetStopTimes)
+	api.GET("/stops/:id/departures", handlers.GetStopDepartures)
+	api.GET("/stop-routes", handlers.GetAllStopRoutes)
+	api.GET("/routes", handlers.GetRoutes)
+	api.GET("/routes/:id", handlers.GetRoute)
+	api.GET("/trips", handlers.GetTrips)
+	api.GET("/trips/:id", handlers.GetTrip)
+	api.GET("/trips/:id/stops", handlers.GetTripStops)
+	api.GET("/trips/:id/frequencies", handlers.GetTripFrequencies)
+	api.GET("/calendars", handlers.GetCalendars)
+	api.GET("/calendars/:service_id/dates", handlers.GetCalendarDates)
+	api.GET("/shapes/:shape_id", handlers.GetShape)
+	api.GET("/shapes/:shape_id/stops", handlers.GetShapeStops)
+	api.GET("/levels", handlers.GetLevels)
+	api.GET("/pathways", handlers.GetPathways)
+	api.GET("/transfers", handlers.GetTransfers)
+	api.GET("/fares", handlers.GetFareAttributes)
+	api.GET("/fare-rules", handlers.GetFareRules)
+	api.GET("/areas", handlers.GetAreas)
+	api.GET("/fare-media", handlers.GetFareMedia)
+	api.GET("/fare-products", handlers.GetFareProducts)
+	api.GET("/fare-leg-rules", handlers.GetFareLegRules)
+	api.GET("/shapes", handlers.GetUniqueShapes)
+	api.POST("/shapes/bulk", handlers.GetBulkShapes)
+	api.GET("/plan", handlers.PlanJourney)
+	api.GET("/alerts", handlers.GetAlerts)
+	api.GET("/alerts/:id", handlers.GetAlert)
+	api.GET("/realtime/trip-updates.pb", handlers.GetTripUpdatesFeed)
+	api.GET("/realtime/trip-updates.json", handlers.GetTripUpdatesJSON)
+	api.GET("/realtime/vehicle-positions.pb", handlers.GetVehiclePositionsFeed)
+	api.GET("/realtime/vehicle-positions.json", handlers.GetVehiclePositionsJSON)
+	api.GET("/realtime/alerts.pb", handlers.GetAlertsFeed)
+	api.GET("/realtime/alerts.json", handlers.GetAlertsJSON)
+
+	// Account routes work from any workspace, member or not
+	account := api.Group("", handlers.RequireSignIn)
+	{
+		account.GET("/auth/me", handlers.GetCurrentUser)
+		account.POST("/auth/logout", handlers.Logout)
+		account.PUT("/auth/password", handlers.ChangePassword)
+		account.GET("/workspaces", handlers.GetWorkspaces)
+	}
+
+	viewer := api.Group("", handlers.RequireRole(models.RoleViewer))
+	{
+		viewer.GET("/validate", handlers.ValidateFeed)
+		viewer.GET("/activity-logs", handlers.GetActivityLogs)
+		viewer.GET("/events", handlers.GetEvents)
+		viewer.GET("/presence", handlers.GetPresence)
+		viewer.GET("/snapshots", handlers.GetSnapshots)
+		viewer.GET("/snapshots/:id", handlers.GetSnapshot)
+		viewer.GET("/snapshots/:id/diff", handlers.DiffSnapshot)
+		viewer.GET("/drafts", handlers.GetDrafts)
+		viewer.GET("/drafts/:id", handlers.GetDraft)
+		viewer.GET("/drafts/:id/changes", handlers.GetDraftChanges)
+	}
+
+	editor := api.Group("", handlers.RequireRole(models.RoleEditor))
+	{
+		editor.POST("/agencies", handlers.CreateAgency)
+		editor.PUT("/agencies/:id", handlers.UpdateAgency)
+
+		editor.POST("/stops", handlers.CreateStop)
+		editor.PUT("/stops/:id", handlers.UpdateStop)
+		editor.PUT("/stops/:id/routes", handlers.UpdateStopRoutes)
+
+		editor.POST("/levels", handlers.CreateLevel)
+		editor.PUT("/levels/:id", handlers.UpdateLevel)
+		editor.POST("/pathways", handlers.CreatePathway)
+		editor.PUT("/pathways/:id", handlers.UpdatePathway)
+		editor.POST("/transfers", handlers.CreateTransfer)
+		editor.PUT("/transfers/:id", handlers.UpdateTransfer)
+		editor.POST("/transfers/generate", handlers.GenerateTransfers)
+
+		editor.POST("/fares", handlers.CreateFareAttribute)
+		editor.PUT("/fares/:id", handlers.UpdateFareAttribute)
+		editor.POST("/fare-rules", handlers.CreateFareRule)
+		editor.PUT("/fare-rules/:id", handlers.UpdateFareRule)
+		editor.POST("/areas", handlers.CreateArea)
+		editor.PUT("/areas/:id", handlers.UpdateArea)
+		editor.POST("/fare-media", handlers.CreateFareMedia)
+		editor.PUT("/fare-media/:id", handlers.UpdateFareMedia)
+		editor.POST("/fare-products", handlers.CreateFareProduct)
+		editor.PUT("/fare-products/:id", handlers.UpdateFareProduct)
+		editor.POST("/fare-leg-rules", handlers.CreateFareLegRule)
+		editor.PUT("/fare-leg-rules/:id", handlers.UpdateFareLegRule)
+
+		editor.POST("/routes", handlers.CreateRoute)
+		editor.PUT("/routes/:id", handlers.UpdateRoute)
+
+		editor.POST("/trips", handlers.CreateTrip)
+		editor.PUT("/trips/:id", handlers.UpdateTrip)
+		editor.POST("/trips/:id/stops", handlers.AddStopToTrip)
+		editor.PUT("/trips/:id/stops", handlers.UpdateTripStops)
+		editor.POST("/trips/:id/generate", handlers.GenerateTrips)
+		editor.POST("/trips/:id/interpolate", handlers.InterpolateTripTimes)
+		editor.POST("/trips/:id/frequencies", handlers.CreateTripFrequency)
+		editor.PUT("/trips/:id/frequencies/:freq_id", handlers.UpdateTripFrequency)
+
+		editor.POST("/calendars", handlers.CreateCalendar)
+		editor.PUT("/calendars/:service_id", handlers.UpdateCalendar)
+		editor.POST("/calendars/:service_id/dates", handlers.CreateCalendarDate)
+
+		editor.POST("/shapes", handlers.CreateShape)
+		editor.PUT("/shapes/:shape_id", handlers.UpdateShape)
+
+		editor.POST("/alerts", handlers.CreateAlert)
+		editor.PUT("/alerts/:id", handlers.UpdateAlert)
+
+		editor.POST("/snapshots", handlers.CreateSnapshot)
+		editor.POST("/drafts", handlers.CreateDraft)
+		editor.PUT("/drafts/:id", handlers.UpdateDraft)
+		editor.POST("/drafts/:id/submit", handlers.SubmitDraft)
+
+		editor.PUT("/presence", handlers.SetPresence)
+		editor.DELETE("/presence", handlers.ClearPresence)
+
+		editor.POST("/import/gtfs", handlers.ImportGTFS)
+		editor.POST("/realtime/trip-updates", handlers.IngestTripUpdates)
+		editor.POST("/realtime/vehicle-positions", handlers.IngestVehiclePositions)
+	}
+
+	// Deleting and publishing the feed are irreversible for riders
+	publisher := api.Group("", handlers.RequireRole(models.RolePublisher))
+	{
+		publisher.DELETE("/agencies/:id", handlers.DeleteAgency)
+		publisher.DELETE("/stops/:id", handlers.DeleteStop)
+		publisher.DELETE("/levels/:id", handlers.DeleteLevel)
+		publisher.DELETE("/pathways/:id", handlers.DeletePathway)
+		publisher.DELETE("/transfers/:id", handlers.DeleteTransfer)
+		publisher.DELETE("/fares/:id", handlers.DeleteFareAttribute)
+		publisher.DELETE("/fare-rules/:id", handlers.DeleteFareRule)
+		publisher.DELETE("/areas/:id", handlers.DeleteArea)
+		publisher.DELETE("/fare-media/:id", handlers.DeleteFareMedia)
+		publisher.DELETE("/fare-products/:id", handlers.DeleteFareProduct)
+		publisher.DELETE("/fare-leg-rules/:id", handlers.DeleteFareLegRule)
+		publisher.DELETE("/routes/:id", handlers.DeleteRoute)
+		publisher.DELETE("/trips/:id", handlers.DeleteTrip)
+		publisher.DELETE("/trips/:id/frequencies/:freq_id", handlers.DeleteTripFrequency)
+		publisher.DELETE("/calendars/:service_id", handlers.DeleteCalendar)
+		publisher.DELETE("/calendars/:service_id/dates/:date_id", handlers.DeleteCalendarDate)
+		publisher.DELETE("/shapes/:shape_id", handlers.DeleteShape)
+		publisher.DELETE("/alerts/:id", handlers.DeleteAlert)
+		publisher.DELETE("/snapshots/:id", handlers.DeleteSnapshot)
+		publisher.POST("/snapshots/:id/restore", handlers.RestoreSnapshot)
+		publisher.DELETE("/drafts/:id", handlers.DeleteDraft)
+		publisher.POST("/drafts/:id/approve", handlers.ApproveDraft)
+		publisher.POST("/drafts/:id/reject", handlers.RejectDraft)
+		publisher.POST("/drafts/:id/merge", handlers.MergeDraft)
+		publisher.GET("/export/gtfs", handlers.ExportGTFS)
+	}
+
+	admin := api.Group("", handlers.RequireRole(models.RoleAdmin))
+	{
+		admin.PUT("/settings", handlers.UpdateSetting)
+
+		admin.GET("/workspace/members", handlers.GetMembers)
+		admin.POST("/workspace/members", handlers.AddMember)
+		admin.PUT("/workspace/members/:id", handlers.UpdateMember)
+		admin.DELETE("/workspace/members/:id", handlers.RemoveMember)
+
+		admin.GET("/webhooks", handlers.GetWebhooks)
+		admin.POST("/webhooks", handlers.CreateWebhook)
+		admin.GET("/webhooks/:id", handlers.GetWebhook)
+		admin.PUT("/webhooks/:id", handlers.UpdateWebhook)
+		admin.DELETE("/webhooks/:id", handlers.DeleteWebhook)
+		admin.POST("/webhooks/:id/ping", handlers.PingWebhook)
+		admin.GET("/webhooks/:id/deliveries", handlers.GetWebhookDeliveries)
+		admin.GET("/webhooks/:id/deliveries/:delivery_id", handlers.GetWebhookDelivery)
+		admin.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", handlers.RedeliverWebhook)
+	}
+
+	// Accounts and workspaces span every workspace
+	site := api.Group("", handlers.RequireSiteAdmin)
 	{
-		api.GET("/settings", handlers.GetSettings)
-		api.PUT("/settings", handlers.UpdateSetting)
-
-		api.GET("/agencies", handlers.GetAgencies)
-		api.POST("/agencies", handlers.CreateAgency)
-		api.PUT("/agencies/:id", handlers.UpdateAgency)
-		api.DELETE("/agencies/:id", handlers.DeleteAgency)
-
-		api.GET("/stops", handlers.GetStops)
-		api.POST("/stops", handlers.CreateStop)
-		             api.PUT("/stops/:id", handlers.UpdateStop)
-		             api.DELETE("/stops/:id", handlers.DeleteStop)
-		             api.GET("/stops/:id/routes", handlers.GetStopRoutes)
-		             api.GET("/stops/:id/times", handlers.GetStopTimes)
-		             api.GET("/stop-routes", handlers.GetAllStopRoutes)
-		
-		api.PUT("/stops/:id/routes", handlers.UpdateStopRoutes)
-
-		api.GET("/routes", handlers.GetRoutes)
-		api.POST("/routes", handlers.CreateRoute)
-		api.PUT("/routes/:id", handlers.UpdateRoute)
-		api.DELETE("/routes/:id", handlers.DeleteRoute)
-
-		api.GET("/trips/:id/stops", handlers.GetTripStops)
-		api.POST("/trips/:id/stops", handlers.AddStopToTrip)
-		api.PUT("/trips/:id/stops", handlers.UpdateTripStops)
-
-		api.GET("/trips", handlers.GetTrips)
-		api.POST("/trips", handlers.CreateTrip)
-		api.PUT("/trips/:id", handlers.UpdateTrip)
-		api.DELETE("/trips/:id", handlers.DeleteTrip)
-
-		             api.GET("/shapes/:shape_id", handlers.GetShape)
-
-		             api.GET("/shapes", handlers.GetUniqueShapes)
-
-		             api.POST("/shapes/bulk", handlers.GetBulkShapes)
-
-		
-		api.POST("/shapes", handlers.CreateShape)
-		api.PUT("/shapes/:shape_id", handlers.UpdateShape)
-		api.DELETE("/shapes/:shape_id", handlers.DeleteShape)
-
-		api.GET("/export/gtfs", handlers.ExportGTFS)
-		api.GET("/activity-logs", handlers.GetActivityLogs)
+		site.GET("/users", handlers.GetUsers)
+		site.POST("/users", handlers.CreateUser)
+		site.PUT("/users/:id", handlers.UpdateUser)
+		site.DELETE("/users/:id", handlers.DeleteUser)
+
+		site.POST("/workspaces", handlers.CreateWorkspace)
+		site.PUT("/workspaces/:id", handlers.UpdateWorkspace)
+		site.DELETE("/workspaces/:id", handlers.DeleteWorkspace)
 	}
 
 	r.Run(":8080")
@@ -1,12 +1,18 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Agency struct {
 	ID       uint   `gorm:"primaryKey" json:"id"`
 	Name     string `json:"name"`
 	Url      string `json:"url"`
 	Timezone string `json:"timezone"`
+	Version  uint   `gorm:"not null;default:1" json:"version"` // Bumped by every update, sent as the ETag
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
 }
 
 type Stop struct {
@@ -14,7 +20,189 @@ type Stop struct {
 	Name     string  `json:"name"`
 	Lat      float64 `json:"lat"`
 	Lon      float64 `json:"lon"`
-	RouteIDs []uint  `gorm:"-" json:"route_ids"` // Hydrated field
+	Version  uint    `gorm:"not null;default:1" json:"version"` // Bumped by every update, sent as the ETag
+	RouteIDs []uint  `gorm:"-" json:"route_ids"`                // Hydrated field
+
+	// Station hierarchy (stops.txt), see LocationStop
+	LocationType  int    `gorm:"not null;default:0" json:"location_type"`
+	ParentStation *uint  `gorm:"index" json:"parent_station,omitempty"`
+	PlatformCode  string `json:"platform_code,omitempty"`
+	StopCode      string `json:"stop_code,omitempty"`
+	ZoneID        string `gorm:"index" json:"zone_id,omitempty"` // Fare zone
+	LevelID       *uint  `gorm:"index" json:"level_id,omitempty"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// Stop location types. Platforms and other plain stops may belong to a
+// station; entrances and generic nodes must; boarding areas belong to a
+// platform.
+const (
+	LocationStop         = 0 // stop or platform, the only kind trips serve
+	LocationStation      = 1
+	LocationEntrance     = 2 // entrance or exit of a station
+	LocationGenericNode  = 3 // point inside a station, for pathways
+	LocationBoardingArea = 4 // part of a platform
+)
+
+// Level is a floor of a station (levels.txt)
+type Level struct {
+	ID    uint    `gorm:"primaryKey" json:"id"`
+	Index float64 `gorm:"column:level_index;not null;default:0" json:"level_index"` // 0 is the ground floor, negative below it
+	Name  string  `json:"level_name,omitempty"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// Pathway modes (pathways.txt)
+const (
+	PathwayWalkway    = 1
+	PathwayStairs     = 2
+	PathwayTravelator = 3 // moving sidewalk
+	PathwayEscalator  = 4
+	PathwayElevator   = 5
+	PathwayFareGate   = 6
+	PathwayExitGate   = 7
+	MaxPathwayMode    = PathwayExitGate
+)
+
+// Pathway links two locations inside a station: platforms, entrances,
+// generic nodes or boarding areas
+type Pathway struct {
+	ID              uint     `gorm:"primaryKey" json:"id"`
+	FromStopID      uint     `gorm:"index;not null" json:"from_stop_id"`
+	ToStopID        uint     `gorm:"index;not null" json:"to_stop_id"`
+	Mode            int      `gorm:"not null" json:"pathway_mode"`
+	IsBidirectional bool     `json:"is_bidirectional"`
+	Length          *float64 `json:"length,omitempty"`         // metres
+	TraversalTime   *int     `json:"traversal_time,omitempty"` // seconds
+	StairCount      *int     `json:"stair_count,omitempty"`    // positive going up from FromStop
+	MaxSlope        *float64 `json:"max_slope,omitempty"`
+	MinWidth        *float64 `json:"min_width,omitempty"` // metres
+	SignpostedAs    string   `json:"signposted_as,omitempty"`
+
+	// Wheelchair says whether the pathway is usable in a wheelchair. Unset,
+	// it follows from the mode: stairs and escalators are not, the rest are.
+	// GTFS has no column for it; it decides step-free reachability.
+	Wheelchair *bool `json:"wheelchair,omitempty"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// Transfer types (transfers.txt)
+const (
+	TransferRecommended = 0
+	TransferTimed       = 1 // the departing vehicle waits for the arriving one
+	TransferMinTime     = 2 // needs at least MinTransferTime seconds
+	TransferNotPossible = 3
+	TransferInSeat      = 4 // stay on board between trips
+	TransferReboard     = 5 // get off and back on the next trip
+)
+
+// Transfer is a rule for changing between two stops, routes or trips. Rows
+// proposed by the walking-transfer generator are marked Generated and
+// replaced whenever it runs again.
+type Transfer struct {
+	ID              uint  `gorm:"primaryKey" json:"id"`
+	FromStopID      *uint `gorm:"index" json:"from_stop_id,omitempty"`
+	ToStopID        *uint `gorm:"index" json:"to_stop_id,omitempty"`
+	FromRouteID     *uint `json:"from_route_id,omitempty"`
+	ToRouteID       *uint `json:"to_route_id,omitempty"`
+	FromTripID      *uint `json:"from_trip_id,omitempty"`
+	ToTripID        *uint `json:"to_trip_id,omitempty"`
+	Type            int   `gorm:"not null;default:0" json:"transfer_type"`
+	MinTransferTime *int  `json:"min_transfer_time,omitempty"` // seconds
+	Generated       bool  `gorm:"index" json:"generated"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// Fare payment methods (fare_attributes.txt)
+const (
+	PayOnBoard        = 0
+	PayBeforeBoarding = 1
+)
+
+// FareAttribute is a Fares v1 fare (fare_attributes.txt). FareRules say
+// which journeys it applies to.
+type FareAttribute struct {
+	ID               uint    `gorm:"primaryKey" json:"id"`
+	Price            float64 `json:"price"`
+	CurrencyType     string  `json:"currency_type"` // ISO 4217, such as EUR
+	PaymentMethod    int     `gorm:"not null;default:0" json:"payment_method"`
+	Transfers        *int    `json:"transfers,omitempty"` // transfers allowed, unset for unlimited
+	AgencyID         *uint   `gorm:"index" json:"agency_id,omitempty"`
+	TransferDuration *int    `json:"transfer_duration,omitempty"` // seconds the fare stays valid
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// FareRule applies a fare to a route and to journeys between, or through,
+// the fare zones of Stop.ZoneID. Empty fields match anything.
+type FareRule struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	FareID        uint   `gorm:"index;not null" json:"fare_id"`
+	RouteID       *uint  `gorm:"index" json:"route_id,omitempty"`
+	OriginID      string `json:"origin_id,omitempty"`
+	DestinationID string `json:"destination_id,omitempty"`
+	ContainsID    string `json:"contains_id,omitempty"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// Area is a Fares v2 area (areas.txt). Its stops are those in the fare zone
+// ZoneID, written out as stop_areas.txt.
+type Area struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	Name   string `json:"area_name"`
+	ZoneID string `gorm:"index;not null" json:"zone_id"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// Fare media types (fare_media.txt)
+const (
+	FareMediaNone        = 0 // paid in cash, no ticket
+	FareMediaPaper       = 1
+	FareMediaTransitCard = 2
+	FareMediaContactless = 3 // bank card or phone
+	FareMediaApp         = 4
+	MaxFareMediaType     = FareMediaApp
+)
+
+// FareMedia is what a rider pays or holds their fare with (fare_media.txt)
+type FareMedia struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Name string `json:"fare_media_name"`
+	Type int    `gorm:"not null;default:0" json:"fare_media_type"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// FareProduct is something a rider can buy, such as a single ride or a day
+// pass, at one price on one medium (fare_products.txt)
+type FareProduct struct {
+	ID          uint    `gorm:"primaryKey" json:"id"`
+	Name        string  `json:"fare_product_name"`
+	FareMediaID *uint   `gorm:"index" json:"fare_media_id,omitempty"`
+	Amount      float64 `json:"amount"`
+	Currency    string  `json:"currency"` // ISO 4217, such as EUR
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// FareLegRule prices a leg on the routes of a network between two areas
+// with a fare product (fare_leg_rules.txt). Empty fields match anything.
+type FareLegRule struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	LegGroupID    string `json:"leg_group_id,omitempty"`
+	NetworkID     string `gorm:"index" json:"network_id,omitempty"` // See Route.NetworkID
+	FromAreaID    *uint  `gorm:"index" json:"from_area_id,omitempty"`
+	ToAreaID      *uint  `gorm:"index" json:"to_area_id,omitempty"`
+	FareProductID uint   `gorm:"index;not null" json:"fare_product_id"`
+	RulePriority  *int   `json:"rule_priority,omitempty"` // higher wins where rules overlap
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
 }
 
 type Route struct {
@@ -27,16 +215,59 @@ type Route struct {
 	RouteDesc *string `json:"route_desc,omitempty"`
 	RouteUrl  *string `json:"route_url,omitempty"`
 	AgencyID  uint    `json:"agency_id"`
+	NetworkID string  `gorm:"index" json:"network_id,omitempty"` // Fare network, see FareLegRule
+	Version   uint    `gorm:"not null;default:1" json:"version"` // Bumped by every update, sent as the ETag
+
+	DraftID  uint  `gorm:"index;not null;default:0;<-:create" json:"draft_id,omitempty"` // 0 = published, see Draft
+	OriginID *uint `gorm:"<-:create" json:"origin_id,omitempty"`                         // Published route a draft copy was made from
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// DefaultServiceID is the service assigned to trips created without one
+const DefaultServiceID = "DAILY"
+
+// Calendar is a GTFS service (calendar.txt) that trips run on
+type Calendar struct {
+	WorkspaceID uint `gorm:"primaryKey;default:1;<-:create" json:"-"` // See Workspace
+
+	ServiceID string         `gorm:"primaryKey" json:"service_id"`
+	Monday    bool           `json:"monday"`
+	Tuesday   bool           `json:"tuesday"`
+	Wednesday bool           `json:"wednesday"`
+	Thursday  bool           `json:"thursday"`
+	Friday    bool           `json:"friday"`
+	Saturday  bool           `json:"saturday"`
+	Sunday    bool           `json:"sunday"`
+	StartDate string         `json:"start_date"` // YYYYMMDD
+	EndDate   string         `json:"end_date"`   // YYYYMMDD
+	Dates     []CalendarDate `gorm:"foreignKey:WorkspaceID,ServiceID;references:WorkspaceID,ServiceID" json:"dates,omitempty"`
+}
+
+// CalendarDate is a service exception (calendar_dates.txt)
+type CalendarDate struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	ServiceID     string `gorm:"uniqueIndex:idx_workspace_calendar_date" json:"service_id"`
+	Date          string `gorm:"uniqueIndex:idx_workspace_calendar_date" json:"date"` // YYYYMMDD
+	ExceptionType int    `json:"exception_type"`                                      // 1 = added, 2 = removed
+
+	WorkspaceID uint `gorm:"uniqueIndex:idx_workspace_calendar_date;not null;default:1;<-:create" json:"-"` // See Workspace
 }
 
 type Trip struct {
 	ID          uint   `gorm:"primaryKey" json:"id"`
 	RouteID     uint   `json:"route_id"`
 	Route       Route  `gorm:"foreignKey:RouteID" json:"route,omitempty"`
-	ServiceID   string `json:"service_id"`
+	ServiceID   string `gorm:"index" json:"service_id"` // References Calendar.ServiceID
 	DirectionID *int   `json:"direction_id,omitempty"`
 	Headsign    string `json:"headsign"`
-	ShapeID     string `json:"shape_id"` // Grouping ID for shapes
+	ShapeID     string `json:"shape_id"`                          // Grouping ID for shapes
+	Version     uint   `gorm:"not null;default:1" json:"version"` // Bumped by every update, sent as the ETag
+
+	DraftID  uint  `gorm:"index;not null;default:0;<-:create" json:"draft_id,omitempty"` // 0 = published, see Draft
+	OriginID *uint `gorm:"<-:create" json:"origin_id,omitempty"`                         // Published trip a draft copy was made from
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
 }
 
 // TripStop represents a stop assigned to a specific trip in a specific order
@@ -49,6 +280,23 @@ type TripStop struct {
 	Sequence      int    `json:"sequence"`
 	ArrivalTime   string `json:"arrival_time"`
 	DepartureTime string `json:"departure_time"`
+	DraftID       uint   `gorm:"index;not null;default:0;<-:create" json:"draft_id,omitempty"` // 0 = published, see Draft
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+
+	ShapeDistTraveled *float64 `gorm:"-" json:"shape_dist_traveled,omitempty"` // Hydrated field, metres along the trip's shape
+}
+
+// Frequency runs a template trip repeatedly at a fixed headway (frequencies.txt)
+type Frequency struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	TripID      uint   `gorm:"index" json:"trip_id"`
+	StartTime   string `json:"start_time"`
+	EndTime     string `json:"end_time"`
+	HeadwaySecs int    `json:"headway_secs"`
+	ExactTimes  bool   `json:"exact_times"` // false = frequency-based, true = schedule-based
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
 }
 
 // ShapePoint represents a single point in a polyline
@@ -58,16 +306,240 @@ type ShapePoint struct {
 	Lat      float64 `json:"lat"`
 	Lon      float64 `json:"lon"`
 	Sequence int     `json:"sequence"`
+	DraftID  uint    `gorm:"index;not null;default:0;<-:create" json:"draft_id,omitempty"` // 0 = published, see Draft
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+
+	ShapeDistTraveled float64 `gorm:"-" json:"shape_dist_traveled"` // Hydrated field, metres from the first point
+}
+
+// Draft statuses, in workflow order
+const (
+	DraftOpen     = "open"      // being edited
+	DraftInReview = "in_review" // submitted for review, edits are locked
+	DraftApproved = "approved"  // a publisher signed it off, ready to merge
+	DraftMerged   = "merged"    // applied to the published data; its rows are gone
+)
+
+// Draft is a staged copy of the routes, trips, stop times and shapes. Its
+// rows live in the same tables as the published ones, tagged with its ID in
+// DraftID, until it is merged or discarded.
+type Draft struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Name        string     `gorm:"uniqueIndex:idx_workspace_draft_name;not null" json:"name"`
+	Description string     `json:"description,omitempty"`
+	Status      string     `gorm:"index;not null;default:open" json:"status"`
+	Base        DraftBase  `gorm:"serializer:json" json:"-"`
+	CreatedByID *uint      `json:"created_by_id,omitempty"`
+	CreatedBy   string     `json:"created_by,omitempty"`
+	ReviewedBy  string     `json:"reviewed_by,omitempty"`
+	ReviewNote  string     `json:"review_note,omitempty"`
+	ApprovedAt  *time.Time `json:"approved_at,omitempty"`
+	MergedAt    *time.Time `json:"merged_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	WorkspaceID uint `gorm:"uniqueIndex:idx_workspace_draft_name;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// DraftBase lists the published rows a draft was copied from, so a merge can
+// tell rows deleted in the draft from rows published after it was created
+type DraftBase struct {
+	RouteIDs []uint   `json:"route_ids"`
+	TripIDs  []uint   `json:"trip_ids"`
+	ShapeIDs []string `json:"shape_ids"`
+}
+
+// Alert is a rider-facing service alert. Texts are keyed by language code.
+type Alert struct {
+	ID               uint                  `gorm:"primaryKey" json:"id"`
+	Cause            string                `json:"cause"`  // GTFS-Realtime cause, e.g. CONSTRUCTION
+	Effect           string                `json:"effect"` // GTFS-Realtime effect, e.g. DETOUR
+	HeaderText       map[string]string     `gorm:"serializer:json" json:"header_text"`
+	DescriptionText  map[string]string     `gorm:"serializer:json" json:"description_text"`
+	URL              map[string]string     `gorm:"serializer:json" json:"url"`
+	ActivePeriods    []AlertActivePeriod   `gorm:"foreignKey:AlertID" json:"active_periods"`
+	InformedEntities []AlertInformedEntity `gorm:"foreignKey:AlertID" json:"informed_entities"`
+	CreatedAt        time.Time             `json:"created_at"`
+	UpdatedAt        time.Time             `json:"updated_at"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// AlertActivePeriod is a time window in which an alert applies; nil ends are open
+type AlertActivePeriod struct {
+	ID      uint       `gorm:"primaryKey" json:"id"`
+	AlertID uint       `gorm:"index" json:"alert_id"`
+	Start   *time.Time `json:"start,omitempty"`
+	End     *time.Time `json:"end,omitempty"`
+}
+
+// AlertInformedEntity points an alert at an agency, route, trip or stop
+type AlertInformedEntity struct {
+	ID       uint  `gorm:"primaryKey" json:"id"`
+	AlertID  uint  `gorm:"index" json:"alert_id"`
+	AgencyID *uint `json:"agency_id,omitempty"`
+	RouteID  *uint `gorm:"index" json:"route_id,omitempty"`
+	TripID   *uint `gorm:"index" json:"trip_id,omitempty"`
+	StopID   *uint `gorm:"index" json:"stop_id,omitempty"`
 }
 
 type ActivityLog struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
-	Timestamp time.Time `json:"timestamp"`
-	Action    string    `json:"action"`
+	Timestamp time.Time `gorm:"index" json:"timestamp"`
+	Action    string    `gorm:"index" json:"action"`
 	Details   string    `json:"details"`
+
+	// Actor, copied so the entry survives the user being deleted
+	UserID    *uint  `gorm:"index" json:"user_id,omitempty"`
+	UserEmail string `json:"user_email,omitempty"`
+
+	// The entity a create, update or delete touched, with its JSON before and
+	// after the change and the top-level fields that differ
+	EntityType string        `gorm:"index:idx_activity_entity" json:"entity_type,omitempty"`
+	EntityID   string        `gorm:"index:idx_activity_entity" json:"entity_id,omitempty"`
+	Before     interface{}   `gorm:"serializer:json" json:"before,omitempty"`
+	After      interface{}   `gorm:"serializer:json" json:"after,omitempty"`
+	Changes    []FieldChange `gorm:"serializer:json" json:"changes,omitempty"`
+
+	// Set when the change was made inside a draft rather than to published data
+	DraftID *uint `gorm:"index" json:"draft_id,omitempty"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// FieldChange is one top-level field that differs between Before and After
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Snapshot sources
+const (
+	SnapshotManual  = "manual"
+	SnapshotExport  = "export"
+	SnapshotRestore = "restore" // taken automatically right before a restore
+	SnapshotMerge   = "merge"   // taken automatically right before a draft is merged
+)
+
+// Snapshot is a named copy of the whole feed that can be diffed and restored
+type Snapshot struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	Name      string         `json:"name"`
+	Note      string         `json:"note,omitempty"`
+	Source    string         `gorm:"index" json:"source"`
+	Checksum  string         `gorm:"index" json:"checksum"` // SHA-256 of the uncompressed data
+	Counts    map[string]int `gorm:"serializer:json" json:"counts"`
+	Data      []byte         `json:"-"` // gzip-compressed JSON of snapshot.Dataset
+	UserID    *uint          `json:"user_id,omitempty"`
+	UserEmail string         `json:"user_email,omitempty"`
+	CreatedAt time.Time      `gorm:"index" json:"created_at"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
 }
 
 type Setting struct {
+	WorkspaceID uint `gorm:"primaryKey;default:1;<-:create" json:"-"` // See Workspace
+
 	Key   string `gorm:"primaryKey" json:"key"`
 	Value string `json:"value"`
 }
+
+// Roles, from least to most privileged. Each role may do everything the
+// roles before it can.
+const (
+	RoleViewer    = "viewer"
+	RoleEditor    = "editor"
+	RolePublisher = "publisher"
+	RoleAdmin     = "admin"
+)
+
+type User struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Email        string    `gorm:"uniqueIndex;not null" json:"email"`
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"-"`
+	Role         string    `gorm:"not null;default:viewer" json:"role"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Session is a bearer token issued at login. Only the SHA-256 of the token is
+// stored, so a database dump cannot be replayed against the API.
+type Session struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TokenHash string    `gorm:"uniqueIndex;not null" json:"-"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	User      User      `json:"user"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Webhook is a downstream system notified of changes to the published data.
+// Events are names such as route.updated or export.published; route.* and *
+// match several.
+type Webhook struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `json:"name"`
+	URL         string    `gorm:"not null" json:"url"`
+	Secret      string    `gorm:"not null" json:"-"` // HMAC key for the signature header, shown once on create
+	Events      []string  `gorm:"serializer:json" json:"events"`
+	Active      bool      `gorm:"not null" json:"active"`
+	CreatedByID *uint     `json:"created_by_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1;<-:create" json:"-"` // See Workspace
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"   // waiting for its first attempt or a retry
+	DeliverySucceeded = "succeeded" // the receiver answered 2xx
+	DeliveryFailed    = "failed"    // out of attempts, or the webhook was disabled
+)
+
+// WebhookDelivery is one event sent, or to be sent, to one webhook
+type WebhookDelivery struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	WebhookID      uint            `gorm:"index" json:"webhook_id"`
+	Event          string          `gorm:"index" json:"event"`
+	Payload        json.RawMessage `gorm:"type:jsonb" json:"payload"`
+	Status         string          `gorm:"index;not null" json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `gorm:"index" json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	ResponseBody   string          `json:"response_body,omitempty"` // truncated
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      time.Time       `gorm:"index" json:"created_at"`
+}
+
+// DefaultWorkspaceID is the workspace data from before workspaces existed
+// was moved into, and the one statements without a workspace run against
+const DefaultWorkspaceID = 1
+
+// Workspace is one operator's feed. Agencies, stops, routes, trips, shapes,
+// calendars, alerts, settings, drafts, snapshots, webhooks and the activity
+// log all carry the WorkspaceID of the workspace they belong to, and every
+// request works inside exactly one workspace.
+type Workspace struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WorkspaceMember gives a user a role in one workspace. Accounts with the
+// admin role reach every workspace as admins without a membership.
+type WorkspaceMember struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	WorkspaceID uint      `gorm:"uniqueIndex:idx_workspace_member;not null" json:"workspace_id"`
+	UserID      uint      `gorm:"uniqueIndex:idx_workspace_member;index;not null" json:"user_id"`
+	User        User      `json:"user,omitempty"`
+	Role        string    `gorm:"not null;default:viewer" json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+}
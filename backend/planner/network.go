@@ -0,0 +1,274 @@
+// Package planner answers "how do I get from A to B" over the scheduled
+// timetable with a RAPTOR search (Delling, Pajor, Werneck 2012).
+package planner
+
+import (
+	"fmt"
+	"gtfs-cms/geometry"
+	"gtfs-cms/gtfs"
+	"gtfs-cms/models"
+	"gtfs-cms/schedule"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Options tune walking and the size of the search
+type Options struct {
+	WalkSpeed      float64 // metres per second
+	MaxWalkMeters  float64 // access and egress radius around origin and destination
+	TransferMeters float64 // radius for walking transfers between stops
+	MaxTransfers   int
+}
+
+// DefaultOptions are sensible for an urban bus network
+var DefaultOptions = Options{
+	WalkSpeed:      1.25,
+	MaxWalkMeters:  800,
+	TransferMeters: 300,
+	MaxTransfers:   4,
+}
+
+func (o Options) walkSecs(meters float64) int {
+	return int(math.Ceil(meters / o.WalkSpeed))
+}
+
+// TripSchedule is one trip with its stop times, in seconds of the search day
+type TripSchedule struct {
+	Trip  models.Trip
+	Stops []models.TripStop
+	// Offset is added to every time, e.g. -86400 for trips of the previous
+	// service day that run past midnight
+	Offset int
+}
+
+type stopTime struct {
+	arr, dep int
+}
+
+type patternTrip struct {
+	trip  models.Trip
+	times []stopTime
+}
+
+// pattern is a group of trips that serve exactly the same stop sequence
+type pattern struct {
+	stops []int
+	trips []patternTrip // ordered by departure, assumed not to overtake
+}
+
+type patternRef struct {
+	pattern  int
+	position int
+}
+
+type footpath struct {
+	to     int
+	meters float64
+	secs   int
+}
+
+// Network is the timetable of one service day prepared for RAPTOR
+type Network struct {
+	opts      Options
+	stops     []models.Stop
+	stopIndex map[uint]int
+	routes    map[uint]models.Route
+	patterns  []pattern
+	atStop    [][]patternRef
+	transfers [][]footpath
+}
+
+// Build prepares a network from plain timetable data. Trips with stops that
+// have no parseable time are left out.
+func Build(stops []models.Stop, routes []models.Route, trips []TripSchedule, opts Options) *Network {
+	n := &Network{
+		opts:      opts,
+		stops:     stops,
+		stopIndex: make(map[uint]int, len(stops)),
+		routes:    make(map[uint]models.Route, len(routes)),
+		atStop:    make([][]patternRef, len(stops)),
+		transfers: make([][]footpath, len(stops)),
+	}
+	for i, s := range stops {
+		n.stopIndex[s.ID] = i
+	}
+	for _, r := range routes {
+		n.routes[r.ID] = r
+	}
+
+	byKey := make(map[string]int)
+	for _, ts := range trips {
+		if len(ts.Stops) < 2 {
+			continue
+		}
+		idx := make([]int, 0, len(ts.Stops))
+		times := make([]stopTime, 0, len(ts.Stops))
+		keyParts := make([]string, 0, len(ts.Stops))
+		ok := true
+		for _, st := range ts.Stops {
+			si, known := n.stopIndex[st.StopID]
+			arr, errA := gtfs.ParseTime(st.ArrivalTime)
+			dep, errD := gtfs.ParseTime(st.DepartureTime)
+			if !known || errA != nil || errD != nil {
+				ok = false
+				break
+			}
+			idx = append(idx, si)
+			times = append(times, stopTime{arr: arr + ts.Offset, dep: dep + ts.Offset})
+			keyParts = append(keyParts, fmt.Sprint(st.StopID))
+		}
+		if !ok {
+			continue
+		}
+
+		key := strings.Join(keyParts, ",")
+		p, exists := byKey[key]
+		if !exists {
+			p = len(n.patterns)
+			byKey[key] = p
+			n.patterns = append(n.patterns, pattern{stops: idx})
+		}
+		n.patterns[p].trips = append(n.patterns[p].trips, patternTrip{trip: ts.Trip, times: times})
+	}
+
+	for p := range n.patterns {
+		trips := n.patterns[p].trips
+		sort.SliceStable(trips, func(i, j int) bool { return trips[i].times[0].dep < trips[j].times[0].dep })
+		for pos, s := range n.patterns[p].stops {
+			n.atStop[s] = append(n.atStop[s], patternRef{pattern: p, position: pos})
+		}
+	}
+
+	n.buildTransfers()
+	return n
+}
+
+// buildTransfers links every pair of stops within TransferMeters, using a
+// grid of cells about one radius wide so only neighbouring cells are compared.
+func (n *Network) buildTransfers() {
+	if n.opts.TransferMeters <= 0 {
+		return
+	}
+	cellDeg := n.opts.TransferMeters / 111000
+	type cell struct{ x, y int }
+	cellOf := func(s models.Stop) cell {
+		return cell{int(math.Floor(s.Lon / cellDeg)), int(math.Floor(s.Lat / cellDeg))}
+	}
+	grid := make(map[cell][]int)
+	for i, s := range n.stops {
+		grid[cellOf(s)] = append(grid[cellOf(s)], i)
+	}
+
+	for i, s := range n.stops {
+		c := cellOf(s)
+		// Longitude cells shrink with latitude, so widen the search east-west
+		span := int(math.Ceil(1/math.Max(math.Cos(s.Lat*math.Pi/180), 0.1))) + 1
+		for dx := -span; dx <= span; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for _, j := range grid[cell{c.x + dx, c.y + dy}] {
+					if i == j {
+						continue
+					}
+					d := geometry.Haversine(geometry.Point{Lat: s.Lat, Lon: s.Lon}, geometry.Point{Lat: n.stops[j].Lat, Lon: n.stops[j].Lon})
+					if d <= n.opts.TransferMeters {
+						n.transfers[i] = append(n.transfers[i], footpath{to: j, meters: d, secs: n.opts.walkSecs(d)})
+					}
+				}
+			}
+		}
+	}
+}
+
+// Load builds the network for the service day of day. Trips of the previous
+// service day that are still running after midnight are included with their
+// times shifted back by 24 hours, and frequency-based trips are expanded into
+// one run per headway.
+func Load(db *gorm.DB, day time.Time, opts Options) (*Network, error) {
+	var stops []models.Stop
+	if err := db.Find(&stops).Error; err != nil {
+		return nil, fmt.Errorf("stops: %w", err)
+	}
+	var routes []models.Route
+	if err := db.Find(&routes).Error; err != nil {
+		return nil, fmt.Errorf("routes: %w", err)
+	}
+
+	var schedules []TripSchedule
+	for _, d := range []struct {
+		day    time.Time
+		offset int
+	}{{day.AddDate(0, 0, -1), -86400}, {day, 0}} {
+		services, err := schedule.ActiveServices(db, d.day)
+		if err != nil {
+			return nil, fmt.Errorf("services: %w", err)
+		}
+		if len(services) == 0 {
+			continue
+		}
+		ids := make([]string, 0, len(services))
+		for id := range services {
+			ids = append(ids, id)
+		}
+
+		var trips []models.Trip
+		if err := db.Where("service_id IN ?", ids).Find(&trips).Error; err != nil {
+			return nil, fmt.Errorf("trips: %w", err)
+		}
+		if len(trips) == 0 {
+			continue
+		}
+		tripIDs := make([]uint, len(trips))
+		for i, t := range trips {
+			tripIDs[i] = t.ID
+		}
+		var tripStops []models.TripStop
+		if err := db.Where("trip_id IN ?", tripIDs).Order("trip_id, sequence asc").Find(&tripStops).Error; err != nil {
+			return nil, fmt.Errorf("trip stops: %w", err)
+		}
+		byTrip := make(map[uint][]models.TripStop)
+		for _, ts := range tripStops {
+			byTrip[ts.TripID] = append(byTrip[ts.TripID], ts)
+		}
+		var frequencies []models.Frequency
+		if err := db.Where("trip_id IN ?", tripIDs).Find(&frequencies).Error; err != nil {
+			return nil, fmt.Errorf("frequencies: %w", err)
+		}
+		freqByTrip := make(map[uint][]models.Frequency)
+		for _, f := range frequencies {
+			freqByTrip[f.TripID] = append(freqByTrip[f.TripID], f)
+		}
+
+		for _, t := range trips {
+			stops := byTrip[t.ID]
+			if len(freqByTrip[t.ID]) == 0 {
+				schedules = append(schedules, TripSchedule{Trip: t, Stops: stops, Offset: d.offset})
+				continue
+			}
+			// The stop times of a frequency-based trip only give the travel
+			// times between stops, starting from the first departure
+			if len(stops) == 0 {
+				continue
+			}
+			first, err := gtfs.ParseTime(stops[0].DepartureTime)
+			if err != nil {
+				continue
+			}
+			for _, f := range freqByTrip[t.ID] {
+				start, errS := gtfs.ParseTime(f.StartTime)
+				end, errE := gtfs.ParseTime(f.EndTime)
+				if errS != nil || errE != nil || f.HeadwaySecs <= 0 {
+					continue
+				}
+				for dep := start; dep < end; dep += f.HeadwaySecs {
+					schedules = append(schedules, TripSchedule{Trip: t, Stops: stops, Offset: d.offset + dep - first})
+				}
+			}
+		}
+	}
+
+	return Build(stops, routes, schedules, opts), nil
+}
@@ -0,0 +1,329 @@
+package planner
+
+import (
+	"gtfs-cms/geometry"
+	"gtfs-cms/gtfs"
+	"math"
+	"sort"
+)
+
+// Place is the start or end of a leg
+type Place struct {
+	Name   string  `json:"name"`
+	StopID uint    `json:"stop_id,omitempty"`
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+}
+
+// Leg is one walking or transit part of an itinerary
+type Leg struct {
+	Mode           string  `json:"mode"` // WALK or TRANSIT
+	From           Place   `json:"from"`
+	To             Place   `json:"to"`
+	Departure      string  `json:"departure"`
+	Arrival        string  `json:"arrival"`
+	DurationSecs   int     `json:"duration_secs"`
+	DistanceMeters float64 `json:"distance_meters,omitempty"`
+	RouteID        uint    `json:"route_id,omitempty"`
+	RouteShortName string  `json:"route_short_name,omitempty"`
+	RouteColor     string  `json:"route_color,omitempty"`
+	TripID         uint    `json:"trip_id,omitempty"`
+	Headsign       string  `json:"headsign,omitempty"`
+	Stops          int     `json:"stops,omitempty"` // Number of stops ridden
+}
+
+// Itinerary is one complete journey
+type Itinerary struct {
+	Departure    string `json:"departure"`
+	Arrival      string `json:"arrival"`
+	DurationSecs int    `json:"duration_secs"`
+	Transfers    int    `json:"transfers"`
+	WalkSecs     int    `json:"walk_secs"`
+	Legs         []Leg  `json:"legs"`
+}
+
+const unreached = math.MaxInt32
+
+type labelKind int
+
+const (
+	labelNone labelKind = iota
+	labelAccess
+	labelTransit
+	labelWalk
+)
+
+// label records how a stop was reached in a round, for reconstruction
+type label struct {
+	kind      labelKind
+	pattern   int
+	trip      int
+	boardPos  int
+	alightPos int
+	from      int // Stop walked from
+	walk      footpath
+}
+
+type endpoint struct {
+	stop   int
+	meters float64
+	secs   int
+}
+
+func (n *Network) nearbyStops(p geometry.Point) []endpoint {
+	var out []endpoint
+	for i, s := range n.stops {
+		d := geometry.Haversine(p, geometry.Point{Lat: s.Lat, Lon: s.Lon})
+		if d <= n.opts.MaxWalkMeters {
+			out = append(out, endpoint{stop: i, meters: d, secs: n.opts.walkSecs(d)})
+		}
+	}
+	return out
+}
+
+// Plan returns the Pareto-optimal itineraries (arrival time versus number of
+// transfers) for leaving from at depart seconds into the service day.
+func (n *Network) Plan(from, to geometry.Point, depart int) []Itinerary {
+	var itineraries []Itinerary
+
+	// Walking all the way is always an answer when it is short enough
+	direct := geometry.Haversine(from, to)
+	if direct <= n.opts.MaxWalkMeters {
+		secs := n.opts.walkSecs(direct)
+		itineraries = append(itineraries, Itinerary{
+			Departure: gtfs.FormatTime(depart), Arrival: gtfs.FormatTime(depart + secs),
+			DurationSecs: secs, WalkSecs: secs,
+			Legs: []Leg{n.walkLeg(Place{Name: "Origin", Lat: from.Lat, Lon: from.Lon}, Place{Name: "Destination", Lat: to.Lat, Lon: to.Lon}, depart, secs, direct)},
+		})
+	}
+
+	access := n.nearbyStops(from)
+	egress := n.nearbyStops(to)
+	if len(access) == 0 || len(egress) == 0 {
+		return itineraries
+	}
+
+	rounds := n.opts.MaxTransfers + 2 // round 0 is access, round k uses k vehicles
+	stopCount := len(n.stops)
+	arr := make([][]int, rounds)
+	labels := make([][]label, rounds)
+	best := make([]int, stopCount)
+	for i := range best {
+		best[i] = unreached
+	}
+	for k := range arr {
+		arr[k] = make([]int, stopCount)
+		labels[k] = make([]label, stopCount)
+		for i := range arr[k] {
+			arr[k][i] = unreached
+		}
+	}
+
+	marked := make([]bool, stopCount)
+	for _, a := range access {
+		t := depart + a.secs
+		if t < arr[0][a.stop] {
+			arr[0][a.stop] = t
+			best[a.stop] = t
+			labels[0][a.stop] = label{kind: labelAccess, walk: footpath{to: a.stop, meters: a.meters, secs: a.secs}}
+			marked[a.stop] = true
+		}
+	}
+
+	egressSecs := make(map[int]endpoint, len(egress))
+	for _, e := range egress {
+		egressSecs[e.stop] = e
+	}
+	targetBound := func() int {
+		b := unreached
+		for s, e := range egressSecs {
+			if best[s] != unreached && best[s]+e.secs < b {
+				b = best[s] + e.secs
+			}
+		}
+		return b
+	}
+
+	for k := 1; k < rounds; k++ {
+		// Collect every pattern serving a stop improved in the last round,
+		// starting from its earliest such stop
+		queue := make(map[int]int)
+		for s, m := range marked {
+			if !m {
+				continue
+			}
+			for _, ref := range n.atStop[s] {
+				if pos, ok := queue[ref.pattern]; !ok || ref.position < pos {
+					queue[ref.pattern] = ref.position
+				}
+			}
+			marked[s] = false
+		}
+		if len(queue) == 0 {
+			break
+		}
+		copy(arr[k], arr[k-1])
+		bound := targetBound()
+
+		for p, startPos := range queue {
+			pat := n.patterns[p]
+			trip, boardPos := -1, 0
+			for pos := startPos; pos < len(pat.stops); pos++ {
+				s := pat.stops[pos]
+				if trip >= 0 {
+					a := pat.trips[trip].times[pos].arr
+					if a < best[s] && a < bound {
+						arr[k][s] = a
+						best[s] = a
+						labels[k][s] = label{kind: labelTransit, pattern: p, trip: trip, boardPos: boardPos, alightPos: pos}
+						marked[s] = true
+					}
+				}
+				// Hop on an earlier trip if the stop was reached in time for it
+				prev := arr[k-1][s]
+				if prev == unreached || (trip >= 0 && prev > pat.trips[trip].times[pos].dep) {
+					continue
+				}
+				i := sort.Search(len(pat.trips), func(i int) bool { return pat.trips[i].times[pos].dep >= prev })
+				if i < len(pat.trips) && (trip < 0 || i < trip) {
+					trip, boardPos = i, pos
+				}
+			}
+		}
+
+		// Walking transfers from stops reached by vehicle in this round
+		var reached []int
+		for s, m := range marked {
+			if m {
+				reached = append(reached, s)
+			}
+		}
+		for _, s := range reached {
+			for _, fp := range n.transfers[s] {
+				t := arr[k][s] + fp.secs
+				if t < best[fp.to] {
+					arr[k][fp.to] = t
+					best[fp.to] = t
+					labels[k][fp.to] = label{kind: labelWalk, from: s, walk: fp}
+					marked[fp.to] = true
+				}
+			}
+		}
+	}
+
+	// One itinerary per round that beats every itinerary with fewer vehicles
+	bestArrival := unreached
+	for _, it := range itineraries {
+		bestArrival = depart + it.DurationSecs
+	}
+	for k := 1; k < rounds; k++ {
+		end, endArr := -1, unreached
+		for s, e := range egressSecs {
+			if labels[k][s].kind == labelNone || arr[k][s] == unreached {
+				continue
+			}
+			if t := arr[k][s] + e.secs; t < endArr {
+				end, endArr = s, t
+			}
+		}
+		if end < 0 || endArr >= bestArrival {
+			continue
+		}
+		bestArrival = endArr
+		itineraries = append(itineraries, n.reconstruct(labels, arr, k, end, egressSecs[end], from, to, depart))
+	}
+
+	sort.SliceStable(itineraries, func(i, j int) bool { return itineraries[i].DurationSecs < itineraries[j].DurationSecs })
+	return itineraries
+}
+
+func (n *Network) place(stop int) Place {
+	s := n.stops[stop]
+	return Place{Name: s.Name, StopID: s.ID, Lat: s.Lat, Lon: s.Lon}
+}
+
+func (n *Network) walkLeg(from, to Place, depart, secs int, meters float64) Leg {
+	return Leg{
+		Mode: "WALK", From: from, To: to,
+		Departure: gtfs.FormatTime(depart), Arrival: gtfs.FormatTime(depart + secs),
+		DurationSecs: secs, DistanceMeters: math.Round(meters),
+	}
+}
+
+// reconstruct walks the labels back from the egress stop reached in round k
+func (n *Network) reconstruct(labels [][]label, arr [][]int, k, end int, egress endpoint, from, to geometry.Point, depart int) Itinerary {
+	origin := Place{Name: "Origin", Lat: from.Lat, Lon: from.Lon}
+	destination := Place{Name: "Destination", Lat: to.Lat, Lon: to.Lon}
+
+	legs := []Leg{n.walkLeg(n.place(end), destination, arr[k][end], egress.secs, egress.meters)}
+	cur, round := end, k
+	for {
+		lbl := labels[round][cur]
+		for lbl.kind == labelNone && round > 0 {
+			round--
+			lbl = labels[round][cur]
+		}
+
+		switch lbl.kind {
+		case labelAccess:
+			legs = append(legs, n.walkLeg(origin, n.place(cur), depart, lbl.walk.secs, lbl.walk.meters))
+		case labelWalk:
+			legs = append(legs, n.walkLeg(n.place(lbl.from), n.place(cur), arr[round][lbl.from], lbl.walk.secs, lbl.walk.meters))
+			cur = lbl.from
+			continue
+		case labelTransit:
+			pat := n.patterns[lbl.pattern]
+			trip := pat.trips[lbl.trip]
+			board, alight := trip.times[lbl.boardPos].dep, trip.times[lbl.alightPos].arr
+			route := n.routes[trip.trip.RouteID]
+			legs = append(legs, Leg{
+				Mode:           "TRANSIT",
+				From:           n.place(pat.stops[lbl.boardPos]),
+				To:             n.place(cur),
+				Departure:      gtfs.FormatTime(board),
+				Arrival:        gtfs.FormatTime(alight),
+				DurationSecs:   alight - board,
+				RouteID:        route.ID,
+				RouteShortName: route.ShortName,
+				RouteColor:     route.Color,
+				TripID:         trip.trip.ID,
+				Headsign:       trip.trip.Headsign,
+				Stops:          lbl.alightPos - lbl.boardPos,
+			})
+			cur = pat.stops[lbl.boardPos]
+			round--
+			continue
+		}
+		break
+	}
+
+	// Legs were collected back to front
+	for i, j := 0, len(legs)-1; i < j; i, j = i+1, j-1 {
+		legs[i], legs[j] = legs[j], legs[i]
+	}
+	// Drop zero-length walks, e.g. when the origin is right at a stop
+	filtered := legs[:0]
+	for _, l := range legs {
+		if l.Mode == "WALK" && l.DurationSecs == 0 {
+			continue
+		}
+		filtered = append(filtered, l)
+	}
+	legs = filtered
+
+	it := Itinerary{Legs: legs, Departure: gtfs.FormatTime(depart)}
+	arrival := arr[k][end] + egress.secs
+	it.Arrival = gtfs.FormatTime(arrival)
+	it.DurationSecs = arrival - depart
+	for _, l := range legs {
+		if l.Mode == "TRANSIT" {
+			it.Transfers++
+		} else {
+			it.WalkSecs += l.DurationSecs
+		}
+	}
+	if it.Transfers > 0 {
+		it.Transfers--
+	}
+	return it
+}
@@ -0,0 +1,97 @@
+package planner
+
+import (
+	"gtfs-cms/geometry"
+	"gtfs-cms/models"
+	"testing"
+)
+
+func testTrip(id, routeID uint, stops []uint, times []string) TripSchedule {
+	ts := TripSchedule{Trip: models.Trip{ID: id, RouteID: routeID}}
+	for i, s := range stops {
+		ts.Stops = append(ts.Stops, models.TripStop{TripID: id, StopID: s, Sequence: i + 1, ArrivalTime: times[i], DepartureTime: times[i]})
+	}
+	return ts
+}
+
+// Route 1 runs S1-S2-S3, route 2 runs S4-S5 with S4 a short walk from S3,
+// and route 3 is a slower direct S1-S5 service.
+func testNetwork() *Network {
+	stops := []models.Stop{
+		{ID: 1, Name: "S1", Lat: 52.000, Lon: 4.000},
+		{ID: 2, Name: "S2", Lat: 52.000, Lon: 4.020},
+		{ID: 3, Name: "S3", Lat: 52.000, Lon: 4.040},
+		{ID: 4, Name: "S4", Lat: 52.001, Lon: 4.041},
+		{ID: 5, Name: "S5", Lat: 52.000, Lon: 4.080},
+	}
+	routes := []models.Route{{ID: 1, ShortName: "1"}, {ID: 2, ShortName: "2"}, {ID: 3, ShortName: "3"}}
+	trips := []TripSchedule{
+		testTrip(11, 1, []uint{1, 2, 3}, []string{"08:30:00", "08:35:00", "08:40:00"}),
+		testTrip(10, 1, []uint{1, 2, 3}, []string{"08:00:00", "08:05:00", "08:10:00"}),
+		testTrip(20, 2, []uint{4, 5}, []string{"08:15:00", "08:25:00"}),
+		testTrip(30, 3, []uint{1, 5}, []string{"08:00:00", "08:40:00"}),
+	}
+	return Build(stops, routes, trips, DefaultOptions)
+}
+
+func TestPlanWithTransfer(t *testing.T) {
+	n := testNetwork()
+	from := geometry.Point{Lat: 52.000, Lon: 4.000}
+	to := geometry.Point{Lat: 52.000, Lon: 4.080}
+
+	its := n.Plan(from, to, 7*3600+55*60)
+	if len(its) != 2 {
+		t.Fatalf("expected 2 itineraries, got %d: %+v", len(its), its)
+	}
+
+	fast := its[0]
+	if fast.Arrival != "08:25:00" || fast.Transfers != 1 {
+		t.Errorf("fastest itinerary arrives %s with %d transfers, want 08:25:00 with 1", fast.Arrival, fast.Transfers)
+	}
+	var modes []string
+	for _, l := range fast.Legs {
+		modes = append(modes, l.Mode+":"+l.RouteShortName)
+	}
+	want := []string{"TRANSIT:1", "WALK:", "TRANSIT:2"}
+	if len(modes) != len(want) {
+		t.Fatalf("legs = %v, want %v", modes, want)
+	}
+	for i := range want {
+		if modes[i] != want[i] {
+			t.Fatalf("legs = %v, want %v", modes, want)
+		}
+	}
+	if fast.Legs[0].TripID != 10 || fast.Legs[0].Departure != "08:00:00" {
+		t.Errorf("first leg = trip %d at %s, want trip 10 at 08:00:00", fast.Legs[0].TripID, fast.Legs[0].Departure)
+	}
+
+	direct := its[1]
+	if direct.Arrival != "08:40:00" || direct.Transfers != 0 {
+		t.Errorf("direct itinerary arrives %s with %d transfers, want 08:40:00 with 0", direct.Arrival, direct.Transfers)
+	}
+}
+
+func TestPlanBoardsLaterTrip(t *testing.T) {
+	n := testNetwork()
+	from := geometry.Point{Lat: 52.000, Lon: 4.000}
+	to := geometry.Point{Lat: 52.000, Lon: 4.040}
+
+	its := n.Plan(from, to, 8*3600+1)
+	if len(its) != 1 {
+		t.Fatalf("expected 1 itinerary, got %d: %+v", len(its), its)
+	}
+	if its[0].Legs[0].TripID != 11 || its[0].Arrival != "08:40:00" {
+		t.Errorf("got trip %d arriving %s, want trip 11 arriving 08:40:00", its[0].Legs[0].TripID, its[0].Arrival)
+	}
+}
+
+func TestPlanWalkOnly(t *testing.T) {
+	n := testNetwork()
+	from := geometry.Point{Lat: 52.000, Lon: 4.040}
+	to := geometry.Point{Lat: 52.001, Lon: 4.041}
+
+	its := n.Plan(from, to, 8*3600)
+	if len(its) != 1 || len(its[0].Legs) != 1 || its[0].Legs[0].Mode != "WALK" {
+		t.Fatalf("expected a single walking itinerary, got %+v", its)
+	}
+}
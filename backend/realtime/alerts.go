@@ -0,0 +1,85 @@
+package realtime
+
+import (
+	"gtfs-cms/models"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FromModel converts a stored alert into its feed form
+func FromModel(m models.Alert) Alert {
+	a := Alert{
+		ID:               strconv.FormatUint(uint64(m.ID), 10),
+		Cause:            m.Cause,
+		Effect:           m.Effect,
+		HeaderText:       m.HeaderText,
+		DescriptionText:  m.DescriptionText,
+		URL:              m.URL,
+		ActivePeriods:    []TimeRange{},
+		InformedEntities: []EntitySelector{},
+	}
+	for _, p := range m.ActivePeriods {
+		var r TimeRange
+		if p.Start != nil {
+			r.Start = p.Start.Unix()
+		}
+		if p.End != nil {
+			r.End = p.End.Unix()
+		}
+		a.ActivePeriods = append(a.ActivePeriods, r)
+	}
+	deref := func(id *uint) uint {
+		if id == nil {
+			return 0
+		}
+		return *id
+	}
+	for _, e := range m.InformedEntities {
+		a.InformedEntities = append(a.InformedEntities, EntitySelector{
+			AgencyID: deref(e.AgencyID),
+			RouteID:  deref(e.RouteID),
+			TripID:   deref(e.TripID),
+			StopID:   deref(e.StopID),
+		})
+	}
+	return a
+}
+
+// LoadActiveAlerts returns the stored alerts that apply at now. Alerts whose
+// informed entities were all deleted along with their records inform nobody
+// and are left out.
+func LoadActiveAlerts(db *gorm.DB, now time.Time) ([]Alert, error) {
+	var stored []models.Alert
+	if err := db.Preload("ActivePeriods").Preload("InformedEntities").Order("id asc").Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	out := []Alert{}
+	for _, m := range stored {
+		if len(m.InformedEntities) == 0 {
+			continue
+		}
+		if a := FromModel(m); a.ActiveAt(now) {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+// Affects reports whether any informed entity of the alert matches the
+// context of a departure or vehicle. As in GTFS-Realtime, every field set on a
+// selector has to match; zero fields are wildcards.
+func (a Alert) Affects(ctx EntitySelector) bool {
+	match := func(want, got uint) bool { return want == 0 || want == got }
+	for _, e := range a.InformedEntities {
+		if e == (EntitySelector{}) {
+			continue
+		}
+		if match(e.AgencyID, ctx.AgencyID) && match(e.RouteID, ctx.RouteID) &&
+			match(e.TripID, ctx.TripID) && match(e.StopID, ctx.StopID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package realtime
+
+import (
+	"gtfs-cms/models"
+	"testing"
+	"time"
+)
+
+func TestFromModelAndAffects(t *testing.T) {
+	route, stop := uint(3), uint(40)
+	end := time.Unix(2000, 0)
+	a := FromModel(models.Alert{
+		ID:            7,
+		Cause:         "CONSTRUCTION",
+		Effect:        "STOP_MOVED",
+		ActivePeriods: []models.AlertActivePeriod{{End: &end}},
+		InformedEntities: []models.AlertInformedEntity{
+			{RouteID: &route, StopID: &stop},
+		},
+	})
+
+	if a.ID != "7" || a.ActivePeriods[0].End != 2000 || a.ActivePeriods[0].Start != 0 {
+		t.Errorf("unexpected conversion %+v", a)
+	}
+	if !a.ActiveAt(time.Unix(1999, 0)) || a.ActiveAt(time.Unix(2000, 0)) {
+		t.Errorf("active period end should be exclusive")
+	}
+	if !a.Affects(EntitySelector{AgencyID: 1, RouteID: 3, TripID: 9, StopID: 40}) {
+		t.Errorf("alert should affect route 3 at stop 40")
+	}
+	if a.Affects(EntitySelector{RouteID: 3, StopID: 41}) {
+		t.Errorf("alert should not affect route 3 at another stop")
+	}
+}
@@ -0,0 +1,254 @@
+package realtime
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers and enum values follow gtfs-realtime.proto. The messages are
+// encoded by hand with protowire so no generated bindings are needed.
+
+// Causes maps alert cause names to their GTFS-Realtime enum values
+var Causes = map[string]uint64{
+	"UNKNOWN_CAUSE":     1,
+	"OTHER_CAUSE":       2,
+	"TECHNICAL_PROBLEM": 3,
+	"STRIKE":            4,
+	"DEMONSTRATION":     5,
+	"ACCIDENT":          6,
+	"HOLIDAY":           7,
+	"WEATHER":           8,
+	"MAINTENANCE":       9,
+	"CONSTRUCTION":      10,
+	"POLICE_ACTIVITY":   11,
+	"MEDICAL_EMERGENCY": 12,
+}
+
+// Effects maps alert effect names to their GTFS-Realtime enum values
+var Effects = map[string]uint64{
+	"NO_SERVICE":          1,
+	"REDUCED_SERVICE":     2,
+	"SIGNIFICANT_DELAYS":  3,
+	"DETOUR":              4,
+	"ADDITIONAL_SERVICE":  5,
+	"MODIFIED_SERVICE":    6,
+	"OTHER_EFFECT":        7,
+	"UNKNOWN_EFFECT":      8,
+	"STOP_MOVED":          9,
+	"NO_EFFECT":           10,
+	"ACCESSIBILITY_ISSUE": 11,
+}
+
+// VehicleStatuses maps vehicle stop status names to their enum values
+var VehicleStatuses = map[string]uint64{
+	"INCOMING_AT":   0,
+	"STOPPED_AT":    1,
+	"IN_TRANSIT_TO": 2,
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendInt32 encodes a non-zigzag int32, which protobuf sign-extends to 64 bits
+func appendInt32(b []byte, num protowire.Number, v int32) []byte {
+	return appendVarint(b, num, uint64(int64(v)))
+}
+
+func appendFloat(b []byte, num protowire.Number, v float32) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, math.Float32bits(v))
+}
+
+func idString(id uint) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// Marshal encodes the feed as a GTFS-Realtime FeedMessage
+func (f *FeedMessage) Marshal() []byte {
+	var header []byte
+	header = appendString(header, 1, f.Header.Version)
+	header = appendVarint(header, 2, 0) // FULL_DATASET
+	header = appendVarint(header, 3, uint64(f.Header.Timestamp))
+
+	b := appendMessage(nil, 1, header)
+	for _, e := range f.Entities {
+		b = appendMessage(b, 2, encodeEntity(e))
+	}
+	return b
+}
+
+func encodeEntity(e FeedEntity) []byte {
+	b := appendString(nil, 1, e.ID)
+	switch {
+	case e.TripUpdate != nil:
+		b = appendMessage(b, 3, encodeTripUpdate(e.TripUpdate))
+	case e.Vehicle != nil:
+		b = appendMessage(b, 4, encodeVehiclePosition(e.Vehicle))
+	case e.Alert != nil:
+		b = appendMessage(b, 5, encodeAlert(e.Alert))
+	}
+	return b
+}
+
+func encodeTripDescriptor(tripID, routeID uint, directionID *int, startDate string) []byte {
+	b := appendString(nil, 1, idString(tripID))
+	b = appendString(b, 3, startDate)
+	b = appendString(b, 5, idString(routeID))
+	if directionID != nil {
+		b = appendVarint(b, 6, uint64(*directionID))
+	}
+	return b
+}
+
+func encodeVehicleDescriptor(id, label string) []byte {
+	b := appendString(nil, 1, id)
+	return appendString(b, 2, label)
+}
+
+func encodeStopTimeEvent(ev *StopTimeEvent) []byte {
+	var b []byte
+	if ev.Delay != nil {
+		b = appendInt32(b, 1, *ev.Delay)
+	}
+	if ev.Time != 0 {
+		b = appendVarint(b, 2, uint64(ev.Time))
+	}
+	return b
+}
+
+func encodeTripUpdate(tu *TripUpdate) []byte {
+	b := appendMessage(nil, 1, encodeTripDescriptor(tu.TripID, tu.RouteID, tu.DirectionID, tu.StartDate))
+	for _, stu := range tu.StopTimeUpdates {
+		var s []byte
+		if stu.StopSequence > 0 {
+			s = appendVarint(s, 1, uint64(stu.StopSequence))
+		}
+		if stu.Arrival != nil {
+			s = appendMessage(s, 2, encodeStopTimeEvent(stu.Arrival))
+		}
+		if stu.Departure != nil {
+			s = appendMessage(s, 3, encodeStopTimeEvent(stu.Departure))
+		}
+		s = appendString(s, 4, idString(stu.StopID))
+		if stu.Skipped {
+			s = appendVarint(s, 5, 1) // SKIPPED
+		}
+		b = appendMessage(b, 2, s)
+	}
+	if tu.VehicleID != "" {
+		b = appendMessage(b, 3, encodeVehicleDescriptor(tu.VehicleID, ""))
+	}
+	if tu.Timestamp != 0 {
+		b = appendVarint(b, 4, uint64(tu.Timestamp))
+	}
+	if tu.Delay != nil {
+		b = appendInt32(b, 5, *tu.Delay)
+	}
+	return b
+}
+
+func encodeVehiclePosition(vp *VehiclePosition) []byte {
+	var b []byte
+	if vp.TripID != 0 {
+		b = appendMessage(b, 1, encodeTripDescriptor(vp.TripID, vp.RouteID, nil, vp.StartDate))
+	}
+
+	pos := appendFloat(nil, 1, float32(vp.Lat))
+	pos = appendFloat(pos, 2, float32(vp.Lon))
+	if vp.Bearing != nil {
+		pos = appendFloat(pos, 3, *vp.Bearing)
+	}
+	if vp.Speed != nil {
+		pos = appendFloat(pos, 5, *vp.Speed)
+	}
+	b = appendMessage(b, 2, pos)
+
+	if vp.CurrentStopSequence > 0 {
+		b = appendVarint(b, 3, uint64(vp.CurrentStopSequence))
+	}
+	if status, ok := VehicleStatuses[vp.CurrentStatus]; ok {
+		b = appendVarint(b, 4, status)
+	}
+	if vp.Timestamp != 0 {
+		b = appendVarint(b, 5, uint64(vp.Timestamp))
+	}
+	b = appendString(b, 7, idString(vp.StopID))
+	return appendMessage(b, 8, encodeVehicleDescriptor(vp.VehicleID, vp.Label))
+}
+
+// encodeTranslatedString writes translations in a stable language order
+func encodeTranslatedString(texts map[string]string) []byte {
+	langs := make([]string, 0, len(texts))
+	for lang := range texts {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	var b []byte
+	for _, lang := range langs {
+		t := appendString(nil, 1, texts[lang])
+		t = appendString(t, 2, lang)
+		b = appendMessage(b, 1, t)
+	}
+	return b
+}
+
+func encodeAlert(a *Alert) []byte {
+	var b []byte
+	for _, p := range a.ActivePeriods {
+		var r []byte
+		if p.Start != 0 {
+			r = appendVarint(r, 1, uint64(p.Start))
+		}
+		if p.End != 0 {
+			r = appendVarint(r, 2, uint64(p.End))
+		}
+		b = appendMessage(b, 1, r)
+	}
+	for _, e := range a.InformedEntities {
+		s := appendString(nil, 1, idString(e.AgencyID))
+		s = appendString(s, 2, idString(e.RouteID))
+		if e.TripID != 0 {
+			s = appendMessage(s, 4, encodeTripDescriptor(e.TripID, 0, nil, ""))
+		}
+		s = appendString(s, 5, idString(e.StopID))
+		b = appendMessage(b, 5, s)
+	}
+	if v, ok := Causes[a.Cause]; ok {
+		b = appendVarint(b, 6, v)
+	}
+	if v, ok := Effects[a.Effect]; ok {
+		b = appendVarint(b, 7, v)
+	}
+	if len(a.URL) > 0 {
+		b = appendMessage(b, 8, encodeTranslatedString(a.URL))
+	}
+	if len(a.HeaderText) > 0 {
+		b = appendMessage(b, 10, encodeTranslatedString(a.HeaderText))
+	}
+	if len(a.DescriptionText) > 0 {
+		b = appendMessage(b, 11, encodeTranslatedString(a.DescriptionText))
+	}
+	return b
+}
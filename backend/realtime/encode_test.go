@@ -0,0 +1,100 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fields decodes one level of a protobuf message into raw values per field number
+func fields(t *testing.T, b []byte) map[protowire.Number][]interface{} {
+	t.Helper()
+	out := make(map[protowire.Number][]interface{})
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("bad tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			out[num] = append(out[num], v)
+			b = b[n:]
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			out[num] = append(out[num], v)
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			out[num] = append(out[num], v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+	}
+	return out
+}
+
+func TestTripUpdatesFeedMarshal(t *testing.T) {
+	now := time.Unix(1767225600, 0)
+	store := NewStore(DefaultMaxAge)
+	delay := int32(-30)
+	store.PutTripUpdate(TripUpdate{
+		TripID:    12,
+		RouteID:   3,
+		Timestamp: now.Unix(),
+		Delay:     &delay,
+		StopTimeUpdates: []StopTimeUpdate{
+			{StopSequence: 2, StopID: 40, Arrival: &StopTimeEvent{Delay: &delay}},
+		},
+	})
+	store.PutTripUpdate(TripUpdate{TripID: 13, Timestamp: now.Add(-time.Hour).Unix()})
+
+	msg := fields(t, TripUpdatesFeed(store, now).Marshal())
+	header := fields(t, msg[1][0].([]byte))
+	if string(header[1][0].([]byte)) != Version || header[3][0].(uint64) != uint64(now.Unix()) {
+		t.Errorf("unexpected header %v", header)
+	}
+	if len(msg[2]) != 1 {
+		t.Fatalf("got %d entities, want 1 (stale update must be dropped)", len(msg[2]))
+	}
+
+	entity := fields(t, msg[2][0].([]byte))
+	if string(entity[1][0].([]byte)) != "trip-12" {
+		t.Errorf("entity id = %s", entity[1][0])
+	}
+	update := fields(t, entity[3][0].([]byte))
+	trip := fields(t, update[1][0].([]byte))
+	if string(trip[1][0].([]byte)) != "12" || string(trip[5][0].([]byte)) != "3" {
+		t.Errorf("unexpected trip descriptor %v", trip)
+	}
+	if int32(update[5][0].(uint64)) != delay {
+		t.Errorf("delay = %d, want %d", int32(update[5][0].(uint64)), delay)
+	}
+	stu := fields(t, update[2][0].([]byte))
+	if stu[1][0].(uint64) != 2 || string(stu[4][0].([]byte)) != "40" {
+		t.Errorf("unexpected stop time update %v", stu)
+	}
+}
+
+func TestAlertsFeedSkipsInactive(t *testing.T) {
+	now := time.Unix(1767225600, 0)
+	alerts := []Alert{
+		{ID: "a", Cause: "CONSTRUCTION", Effect: "DETOUR", HeaderText: map[string]string{"en": "Detour", "id": "Pengalihan"}},
+		{ID: "b", Cause: "STRIKE", Effect: "NO_SERVICE", ActivePeriods: []TimeRange{{End: now.Unix() - 1}}},
+	}
+
+	msg := fields(t, AlertsFeed(alerts, now).Marshal())
+	if len(msg[2]) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(msg[2]))
+	}
+	alert := fields(t, fields(t, msg[2][0].([]byte))[5][0].([]byte))
+	if alert[6][0].(uint64) != Causes["CONSTRUCTION"] || alert[7][0].(uint64) != Effects["DETOUR"] {
+		t.Errorf("unexpected cause/effect %v", alert)
+	}
+	if translations := fields(t, alert[10][0].([]byte)); len(translations[1]) != 2 {
+		t.Errorf("want 2 header translations, got %d", len(translations[1]))
+	}
+}
@@ -0,0 +1,66 @@
+package realtime
+
+import "time"
+
+// Estimate is the predicted departure of a trip from one of its stops
+type Estimate struct {
+	Time     time.Time
+	Delay    int32
+	Canceled bool // The stop is skipped
+}
+
+// EstimateDeparture predicts when the trip leaves the stop at sequence seq,
+// which is scheduled for scheduled. A prediction for the stop itself wins;
+// otherwise the delay of the closest earlier stop carries forward, as the
+// GTFS-Realtime spec prescribes, and the trip-level delay is the last resort.
+func (tu TripUpdate) EstimateDeparture(seq int, stopID uint, scheduled time.Time) (Estimate, bool) {
+	var upstream *StopTimeUpdate
+	for i := range tu.StopTimeUpdates {
+		stu := &tu.StopTimeUpdates[i]
+		if stu.StopSequence == seq || (stu.StopSequence == 0 && stu.StopID == stopID) {
+			if stu.Skipped {
+				return Estimate{Time: scheduled, Canceled: true}, true
+			}
+			if e, ok := eventEstimate(stu.Departure, scheduled); ok {
+				return e, true
+			}
+			if e, ok := eventEstimate(stu.Arrival, scheduled); ok {
+				// Nobody leaves before the scheduled departure
+				if e.Time.Before(scheduled) {
+					e = Estimate{Time: scheduled}
+				}
+				return e, true
+			}
+			continue
+		}
+		if stu.StopSequence > 0 && stu.StopSequence < seq && !stu.Skipped &&
+			(upstream == nil || stu.StopSequence > upstream.StopSequence) {
+			upstream = stu
+		}
+	}
+
+	if upstream != nil {
+		for _, ev := range []*StopTimeEvent{upstream.Departure, upstream.Arrival} {
+			if ev != nil && ev.Delay != nil {
+				return Estimate{Time: scheduled.Add(time.Duration(*ev.Delay) * time.Second), Delay: *ev.Delay}, true
+			}
+		}
+	}
+	if tu.Delay != nil {
+		return Estimate{Time: scheduled.Add(time.Duration(*tu.Delay) * time.Second), Delay: *tu.Delay}, true
+	}
+	return Estimate{}, false
+}
+
+func eventEstimate(ev *StopTimeEvent, scheduled time.Time) (Estimate, bool) {
+	switch {
+	case ev == nil:
+		return Estimate{}, false
+	case ev.Time != 0:
+		t := time.Unix(ev.Time, 0)
+		return Estimate{Time: t, Delay: int32(t.Sub(scheduled) / time.Second)}, true
+	case ev.Delay != nil:
+		return Estimate{Time: scheduled.Add(time.Duration(*ev.Delay) * time.Second), Delay: *ev.Delay}, true
+	}
+	return Estimate{}, false
+}
@@ -0,0 +1,49 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimateDeparture(t *testing.T) {
+	scheduled := time.Unix(10000, 0)
+	late, early := int32(120), int32(-30)
+	tu := TripUpdate{
+		TripID: 1,
+		Delay:  &early,
+		StopTimeUpdates: []StopTimeUpdate{
+			{StopSequence: 2, StopID: 20, Departure: &StopTimeEvent{Delay: &late}},
+			{StopSequence: 4, StopID: 40, Departure: &StopTimeEvent{Time: 10300}},
+			{StopSequence: 5, StopID: 50, Skipped: true},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		seq      int
+		stop     uint
+		want     int64
+		delay    int32
+		canceled bool
+	}{
+		{"trip delay before any update", 1, 10, 9970, -30, false},
+		{"own delay", 2, 20, 10120, 120, false},
+		{"propagated from upstream", 3, 30, 10120, 120, false},
+		{"absolute time", 4, 40, 10300, 300, false},
+		{"skipped stop", 5, 50, 10000, 0, true},
+	}
+	for _, c := range cases {
+		e, ok := tu.EstimateDeparture(c.seq, c.stop, scheduled)
+		if !ok {
+			t.Errorf("%s: no estimate", c.name)
+			continue
+		}
+		if e.Time.Unix() != c.want || e.Delay != c.delay || e.Canceled != c.canceled {
+			t.Errorf("%s: got %+v, want time %d delay %d canceled %v", c.name, e, c.want, c.delay, c.canceled)
+		}
+	}
+
+	if _, ok := (TripUpdate{}).EstimateDeparture(1, 10, scheduled); ok {
+		t.Errorf("an empty trip update should not produce an estimate")
+	}
+}
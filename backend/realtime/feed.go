@@ -0,0 +1,163 @@
+// Package realtime holds live trip updates and vehicle positions and serves
+// them, together with the stored service alerts, as GTFS-Realtime feeds keyed
+// by the static Trip, Route and Stop IDs.
+package realtime
+
+import (
+	"fmt"
+	"time"
+)
+
+// Version is the GTFS-Realtime specification version we produce
+const Version = "2.0"
+
+// StopTimeEvent is a predicted arrival or departure. Time is a Unix timestamp.
+type StopTimeEvent struct {
+	Delay *int32 `json:"delay,omitempty"`
+	Time  int64  `json:"time,omitempty"`
+}
+
+// StopTimeUpdate is the prediction for one stop of a trip
+type StopTimeUpdate struct {
+	StopSequence int            `json:"stop_sequence"`
+	StopID       uint           `json:"stop_id"`
+	Arrival      *StopTimeEvent `json:"arrival,omitempty"`
+	Departure    *StopTimeEvent `json:"departure,omitempty"`
+	Skipped      bool           `json:"skipped,omitempty"`
+}
+
+// TripUpdate carries the realtime progress of one scheduled trip
+type TripUpdate struct {
+	TripID          uint             `json:"trip_id"`
+	RouteID         uint             `json:"route_id"`
+	DirectionID     *int             `json:"direction_id,omitempty"`
+	StartDate       string           `json:"start_date,omitempty"` // YYYYMMDD
+	VehicleID       string           `json:"vehicle_id,omitempty"`
+	Delay           *int32           `json:"delay,omitempty"`
+	Timestamp       int64            `json:"timestamp"`
+	StopTimeUpdates []StopTimeUpdate `json:"stop_time_updates"`
+}
+
+// VehiclePosition is the last known location of a vehicle
+type VehiclePosition struct {
+	VehicleID           string   `json:"vehicle_id"`
+	Label               string   `json:"label,omitempty"`
+	TripID              uint     `json:"trip_id,omitempty"`
+	RouteID             uint     `json:"route_id,omitempty"`
+	StartDate           string   `json:"start_date,omitempty"`
+	Lat                 float64  `json:"lat"`
+	Lon                 float64  `json:"lon"`
+	Bearing             *float32 `json:"bearing,omitempty"`
+	Speed               *float32 `json:"speed,omitempty"` // metres per second
+	StopID              uint     `json:"stop_id,omitempty"`
+	CurrentStopSequence int      `json:"current_stop_sequence,omitempty"`
+	CurrentStatus       string   `json:"current_status,omitempty"` // INCOMING_AT, STOPPED_AT or IN_TRANSIT_TO
+	Timestamp           int64    `json:"timestamp"`
+}
+
+// TimeRange is an alert active period; zero means open-ended
+type TimeRange struct {
+	Start int64 `json:"start,omitempty"`
+	End   int64 `json:"end,omitempty"`
+}
+
+// Contains reports whether t falls inside the range
+func (r TimeRange) Contains(t time.Time) bool {
+	unix := t.Unix()
+	return (r.Start == 0 || unix >= r.Start) && (r.End == 0 || unix < r.End)
+}
+
+// EntitySelector points an alert at part of the static network
+type EntitySelector struct {
+	AgencyID uint `json:"agency_id,omitempty"`
+	RouteID  uint `json:"route_id,omitempty"`
+	TripID   uint `json:"trip_id,omitempty"`
+	StopID   uint `json:"stop_id,omitempty"`
+}
+
+// Alert is a service alert. Texts are keyed by BCP-47 language code.
+type Alert struct {
+	ID               string            `json:"id"`
+	Cause            string            `json:"cause"`
+	Effect           string            `json:"effect"`
+	ActivePeriods    []TimeRange       `json:"active_periods"`
+	InformedEntities []EntitySelector  `json:"informed_entities"`
+	HeaderText       map[string]string `json:"header_text"`
+	DescriptionText  map[string]string `json:"description_text,omitempty"`
+	URL              map[string]string `json:"url,omitempty"`
+}
+
+// ActiveAt reports whether the alert applies at t. Alerts without periods are always active.
+func (a Alert) ActiveAt(t time.Time) bool {
+	if len(a.ActivePeriods) == 0 {
+		return true
+	}
+	for _, p := range a.ActivePeriods {
+		if p.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// FeedHeader mirrors the GTFS-Realtime FeedHeader message
+type FeedHeader struct {
+	Version        string `json:"gtfs_realtime_version"`
+	Incrementality string `json:"incrementality"`
+	Timestamp      int64  `json:"timestamp"`
+}
+
+// FeedEntity holds exactly one of TripUpdate, Vehicle or Alert
+type FeedEntity struct {
+	ID         string           `json:"id"`
+	TripUpdate *TripUpdate      `json:"trip_update,omitempty"`
+	Vehicle    *VehiclePosition `json:"vehicle,omitempty"`
+	Alert      *Alert           `json:"alert,omitempty"`
+}
+
+// FeedMessage is a full-dataset GTFS-Realtime feed. Its JSON form is the
+// debug variant of the protobuf served by Marshal.
+type FeedMessage struct {
+	Header   FeedHeader   `json:"header"`
+	Entities []FeedEntity `json:"entity"`
+}
+
+func newFeed(now time.Time) *FeedMessage {
+	return &FeedMessage{
+		Header:   FeedHeader{Version: Version, Incrementality: "FULL_DATASET", Timestamp: now.Unix()},
+		Entities: []FeedEntity{},
+	}
+}
+
+// TripUpdatesFeed builds the trip-updates feed from the store
+func TripUpdatesFeed(s *Store, now time.Time) *FeedMessage {
+	feed := newFeed(now)
+	for _, tu := range s.TripUpdates(now) {
+		tu := tu
+		feed.Entities = append(feed.Entities, FeedEntity{ID: fmt.Sprintf("trip-%d", tu.TripID), TripUpdate: &tu})
+	}
+	return feed
+}
+
+// VehiclePositionsFeed builds the vehicle-positions feed from the store
+func VehiclePositionsFeed(s *Store, now time.Time) *FeedMessage {
+	feed := newFeed(now)
+	for _, vp := range s.VehiclePositions(now) {
+		vp := vp
+		feed.Entities = append(feed.Entities, FeedEntity{ID: "vehicle-" + vp.VehicleID, Vehicle: &vp})
+	}
+	return feed
+}
+
+// AlertsFeed builds the alerts feed from alerts that are active at now
+func AlertsFeed(alerts []Alert, now time.Time) *FeedMessage {
+	feed := newFeed(now)
+	for _, a := range alerts {
+		if !a.ActiveAt(now) {
+			continue
+		}
+		a := a
+		feed.Entities = append(feed.Entities, FeedEntity{ID: "alert-" + a.ID, Alert: &a})
+	}
+	return feed
+}
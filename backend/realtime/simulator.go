@@ -0,0 +1,196 @@
+package realtime
+
+import (
+	"context"
+	"fmt"
+	"gtfs-cms/geometry"
+	"gtfs-cms/gtfs"
+	"gtfs-cms/models"
+	"gtfs-cms/schedule"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Simulator publishes synthetic vehicle positions and trip updates for every
+// trip that is in service according to the timetable, so realtime consumers
+// can be developed locally without a vehicle feed.
+type Simulator struct {
+	DB       *gorm.DB
+	Store    *Store
+	Interval time.Duration
+}
+
+func NewSimulator(db *gorm.DB, store *Store, interval time.Duration) *Simulator {
+	return &Simulator{DB: db, Store: store, Interval: interval}
+}
+
+// Run ticks until ctx is cancelled
+func (s *Simulator) Run(ctx context.Context) {
+	log.Printf("Realtime simulator started (every %s).", s.Interval)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Tick(time.Now()); err != nil {
+			log.Printf("Realtime simulator: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// simulatedDelay gives every trip a stable delay between -1 and +4 minutes
+func simulatedDelay(tripID uint) int32 {
+	return int32(tripID*97%300) - 60
+}
+
+// Tick publishes the state of all running trips at now
+func (s *Simulator) Tick(now time.Time) error {
+	local := now.In(schedule.Location(s.DB))
+	today := schedule.ServiceDay(local, local.Location())
+
+	// Trips after midnight still belong to yesterday's service day
+	for _, day := range []time.Time{today.AddDate(0, 0, -1), today} {
+		if err := s.simulateServiceDay(day, int(local.Sub(day).Seconds()), now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Simulator) simulateServiceDay(day time.Time, secs int, now time.Time) error {
+	services, err := schedule.ActiveServices(s.DB, day)
+	if err != nil {
+		return fmt.Errorf("active services: %w", err)
+	}
+	if len(services) == 0 {
+		return nil
+	}
+	serviceIDs := make([]string, 0, len(services))
+	for id := range services {
+		serviceIDs = append(serviceIDs, id)
+	}
+
+	var trips []models.Trip
+	if err := s.DB.Where("service_id IN ?", serviceIDs).Find(&trips).Error; err != nil {
+		return fmt.Errorf("trips: %w", err)
+	}
+	for _, trip := range trips {
+		var stops []models.TripStop
+		if err := s.DB.Preload("Stop").Where("trip_id = ?", trip.ID).Order("sequence asc").Find(&stops).Error; err != nil {
+			return fmt.Errorf("trip %d stops: %w", trip.ID, err)
+		}
+		if err := s.simulateTrip(trip, stops, day, secs, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Simulator) simulateTrip(trip models.Trip, stops []models.TripStop, day time.Time, secs int, now time.Time) error {
+	if len(stops) < 2 {
+		return nil
+	}
+	arr := make([]int, len(stops))
+	dep := make([]int, len(stops))
+	for i, ts := range stops {
+		a, errA := gtfs.ParseTime(ts.ArrivalTime)
+		d, errD := gtfs.ParseTime(ts.DepartureTime)
+		if errA != nil || errD != nil {
+			return nil // Untimed trips cannot be placed
+		}
+		arr[i], dep[i] = a, d
+	}
+
+	delay := simulatedDelay(trip.ID)
+	t := secs - int(delay)
+	if t < dep[0] || t > arr[len(arr)-1] {
+		return nil
+	}
+
+	var shape []models.ShapePoint
+	if trip.ShapeID != "" {
+		if err := s.DB.Where("shape_id = ?", trip.ShapeID).Order("sequence asc").Find(&shape).Error; err != nil {
+			return fmt.Errorf("trip %d shape: %w", trip.ID, err)
+		}
+	}
+	coords := make([]models.Stop, len(stops))
+	line := make([]geometry.Point, 0, len(shape))
+	for i, ts := range stops {
+		coords[i] = ts.Stop
+	}
+	for _, p := range shape {
+		line = append(line, geometry.Point{Lat: p.Lat, Lon: p.Lon})
+	}
+	if len(line) < 2 {
+		line = line[:0]
+		for _, st := range coords {
+			line = append(line, geometry.Point{Lat: st.Lat, Lon: st.Lon})
+		}
+	}
+	cum := geometry.CumulativeDistances(line)
+	dists := schedule.StopDistances(shape, coords)
+
+	// Find where the vehicle is: dwelling at stop i, or between i and i+1
+	status, next, dist := "IN_TRANSIT_TO", 0, 0.0
+	for i := range stops {
+		if t >= arr[i] && t <= dep[i] {
+			status, next, dist = "STOPPED_AT", i, dists[i]
+			break
+		}
+		if i+1 < len(stops) && t > dep[i] && t < arr[i+1] {
+			frac := float64(t-dep[i]) / float64(arr[i+1]-dep[i])
+			next, dist = i+1, dists[i]+frac*(dists[i+1]-dists[i])
+			break
+		}
+	}
+
+	vehicleID := fmt.Sprintf("sim-%d", trip.ID)
+	startDate := day.Format(gtfs.DateLayout)
+	pos := geometry.PointAt(line, cum, dist)
+	bearing := float32(geometry.Bearing(pos, geometry.PointAt(line, cum, dist+25)))
+	var speed float32
+	if status == "IN_TRANSIT_TO" && next > 0 && arr[next] > dep[next-1] {
+		speed = float32((dists[next] - dists[next-1]) / float64(arr[next]-dep[next-1]))
+	}
+
+	s.Store.PutVehiclePosition(VehiclePosition{
+		VehicleID:           vehicleID,
+		Label:               trip.Headsign,
+		TripID:              trip.ID,
+		RouteID:             trip.RouteID,
+		StartDate:           startDate,
+		Lat:                 pos.Lat,
+		Lon:                 pos.Lon,
+		Bearing:             &bearing,
+		Speed:               &speed,
+		StopID:              stops[next].StopID,
+		CurrentStopSequence: stops[next].Sequence,
+		CurrentStatus:       status,
+		Timestamp:           now.Unix(),
+	})
+
+	update := TripUpdate{
+		TripID:      trip.ID,
+		RouteID:     trip.RouteID,
+		DirectionID: trip.DirectionID,
+		StartDate:   startDate,
+		VehicleID:   vehicleID,
+		Delay:       &delay,
+		Timestamp:   now.Unix(),
+	}
+	for i := next; i < len(stops); i++ {
+		update.StopTimeUpdates = append(update.StopTimeUpdates, StopTimeUpdate{
+			StopSequence: stops[i].Sequence,
+			StopID:       stops[i].StopID,
+			Arrival:      &StopTimeEvent{Delay: &delay, Time: day.Add(time.Duration(arr[i]+int(delay)) * time.Second).Unix()},
+			Departure:    &StopTimeEvent{Delay: &delay, Time: day.Add(time.Duration(dep[i]+int(delay)) * time.Second).Unix()},
+		})
+	}
+	s.Store.PutTripUpdate(update)
+	return nil
+}
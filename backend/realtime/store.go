@@ -0,0 +1,117 @@
+package realtime
+
+import (
+	"gtfs-cms/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxAge is how long a trip update or vehicle position is served
+// after it was last reported
+const DefaultMaxAge = 10 * time.Minute
+
+// Store keeps the latest realtime state in memory
+type Store struct {
+	MaxAge time.Duration
+
+	mu          sync.RWMutex
+	tripUpdates map[uint]TripUpdate
+	vehicles    map[string]VehiclePosition
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = make(map[uint]*Store)
+)
+
+// StoreFor returns the store of a workspace, shared by its ingest APIs and
+// feeds
+func StoreFor(workspace uint) *Store {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	s, ok := stores[workspace]
+	if !ok {
+		s = NewStore(DefaultMaxAge)
+		stores[workspace] = s
+	}
+	return s
+}
+
+// Default is the store of the default workspace, which the simulator feeds
+var Default = StoreFor(models.DefaultWorkspaceID)
+
+func NewStore(maxAge time.Duration) *Store {
+	return &Store{
+		MaxAge:      maxAge,
+		tripUpdates: make(map[uint]TripUpdate),
+		vehicles:    make(map[string]VehiclePosition),
+	}
+}
+
+func (s *Store) fresh(ts int64, now time.Time) bool {
+	return s.MaxAge <= 0 || now.Sub(time.Unix(ts, 0)) <= s.MaxAge
+}
+
+// PutTripUpdate replaces the update of a trip. A zero timestamp means now.
+func (s *Store) PutTripUpdate(tu TripUpdate) {
+	if tu.Timestamp == 0 {
+		tu.Timestamp = time.Now().Unix()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tripUpdates[tu.TripID] = tu
+}
+
+// PutVehiclePosition replaces the position of a vehicle. A zero timestamp means now.
+func (s *Store) PutVehiclePosition(vp VehiclePosition) {
+	if vp.Timestamp == 0 {
+		vp.Timestamp = time.Now().Unix()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vehicles[vp.VehicleID] = vp
+}
+
+// TripUpdate returns the current update of a trip, if any
+func (s *Store) TripUpdate(tripID uint, now time.Time) (TripUpdate, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tu, ok := s.tripUpdates[tripID]
+	if !ok || !s.fresh(tu.Timestamp, now) {
+		return TripUpdate{}, false
+	}
+	return tu, true
+}
+
+// TripUpdates returns all fresh trip updates ordered by trip ID, dropping stale ones
+func (s *Store) TripUpdates(now time.Time) []TripUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]TripUpdate, 0, len(s.tripUpdates))
+	for id, tu := range s.tripUpdates {
+		if !s.fresh(tu.Timestamp, now) {
+			delete(s.tripUpdates, id)
+			continue
+		}
+		out = append(out, tu)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TripID < out[j].TripID })
+	return out
+}
+
+// VehiclePositions returns all fresh positions ordered by vehicle ID, dropping stale ones
+func (s *Store) VehiclePositions(now time.Time) []VehiclePosition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]VehiclePosition, 0, len(s.vehicles))
+	for id, vp := range s.vehicles {
+		if !s.fresh(vp.Timestamp, now) {
+			delete(s.vehicles, id)
+			continue
+		}
+		out = append(out, vp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].VehicleID < out[j].VehicleID })
+	return out
+}
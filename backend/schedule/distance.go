@@ -0,0 +1,74 @@
+package schedule
+
+import (
+	"gtfs-cms/geometry"
+	"gtfs-cms/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HydrateShapeDistances sets ShapeDistTraveled on the points of one shape.
+// Points are sorted by sequence in place.
+func HydrateShapeDistances(points []models.ShapePoint) {
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Sequence < points[j].Sequence })
+	line := make([]geometry.Point, len(points))
+	for i, p := range points {
+		line[i] = geometry.Point{Lat: p.Lat, Lon: p.Lon}
+	}
+	for i, d := range geometry.CumulativeDistances(line) {
+		points[i].ShapeDistTraveled = d
+	}
+}
+
+// HydrateStopDistances sets ShapeDistTraveled on the stops of one trip,
+// ordered by sequence, by projecting stops onto the trip's shape. coords
+// holds the location of every stop ID used by the trip. Without a shape the
+// stops are left without a distance.
+func HydrateStopDistances(shape []models.ShapePoint, stops []models.TripStop, coords map[uint]models.Stop) {
+	if len(shape) < 2 {
+		return
+	}
+	ordered := make([]models.Stop, len(stops))
+	for i, ts := range stops {
+		ordered[i] = coords[ts.StopID]
+	}
+	for i, d := range StopDistances(shape, ordered) {
+		d := d
+		stops[i].ShapeDistTraveled = &d
+	}
+}
+
+// DistanceCache remembers the stop distances of each shape and stop pattern,
+// so that trips sharing both are projected once, e.g. across an export. Make
+// one with make(DistanceCache).
+type DistanceCache map[string][]float64
+
+// HydrateStopDistances works as the function of the same name for a trip
+// on shapeID, reusing the distances of an earlier trip with the same shape
+// and stops.
+func (dc DistanceCache) HydrateStopDistances(shapeID string, shape []models.ShapePoint, stops []models.TripStop, coords map[uint]models.Stop) {
+	if len(shape) < 2 {
+		return
+	}
+	var key strings.Builder
+	for _, ts := range stops {
+		key.WriteString(strconv.FormatUint(uint64(ts.StopID), 10))
+		key.WriteByte(',')
+	}
+	key.WriteString(shapeID)
+
+	dists, ok := dc[key.String()]
+	if !ok {
+		ordered := make([]models.Stop, len(stops))
+		for i, ts := range stops {
+			ordered[i] = coords[ts.StopID]
+		}
+		dists = StopDistances(shape, ordered)
+		dc[key.String()] = dists
+	}
+	for i, d := range dists {
+		d := d
+		stops[i].ShapeDistTraveled = &d
+	}
+}
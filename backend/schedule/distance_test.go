@@ -0,0 +1,34 @@
+package schedule
+
+import (
+	"gtfs-cms/models"
+	"testing"
+)
+
+func TestDistanceCache(t *testing.T) {
+	shape := []models.ShapePoint{{Lat: 0, Lon: 0, Sequence: 1}, {Lat: 0, Lon: 0.01, Sequence: 2}}
+	coords := map[uint]models.Stop{1: {ID: 1, Lat: 0, Lon: 0}, 2: {ID: 2, Lat: 0, Lon: 0.005}}
+	trip := func() []models.TripStop { return []models.TripStop{{StopID: 1, Sequence: 1}, {StopID: 2, Sequence: 2}} }
+
+	dc := make(DistanceCache)
+	first, second, plain := trip(), trip(), trip()
+	dc.HydrateStopDistances("S1", shape, first, coords)
+	dc.HydrateStopDistances("S1", shape, second, coords)
+	HydrateStopDistances(shape, plain, coords)
+	if len(dc) != 1 {
+		t.Errorf("cache holds %d patterns, want 1 for two identical trips", len(dc))
+	}
+	for i := range plain {
+		if *first[i].ShapeDistTraveled != *plain[i].ShapeDistTraveled || *second[i].ShapeDistTraveled != *plain[i].ShapeDistTraveled {
+			t.Errorf("stop %d: cached distances %v and %v, want %v", i, *first[i].ShapeDistTraveled, *second[i].ShapeDistTraveled, *plain[i].ShapeDistTraveled)
+		}
+	}
+	if first[1].ShapeDistTraveled == second[1].ShapeDistTraveled {
+		t.Errorf("trips sharing a pattern must not share distance pointers")
+	}
+
+	dc.HydrateStopDistances("S2", shape, trip(), coords)
+	if len(dc) != 2 {
+		t.Errorf("cache holds %d patterns, want another one for a different shape", len(dc))
+	}
+}
@@ -0,0 +1,200 @@
+// Package schedule fills in stop times for trips from the geometry of their
+// stops and shapes.
+package schedule
+
+import (
+	"fmt"
+	"gtfs-cms/geometry"
+	"gtfs-cms/gtfs"
+	"gtfs-cms/models"
+	"math"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// DefaultSpeedKmh is the vehicle speed used when a trip has fewer than two
+// known times to interpolate between.
+const DefaultSpeedKmh = 20.0
+
+// DefaultStartTime anchors trips that have no known time at all
+const DefaultStartTime = 8 * 3600
+
+// Options controls how untimed stops are filled
+type Options struct {
+	SpeedKmh  float64 // Used outside the span of known times
+	StartTime int     // Seconds; departure of the first stop when no time is known
+}
+
+func (o Options) speedMps() float64 {
+	if o.SpeedKmh <= 0 {
+		return DefaultSpeedKmh / 3.6
+	}
+	return o.SpeedKmh / 3.6
+}
+
+// StopDistances returns how far along the trip each stop lies, in metres.
+// Stops are projected onto the shape when one exists; otherwise the
+// straight-line distance between consecutive stops is accumulated.
+func StopDistances(shape []models.ShapePoint, stops []models.Stop) []float64 {
+	stopPts := make([]geometry.Point, len(stops))
+	for i, s := range stops {
+		stopPts[i] = geometry.Point{Lat: s.Lat, Lon: s.Lon}
+	}
+	if len(shape) < 2 {
+		return geometry.CumulativeDistances(stopPts)
+	}
+	line, cum := shapeLine(shape)
+	return geometry.ProjectSequence(line, cum, stopPts)
+}
+
+// shapeLine orders shape points by sequence and returns them with their
+// cumulative distances.
+func shapeLine(shape []models.ShapePoint) ([]geometry.Point, []float64) {
+	sorted := append([]models.ShapePoint(nil), shape...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Sequence < sorted[j].Sequence })
+	line := make([]geometry.Point, len(sorted))
+	for i, p := range sorted {
+		line[i] = geometry.Point{Lat: p.Lat, Lon: p.Lon}
+	}
+	return line, geometry.CumulativeDistances(line)
+}
+
+type timepoint struct {
+	index     int
+	arrival   int
+	departure int
+}
+
+// Interpolate fills the empty arrival and departure times of stops, which must
+// be ordered by sequence and aligned with dists. Stops that already have a time
+// are timepoints: stops between two timepoints are placed proportionally to
+// their distance, stops outside that span are timed with the configured speed.
+func Interpolate(stops []models.TripStop, dists []float64, opts Options) error {
+	if len(stops) != len(dists) {
+		return fmt.Errorf("got %d distances for %d stops", len(dists), len(stops))
+	}
+	if len(stops) == 0 {
+		return nil
+	}
+
+	// Projection noise can make distances dip slightly; never run backwards
+	d := make([]float64, len(dists))
+	for i, v := range dists {
+		d[i] = v
+		if i > 0 && d[i] < d[i-1] {
+			d[i] = d[i-1]
+		}
+	}
+
+	var known []timepoint
+	for i, ts := range stops {
+		arr, dep := ts.ArrivalTime, ts.DepartureTime
+		if arr == "" && dep == "" {
+			continue
+		}
+		if arr == "" {
+			arr = dep
+		}
+		if dep == "" {
+			dep = arr
+		}
+		a, err := gtfs.ParseTime(arr)
+		if err != nil {
+			return fmt.Errorf("stop sequence %d: %w", ts.Sequence, err)
+		}
+		b, err := gtfs.ParseTime(dep)
+		if err != nil {
+			return fmt.Errorf("stop sequence %d: %w", ts.Sequence, err)
+		}
+		known = append(known, timepoint{index: i, arrival: a, departure: b})
+	}
+
+	start := opts.StartTime
+	if start <= 0 {
+		start = DefaultStartTime
+	}
+	if len(known) == 0 {
+		known = []timepoint{{index: 0, arrival: start, departure: start}}
+	}
+	speed := opts.speedMps()
+
+	times := make([]int, len(stops))
+	first, last := known[0], known[len(known)-1]
+
+	// Before the first timepoint: run back from it at the configured speed
+	for i := 0; i < first.index; i++ {
+		times[i] = max(0, first.arrival-int(math.Round((d[first.index]-d[i])/speed)))
+	}
+
+	// Between timepoints: share the time proportionally to distance
+	for k := 0; k < len(known)-1; k++ {
+		a, b := known[k], known[k+1]
+		span := d[b.index] - d[a.index]
+		for i := a.index + 1; i < b.index; i++ {
+			frac := float64(i-a.index) / float64(b.index-a.index)
+			if span > 0 {
+				frac = (d[i] - d[a.index]) / span
+			}
+			times[i] = a.departure + int(math.Round(frac*float64(b.arrival-a.departure)))
+		}
+	}
+
+	// After the last timepoint: continue at the configured speed
+	for i := last.index + 1; i < len(stops); i++ {
+		times[i] = last.departure + int(math.Round((d[i]-d[last.index])/speed))
+	}
+
+	for _, tp := range known {
+		times[tp.index] = tp.arrival
+	}
+	for i := range stops {
+		ts := &stops[i]
+		switch {
+		case ts.ArrivalTime == "" && ts.DepartureTime == "":
+			ts.ArrivalTime = gtfs.FormatTime(times[i])
+			ts.DepartureTime = ts.ArrivalTime
+		case ts.ArrivalTime == "":
+			ts.ArrivalTime = ts.DepartureTime
+		case ts.DepartureTime == "":
+			ts.DepartureTime = ts.ArrivalTime
+		}
+	}
+	return nil
+}
+
+// InterpolateTrip looks up the shape and stop coordinates of a trip in db and
+// fills the empty times of stops, which must be ordered by sequence.
+func InterpolateTrip(db *gorm.DB, shapeID string, stops []models.TripStop, opts Options) error {
+	var shape []models.ShapePoint
+	if shapeID != "" {
+		if err := db.Where("shape_id = ?", shapeID).Order("sequence asc").Find(&shape).Error; err != nil {
+			return fmt.Errorf("load shape %s: %w", shapeID, err)
+		}
+	}
+
+	ids := make([]uint, 0, len(stops))
+	for _, ts := range stops {
+		ids = append(ids, ts.StopID)
+	}
+	var found []models.Stop
+	if len(ids) > 0 {
+		if err := db.Where("id IN ?", ids).Find(&found).Error; err != nil {
+			return fmt.Errorf("load stops: %w", err)
+		}
+	}
+	byID := make(map[uint]models.Stop, len(found))
+	for _, s := range found {
+		byID[s.ID] = s
+	}
+
+	coords := make([]models.Stop, len(stops))
+	for i, ts := range stops {
+		s, ok := byID[ts.StopID]
+		if !ok {
+			return fmt.Errorf("stop #%d does not exist", ts.StopID)
+		}
+		coords[i] = s
+	}
+	return Interpolate(stops, StopDistances(shape, coords), opts)
+}
@@ -0,0 +1,74 @@
+package schedule
+
+import (
+	"gtfs-cms/models"
+	"testing"
+)
+
+func TestInterpolateBetweenTimepoints(t *testing.T) {
+	stops := []models.TripStop{
+		{Sequence: 1, ArrivalTime: "08:00:00", DepartureTime: "08:00:00"},
+		{Sequence: 2},
+		{Sequence: 3},
+		{Sequence: 4, ArrivalTime: "08:20:00", DepartureTime: "08:21:00"},
+	}
+	// The second stop is close to the start, the third close to the end
+	dists := []float64{0, 1000, 7000, 8000}
+
+	if err := Interpolate(stops, dists, Options{}); err != nil {
+		t.Fatalf("Interpolate() error = %v", err)
+	}
+	want := []string{"08:00:00", "08:02:30", "08:17:30", "08:20:00"}
+	for i, w := range want {
+		if stops[i].ArrivalTime != w {
+			t.Errorf("stop %d arrival = %s, want %s", i+1, stops[i].ArrivalTime, w)
+		}
+	}
+	if stops[3].DepartureTime != "08:21:00" {
+		t.Errorf("timepoint departure must be kept, got %s", stops[3].DepartureTime)
+	}
+}
+
+func TestInterpolateWithSpeed(t *testing.T) {
+	stops := []models.TripStop{
+		{Sequence: 1},
+		{Sequence: 2, DepartureTime: "07:00:00"},
+		{Sequence: 3},
+	}
+	// 36 km/h = 10 m/s
+	dists := []float64{0, 600, 1800}
+
+	if err := Interpolate(stops, dists, Options{SpeedKmh: 36}); err != nil {
+		t.Fatalf("Interpolate() error = %v", err)
+	}
+	want := []string{"06:59:00", "07:00:00", "07:02:00"}
+	for i, w := range want {
+		if stops[i].ArrivalTime != w {
+			t.Errorf("stop %d arrival = %s, want %s", i+1, stops[i].ArrivalTime, w)
+		}
+	}
+}
+
+func TestInterpolateWithoutTimes(t *testing.T) {
+	stops := []models.TripStop{{Sequence: 1}, {Sequence: 2}}
+	if err := Interpolate(stops, []float64{0, 5000}, Options{SpeedKmh: 30}); err != nil {
+		t.Fatalf("Interpolate() error = %v", err)
+	}
+	if stops[0].DepartureTime != "08:00:00" || stops[1].ArrivalTime != "08:10:00" {
+		t.Errorf("got %s -> %s, want 08:00:00 -> 08:10:00", stops[0].DepartureTime, stops[1].ArrivalTime)
+	}
+}
+
+func TestStopDistancesAlongShape(t *testing.T) {
+	shape := []models.ShapePoint{
+		{Lat: 0, Lon: 0, Sequence: 1},
+		{Lat: 0, Lon: 0.01, Sequence: 2},
+		{Lat: 0.01, Lon: 0.01, Sequence: 3},
+	}
+	stops := []models.Stop{{Lat: 0.0001, Lon: 0}, {Lat: 0.0001, Lon: 0.01}, {Lat: 0.01, Lon: 0.0101}}
+
+	d := StopDistances(shape, stops)
+	if d[0] > 1 || d[1] < 1100 || d[1] > 1125 || d[2] < 2200 || d[2] > 2240 {
+		t.Errorf("StopDistances() = %v, want roughly [0 1112 2224]", d)
+	}
+}
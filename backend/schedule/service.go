@@ -0,0 +1,76 @@
+package schedule
+
+import (
+	"gtfs-cms/gtfs"
+	"gtfs-cms/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ServiceRunsOn reports whether a calendar runs on day, applying the
+// calendar_dates exceptions of that service on top of its weekly pattern.
+func ServiceRunsOn(cal models.Calendar, exceptions []models.CalendarDate, day time.Time) bool {
+	date := day.Format(gtfs.DateLayout)
+	for _, ex := range exceptions {
+		if ex.ServiceID == cal.ServiceID && ex.Date == date {
+			return ex.ExceptionType == 1
+		}
+	}
+	if date < cal.StartDate || date > cal.EndDate {
+		return false
+	}
+	switch day.Weekday() {
+	case time.Monday:
+		return cal.Monday
+	case time.Tuesday:
+		return cal.Tuesday
+	case time.Wednesday:
+		return cal.Wednesday
+	case time.Thursday:
+		return cal.Thursday
+	case time.Friday:
+		return cal.Friday
+	case time.Saturday:
+		return cal.Saturday
+	default:
+		return cal.Sunday
+	}
+}
+
+// ActiveServices returns the service IDs that run on the service day of day
+func ActiveServices(db *gorm.DB, day time.Time) (map[string]bool, error) {
+	var calendars []models.Calendar
+	if err := db.Find(&calendars).Error; err != nil {
+		return nil, err
+	}
+	var exceptions []models.CalendarDate
+	if err := db.Where("date = ?", day.Format(gtfs.DateLayout)).Find(&exceptions).Error; err != nil {
+		return nil, err
+	}
+	active := make(map[string]bool)
+	for _, cal := range calendars {
+		if ServiceRunsOn(cal, exceptions, day) {
+			active[cal.ServiceID] = true
+		}
+	}
+	return active, nil
+}
+
+// Location returns the timezone of the first agency, which the timetable is
+// written in, falling back to the server's zone.
+func Location(db *gorm.DB) *time.Location {
+	var agency models.Agency
+	if err := db.Order("id asc").First(&agency).Error; err == nil {
+		if loc, err := time.LoadLocation(agency.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// ServiceDay is local midnight of the calendar date t falls on in loc
+func ServiceDay(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+}
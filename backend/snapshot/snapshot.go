@@ -0,0 +1,392 @@
+// Package snapshot captures the whole feed as one versioned value that can be
+// stored, compared with another version and written back.
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gtfs-cms/models"
+	"io"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Dataset holds every row of the feed tables, agencies through shape points
+type Dataset struct {
+	Agencies      []models.Agency       `json:"agencies"`
+	Stops         []models.Stop         `json:"stops"`
+	Routes        []models.Route        `json:"routes"`
+	Calendars     []models.Calendar     `json:"calendars"`
+	CalendarDates []models.CalendarDate `json:"calendar_dates"`
+	Trips         []models.Trip         `json:"trips"`
+	TripStops     []models.TripStop     `json:"trip_stops"`
+	Frequencies   []models.Frequency    `json:"frequencies"`
+	ShapePoints   []models.ShapePoint   `json:"shape_points"`
+	Levels        []models.Level        `json:"levels"`
+	Pathways      []models.Pathway      `json:"pathways"`
+	Transfers     []models.Transfer     `json:"transfers"`
+
+	FareAttributes []models.FareAttribute `json:"fare_attributes"`
+	FareRules      []models.FareRule      `json:"fare_rules"`
+	Areas          []models.Area          `json:"areas"`
+	FareMedia      []models.FareMedia     `json:"fare_media"`
+	FareProducts   []models.FareProduct   `json:"fare_products"`
+	FareLegRules   []models.FareLegRule   `json:"fare_leg_rules"`
+}
+
+// Load reads the dataset in a stable order, so equal data encodes equally
+func Load(db *gorm.DB) (*Dataset, error) {
+	ds := &Dataset{}
+	for _, t := range []struct {
+		name  string
+		order string
+		dest  interface{}
+	}{
+		{"agencies", "id", &ds.Agencies},
+		{"stops", "id", &ds.Stops},
+		{"routes", "id", &ds.Routes},
+		{"calendars", "service_id", &ds.Calendars},
+		{"calendar dates", "id", &ds.CalendarDates},
+		{"trips", "id", &ds.Trips},
+		{"trip stops", "id", &ds.TripStops},
+		{"frequencies", "id", &ds.Frequencies},
+		{"shape points", "id", &ds.ShapePoints},
+		{"levels", "id", &ds.Levels},
+		{"pathways", "id", &ds.Pathways},
+		{"transfers", "id", &ds.Transfers},
+		{"fare attributes", "id", &ds.FareAttributes},
+		{"fare rules", "id", &ds.FareRules},
+		{"areas", "id", &ds.Areas},
+		{"fare media", "id", &ds.FareMedia},
+		{"fare products", "id", &ds.FareProducts},
+		{"fare leg rules", "id", &ds.FareLegRules},
+	} {
+		if err := db.Order(t.order).Find(t.dest).Error; err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+	}
+	return ds, nil
+}
+
+// Counts is the number of rows per table
+func (ds *Dataset) Counts() map[string]int {
+	return map[string]int{
+		"agencies":        len(ds.Agencies),
+		"stops":           len(ds.Stops),
+		"routes":          len(ds.Routes),
+		"calendars":       len(ds.Calendars),
+		"calendar_dates":  len(ds.CalendarDates),
+		"trips":           len(ds.Trips),
+		"trip_stops":      len(ds.TripStops),
+		"frequencies":     len(ds.Frequencies),
+		"shape_points":    len(ds.ShapePoints),
+		"levels":          len(ds.Levels),
+		"pathways":        len(ds.Pathways),
+		"transfers":       len(ds.Transfers),
+		"fare_attributes": len(ds.FareAttributes),
+		"fare_rules":      len(ds.FareRules),
+		"areas":           len(ds.Areas),
+		"fare_media":      len(ds.FareMedia),
+		"fare_products":   len(ds.FareProducts),
+		"fare_leg_rules":  len(ds.FareLegRules),
+	}
+}
+
+// Encode returns the gzip-compressed JSON of ds and the SHA-256 of the
+// uncompressed JSON, which identifies identical datasets
+func (ds *Dataset) Encode() (data []byte, checksum string, err error) {
+	raw, err := json.Marshal(ds)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(raw)
+
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(raw); err != nil {
+		return nil, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), hex.EncodeToString(sum[:]), nil
+}
+
+// Decode reverses Encode
+func Decode(data []byte) (*Dataset, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	ds := &Dataset{}
+	if err := json.Unmarshal(raw, ds); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// Restore replaces the contents of every feed table with ds, keeping the
+// original IDs, and unlinks alerts from rows ds does not have. It must run
+// inside a transaction.
+func (ds *Dataset) Restore(tx *gorm.DB) error {
+	all := tx.Session(&gorm.Session{AllowGlobalUpdate: true})
+	// Children first, so no row is left pointing at a deleted parent
+	for _, m := range []interface{}{
+		&models.FareLegRule{}, &models.FareProduct{}, &models.FareMedia{}, &models.Area{}, &models.FareRule{}, &models.FareAttribute{},
+		&models.Transfer{}, &models.TripStop{}, &models.Frequency{}, &models.Trip{}, &models.ShapePoint{}, &models.Pathway{},
+		&models.CalendarDate{}, &models.Calendar{}, &models.Route{}, &models.Stop{}, &models.Level{}, &models.Agency{},
+	} {
+		if err := all.Delete(m).Error; err != nil {
+			return fmt.Errorf("clear %T: %w", m, err)
+		}
+	}
+
+	if err := restoreTable(tx, ds.Agencies, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.Levels, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.Stops, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.Pathways, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.Routes, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.Calendars, false); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.CalendarDates, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.Trips, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.TripStops, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.Frequencies, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.Transfers, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.FareAttributes, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.FareRules, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.Areas, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.FareMedia, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.FareProducts, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.FareLegRules, true); err != nil {
+		return err
+	}
+	if err := restoreTable(tx, ds.ShapePoints, true); err != nil {
+		return err
+	}
+	return dropStaleAlertEntities(tx)
+}
+
+// dropStaleAlertEntities removes the informed entities of alerts that point
+// at agencies, routes, trips or stops the restored feed does not have.
+// Alerts are not part of a dataset, so they outlive a restore.
+func dropStaleAlertEntities(tx *gorm.DB) error {
+	for _, ref := range []struct {
+		column string
+		model  interface{}
+	}{
+		{"agency_id", &models.Agency{}},
+		{"route_id", &models.Route{}},
+		{"trip_id", &models.Trip{}},
+		{"stop_id", &models.Stop{}},
+	} {
+		err := tx.Where("alert_id IN (?)", tx.Model(&models.Alert{}).Select("id")).
+			Where(ref.column+" IS NOT NULL AND "+ref.column+" NOT IN (?)", tx.Model(ref.model).Select("id")).
+			Delete(&models.AlertInformedEntity{}).Error
+		if err != nil {
+			return fmt.Errorf("clear alert entities by %s: %w", ref.column, err)
+		}
+	}
+	return nil
+}
+
+// restoreTable inserts rows without their preloaded associations. Tables
+// with a serial id get their sequence moved past the restored ids, or the
+// next create would collide with them.
+func restoreTable[T any](tx *gorm.DB, rows []T, serial bool) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(new(T)); err != nil {
+		return err
+	}
+	table := stmt.Schema.Table
+	if len(rows) > 0 {
+		if err := tx.Omit(clause.Associations).CreateInBatches(rows, 500).Error; err != nil {
+			return fmt.Errorf("restore %s: %w", table, err)
+		}
+	}
+	if !serial || tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	err := tx.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), COALESCE((SELECT MAX(id) FROM %[1]s), 0) + 1, false)", table)).Error
+	if err != nil {
+		return fmt.Errorf("reset %s sequence: %w", table, err)
+	}
+	return nil
+}
+
+// TableDiff lists the keys of the rows that only one side has or that differ
+type TableDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Diff compares two datasets table by table. Only tables that differ appear.
+func Diff(from, to *Dataset) (map[string]TableDiff, error) {
+	id := func(v uint) string { return fmt.Sprint(v) }
+	tables := []struct {
+		name string
+		diff func() (TableDiff, error)
+	}{
+		{"agencies", func() (TableDiff, error) {
+			return diffTable(from.Agencies, to.Agencies, func(r models.Agency) string { return id(r.ID) })
+		}},
+		{"stops", func() (TableDiff, error) {
+			return diffTable(from.Stops, to.Stops, func(r models.Stop) string { return id(r.ID) })
+		}},
+		{"routes", func() (TableDiff, error) {
+			return diffTable(from.Routes, to.Routes, func(r models.Route) string { return id(r.ID) })
+		}},
+		{"calendars", func() (TableDiff, error) {
+			return diffTable(from.Calendars, to.Calendars, func(r models.Calendar) string { return r.ServiceID })
+		}},
+		{"calendar_dates", func() (TableDiff, error) {
+			return diffTable(from.CalendarDates, to.CalendarDates, func(r models.CalendarDate) string { return id(r.ID) })
+		}},
+		{"trips", func() (TableDiff, error) {
+			return diffTable(from.Trips, to.Trips, func(r models.Trip) string { return id(r.ID) })
+		}},
+		{"trip_stops", func() (TableDiff, error) {
+			return diffTable(from.TripStops, to.TripStops, func(r models.TripStop) string { return id(r.ID) })
+		}},
+		{"frequencies", func() (TableDiff, error) {
+			return diffTable(from.Frequencies, to.Frequencies, func(r models.Frequency) string { return id(r.ID) })
+		}},
+		{"shape_points", func() (TableDiff, error) {
+			return diffTable(from.ShapePoints, to.ShapePoints, func(r models.ShapePoint) string { return id(r.ID) })
+		}},
+		{"levels", func() (TableDiff, error) {
+			return diffTable(from.Levels, to.Levels, func(r models.Level) string { return id(r.ID) })
+		}},
+		{"pathways", func() (TableDiff, error) {
+			return diffTable(from.Pathways, to.Pathways, func(r models.Pathway) string { return id(r.ID) })
+		}},
+		{"transfers", func() (TableDiff, error) {
+			return diffTable(from.Transfers, to.Transfers, func(r models.Transfer) string { return id(r.ID) })
+		}},
+		{"fare_attributes", func() (TableDiff, error) {
+			return diffTable(from.FareAttributes, to.FareAttributes, func(r models.FareAttribute) string { return id(r.ID) })
+		}},
+		{"fare_rules", func() (TableDiff, error) {
+			return diffTable(from.FareRules, to.FareRules, func(r models.FareRule) string { return id(r.ID) })
+		}},
+		{"areas", func() (TableDiff, error) {
+			return diffTable(from.Areas, to.Areas, func(r models.Area) string { return id(r.ID) })
+		}},
+		{"fare_media", func() (TableDiff, error) {
+			return diffTable(from.FareMedia, to.FareMedia, func(r models.FareMedia) string { return id(r.ID) })
+		}},
+		{"fare_products", func() (TableDiff, error) {
+			return diffTable(from.FareProducts, to.FareProducts, func(r models.FareProduct) string { return id(r.ID) })
+		}},
+		{"fare_leg_rules", func() (TableDiff, error) {
+			return diffTable(from.FareLegRules, to.FareLegRules, func(r models.FareLegRule) string { return id(r.ID) })
+		}},
+	}
+
+	out := make(map[string]TableDiff)
+	for _, t := range tables {
+		d, err := t.diff()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+		if len(d.Added)+len(d.Removed)+len(d.Changed) > 0 {
+			out[t.name] = d
+		}
+	}
+	return out, nil
+}
+
+func diffTable[T any](from, to []T, key func(T) string) (TableDiff, error) {
+	index := func(rows []T) (map[string][]byte, error) {
+		m := make(map[string][]byte, len(rows))
+		for _, r := range rows {
+			b, err := json.Marshal(r)
+			if err != nil {
+				return nil, err
+			}
+			m[key(r)] = b
+		}
+		return m, nil
+	}
+	a, err := index(from)
+	if err != nil {
+		return TableDiff{}, err
+	}
+	b, err := index(to)
+	if err != nil {
+		return TableDiff{}, err
+	}
+
+	var d TableDiff
+	for k, av := range a {
+		bv, ok := b[k]
+		switch {
+		case !ok:
+			d.Removed = append(d.Removed, k)
+		case !bytes.Equal(av, bv):
+			d.Changed = append(d.Changed, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			d.Added = append(d.Added, k)
+		}
+	}
+	for _, keys := range [][]string{d.Added, d.Removed, d.Changed} {
+		sortKeys(keys)
+	}
+	return d, nil
+}
+
+// sortKeys puts shorter keys first, which orders numeric ids numerically
+func sortKeys(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+}
@@ -0,0 +1,63 @@
+package snapshot
+
+import (
+	"gtfs-cms/models"
+	"reflect"
+	"testing"
+)
+
+func sample() *Dataset {
+	return &Dataset{
+		Agencies:  []models.Agency{{ID: 1, Name: "Metro", Timezone: "Asia/Jakarta"}},
+		Stops:     []models.Stop{{ID: 1, Name: "A", Lat: 1, Lon: 1}, {ID: 2, Name: "B", Lat: 2, Lon: 2}},
+		Calendars: []models.Calendar{{ServiceID: "DAILY", Monday: true, StartDate: "20250101", EndDate: "20251231"}},
+		Trips:     []models.Trip{{ID: 5, RouteID: 1, ServiceID: "DAILY"}},
+		TripStops: []models.TripStop{{ID: 9, TripID: 5, StopID: 1, Sequence: 1, ArrivalTime: "08:00:00"}},
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	ds := sample()
+	data, sum, err := ds.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Stops, ds.Stops) || !reflect.DeepEqual(got.TripStops, ds.TripStops) {
+		t.Errorf("Decode(Encode()) lost data: %+v", got)
+	}
+	if _, again, _ := got.Encode(); again != sum {
+		t.Errorf("checksum changed after a round trip: %s != %s", again, sum)
+	}
+	if got.Counts()["stops"] != 2 {
+		t.Errorf("Counts() = %v, want 2 stops", got.Counts())
+	}
+}
+
+func TestDiff(t *testing.T) {
+	from, to := sample(), sample()
+	to.Stops[1].Name = "B Terminal"
+	to.Stops = append(to.Stops, models.Stop{ID: 10, Name: "C"})
+	to.TripStops = nil
+	to.Calendars[0].Sunday = true
+
+	got, err := Diff(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]TableDiff{
+		"stops":      {Added: []string{"10"}, Changed: []string{"2"}},
+		"trip_stops": {Removed: []string{"9"}},
+		"calendars":  {Changed: []string{"DAILY"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %+v, want %+v", got, want)
+	}
+
+	if same, _ := Diff(from, sample()); len(same) != 0 {
+		t.Errorf("Diff() of equal datasets = %+v, want none", same)
+	}
+}
@@ -0,0 +1,254 @@
+// Package spatial answers the map queries on stops: which stops lie in a
+// viewport, near a point or along a shape.
+//
+// Stops are bucketed into an in-memory grid of cells a few hundred metres
+// wide, so a query only measures the stops of the cells it touches. The grid
+// is rebuilt whenever the stops table changes, see Cache.
+package spatial
+
+import (
+	"fmt"
+	"gtfs-cms/database"
+	"gtfs-cms/geometry"
+	"gtfs-cms/models"
+	"math"
+	"sort"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// CellMeters is the default grid cell size
+const CellMeters = 500
+
+// metersPerDegree is the length of one degree of latitude
+const metersPerDegree = 111320
+
+// BBox is a viewport in degrees. A box with MinLon > MaxLon crosses the
+// antimeridian.
+type BBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+// Contains reports whether p lies in b, edges included
+func (b BBox) Contains(p geometry.Point) bool {
+	if p.Lat < b.MinLat || p.Lat > b.MaxLat {
+		return false
+	}
+	if b.MinLon > b.MaxLon {
+		return p.Lon >= b.MinLon || p.Lon <= b.MaxLon
+	}
+	return p.Lon >= b.MinLon && p.Lon <= b.MaxLon
+}
+
+// grow widens b by radius metres on every side. Degrees of longitude are
+// measured at the edge furthest from the equator, where they are shortest.
+func (b BBox) grow(radius float64) BBox {
+	dLat := radius / metersPerDegree
+	lat := math.Max(math.Abs(b.MinLat), math.Abs(b.MaxLat))
+	dLon := dLat / math.Max(math.Cos(lat*math.Pi/180), 0.01)
+	return BBox{MinLon: b.MinLon - dLon, MinLat: b.MinLat - dLat, MaxLon: b.MaxLon + dLon, MaxLat: b.MaxLat + dLat}
+}
+
+// Hit is a stop found near a point or line, with its distance in metres.
+// Along is the distance along the shape for line queries.
+type Hit struct {
+	models.Stop
+	Distance float64  `json:"distance"`
+	Along    *float64 `json:"along,omitempty"`
+}
+
+type cell struct{ x, y int }
+
+// Index is a read-only grid of stops. It is safe for concurrent use.
+type Index struct {
+	cellDeg float64
+	stops   []models.Stop
+	cells   map[cell][]int
+}
+
+// NewIndex buckets stops into cells of about cellMeters
+func NewIndex(stops []models.Stop, cellMeters float64) *Index {
+	ix := &Index{cellDeg: cellMeters / metersPerDegree, stops: stops, cells: make(map[cell][]int)}
+	for i, s := range stops {
+		c := ix.cellOf(s.Lon, s.Lat)
+		ix.cells[c] = append(ix.cells[c], i)
+	}
+	return ix
+}
+
+// Len is the number of indexed stops
+func (ix *Index) Len() int {
+	return len(ix.stops)
+}
+
+func (ix *Index) cellOf(lon, lat float64) cell {
+	return cell{int(math.Floor(lon / ix.cellDeg)), int(math.Floor(lat / ix.cellDeg))}
+}
+
+// candidates calls fn with every stop in the cells overlapping b. Boxes
+// that span more cells than there are stops, or cross the antimeridian,
+// are answered by a plain scan.
+func (ix *Index) candidates(b BBox, fn func(i int)) {
+	lo, hi := ix.cellOf(b.MinLon, b.MinLat), ix.cellOf(b.MaxLon, b.MaxLat)
+	spanX, spanY := float64(hi.x-lo.x+1), float64(hi.y-lo.y+1)
+	if b.MinLon > b.MaxLon || spanX*spanY > float64(len(ix.cells)) {
+		for i := range ix.stops {
+			fn(i)
+		}
+		return
+	}
+	for x := lo.x; x <= hi.x; x++ {
+		for y := lo.y; y <= hi.y; y++ {
+			for _, i := range ix.cells[cell{x, y}] {
+				fn(i)
+			}
+		}
+	}
+}
+
+func point(s models.Stop) geometry.Point {
+	return geometry.Point{Lat: s.Lat, Lon: s.Lon}
+}
+
+// Within returns the IDs of the stops inside b in ascending order
+func (ix *Index) Within(b BBox) []uint {
+	ids := []uint{}
+	ix.candidates(b, func(i int) {
+		if b.Contains(point(ix.stops[i])) {
+			ids = append(ids, ix.stops[i].ID)
+		}
+	})
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Nearby returns the stops within radius metres of p, nearest first. A
+// positive limit keeps only that many.
+func (ix *Index) Nearby(p geometry.Point, radius float64, limit int) []Hit {
+	hits := []Hit{}
+	ix.candidates(BBox{MinLon: p.Lon, MinLat: p.Lat, MaxLon: p.Lon, MaxLat: p.Lat}.grow(radius), func(i int) {
+		if d := geometry.Haversine(p, point(ix.stops[i])); d <= radius {
+			hits = append(hits, Hit{Stop: ix.stops[i], Distance: d})
+		}
+	})
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Distance != hits[j].Distance {
+			return hits[i].Distance < hits[j].Distance
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// NearLine returns the stops within radius metres of the polyline, ordered
+// by where they lie along it
+func (ix *Index) NearLine(line []geometry.Point, radius float64) []Hit {
+	hits := []Hit{}
+	if len(line) == 0 {
+		return hits
+	}
+	// Gather the stops near each segment once, then measure them against the
+	// whole line
+	seen := make(map[int]bool)
+	for k := range line {
+		a, b := line[k], line[min(k+1, len(line)-1)]
+		seg := BBox{MinLon: math.Min(a.Lon, b.Lon), MinLat: math.Min(a.Lat, b.Lat), MaxLon: math.Max(a.Lon, b.Lon), MaxLat: math.Max(a.Lat, b.Lat)}
+		ix.candidates(seg.grow(radius), func(i int) { seen[i] = true })
+	}
+
+	cum := geometry.CumulativeDistances(line)
+	for i := range seen {
+		along, d := geometry.Nearest(line, cum, point(ix.stops[i]))
+		if d <= radius {
+			hits = append(hits, Hit{Stop: ix.stops[i], Distance: d, Along: &along})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if *hits[i].Along != *hits[j].Along {
+			return *hits[i].Along < *hits[j].Along
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits
+}
+
+// Pair is two stops within some distance of each other, in metres
+type Pair struct {
+	From, To models.Stop
+	Distance float64
+}
+
+// Pairs returns every ordered pair of distinct stops within radius metres
+// of each other that both pass keep, nearest first per stop
+func (ix *Index) Pairs(radius float64, keep func(models.Stop) bool) []Pair {
+	pairs := []Pair{}
+	for _, s := range ix.stops {
+		if !keep(s) {
+			continue
+		}
+		for _, h := range ix.Nearby(point(s), radius, 0) {
+			if h.ID != s.ID && keep(h.Stop) {
+				pairs = append(pairs, Pair{From: s, To: h.Stop, Distance: h.Distance})
+			}
+		}
+	}
+	return pairs
+}
+
+// stopsKey changes whenever a stop is added, removed, moved or edited, so a
+// cached index can be checked with one aggregate query instead of a reload
+type stopsKey struct {
+	Count   int64
+	MaxID   uint
+	Version int64
+	Coords  float64
+}
+
+type cached struct {
+	key   stopsKey
+	index *Index
+}
+
+// Cache keeps an index of the stops of each workspace and rebuilds it when
+// they have changed since the last query
+type Cache struct {
+	CellMeters float64
+
+	mu      sync.Mutex
+	indexes map[uint]cached
+}
+
+// Default is the index shared by the request handlers
+var Default = &Cache{CellMeters: CellMeters}
+
+// Get returns an up-to-date index of the stops in db, which is scoped to
+// one workspace
+func (c *Cache) Get(db *gorm.DB) (*Index, error) {
+	var key stopsKey
+	if err := db.Model(&models.Stop{}).
+		Select("COUNT(*) AS count, COALESCE(MAX(id), 0) AS max_id, COALESCE(SUM(version), 0) AS version, COALESCE(SUM(lat + lon), 0) AS coords").
+		Scan(&key).Error; err != nil {
+		return nil, fmt.Errorf("stops index: %w", err)
+	}
+
+	workspace := database.WorkspaceOf(db.Statement.Context)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit, ok := c.indexes[workspace]; ok && hit.key == key {
+		return hit.index, nil
+	}
+	var stops []models.Stop
+	if err := db.Order("id").Find(&stops).Error; err != nil {
+		return nil, fmt.Errorf("stops index: %w", err)
+	}
+	if c.indexes == nil {
+		c.indexes = make(map[uint]cached)
+	}
+	index := NewIndex(stops, c.CellMeters)
+	c.indexes[workspace] = cached{key: key, index: index}
+	return index, nil
+}
@@ -0,0 +1,101 @@
+package spatial
+
+import (
+	"gtfs-cms/geometry"
+	"gtfs-cms/models"
+	"math/rand"
+	"testing"
+)
+
+// randomStops scatters stops over a city-sized area around Purbalingga
+func randomStops(n int) []models.Stop {
+	r := rand.New(rand.NewSource(1))
+	stops := make([]models.Stop, n)
+	for i := range stops {
+		stops[i] = models.Stop{ID: uint(i + 1), Lat: -7.39 + r.Float64()*0.1, Lon: 109.36 + r.Float64()*0.1}
+	}
+	return stops
+}
+
+func TestNearbyMatchesBruteForce(t *testing.T) {
+	stops := randomStops(2000)
+	ix := NewIndex(stops, CellMeters)
+	p := geometry.Point{Lat: -7.34, Lon: 109.41}
+
+	want := 0
+	for _, s := range stops {
+		if geometry.Haversine(p, point(s)) <= 800 {
+			want++
+		}
+	}
+	hits := ix.Nearby(p, 800, 0)
+	if len(hits) != want || want == 0 {
+		t.Fatalf("Nearby() found %d stops, want %d", len(hits), want)
+	}
+	for i := 1; i < len(hits); i++ {
+		if hits[i].Distance < hits[i-1].Distance {
+			t.Fatalf("Nearby() is not ordered by distance at %d", i)
+		}
+	}
+	if got := ix.Nearby(p, 800, 3); len(got) != 3 || got[0].ID != hits[0].ID {
+		t.Errorf("Nearby() with a limit = %d stops, want the nearest 3", len(got))
+	}
+}
+
+func TestWithin(t *testing.T) {
+	stops := randomStops(2000)
+	ix := NewIndex(stops, CellMeters)
+	for _, b := range []BBox{
+		{MinLon: 109.38, MinLat: -7.37, MaxLon: 109.40, MaxLat: -7.35},
+		{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90},
+	} {
+		want := 0
+		for _, s := range stops {
+			if b.Contains(point(s)) {
+				want++
+			}
+		}
+		if got := ix.Within(b); len(got) != want {
+			t.Errorf("Within(%+v) = %d stops, want %d", b, len(got), want)
+		}
+	}
+	// A box across the antimeridian holds both edges of the map
+	ix = NewIndex([]models.Stop{{ID: 1, Lat: 0, Lon: 179.9}, {ID: 2, Lat: 0, Lon: -179.9}, {ID: 3, Lat: 0, Lon: 0}}, CellMeters)
+	if got := ix.Within(BBox{MinLon: 179, MinLat: -1, MaxLon: -179, MaxLat: 1}); len(got) != 2 {
+		t.Errorf("Within() across the antimeridian = %v, want stops 1 and 2", got)
+	}
+}
+
+func TestNearLine(t *testing.T) {
+	// A straight east-west shape about 2.2 km long
+	line := []geometry.Point{{Lat: -7.38, Lon: 109.36}, {Lat: -7.38, Lon: 109.38}}
+	stops := []models.Stop{
+		{ID: 1, Lat: -7.3805, Lon: 109.375}, // 55 m off, near the end
+		{ID: 2, Lat: -7.3795, Lon: 109.362}, // 55 m off, near the start
+		{ID: 3, Lat: -7.39, Lon: 109.37},    // over a kilometre away
+		{ID: 4, Lat: -7.38, Lon: 109.381},   // past the end, 110 m away
+	}
+	hits := NewIndex(stops, CellMeters).NearLine(line, 100)
+	if len(hits) != 2 || hits[0].ID != 2 || hits[1].ID != 1 {
+		t.Fatalf("NearLine() = %+v, want stops 2 and 1 in order along the shape", hits)
+	}
+	if hits[0].Distance < 50 || hits[0].Distance > 60 || *hits[0].Along > *hits[1].Along {
+		t.Errorf("NearLine() hit = %+v", hits[0])
+	}
+}
+
+func TestPairs(t *testing.T) {
+	stops := []models.Stop{
+		{ID: 1, Lat: 0, Lon: 0},
+		{ID: 2, Lat: 0, Lon: 0.001}, // about 111 m east of 1
+		{ID: 3, Lat: 0, Lon: 0.01},  // about 1.1 km east of 1
+		{ID: 4, Lat: 0, Lon: 0.0005, LocationType: models.LocationStation},
+	}
+	pairs := NewIndex(stops, CellMeters).Pairs(200, func(s models.Stop) bool { return s.LocationType == models.LocationStop })
+	if len(pairs) != 2 || pairs[0].From.ID != 1 || pairs[0].To.ID != 2 || pairs[1].From.ID != 2 || pairs[1].To.ID != 1 {
+		t.Fatalf("Pairs() = %+v, want 1->2 and 2->1", pairs)
+	}
+	if d := pairs[0].Distance; d < 100 || d > 120 {
+		t.Errorf("distance = %.1f, want about 111 m", d)
+	}
+}
@@ -0,0 +1,714 @@
+package validator
+
+import (
+	"fmt"
+	"gtfs-cms/gtfs"
+	"gtfs-cms/models"
+	"regexp"
+	"sort"
+	"time"
+	_ "time/tzdata" // Timezone checks must not depend on the host's zoneinfo
+
+	"gorm.io/gorm"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a single problem detected in the dataset
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Entity   string   `json:"entity"`
+	EntityID string   `json:"entity_id"`
+	Message  string   `json:"message"`
+}
+
+// Report groups all findings of one validation run
+type Report struct {
+	Errors   int       `json:"errors"`
+	Warnings int       `json:"warnings"`
+	Findings []Finding `json:"findings"`
+}
+
+func (r *Report) add(sev Severity, code, entity string, id interface{}, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{
+		Severity: sev,
+		Code:     code,
+		Entity:   entity,
+		EntityID: fmt.Sprint(id),
+		Message:  fmt.Sprintf(format, args...),
+	})
+	if sev == SeverityError {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+}
+
+// HasErrors reports whether the dataset would produce an invalid feed
+func (r *Report) HasErrors() bool {
+	return r.Errors > 0
+}
+
+// Dataset is the snapshot of the models that the checks run over
+type Dataset struct {
+	Agencies    []models.Agency
+	Stops       []models.Stop
+	Routes      []models.Route
+	Trips       []models.Trip
+	TripStops   []models.TripStop
+	ShapePoints []models.ShapePoint
+	Calendars   []models.Calendar
+	Frequencies []models.Frequency
+	Levels      []models.Level
+	Pathways    []models.Pathway
+	Transfers   []models.Transfer
+
+	FareAttributes []models.FareAttribute
+	FareRules      []models.FareRule
+	Areas          []models.Area
+	FareMedia      []models.FareMedia
+	FareProducts   []models.FareProduct
+	FareLegRules   []models.FareLegRule
+}
+
+// Load reads everything the checks need from the database
+func Load(db *gorm.DB) (*Dataset, error) {
+	ds := &Dataset{}
+	if err := db.Find(&ds.Agencies).Error; err != nil {
+		return nil, fmt.Errorf("agencies: %w", err)
+	}
+	if err := db.Find(&ds.Stops).Error; err != nil {
+		return nil, fmt.Errorf("stops: %w", err)
+	}
+	if err := db.Find(&ds.Routes).Error; err != nil {
+		return nil, fmt.Errorf("routes: %w", err)
+	}
+	if err := db.Find(&ds.Trips).Error; err != nil {
+		return nil, fmt.Errorf("trips: %w", err)
+	}
+	if err := db.Order("trip_id, sequence asc").Find(&ds.TripStops).Error; err != nil {
+		return nil, fmt.Errorf("trip stops: %w", err)
+	}
+	if err := db.Order("shape_id, sequence asc").Find(&ds.ShapePoints).Error; err != nil {
+		return nil, fmt.Errorf("shape points: %w", err)
+	}
+	if err := db.Find(&ds.Calendars).Error; err != nil {
+		return nil, fmt.Errorf("calendars: %w", err)
+	}
+	if err := db.Order("trip_id, start_time asc").Find(&ds.Frequencies).Error; err != nil {
+		return nil, fmt.Errorf("frequencies: %w", err)
+	}
+	if err := db.Find(&ds.Levels).Error; err != nil {
+		return nil, fmt.Errorf("levels: %w", err)
+	}
+	if err := db.Order("id").Find(&ds.Pathways).Error; err != nil {
+		return nil, fmt.Errorf("pathways: %w", err)
+	}
+	if err := db.Order("id").Find(&ds.Transfers).Error; err != nil {
+		return nil, fmt.Errorf("transfers: %w", err)
+	}
+	for _, t := range []struct {
+		name string
+		dest interface{}
+	}{
+		{"fare attributes", &ds.FareAttributes},
+		{"fare rules", &ds.FareRules},
+		{"areas", &ds.Areas},
+		{"fare media", &ds.FareMedia},
+		{"fare products", &ds.FareProducts},
+		{"fare leg rules", &ds.FareLegRules},
+	} {
+		if err := db.Order("id").Find(t.dest).Error; err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+	}
+	return ds, nil
+}
+
+// ValidateDB loads the dataset and runs every check over it
+func ValidateDB(db *gorm.DB) (*Report, error) {
+	ds, err := Load(db)
+	if err != nil {
+		return nil, err
+	}
+	return Validate(ds), nil
+}
+
+var hexColor = regexp.MustCompile(`^[0-9A-Fa-f]{6}$`)
+
+// Validate runs every check over ds. Findings are ordered errors first.
+func Validate(ds *Dataset) *Report {
+	report := &Report{Findings: []Finding{}}
+
+	for _, a := range ds.Agencies {
+		if a.Timezone == "" {
+			report.add(SeverityError, "missing_timezone", "agency", a.ID, "Agency [%s] has no timezone.", a.Name)
+		} else if _, err := time.LoadLocation(a.Timezone); err != nil || a.Timezone == "Local" {
+			report.add(SeverityError, "invalid_timezone", "agency", a.ID, "Agency [%s] timezone %q is not a valid IANA timezone.", a.Name, a.Timezone)
+		}
+	}
+
+	stopsByID := make(map[uint]*models.Stop, len(ds.Stops))
+	for i := range ds.Stops {
+		stopsByID[ds.Stops[i].ID] = &ds.Stops[i]
+	}
+	for _, s := range ds.Stops {
+		if s.Lat == 0 && s.Lon == 0 {
+			report.add(SeverityError, "stop_at_null_island", "stop", s.ID, "Stop [%s] has 0/0 coordinates.", s.Name)
+		}
+		var parent *models.Stop
+		if s.ParentStation != nil {
+			if parent = stopsByID[*s.ParentStation]; parent == nil {
+				report.add(SeverityError, "unknown_parent_station", "stop", s.ID, "Stop [%s] references parent station #%d which does not exist.", s.Name, *s.ParentStation)
+				continue
+			}
+		}
+		if err := CheckParentStation(s, parent); err != nil {
+			report.add(SeverityError, "invalid_parent_station", "stop", s.ID, "Stop [%s]: %v.", s.Name, err)
+		}
+	}
+
+	checkPathways(report, ds, stopsByID)
+
+	tripsPerRoute := make(map[uint]int)
+	for _, t := range ds.Trips {
+		tripsPerRoute[t.RouteID]++
+	}
+	for _, r := range ds.Routes {
+		if r.Color != "" && !hexColor.MatchString(r.Color) {
+			report.add(SeverityError, "invalid_color", "route", r.ID, "Route [%s] color %q is not a 6-digit hex value.", r.ShortName, r.Color)
+		}
+		if r.TextColor != nil && *r.TextColor != "" && !hexColor.MatchString(*r.TextColor) {
+			report.add(SeverityError, "invalid_color", "route", r.ID, "Route [%s] text color %q is not a 6-digit hex value.", r.ShortName, *r.TextColor)
+		}
+		if tripsPerRoute[r.ID] == 0 {
+			report.add(SeverityWarning, "route_without_trips", "route", r.ID, "Route [%s] has no trips and will not appear in journey planners.", r.ShortName)
+		}
+	}
+
+	services := make(map[string]bool)
+	for _, cal := range ds.Calendars {
+		services[cal.ServiceID] = true
+		start, errStart := gtfs.ParseDate(cal.StartDate)
+		end, errEnd := gtfs.ParseDate(cal.EndDate)
+		if errStart != nil || errEnd != nil || end.Before(start) {
+			report.add(SeverityError, "invalid_calendar_range", "calendar", cal.ServiceID, "Service [%s] has an invalid date range %s-%s.", cal.ServiceID, cal.StartDate, cal.EndDate)
+		}
+	}
+
+	shapes := make(map[string]bool)
+	for _, p := range ds.ShapePoints {
+		shapes[p.ShapeID] = true
+	}
+	stopsPerTrip := make(map[uint][]models.TripStop)
+	for _, ts := range ds.TripStops {
+		stopsPerTrip[ts.TripID] = append(stopsPerTrip[ts.TripID], ts)
+		if s := stopsByID[ts.StopID]; s != nil && s.LocationType != models.LocationStop {
+			report.add(SeverityError, "trip_serves_station", "trip_stop", ts.ID, "Trip #%d serves [%s], which is a %s rather than a stop or platform.", ts.TripID, s.Name, LocationTypeName(s.LocationType))
+		}
+	}
+	for _, t := range ds.Trips {
+		if t.ShapeID != "" && !shapes[t.ShapeID] {
+			report.add(SeverityError, "missing_shape", "trip", t.ID, "Trip #%d references shape %q which has no points.", t.ID, t.ShapeID)
+		}
+		if !services[t.ServiceID] {
+			report.add(SeverityError, "unknown_service", "trip", t.ID, "Trip #%d runs on service %q which has no calendar.", t.ID, t.ServiceID)
+		}
+		stops := stopsPerTrip[t.ID]
+		if len(stops) == 0 {
+			report.add(SeverityError, "trip_without_stops", "trip", t.ID, "Trip #%d has no stops assigned.", t.ID)
+			continue
+		}
+		checkStopTimes(report, t, stops)
+	}
+
+	routes := RouteIDs(ds.Routes)
+	trips := TripIDs(ds.Trips)
+	frequenciesPerTrip := make(map[uint][]models.Frequency)
+	for _, f := range ds.Frequencies {
+		if !known(trips, f.TripID) {
+			report.add(SeverityError, "unknown_frequency_trip", "frequency", f.ID, "Frequency #%d runs trip #%d which does not exist.", f.ID, f.TripID)
+			continue
+		}
+		if err := CheckFrequencyOverlap(f, frequenciesPerTrip[f.TripID]); err != nil {
+			report.add(SeverityError, "overlapping_frequencies", "frequency", f.ID, "Trip #%d frequency %v.", f.TripID, err)
+		}
+		frequenciesPerTrip[f.TripID] = append(frequenciesPerTrip[f.TripID], f)
+	}
+
+	for _, tr := range ds.Transfers {
+		if err := CheckTransfer(tr); err != nil {
+			report.add(SeverityError, "invalid_transfer", "transfer", tr.ID, "Transfer #%d: %v.", tr.ID, err)
+			continue
+		}
+		for _, ref := range []struct {
+			id    *uint
+			known bool
+			what  string
+		}{
+			{tr.FromStopID, tr.FromStopID == nil || stopsByID[*tr.FromStopID] != nil, "from stop"},
+			{tr.ToStopID, tr.ToStopID == nil || stopsByID[*tr.ToStopID] != nil, "to stop"},
+			{tr.FromRouteID, tr.FromRouteID == nil || known(routes, *tr.FromRouteID), "from route"},
+			{tr.ToRouteID, tr.ToRouteID == nil || known(routes, *tr.ToRouteID), "to route"},
+			{tr.FromTripID, tr.FromTripID == nil || known(trips, *tr.FromTripID), "from trip"},
+			{tr.ToTripID, tr.ToTripID == nil || known(trips, *tr.ToTripID), "to trip"},
+		} {
+			if !ref.known {
+				report.add(SeverityError, "unknown_transfer_reference", "transfer", tr.ID, "Transfer #%d references %s #%d which does not exist.", tr.ID, ref.what, *ref.id)
+			}
+		}
+	}
+
+	checkFares(report, ds, routes)
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Severity == SeverityError && report.Findings[j].Severity != SeverityError
+	})
+	return report
+}
+
+// LocationTypeName is how a stops.txt location_type reads in messages
+func LocationTypeName(t int) string {
+	switch t {
+	case models.LocationStop:
+		return "stop"
+	case models.LocationStation:
+		return "station"
+	case models.LocationEntrance:
+		return "entrance"
+	case models.LocationGenericNode:
+		return "generic node"
+	case models.LocationBoardingArea:
+		return "boarding area"
+	}
+	return fmt.Sprintf("location type %d", t)
+}
+
+// CheckParentStation checks the place of s in the station hierarchy given
+// its parent, nil when it has none. Stations stand alone, stops may belong
+// to a station, entrances and generic nodes must, and boarding areas belong
+// to a platform.
+func CheckParentStation(s models.Stop, parent *models.Stop) error {
+	need := -1
+	switch s.LocationType {
+	case models.LocationStop:
+		if parent != nil {
+			need = models.LocationStation
+		}
+	case models.LocationStation:
+		if parent != nil {
+			return fmt.Errorf("a station cannot have a parent station")
+		}
+	case models.LocationEntrance, models.LocationGenericNode:
+		need = models.LocationStation
+	case models.LocationBoardingArea:
+		need = models.LocationStop
+	default:
+		return fmt.Errorf("unknown location_type %d", s.LocationType)
+	}
+	if need < 0 {
+		return nil
+	}
+	if parent == nil {
+		return fmt.Errorf("a %s needs a parent %s", LocationTypeName(s.LocationType), parentName(need))
+	}
+	if parent.LocationType != need {
+		return fmt.Errorf("the parent of a %s must be a %s, not a %s", LocationTypeName(s.LocationType), parentName(need), LocationTypeName(parent.LocationType))
+	}
+	return nil
+}
+
+// parentName calls the stops that boarding areas belong to platforms
+func parentName(t int) string {
+	if t == models.LocationStop {
+		return "platform"
+	}
+	return LocationTypeName(t)
+}
+
+// CheckTransfer checks that a transfer names what its type needs: both
+// stops for changes between stops, both trips for staying on board, and a
+// time for minimum-time transfers
+func CheckTransfer(t models.Transfer) error {
+	switch t.Type {
+	case models.TransferRecommended, models.TransferTimed, models.TransferMinTime, models.TransferNotPossible:
+		if t.FromStopID == nil || t.ToStopID == nil {
+			return fmt.Errorf("transfer_type %d needs from_stop_id and to_stop_id", t.Type)
+		}
+	case models.TransferInSeat, models.TransferReboard:
+		if t.FromTripID == nil || t.ToTripID == nil {
+			return fmt.Errorf("transfer_type %d needs from_trip_id and to_trip_id", t.Type)
+		}
+	default:
+		return fmt.Errorf("unknown transfer_type %d", t.Type)
+	}
+	if t.Type == models.TransferMinTime && t.MinTransferTime == nil {
+		return fmt.Errorf("a minimum-time transfer needs min_transfer_time")
+	}
+	if t.MinTransferTime != nil && *t.MinTransferTime < 0 {
+		return fmt.Errorf("min_transfer_time must not be negative")
+	}
+	return nil
+}
+
+// CheckFrequencyOverlap checks that the window of f overlaps none of the
+// other frequencies of its trip. Windows may touch: one can end at the time
+// the next starts.
+func CheckFrequencyOverlap(f models.Frequency, others []models.Frequency) error {
+	start, _ := gtfs.ParseTime(f.StartTime)
+	end, _ := gtfs.ParseTime(f.EndTime)
+	for _, o := range others {
+		if o.TripID != f.TripID || (f.ID != 0 && o.ID == f.ID) {
+			continue
+		}
+		oStart, _ := gtfs.ParseTime(o.StartTime)
+		oEnd, _ := gtfs.ParseTime(o.EndTime)
+		if start < oEnd && oStart < end {
+			return fmt.Errorf("%s-%s overlaps %s-%s of frequency #%d", f.StartTime, f.EndTime, o.StartTime, o.EndTime, o.ID)
+		}
+	}
+	return nil
+}
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// CheckFareAttribute checks a Fares v1 fare on its own
+func CheckFareAttribute(f models.FareAttribute) error {
+	if f.Price < 0 {
+		return fmt.Errorf("price must not be negative")
+	}
+	if !currencyCode.MatchString(f.CurrencyType) {
+		return fmt.Errorf("currency_type %q is not an ISO 4217 code such as EUR", f.CurrencyType)
+	}
+	if f.PaymentMethod != models.PayOnBoard && f.PaymentMethod != models.PayBeforeBoarding {
+		return fmt.Errorf("payment_method must be 0 (on board) or 1 (before boarding)")
+	}
+	if f.Transfers != nil && (*f.Transfers < 0 || *f.Transfers > 2) {
+		return fmt.Errorf("transfers must be 0, 1, 2 or unset for unlimited")
+	}
+	if f.TransferDuration != nil && *f.TransferDuration < 0 {
+		return fmt.Errorf("transfer_duration must not be negative")
+	}
+	return nil
+}
+
+// CheckFareProduct checks the price of a Fares v2 product
+func CheckFareProduct(p models.FareProduct) error {
+	if p.Amount < 0 {
+		return fmt.Errorf("amount must not be negative")
+	}
+	if !currencyCode.MatchString(p.Currency) {
+		return fmt.Errorf("currency %q is not an ISO 4217 code such as EUR", p.Currency)
+	}
+	return nil
+}
+
+// CheckFareMedia checks the type of a fare medium
+func CheckFareMedia(m models.FareMedia) error {
+	if m.Type < models.FareMediaNone || m.Type > models.MaxFareMediaType {
+		return fmt.Errorf("fare_media_type must be between %d and %d", models.FareMediaNone, models.MaxFareMediaType)
+	}
+	return nil
+}
+
+// RouteIDs maps the published ID of each route to its ID in the dataset.
+// Transfers and fare rules are not drafted, so inside a draft they still
+// reference the published route a copy was made from.
+func RouteIDs(routes []models.Route) map[uint]uint {
+	ids := make(map[uint]uint, len(routes))
+	for _, r := range routes {
+		if r.OriginID != nil {
+			ids[*r.OriginID] = r.ID
+		} else {
+			ids[r.ID] = r.ID
+		}
+	}
+	return ids
+}
+
+// TripIDs maps the published ID of each trip to its ID in the dataset, as
+// RouteIDs does for routes. Frequencies are not drafted either.
+func TripIDs(trips []models.Trip) map[uint]uint {
+	ids := make(map[uint]uint, len(trips))
+	for _, t := range trips {
+		if t.OriginID != nil {
+			ids[*t.OriginID] = t.ID
+		} else {
+			ids[t.ID] = t.ID
+		}
+	}
+	return ids
+}
+
+func known(ids map[uint]uint, id uint) bool {
+	_, ok := ids[id]
+	return ok
+}
+
+// checkFares checks that fares only point at fares, routes, zones, areas,
+// networks and products the feed has
+func checkFares(report *Report, ds *Dataset, routes map[uint]uint) {
+	zones := make(map[string]bool)
+	for _, s := range ds.Stops {
+		if s.ZoneID != "" {
+			zones[s.ZoneID] = true
+		}
+	}
+	networks := make(map[string]bool)
+	for _, r := range ds.Routes {
+		if r.NetworkID != "" {
+			networks[r.NetworkID] = true
+		}
+	}
+	agencies := make(map[uint]bool, len(ds.Agencies))
+	for _, a := range ds.Agencies {
+		agencies[a.ID] = true
+	}
+
+	fares := make(map[uint]bool, len(ds.FareAttributes))
+	for _, f := range ds.FareAttributes {
+		fares[f.ID] = true
+		if err := CheckFareAttribute(f); err != nil {
+			report.add(SeverityError, "invalid_fare", "fare_attribute", f.ID, "Fare #%d: %v.", f.ID, err)
+		}
+		if f.AgencyID != nil && !agencies[*f.AgencyID] {
+			report.add(SeverityError, "unknown_fare_agency", "fare_attribute", f.ID, "Fare #%d belongs to agency #%d which does not exist.", f.ID, *f.AgencyID)
+		}
+	}
+	for _, r := range ds.FareRules {
+		if !fares[r.FareID] {
+			report.add(SeverityError, "unknown_fare", "fare_rule", r.ID, "Fare rule #%d applies fare #%d which does not exist.", r.ID, r.FareID)
+		}
+		if r.RouteID != nil && !known(routes, *r.RouteID) {
+			report.add(SeverityError, "unknown_fare_route", "fare_rule", r.ID, "Fare rule #%d references route #%d which does not exist.", r.ID, *r.RouteID)
+		}
+		for _, zone := range []string{r.OriginID, r.DestinationID, r.ContainsID} {
+			if zone != "" && !zones[zone] {
+				report.add(SeverityWarning, "unknown_fare_zone", "fare_rule", r.ID, "Fare rule #%d references zone %q which no stop is in.", r.ID, zone)
+			}
+		}
+	}
+
+	media := make(map[uint]bool, len(ds.FareMedia))
+	for _, m := range ds.FareMedia {
+		media[m.ID] = true
+		if err := CheckFareMedia(m); err != nil {
+			report.add(SeverityError, "invalid_fare_media", "fare_media", m.ID, "Fare media [%s]: %v.", m.Name, err)
+		}
+	}
+	products := make(map[uint]bool, len(ds.FareProducts))
+	for _, p := range ds.FareProducts {
+		products[p.ID] = true
+		if err := CheckFareProduct(p); err != nil {
+			report.add(SeverityError, "invalid_fare_product", "fare_product", p.ID, "Fare product [%s]: %v.", p.Name, err)
+		}
+		if p.FareMediaID != nil && !media[*p.FareMediaID] {
+			report.add(SeverityError, "unknown_fare_media", "fare_product", p.ID, "Fare product [%s] is sold on fare media #%d which does not exist.", p.Name, *p.FareMediaID)
+		}
+	}
+	areas := make(map[uint]bool, len(ds.Areas))
+	for _, a := range ds.Areas {
+		areas[a.ID] = true
+		if !zones[a.ZoneID] {
+			report.add(SeverityWarning, "empty_area", "area", a.ID, "Area [%s] has no stops: no stop is in zone %q.", a.Name, a.ZoneID)
+		}
+	}
+	for _, r := range ds.FareLegRules {
+		if !products[r.FareProductID] {
+			report.add(SeverityError, "unknown_fare_product", "fare_leg_rule", r.ID, "Fare leg rule #%d sells fare product #%d which does not exist.", r.ID, r.FareProductID)
+		}
+		for _, id := range []*uint{r.FromAreaID, r.ToAreaID} {
+			if id != nil && !areas[*id] {
+				report.add(SeverityError, "unknown_area", "fare_leg_rule", r.ID, "Fare leg rule #%d references area #%d which does not exist.", r.ID, *id)
+			}
+		}
+		if r.NetworkID != "" && !networks[r.NetworkID] {
+			report.add(SeverityWarning, "unknown_fare_network", "fare_leg_rule", r.ID, "Fare leg rule #%d applies to network %q which no route is in.", r.ID, r.NetworkID)
+		}
+	}
+}
+
+// CheckPathway checks a pathway between from and to, the stops at its ends
+func CheckPathway(p models.Pathway, from, to models.Stop) error {
+	if p.Mode < models.PathwayWalkway || p.Mode > models.MaxPathwayMode {
+		return fmt.Errorf("unknown pathway_mode %d", p.Mode)
+	}
+	if from.ID == to.ID {
+		return fmt.Errorf("a pathway must link two different locations")
+	}
+	for _, s := range []models.Stop{from, to} {
+		if s.LocationType == models.LocationStation {
+			return fmt.Errorf("pathways link the locations inside station [%s], not the station itself", s.Name)
+		}
+	}
+	if (p.Length != nil && *p.Length < 0) || (p.TraversalTime != nil && *p.TraversalTime <= 0) || (p.MinWidth != nil && *p.MinWidth <= 0) {
+		return fmt.Errorf("length must not be negative, traversal_time and min_width must be positive")
+	}
+	if p.StairCount != nil && *p.StairCount != 0 && p.Mode != models.PathwayStairs {
+		return fmt.Errorf("only stairs have a stair_count")
+	}
+	if p.IsBidirectional && p.Mode == models.PathwayExitGate {
+		return fmt.Errorf("an exit gate only leads out")
+	}
+	return nil
+}
+
+// PathwayStepFree reports whether p can be used in a wheelchair
+func PathwayStepFree(p models.Pathway) bool {
+	if p.Wheelchair != nil {
+		return *p.Wheelchair
+	}
+	return p.Mode != models.PathwayStairs && p.Mode != models.PathwayEscalator
+}
+
+// StationOf is the station a location belongs to, directly or, for a
+// boarding area, through its platform. It is 0 for stops outside stations.
+func StationOf(s models.Stop, stops map[uint]*models.Stop) uint {
+	for depth := 0; s.ParentStation != nil && depth < 2; depth++ {
+		parent := stops[*s.ParentStation]
+		if parent == nil {
+			return 0
+		}
+		if parent.LocationType == models.LocationStation {
+			return parent.ID
+		}
+		s = *parent
+	}
+	return 0
+}
+
+// Reachable walks the pathways from the given locations and returns every
+// location reached, start included. stepFree only follows pathways usable
+// in a wheelchair.
+func Reachable(pathways []models.Pathway, from []uint, stepFree bool) map[uint]bool {
+	next := make(map[uint][]uint)
+	for _, p := range pathways {
+		if stepFree && !PathwayStepFree(p) {
+			continue
+		}
+		next[p.FromStopID] = append(next[p.FromStopID], p.ToStopID)
+		if p.IsBidirectional {
+			next[p.ToStopID] = append(next[p.ToStopID], p.FromStopID)
+		}
+	}
+	reached := make(map[uint]bool)
+	queue := append([]uint(nil), from...)
+	for _, id := range from {
+		reached[id] = true
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, to := range next[id] {
+			if !reached[to] {
+				reached[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	return reached
+}
+
+// checkPathways checks each pathway and, in every station that has
+// pathways, that each platform can be reached from an entrance
+func checkPathways(report *Report, ds *Dataset, stops map[uint]*models.Stop) {
+	levels := make(map[uint]bool, len(ds.Levels))
+	for _, l := range ds.Levels {
+		levels[l.ID] = true
+	}
+	for _, s := range ds.Stops {
+		if s.LevelID != nil && !levels[*s.LevelID] {
+			report.add(SeverityError, "unknown_level", "stop", s.ID, "Stop [%s] is on level #%d which does not exist.", s.Name, *s.LevelID)
+		}
+	}
+
+	withPathways := make(map[uint]bool)
+	for _, p := range ds.Pathways {
+		from, to := stops[p.FromStopID], stops[p.ToStopID]
+		if from == nil || to == nil {
+			report.add(SeverityError, "unknown_pathway_stop", "pathway", p.ID, "Pathway #%d links a stop that does not exist.", p.ID)
+			continue
+		}
+		if err := CheckPathway(p, *from, *to); err != nil {
+			report.add(SeverityError, "invalid_pathway", "pathway", p.ID, "Pathway #%d: %v.", p.ID, err)
+			continue
+		}
+		station := StationOf(*from, stops)
+		if station == 0 || station != StationOf(*to, stops) {
+			report.add(SeverityError, "pathway_across_stations", "pathway", p.ID, "Pathway #%d links [%s] and [%s], which are not in the same station.", p.ID, from.Name, to.Name)
+			continue
+		}
+		withPathways[station] = true
+	}
+	if len(withPathways) == 0 {
+		return
+	}
+
+	var entrances []uint
+	for _, s := range ds.Stops {
+		if s.LocationType == models.LocationEntrance && withPathways[StationOf(s, stops)] {
+			entrances = append(entrances, s.ID)
+		}
+	}
+	// A platform with boarding areas is reached through them
+	areas := make(map[uint][]uint)
+	for _, s := range ds.Stops {
+		if s.LocationType == models.LocationBoardingArea && s.ParentStation != nil {
+			areas[*s.ParentStation] = append(areas[*s.ParentStation], s.ID)
+		}
+	}
+	reached := Reachable(ds.Pathways, entrances, false)
+	stepFree := Reachable(ds.Pathways, entrances, true)
+	within := func(set map[uint]bool, id uint) bool {
+		if set[id] {
+			return true
+		}
+		for _, a := range areas[id] {
+			if set[a] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, s := range ds.Stops {
+		if s.LocationType != models.LocationStop || !withPathways[StationOf(s, stops)] {
+			continue
+		}
+		switch {
+		case !within(reached, s.ID):
+			report.add(SeverityError, "unreachable_platform", "stop", s.ID, "Platform [%s] cannot be reached from any entrance of its station.", s.Name)
+		case !within(stepFree, s.ID):
+			report.add(SeverityWarning, "no_step_free_route", "stop", s.ID, "Platform [%s] can only be reached by stairs or escalators.", s.Name)
+		}
+	}
+}
+
+// checkStopTimes flags times that cannot be parsed or that run backwards
+// along the stop sequence of a trip.
+func checkStopTimes(report *Report, t models.Trip, stops []models.TripStop) {
+	sort.SliceStable(stops, func(i, j int) bool { return stops[i].Sequence < stops[j].Sequence })
+
+	last := -1
+	for _, ts := range stops {
+		for _, v := range []string{ts.ArrivalTime, ts.DepartureTime} {
+			if v == "" {
+				continue
+			}
+			secs, err := gtfs.ParseTime(v)
+			if err != nil {
+				report.add(SeverityError, "invalid_time", "trip_stop", ts.ID, "Trip #%d stop #%d (sequence %d) has an invalid time %q.", t.ID, ts.StopID, ts.Sequence, v)
+				continue
+			}
+			if secs < last {
+				report.add(SeverityError, "time_travel", "trip_stop", ts.ID, "Trip #%d goes backwards in time at sequence %d (%s).", t.ID, ts.Sequence, v)
+			}
+			last = secs
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package validator
+
+import (
+	"gtfs-cms/models"
+	"testing"
+)
+
+func codes(r *Report) map[string]int {
+	m := make(map[string]int)
+	for _, f := range r.Findings {
+		m[f.Code]++
+	}
+	return m
+}
+
+func TestValidateCleanDataset(t *testing.T) {
+	ds := &Dataset{
+		Agencies:    []models.Agency{{ID: 1, Name: "Trans", Timezone: "Asia/Jakarta"}},
+		Stops:       []models.Stop{{ID: 1, Name: "A", Lat: -7.4, Lon: 109.3}},
+		Routes:      []models.Route{{ID: 1, ShortName: "K1", Color: "007AFF", AgencyID: 1}},
+		Trips:       []models.Trip{{ID: 1, RouteID: 1, ShapeID: "S1", ServiceID: "DAILY"}},
+		TripStops:   []models.TripStop{{ID: 1, TripID: 1, StopID: 1, Sequence: 1, ArrivalTime: "08:00:00", DepartureTime: "08:00:00"}},
+		ShapePoints: []models.ShapePoint{{ShapeID: "S1", Lat: -7.4, Lon: 109.3}},
+		Calendars:   []models.Calendar{{ServiceID: "DAILY", StartDate: "20250101", EndDate: "20261231"}},
+	}
+	if r := Validate(ds); len(r.Findings) != 0 {
+		t.Fatalf("expected no findings, got %+v", r.Findings)
+	}
+}
+
+func TestValidateFindings(t *testing.T) {
+	ds := &Dataset{
+		Agencies: []models.Agency{{ID: 1, Name: "Trans", Timezone: "Mars/Olympus"}},
+		Stops:    []models.Stop{{ID: 1, Name: "Null"}},
+		Routes: []models.Route{
+			{ID: 1, ShortName: "K1", Color: "#00FF00"},
+			{ID: 2, ShortName: "K2", Color: "00FF00"},
+		},
+		Trips: []models.Trip{
+			{ID: 1, RouteID: 1, ShapeID: "ghost", ServiceID: "DAILY"},
+			{ID: 2, RouteID: 1, ServiceID: "HOLIDAY"},
+		},
+		Calendars: []models.Calendar{{ServiceID: "DAILY", StartDate: "20261231", EndDate: "20250101"}},
+		TripStops: []models.TripStop{
+			{ID: 1, TripID: 1, StopID: 1, Sequence: 1, ArrivalTime: "08:10:00", DepartureTime: "08:10:00"},
+			{ID: 2, TripID: 1, StopID: 1, Sequence: 2, ArrivalTime: "08:05:00", DepartureTime: "08:05:00"},
+		},
+	}
+
+	r := Validate(ds)
+	got := codes(r)
+	want := map[string]int{
+		"invalid_timezone":       1,
+		"stop_at_null_island":    1,
+		"invalid_color":          1,
+		"route_without_trips":    1,
+		"missing_shape":          1,
+		"trip_without_stops":     1,
+		"time_travel":            1,
+		"unknown_service":        1,
+		"invalid_calendar_range": 1,
+	}
+	for code, n := range want {
+		if got[code] != n {
+			t.Errorf("code %s: got %d findings, want %d", code, got[code], n)
+		}
+	}
+	if r.Warnings != 1 || !r.HasErrors() {
+		t.Errorf("unexpected totals: %d errors, %d warnings", r.Errors, r.Warnings)
+	}
+	if r.Findings[len(r.Findings)-1].Severity != SeverityWarning {
+		t.Errorf("warnings should be ordered after errors")
+	}
+}
+
+func TestStationHierarchy(t *testing.T) {
+	parent := func(id uint) *uint { return &id }
+	ds := &Dataset{
+		Stops: []models.Stop{
+			{ID: 1, Name: "Terminal", Lat: 1, Lon: 1, LocationType: models.LocationStation},
+			{ID: 2, Name: "Bay A", Lat: 1, Lon: 1, ParentStation: parent(1), PlatformCode: "A"},
+			{ID: 3, Name: "North gate", Lat: 1, Lon: 1, LocationType: models.LocationEntrance, ParentStation: parent(1)},
+			{ID: 4, Name: "Bay A front", Lat: 1, Lon: 1, LocationType: models.LocationBoardingArea, ParentStation: parent(2)},
+			{ID: 5, Name: "Bay B", Lat: 1, Lon: 1, ParentStation: parent(2)},
+			{ID: 6, Name: "Lost gate", Lat: 1, Lon: 1, LocationType: models.LocationEntrance},
+			{ID: 7, Name: "Orphan", Lat: 1, Lon: 1, ParentStation: parent(99)},
+		},
+		TripStops: []models.TripStop{{ID: 1, TripID: 1, StopID: 1, Sequence: 1}},
+	}
+	got := codes(Validate(ds))
+	if got["invalid_parent_station"] != 2 || got["unknown_parent_station"] != 1 || got["trip_serves_station"] != 1 {
+		t.Errorf("findings = %v, want bay B and the lost gate misplaced, the orphan unknown and the trip serving a station", got)
+	}
+
+	station := models.Stop{LocationType: models.LocationStation}
+	if err := CheckParentStation(models.Stop{LocationType: models.LocationStation}, &station); err == nil {
+		t.Error("a station inside a station should be rejected")
+	}
+	if err := CheckParentStation(models.Stop{LocationType: models.LocationBoardingArea}, &station); err == nil {
+		t.Error("a boarding area should need a platform")
+	}
+	if err := CheckParentStation(models.Stop{LocationType: 9}, nil); err == nil {
+		t.Error("unknown location types should be rejected")
+	}
+}
+
+func TestPathways(t *testing.T) {
+	parent := func(id uint) *uint { return &id }
+	ds := &Dataset{
+		Stops: []models.Stop{
+			{ID: 1, Name: "Terminal", Lat: 1, Lon: 1, LocationType: models.LocationStation},
+			{ID: 2, Name: "Gate", Lat: 1, Lon: 1, LocationType: models.LocationEntrance, ParentStation: parent(1)},
+			{ID: 3, Name: "Hall", Lat: 1, Lon: 1, LocationType: models.LocationGenericNode, ParentStation: parent(1)},
+			{ID: 4, Name: "Bay A", Lat: 1, Lon: 1, ParentStation: parent(1)},
+			{ID: 5, Name: "Bay B", Lat: 1, Lon: 1, ParentStation: parent(1)},
+			{ID: 6, Name: "Bay C", Lat: 1, Lon: 1, ParentStation: parent(1)},
+			{ID: 7, Name: "Bay C front", Lat: 1, Lon: 1, LocationType: models.LocationBoardingArea, ParentStation: parent(6)},
+			{ID: 8, Name: "Bay D", Lat: 1, Lon: 1, ParentStation: parent(1)},
+			{ID: 9, Name: "Elsewhere", Lat: 1, Lon: 1},
+		},
+		Pathways: []models.Pathway{
+			{ID: 1, FromStopID: 2, ToStopID: 3, Mode: models.PathwayWalkway, IsBidirectional: true},
+			{ID: 2, FromStopID: 3, ToStopID: 4, Mode: models.PathwayElevator, IsBidirectional: true},
+			{ID: 3, FromStopID: 3, ToStopID: 5, Mode: models.PathwayStairs, IsBidirectional: true},
+			{ID: 4, FromStopID: 3, ToStopID: 7, Mode: models.PathwayWalkway},
+			{ID: 5, FromStopID: 8, ToStopID: 3, Mode: models.PathwayWalkway}, // one way out of bay D
+			{ID: 6, FromStopID: 3, ToStopID: 9, Mode: models.PathwayWalkway},
+			{ID: 7, FromStopID: 1, ToStopID: 3, Mode: models.PathwayWalkway},
+		},
+	}
+	r := Validate(ds)
+	got := codes(r)
+	want := map[string]int{"unreachable_platform": 1, "no_step_free_route": 1, "pathway_across_stations": 1, "invalid_pathway": 1}
+	for code, n := range want {
+		if got[code] != n {
+			t.Errorf("code %s: got %d findings, want %d (all: %v)", code, got[code], n, got)
+		}
+	}
+	for _, f := range r.Findings {
+		if f.Code == "unreachable_platform" && f.EntityID != "8" || f.Code == "no_step_free_route" && f.EntityID != "5" {
+			t.Errorf("finding %+v is about the wrong platform", f)
+		}
+	}
+}
+
+func TestTransfers(t *testing.T) {
+	id := func(v uint) *uint { return &v }
+	secs := 120
+	ds := &Dataset{
+		Stops: []models.Stop{{ID: 1, Name: "A", Lat: 1, Lon: 1}, {ID: 2, Name: "B", Lat: 1, Lon: 1}},
+		Transfers: []models.Transfer{
+			{ID: 1, FromStopID: id(1), ToStopID: id(2), Type: models.TransferMinTime, MinTransferTime: &secs},
+			{ID: 2, FromStopID: id(1), ToStopID: id(2), Type: models.TransferMinTime},
+			{ID: 3, FromStopID: id(1), ToStopID: id(9)},
+			{ID: 4, FromTripID: id(5), ToTripID: id(6), Type: models.TransferInSeat},
+			{ID: 5, FromStopID: id(1), ToStopID: id(2), Type: 8},
+		},
+	}
+	got := codes(Validate(ds))
+	if got["invalid_transfer"] != 2 || got["unknown_transfer_reference"] != 3 {
+		t.Errorf("findings = %v, want transfers 2 and 5 invalid and three unknown references", got)
+	}
+}
+
+func TestFrequencyOverlap(t *testing.T) {
+	origin := uint(2)
+	ds := &Dataset{
+		// Trip 5 is the draft copy of trip 2
+		Trips: []models.Trip{{ID: 1}, {ID: 5, OriginID: &origin}},
+		Frequencies: []models.Frequency{
+			{ID: 1, TripID: 1, StartTime: "06:00:00", EndTime: "09:00:00", HeadwaySecs: 600},
+			{ID: 2, TripID: 1, StartTime: "08:30:00", EndTime: "10:00:00", HeadwaySecs: 600},
+			{ID: 3, TripID: 1, StartTime: "10:00:00", EndTime: "12:00:00", HeadwaySecs: 900},
+			{ID: 4, TripID: 2, StartTime: "07:00:00", EndTime: "08:00:00", HeadwaySecs: 600},
+			{ID: 5, TripID: 9, StartTime: "07:00:00", EndTime: "08:00:00", HeadwaySecs: 600},
+		},
+	}
+	r := Validate(ds)
+	if got := codes(r)["overlapping_frequencies"]; got != 1 {
+		t.Fatalf("got %d overlapping_frequencies findings, want 1", got)
+	}
+	if got := codes(r)["unknown_frequency_trip"]; got != 1 {
+		t.Errorf("got %d unknown_frequency_trip findings, want 1", got)
+	}
+	for _, f := range r.Findings {
+		if f.Code == "overlapping_frequencies" && f.EntityID != "2" {
+			t.Errorf("finding for frequency %s, want 2", f.EntityID)
+		}
+	}
+}
+
+func TestFares(t *testing.T) {
+	id := func(v uint) *uint { return &v }
+	ds := &Dataset{
+		Stops:  []models.Stop{{ID: 1, Name: "A", Lat: 1, Lon: 1, ZoneID: "1"}, {ID: 2, Name: "B", Lat: 1, Lon: 1, ZoneID: "2"}},
+		Routes: []models.Route{{ID: 1, ShortName: "10", Color: "FF0000", NetworkID: "city"}},
+		FareAttributes: []models.FareAttribute{
+			{ID: 1, Price: 2.5, CurrencyType: "EUR"},
+			{ID: 2, Price: 2.5, CurrencyType: "euro"},
+		},
+		FareRules: []models.FareRule{
+			{ID: 1, FareID: 1, RouteID: id(1)},
+			{ID: 2, FareID: 1, OriginID: "1", DestinationID: "3"},
+			{ID: 3, FareID: 7, RouteID: id(9)},
+		},
+		Areas:        []models.Area{{ID: 1, Name: "Centre", ZoneID: "1"}},
+		FareMedia:    []models.FareMedia{{ID: 1, Name: "Card", Type: models.FareMediaTransitCard}},
+		FareProducts: []models.FareProduct{{ID: 1, Name: "Single", FareMediaID: id(1), Amount: 2, Currency: "EUR"}},
+		FareLegRules: []models.FareLegRule{
+			{ID: 1, NetworkID: "city", FromAreaID: id(1), ToAreaID: id(1), FareProductID: 1},
+			{ID: 2, NetworkID: "regio", FromAreaID: id(4), FareProductID: 3},
+		},
+	}
+	got := codes(Validate(ds))
+	want := map[string]int{
+		"invalid_fare": 1, "unknown_fare": 1, "unknown_fare_route": 1, "unknown_fare_zone": 1,
+		"unknown_fare_product": 1, "unknown_area": 1, "unknown_fare_network": 1,
+	}
+	for code, n := range want {
+		if got[code] != n {
+			t.Errorf("%s: got %d findings, want %d (all: %v)", code, got[code], n, got)
+		}
+	}
+	if got["empty_area"] != 0 || got["invalid_fare_product"] != 0 || got["invalid_fare_media"] != 0 {
+		t.Errorf("findings = %v, want the valid area, product and media accepted", got)
+	}
+}
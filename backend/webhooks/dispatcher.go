@@ -0,0 +1,196 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"gtfs-cms/database"
+	"gtfs-cms/events"
+	"gtfs-cms/models"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Dispatcher turns published events into deliveries and sends them
+type Dispatcher struct {
+	DB       *gorm.DB
+	Broker   *events.Broker
+	Client   *http.Client
+	Workers  int
+	Interval time.Duration // how often due retries are looked up
+
+	queue chan uint
+}
+
+func NewDispatcher(db *gorm.DB, broker *events.Broker) *Dispatcher {
+	return &Dispatcher{
+		DB:       db,
+		Broker:   broker,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		Workers:  4,
+		Interval: 5 * time.Second,
+		queue:    make(chan uint, 256),
+	}
+}
+
+// claimLease keeps a claimed delivery from being sent twice while its
+// attempt is in flight; it outlasts the client timeout
+const claimLease = time.Minute
+
+// Run delivers until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	log.Printf("Webhook dispatcher started with %d workers.", d.Workers)
+	for i := 0; i < d.Workers; i++ {
+		go d.work(ctx)
+	}
+	go d.listen(ctx)
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		if err := d.queueDue(time.Now()); err != nil {
+			log.Printf("Webhook dispatcher: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// listen records a delivery for every published event. A dropped
+// subscription resumes after the last event seen.
+func (d *Dispatcher) listen(ctx context.Context) {
+	var lastID uint64
+	for ctx.Err() == nil {
+		sub, missed := d.Broker.Subscribe(0, 0, lastID)
+		for _, e := range missed {
+			d.record(e)
+			lastID = e.ID
+		}
+	stream:
+		for {
+			select {
+			case <-ctx.Done():
+				d.Broker.Unsubscribe(sub)
+				return
+			case e, ok := <-sub.C:
+				if !ok {
+					break stream
+				}
+				d.record(e)
+				lastID = e.ID
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) record(e events.Event) {
+	name := Name(e)
+	if name == "" {
+		return
+	}
+	db := d.DB.WithContext(database.WithWorkspace(context.Background(), e.WorkspaceID))
+	ids, err := Enqueue(db, name, e.Time, e, nil)
+	if err != nil {
+		log.Printf("Webhook dispatcher: event %d: %v", e.ID, err)
+		return
+	}
+	for _, id := range ids {
+		select {
+		case d.queue <- id:
+		default: // picked up by the next queueDue
+		}
+	}
+}
+
+// Enqueue stores a pending delivery of data for every active webhook of the
+// workspace of db subscribed to the event, or just for hook when it is
+// given, and returns their IDs. The dispatcher sends them within its
+// interval.
+func Enqueue(db *gorm.DB, name string, at time.Time, data interface{}, hook *models.Webhook) ([]uint, error) {
+	var hooks []models.Webhook
+	if hook != nil {
+		hooks = []models.Webhook{*hook}
+	} else if err := db.Where("active = ?", true).Find(&hooks).Error; err != nil {
+		return nil, fmt.Errorf("webhooks: %w", err)
+	}
+	payload, err := Payload(name, at, data)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var ids []uint
+	for _, h := range hooks {
+		if hook == nil && !Matches(h.Events, name) {
+			continue
+		}
+		delivery := models.WebhookDelivery{WebhookID: h.ID, Event: name, Payload: payload, Status: models.DeliveryPending, NextAttemptAt: &now}
+		if err := db.Create(&delivery).Error; err != nil {
+			return ids, fmt.Errorf("delivery to webhook %d: %w", h.ID, err)
+		}
+		ids = append(ids, delivery.ID)
+	}
+	return ids, nil
+}
+
+// queueDue hands deliveries whose next attempt is due to the workers
+func (d *Dispatcher) queueDue(now time.Time) error {
+	var ids []uint
+	if err := d.DB.Model(&models.WebhookDelivery{}).
+		Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+		Order("next_attempt_at").Limit(cap(d.queue)).Pluck("id", &ids).Error; err != nil {
+		return fmt.Errorf("due deliveries: %w", err)
+	}
+	for _, id := range ids {
+		select {
+		case d.queue <- id:
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-d.queue:
+			if err := d.deliver(ctx, id, time.Now()); err != nil {
+				log.Printf("Webhook delivery %d: %v", id, err)
+			}
+		}
+	}
+}
+
+// deliver makes one attempt of a due delivery. Claiming it first moves its
+// next attempt past the lease, so a delivery queued twice is sent once.
+func (d *Dispatcher) deliver(ctx context.Context, id uint, now time.Time) error {
+	claim := d.DB.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, models.DeliveryPending, now).
+		Update("next_attempt_at", now.Add(claimLease))
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return nil
+	}
+
+	var delivery models.WebhookDelivery
+	if err := d.DB.First(&delivery, id).Error; err != nil {
+		return err
+	}
+	// Deliveries of every workspace pass through here
+	var hook models.Webhook
+	if err := d.DB.WithContext(database.WithAllWorkspaces(ctx)).First(&hook, delivery.WebhookID).Error; err != nil || !hook.Active {
+		delivery.Status, delivery.NextAttemptAt, delivery.Error = models.DeliveryFailed, nil, "webhook was deleted or disabled"
+		return d.DB.Save(&delivery).Error
+	}
+	Attempt(ctx, d.Client, hook, &delivery, now)
+	return d.DB.Save(&delivery).Error
+}
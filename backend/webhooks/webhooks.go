@@ -0,0 +1,200 @@
+// Package webhooks notifies downstream systems, such as trip planners and
+// signage, of changes to the published feed.
+//
+// Every change announced on the event stream becomes one delivery per
+// matching webhook. Deliveries are stored before they are sent, signed with
+// the webhook's secret and retried with exponential backoff, so a receiver
+// that is down for a while still gets every event in the end.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gtfs-cms/events"
+	"gtfs-cms/models"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of a delivery. The signature is the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed with the webhook secret.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// EventPing is sent by the test endpoint to check a receiver
+const EventPing = "ping"
+
+// MaxAttempts is how often a delivery is tried before it is marked failed
+const MaxAttempts = 8
+
+// activityEvents names events that are not tied to one entity
+var activityEvents = map[string]string{
+	"EXPORT":  "export.published",
+	"PUBLISH": "draft.merged",
+	"IMPORT":  "import.completed",
+	"RESTORE": "snapshot.restored",
+}
+
+// internalEntities and internalActions concern the CMS itself, not the feed
+var (
+	internalEntities = map[string]bool{"user": true, "webhook": true}
+	internalActions  = map[string]bool{"SECURITY": true}
+)
+
+// Name is the webhook event name of e, such as route.updated, or "" for
+// events webhooks do not carry: presence, anything inside a draft and
+// changes to accounts and webhooks
+func Name(e events.Event) string {
+	if e.Type == events.TypePresence || e.DraftID != 0 || internalEntities[e.Entity] || internalActions[e.Operation] {
+		return ""
+	}
+	if e.Type == events.TypeActivity {
+		if name, ok := activityEvents[e.Operation]; ok {
+			return name
+		}
+		return "activity." + strings.ToLower(e.Operation)
+	}
+	switch e.Operation {
+	case "CREATE":
+		return e.Entity + ".created"
+	case "UPDATE":
+		return e.Entity + ".updated"
+	case "DELETE":
+		return e.Entity + ".deleted"
+	}
+	return e.Entity + "." + strings.ToLower(e.Operation)
+}
+
+// Matches reports whether one of filters selects the event name. A filter is
+// an exact name, a prefix ending in .* or * for everything.
+func Matches(filters []string, name string) bool {
+	for _, f := range filters {
+		switch {
+		case f == "*" || f == name:
+			return true
+		case strings.HasSuffix(f, ".*") && strings.HasPrefix(name, strings.TrimSuffix(f, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// ValidFilter reports whether f is a filter Matches understands
+func ValidFilter(f string) bool {
+	if f == "*" {
+		return true
+	}
+	prefix, rest, ok := strings.Cut(f, ".")
+	return ok && prefix != "" && rest != "" && !strings.Contains(prefix, "*") && !strings.Contains(strings.TrimSuffix(rest, "*"), "*")
+}
+
+// Envelope is the JSON body of a delivery
+type Envelope struct {
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// Payload encodes the body of a delivery
+func Payload(name string, at time.Time, data interface{}) (json.RawMessage, error) {
+	return json.Marshal(Envelope{Event: name, OccurredAt: at, Data: data})
+}
+
+// NewSecret generates a signing secret for a new webhook
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header value of body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature the way a receiver would
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Backoff is the wait after the given failed attempt: 30s, 1m, 2m, ...
+// doubling up to six hours
+func Backoff(attempt int) time.Duration {
+	const first, max = 30 * time.Second, 6 * time.Hour
+	if attempt < 1 {
+		return first
+	}
+	if attempt > 20 {
+		return max
+	}
+	return min(first<<(attempt-1), max)
+}
+
+// responseLimit is how much of a response body is kept in the delivery log
+const responseLimit = 2048
+
+// Attempt sends d to hook once and records the outcome on d: succeeded on a
+// 2xx answer, otherwise pending with the next attempt scheduled, or failed
+// once MaxAttempts is reached
+func Attempt(ctx context.Context, client *http.Client, hook models.Webhook, d *models.WebhookDelivery, now time.Time) {
+	d.Attempts++
+	d.LastAttemptAt = &now
+	d.ResponseStatus, d.ResponseBody, d.Error = 0, "", ""
+
+	err := send(ctx, client, hook, d, now)
+	switch {
+	case err == nil:
+		d.Status, d.DeliveredAt, d.NextAttemptAt = models.DeliverySucceeded, &now, nil
+		return
+	case d.Attempts >= MaxAttempts:
+		d.Status, d.NextAttemptAt = models.DeliveryFailed, nil
+	default:
+		next := now.Add(Backoff(d.Attempts))
+		d.Status, d.NextAttemptAt = models.DeliveryPending, &next
+	}
+	d.Error = err.Error()
+}
+
+func send(ctx context.Context, client *http.Client, hook models.Webhook, d *models.WebhookDelivery, now time.Time) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	ts := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gtfs-cms-webhooks/1")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(d.ID), 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, ts, d.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, responseLimit))
+	d.ResponseStatus, d.ResponseBody = resp.StatusCode, string(body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"gtfs-cms/events"
+	"gtfs-cms/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestName(t *testing.T) {
+	for _, tc := range []struct {
+		e    events.Event
+		want string
+	}{
+		{events.Event{Type: events.TypeChange, Entity: "route", Operation: "UPDATE"}, "route.updated"},
+		{events.Event{Type: events.TypeChange, Entity: "trip_stops", Operation: "UPDATE"}, "trip_stops.updated"},
+		{events.Event{Type: events.TypeChange, Entity: "stop", Operation: "DELETE"}, "stop.deleted"},
+		{events.Event{Type: events.TypeActivity, Operation: "EXPORT"}, "export.published"},
+		{events.Event{Type: events.TypeActivity, Operation: "SCHEDULE"}, "activity.schedule"},
+		{events.Event{Type: events.TypeChange, Entity: "route", Operation: "UPDATE", DraftID: 2}, ""},
+		{events.Event{Type: events.TypeChange, Entity: "user", Operation: "CREATE"}, ""},
+		{events.Event{Type: events.TypePresence, Entity: "trip", Operation: events.PresenceEnter}, ""},
+	} {
+		if got := Name(tc.e); got != tc.want {
+			t.Errorf("Name(%+v) = %q, want %q", tc.e, got, tc.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	filters := []string{"route.*", "export.published"}
+	for name, want := range map[string]bool{
+		"route.updated":    true,
+		"route.deleted":    true,
+		"routes.updated":   false,
+		"export.published": true,
+		"stop.updated":     false,
+	} {
+		if got := Matches(filters, name); got != want {
+			t.Errorf("Matches(%v, %q) = %v, want %v", filters, name, got, want)
+		}
+	}
+	if !Matches([]string{"*"}, "stop.created") {
+		t.Error("* should match everything")
+	}
+	for f, want := range map[string]bool{"*": true, "route.*": true, "route.updated": true, "route": false, "*.updated": false, "route.up*d": false} {
+		if got := ValidFilter(f); got != want {
+			t.Errorf("ValidFilter(%q) = %v, want %v", f, got, want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if Backoff(1) != 30*time.Second || Backoff(2) != time.Minute || Backoff(4) != 4*time.Minute {
+		t.Errorf("Backoff should double from 30s, got %s %s %s", Backoff(1), Backoff(2), Backoff(4))
+	}
+	if Backoff(30) != 6*time.Hour {
+		t.Errorf("Backoff(30) = %s, want the six hour cap", Backoff(30))
+	}
+}
+
+// receiver is a stand-in for a downstream system. It answers with the
+// queued statuses in turn, then 200, and records what it was sent.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, http.StatusText(status))
+}
+
+func newDelivery(t *testing.T) models.WebhookDelivery {
+	t.Helper()
+	payload, err := Payload("route.updated", time.Unix(1700000000, 0).UTC(), map[string]interface{}{"id": 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return models.WebhookDelivery{ID: 42, WebhookID: 1, Event: "route.updated", Payload: payload, Status: models.DeliveryPending}
+}
+
+func TestAttemptSendsSignedDelivery(t *testing.T) {
+	rec := &receiver{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	hook := models.Webhook{ID: 1, URL: srv.URL, Secret: "s3cret"}
+	d := newDelivery(t)
+	now := time.Unix(1700000100, 0)
+	Attempt(context.Background(), srv.Client(), hook, &d, now)
+
+	if d.Status != models.DeliverySucceeded || d.Attempts != 1 || d.ResponseStatus != 200 || d.DeliveredAt == nil {
+		t.Fatalf("delivery = %+v, want succeeded on the first attempt", d)
+	}
+	req, body := rec.requests[0], rec.bodies[0]
+	if req.Header.Get(HeaderEvent) != "route.updated" || req.Header.Get(HeaderDelivery) != "42" {
+		t.Errorf("headers = %v", req.Header)
+	}
+	ts, _ := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if ts != now.Unix() || !Verify("s3cret", ts, body, req.Header.Get(HeaderSignature)) {
+		t.Errorf("signature %q does not verify", req.Header.Get(HeaderSignature))
+	}
+	if Verify("other", ts, body, req.Header.Get(HeaderSignature)) {
+		t.Error("signature verified with the wrong secret")
+	}
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil || env.Event != "route.updated" {
+		t.Errorf("body = %s, want the route.updated envelope", body)
+	}
+}
+
+func TestAttemptRetriesWithBackoff(t *testing.T) {
+	rec := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	hook := models.Webhook{ID: 1, URL: srv.URL, Secret: "s3cret"}
+	d := newDelivery(t)
+	now := time.Unix(1700000100, 0)
+
+	Attempt(context.Background(), srv.Client(), hook, &d, now)
+	if d.Status != models.DeliveryPending || d.NextAttemptAt == nil || !d.NextAttemptAt.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("after a 503 delivery = %+v, want a retry in 30s", d)
+	}
+	if d.ResponseStatus != 503 || d.Error == "" {
+		t.Errorf("after a 503 delivery = %+v, want the response logged", d)
+	}
+
+	now = *d.NextAttemptAt
+	Attempt(context.Background(), srv.Client(), hook, &d, now)
+	if d.Status != models.DeliveryPending || !d.NextAttemptAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("after a 500 delivery = %+v, want a retry in a minute", d)
+	}
+
+	now = *d.NextAttemptAt
+	Attempt(context.Background(), srv.Client(), hook, &d, now)
+	if d.Status != models.DeliverySucceeded || d.Attempts != 3 || d.Error != "" {
+		t.Errorf("third attempt = %+v, want succeeded", d)
+	}
+}
+
+func TestAttemptGivesUp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer srv.Close()
+
+	hook := models.Webhook{ID: 1, URL: srv.URL, Secret: "s3cret"}
+	d := newDelivery(t)
+	now := time.Unix(1700000100, 0)
+	for i := 0; i < MaxAttempts; i++ {
+		Attempt(context.Background(), srv.Client(), hook, &d, now)
+	}
+	if d.Status != models.DeliveryFailed || d.NextAttemptAt != nil || d.Attempts != MaxAttempts {
+		t.Errorf("delivery = %+v, want failed after %d attempts", d, MaxAttempts)
+	}
+}
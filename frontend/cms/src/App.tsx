@@ -6,6 +6,7 @@ import Stops from './components/Stops';
 import RouteStudio from './components/Routes';
 import Trips from './components/Trips';
 import SettingsPage from './components/SettingsPage';
+import LoginGate from './components/LoginGate';
 import { Dashboard } from './components/Dashboard';
 import { WorkspaceProvider } from './context/WorkspaceContext';
 import { useWorkspace } from './context/useWorkspace';
@@ -161,17 +162,19 @@ function App() {
   }, []);
 
   return (
-    <Router>
-      <WorkspaceProvider>
-        <div className="flex h-full w-full overflow-hidden bg-zinc-50 dark:bg-zinc-900 text-zinc-900 dark:text-zinc-100 font-sans">
-          <Navigation />
-          <main className="flex-1 flex flex-col min-w-0 overflow-hidden relative">
-            <ShortcutManager />
-            <WorkspaceContainer />
-          </main>
-        </div>
-      </WorkspaceProvider>
-    </Router>
+    <LoginGate>
+      <Router>
+        <WorkspaceProvider>
+          <div className="flex h-full w-full overflow-hidden bg-zinc-50 dark:bg-zinc-900 text-zinc-900 dark:text-zinc-100 font-sans">
+            <Navigation />
+            <main className="flex-1 flex flex-col min-w-0 overflow-hidden relative">
+              <ShortcutManager />
+              <WorkspaceContainer />
+            </main>
+          </div>
+        </WorkspaceProvider>
+      </Router>
+    </LoginGate>
   );
 }
 
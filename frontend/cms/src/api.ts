@@ -1,12 +1,36 @@
 import axios, { AxiosInstance } from 'axios';
 import { setupMockApi } from './mockApi';
 
+export const TOKEN_KEY = 'gtfs_token';
+export const isDemoMode = import.meta.env.VITE_DEMO_MODE === 'true';
+
 const api: AxiosInstance = axios.create({
     baseURL: import.meta.env.VITE_API_BASE_URL || 'http://localhost:8080/api',
 });
 
+// Attach the session token to every request
+api.interceptors.request.use((config) => {
+    const token = localStorage.getItem(TOKEN_KEY);
+    if (token) {
+        config.headers.Authorization = `Bearer ${token}`;
+    }
+    return config;
+});
+
+// An expired or revoked session sends the user back to the login screen
+api.interceptors.response.use(
+    (res) => res,
+    (error) => {
+        if (error.response?.status === 401 && localStorage.getItem(TOKEN_KEY)) {
+            localStorage.removeItem(TOKEN_KEY);
+            window.dispatchEvent(new Event('auth:logout'));
+        }
+        return Promise.reject(error);
+    }
+);
+
 // Setup mock API immediately if in demo mode
-if (import.meta.env.VITE_DEMO_MODE === 'true') {
+if (isDemoMode) {
     setupMockApi(api);
     console.log('✅ Mock API initialized for demo mode');
 }
@@ -19,7 +19,7 @@ export const Dashboard: React.FC<DashboardProps> = () => {
     const [loading, setLoading] = useState(true);
     const [health, setHealth] = useState<'checking' | 'online' | 'error'>('checking');
     const [stats, setStats] = useState<{ agencies: any[], stops: any[], routes: any[], trips: any[] }>({ agencies: [], stops: [], routes: [], trips: [] });
-    const [logs, setLogs] = useState<{ timestamp: string, action: string, details: string }[]>([]);
+    const [logs, setLogs] = useState<{ timestamp: string, action: string, details: string, user_email?: string }[]>([]);
 
     // UI State
     const [activeType, setActiveType] = useState<'routes' | 'stops' | 'agencies' | 'trips'>('routes');
@@ -359,6 +359,7 @@ export const Dashboard: React.FC<DashboardProps> = () => {
                                 <span className="text-zinc-400 dark:text-zinc-600 w-20 shrink-0">{new Date(log.timestamp).toLocaleTimeString([], { hour12: false, hour: '2-digit', minute: '2-digit', second: '2-digit' })}</span>
                                 <span className="font-bold text-blue-600 dark:text-blue-400 w-24 shrink-0 truncate uppercase">{log.action}</span>
                                 <span className="text-zinc-600 dark:text-zinc-300 leading-relaxed">{log.details}</span>
+                                {log.user_email && <span className="ml-auto text-zinc-400 dark:text-zinc-600 shrink-0 truncate">{log.user_email}</span>}
                             </div>
                         ))}
                         {logs.length === 0 && <div className="text-zinc-400 dark:text-zinc-600 italic px-2 py-4 text-center">Waiting for system events...</div>}
@@ -0,0 +1,60 @@
+import React, { useEffect, useState } from 'react';
+import { Loader2, Lock } from 'lucide-react';
+import api, { TOKEN_KEY, isDemoMode } from '../api';
+
+// LoginGate shows a sign-in form until the API accepts a session token
+const LoginGate: React.FC<{ children: React.ReactNode }> = ({ children }) => {
+  const [signedIn, setSignedIn] = useState(isDemoMode || !!localStorage.getItem(TOKEN_KEY));
+  const [email, setEmail] = useState('');
+  const [password, setPassword] = useState('');
+  const [error, setError] = useState<string | null>(null);
+  const [loading, setLoading] = useState(false);
+
+  useEffect(() => {
+    const onLogout = () => setSignedIn(false);
+    window.addEventListener('auth:logout', onLogout);
+    return () => window.removeEventListener('auth:logout', onLogout);
+  }, []);
+
+  if (signedIn) return <>{children}</>;
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setLoading(true);
+    setError(null);
+    try {
+      const res = await api.post('/auth/login', { email, password });
+      localStorage.setItem(TOKEN_KEY, res.data.token);
+      setSignedIn(true);
+    } catch (err: any) {
+      setError(err.response?.data?.error || 'Sign in failed');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="flex h-full w-full items-center justify-center bg-zinc-50 dark:bg-zinc-900 text-zinc-900 dark:text-zinc-100 font-sans">
+      <form onSubmit={handleSubmit} className="w-80 bg-white dark:bg-zinc-800 rounded-[1.5rem] shadow-2xl border border-black/5 p-8 flex flex-col gap-4">
+        <div className="flex items-center gap-3 mb-2">
+          <div className="bg-system-blue/10 text-system-blue p-2 rounded-xl"><Lock size={18} /></div>
+          <div>
+            <span className="text-[11px] font-black uppercase tracking-[0.1em] block">GTFS Studio</span>
+            <p className="text-[9px] font-bold text-zinc-400 uppercase tracking-widest">Sign in to continue</p>
+          </div>
+        </div>
+        <input type="email" required autoFocus value={email} onChange={e => setEmail(e.target.value)} placeholder="Email"
+          className="px-4 py-3 rounded-xl bg-zinc-100 dark:bg-zinc-700 text-sm font-bold outline-none focus:ring-2 focus:ring-system-blue" />
+        <input type="password" required value={password} onChange={e => setPassword(e.target.value)} placeholder="Password"
+          className="px-4 py-3 rounded-xl bg-zinc-100 dark:bg-zinc-700 text-sm font-bold outline-none focus:ring-2 focus:ring-system-blue" />
+        {error && <p className="text-[10px] font-black uppercase tracking-widest text-red-500">{error}</p>}
+        <button type="submit" disabled={loading}
+          className="py-3 rounded-xl bg-system-blue text-white text-xs font-black uppercase tracking-widest flex items-center justify-center gap-2 hover:scale-[1.02] active:scale-95 transition-all disabled:opacity-60">
+          {loading && <Loader2 size={14} className="animate-spin" />}Sign in
+        </button>
+      </form>
+    </div>
+  );
+};
+
+export default LoginGate;
@@ -3,6 +3,7 @@ export interface Agency {
   name: string;
   url: string;
   timezone: string;
+  version?: number;
 }
 
 export interface Stop {
@@ -10,6 +11,13 @@ export interface Stop {
   name: string;
   lat: number;
   lon: number;
+  version?: number;
+  location_type?: number; // 0 stop/platform, 1 station, 2 entrance, 3 generic node, 4 boarding area
+  parent_station?: number;
+  platform_code?: string;
+  stop_code?: string;
+  zone_id?: string;
+  level_id?: number;
 }
 
 export interface Route {
@@ -22,6 +30,8 @@ export interface Route {
   route_desc?: string;
   route_url?: string;
   agency_id: number;
+  network_id?: string;
+  version?: number;
 }
 
 export interface Trip {
@@ -32,6 +42,7 @@ export interface Trip {
   headsign: string;
   direction_id?: number;
   shape_id: string;
+  version?: number;
 }
 
 export interface ShapePoint {